@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// LoadAccessTable load the rules of the access table name, they replace the
+// ones loaded before. It returns the number of rules loaded
+func LoadAccessTable(name string) (count int, err error) {
+	if name == "" {
+		return 0, errors.New("Access table not configured")
+	}
+	table, err := quoteIdentifier(name)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid access table %s", name)
+	}
+
+	db := connection.MustGet()
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT name, COALESCE(database, ''), COALESCE(schema, ''), "+
+			"COALESCE(permissions, '{}'), COALESCE(fields, '{}'), COALESCE(masked, '{}') FROM %s", table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var rules []config.TablesConf
+	for rows.Next() {
+		var t config.TablesConf
+		err = rows.Scan(&t.Name, &t.Database, &t.Schema,
+			pq.Array(&t.Permissions), pq.Array(&t.Fields), pq.Array(&t.Masked))
+		if err != nil {
+			return
+		}
+		rules = append(rules, t)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	config.SetTableRules(rules)
+	return len(rules), nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/nuveo/prest/config"
+)
+
+// anonymizers SQL expressions of the anonymization functions, they are
+// deterministic (the same value is always replaced by the same one) to keep
+// the relations between the exported tables, NULL stays NULL
+var anonymizers = map[string]func(column string) string{
+	"hash": func(column string) string {
+		return fmt.Sprintf("md5(%s::text)", column)
+	},
+	"null": func(column string) string {
+		return "NULL"
+	},
+	"name": func(column string) string {
+		return fmt.Sprintf("'name_' || substr(md5(%s::text), 1, 8)", column)
+	},
+	"email": func(column string) string {
+		return fmt.Sprintf("'user_' || substr(md5(%s::text), 1, 10) || '@example.com'", column)
+	},
+	"phone": func(column string) string {
+		return fmt.Sprintf("'555' || lpad((('x' || substr(md5(%s::text), 1, 7))::bit(28)::int %% 10000000)::text, 7, '0')", column)
+	},
+}
+
+// anonymizeConf return the anonymization functions of the table columns
+func anonymizeConf(cfg *config.Prest, database, schema, table string) map[string]string {
+	for _, a := range cfg.Anonymize {
+		if a.Matches(database, schema, table) {
+			return a.Columns
+		}
+	}
+	return nil
+}
+
+// AnonymizedFields return the select expressions of cols, the columns with an
+// anonymization function in the config replaced by the anonymized expression.
+// A "*" is expanded to the columns of the table when it has anonymized columns
+func AnonymizedFields(cfg *config.Prest, database, schema, table string, cols []string) (fields []string, err error) {
+	conf := anonymizeConf(cfg, database, schema, table)
+	if len(conf) == 0 {
+		return quoteSelect(cols)
+	}
+
+	for _, col := range cols {
+		if col != "*" {
+			fields = append(fields, col)
+			continue
+		}
+		var tableColumns []string
+		tableColumns, err = TableColumns(database, schema, table)
+		if err != nil {
+			return
+		}
+		fields = append(fields, quoteNames(unmasked(cfg, database, schema, table, tableColumns))...)
+	}
+	if fields, err = quoteSelect(fields); err != nil {
+		return
+	}
+
+	for i, field := range fields {
+		parts, errName := splitIdentifier(field)
+		if errName != nil {
+			continue
+		}
+		column := parts[len(parts)-1]
+		if len(parts) > 1 && parts[len(parts)-2] != table {
+			continue
+		}
+		name, ok := conf[column]
+		if !ok {
+			continue
+		}
+		anonymizer, ok := anonymizers[name]
+		if !ok {
+			err = fmt.Errorf("Unknown anonymization function %s of %s.%s", name, table, column)
+			return
+		}
+		fields[i] = fmt.Sprintf("%s AS %s", anonymizer(field), quoteName(column))
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// CheckPassword validate the credentials against the users table of the
+// configuration, the passwords are bcrypt hashes checked by pgcrypto crypt()
+func CheckPassword(cfg *config.Prest, username, password string) (valid bool, err error) {
+	if chkInvalidIdentifier(cfg.AuthSchema) ||
+		chkInvalidIdentifier(cfg.AuthTable) ||
+		chkInvalidIdentifier(cfg.AuthUsername) ||
+		chkInvalidIdentifier(cfg.AuthPassword) {
+		err = errors.New("Auth: Invalid identifier")
+		return
+	}
+
+	query := fmt.Sprintf("SELECT %s = crypt($2, %s) FROM %s.%s WHERE %s = $1",
+		cfg.AuthPassword, cfg.AuthPassword, cfg.AuthSchema, cfg.AuthTable, cfg.AuthUsername)
+	db := connection.MustGet()
+	err = db.QueryRow(query, username, password).Scan(&valid)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/statements"
+)
+
+// encodings of the bytea columns in JSON, the Postgres text ("\x0102") by
+// default
+const (
+	ByteaHex    = "hex"
+	ByteaBase64 = "base64"
+)
+
+// ByteaColumns return the columns of bytea type of a table (or view), the
+// result is cached
+func ByteaColumns(database, schema, table string) ([]string, error) {
+	return cachedColumns(statements.ByteaColumns, database, schema, table)
+}
+
+// ByteaByRequest return the encoding of the bytea columns, `_bytea` or else
+// the configured one
+func ByteaByRequest(r *http.Request) (encoding string, err error) {
+	encoding = r.URL.Query().Get("_bytea")
+	if encoding == "" {
+		encoding = config.FromContext(r.Context()).Bytea
+	}
+	switch encoding {
+	case "", ByteaHex, ByteaBase64:
+	default:
+		err = fmt.Errorf("Invalid _bytea: %s", encoding)
+	}
+	return
+}
+
+// ByteaResponse encode the values of the bytea columns of a table (or view)
+// of the rows (or the row) in hex or base64, nothing changes without encoding
+func ByteaResponse(database, schema, table, encoding string, jsonData []byte) ([]byte, error) {
+	fn, err := byteaRows(database, schema, table, encoding)
+	if err != nil || fn == nil {
+		return jsonData, err
+	}
+	return applyRows(jsonData, fn)
+}
+
+// byteaRows return the change of the rows of ByteaResponse, nil without
+// encoding or bytea columns
+func byteaRows(database, schema, table, encoding string) (RowFunc, error) {
+	if encoding == "" {
+		return nil, nil
+	}
+	cols, err := ByteaColumns(database, schema, table)
+	if err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	return func(row map[string]interface{}) error {
+		for _, name := range cols {
+			text, ok := row[name].(string)
+			if !ok {
+				continue
+			}
+			value, err := byteaValue(text)
+			if err != nil {
+				return err
+			}
+			if encoding == ByteaBase64 {
+				row[name] = base64.StdEncoding.EncodeToString(value)
+			} else {
+				row[name] = hex.EncodeToString(value)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// ByteaRequest replace the hex or base64 values of the bytea columns of the
+// body by their Postgres text ("\x0102"), nothing changes without encoding
+func ByteaRequest(database, schema, table, encoding string, data map[string]interface{}) error {
+	if encoding == "" {
+		return nil
+	}
+	cols, err := ByteaColumns(database, schema, table)
+	if err != nil {
+		return err
+	}
+	for _, name := range cols {
+		text, ok := data[name].(string)
+		if !ok {
+			continue
+		}
+		var value []byte
+		if encoding == ByteaBase64 {
+			value, err = base64.StdEncoding.DecodeString(text)
+		} else {
+			value, err = hex.DecodeString(strings.TrimPrefix(text, `\x`))
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid %s value of %s", encoding, name)
+		}
+		data[name] = `\x` + hex.EncodeToString(value)
+	}
+	return nil
+}
+
+// byteaValue decode the Postgres text of a bytea, in hex ("\x0102") or escape
+// ("a\001\\") format
+func byteaValue(text string) ([]byte, error) {
+	if strings.HasPrefix(text, `\x`) {
+		return hex.DecodeString(text[2:])
+	}
+	value := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			value = append(value, text[i])
+			continue
+		}
+		switch {
+		case i+1 < len(text) && text[i+1] == '\\':
+			value = append(value, '\\')
+			i++
+		case i+3 < len(text) && isOctal(text[i+1]) && isOctal(text[i+2]) && isOctal(text[i+3]):
+			value = append(value, (text[i+1]-'0')<<6|(text[i+2]-'0')<<3|(text[i+3]-'0'))
+			i += 3
+		default:
+			return nil, errors.New("Invalid bytea value")
+		}
+	}
+	return value, nil
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// Changes rows changed since a cursor, deleted rows are the ones with the
+// soft delete column set
+type Changes struct {
+	Upserts []map[string]interface{} `json:"upserts"`
+	Deleted []map[string]interface{} `json:"deleted"`
+	Cursor  string                   `json:"cursor"`
+}
+
+// changesConf return the delta sync config of the table, xmin is the cursor
+// of tables without config
+func changesConf(cfg *config.Prest, database, schema, table string) config.ChangesConf {
+	for _, c := range cfg.Changes {
+		if c.Matches(database, schema, table) {
+			return c
+		}
+	}
+	return config.ChangesConf{Table: table}
+}
+
+// ChangesSince return up to limit rows (the default page size when 0) changed
+// after the cursor, all rows when it is empty, in cursor and primary key order
+// with the cursor and the key of the last row. The rows with the same cursor
+// (e.g. written in the same transaction) are told apart by the key
+func ChangesSince(cfg *config.Prest, database, schema, table, cursor string, limit int) (changes Changes, err error) {
+	if !TablePermissions(cfg, database, schema, table, "read") {
+		err = errors.New("Insuficient table permissions")
+		return
+	}
+
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
+		err = errors.New("Changes: Invalid identifier")
+		return
+	}
+
+	conf := changesConf(cfg, database, schema, table)
+	cursorExpr := "xmin::text::bigint"
+	if conf.Column != "" {
+		if cursorExpr, err = quoteIdentifier(conf.Column); err != nil {
+			err = errors.New("Changes: Invalid identifier")
+			return
+		}
+	}
+	deletedExpr := "false"
+	if conf.Deleted != "" {
+		var deleted string
+		if deleted, err = quoteIdentifier(conf.Deleted); err != nil {
+			err = errors.New("Changes: Invalid identifier")
+			return
+		}
+		deletedExpr = fmt.Sprintf("%s IS NOT NULL", deleted)
+	}
+
+	defaultSize, maxSize := pageSizes(cfg)
+	if limit <= 0 {
+		limit = defaultSize
+	}
+	if maxSize > 0 && limit > maxSize {
+		limit = maxSize
+	}
+
+	cols, err := ReadableFields(cfg, database, schema, table, []string{"*"})
+	if err != nil {
+		return
+	}
+	if len(cols) == 0 {
+		err = errors.New("Insuficient table permissions")
+		return
+	}
+
+	if cols, err = quoteSelect(cols); err != nil {
+		return
+	}
+	pk, err := PrimaryKey(database, schema, table)
+	if err != nil {
+		return
+	}
+	if len(pk) == 0 {
+		err = fmt.Errorf("Changes: %s must have a primary key", table)
+		return
+	}
+	keys := strings.Join(quoteNames(pk), ", ")
+	sql := fmt.Sprintf("SELECT %s, %s AS _cursor, json_build_array(%s) AS _key, %s AS _deleted FROM %s",
+		strings.Join(cols, ", "), cursorExpr, keys, deletedExpr, QuoteTable(database, schema, table))
+	values := []interface{}{}
+	if cursor != "" {
+		values, err = DecodeCursor(cursor)
+		if err != nil {
+			return
+		}
+		switch len(values) {
+		case 1:
+			// cursors without the key, the rows of the last cursor are
+			// returned again
+			sql = fmt.Sprintf("%s WHERE %s >= $1", sql, cursorExpr)
+		case len(pk) + 1:
+			placeholders := make([]string, len(values))
+			for i := range values {
+				placeholders[i] = fmt.Sprintf("$%d", i+1)
+			}
+			sql = fmt.Sprintf("%s WHERE (%s, %s) > (%s)", sql, cursorExpr, keys, strings.Join(placeholders, ", "))
+		default:
+			err = errors.New("Invalid cursor")
+			return
+		}
+	}
+	sql = fmt.Sprintf("SELECT COALESCE(json_agg(c), '[]'::json) FROM (%s ORDER BY %s, %s LIMIT %d) c", sql, cursorExpr, keys, limit)
+
+	db := connection.MustGet()
+	var jsonData []byte
+	if err = db.QueryRow(sql, values...).Scan(&jsonData); err != nil {
+		return
+	}
+
+	var rows []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	if err = decoder.Decode(&rows); err != nil {
+		return
+	}
+
+	changes.Upserts = []map[string]interface{}{}
+	changes.Deleted = []map[string]interface{}{}
+	changes.Cursor = cursor
+	for _, row := range rows {
+		key, _ := row["_key"].([]interface{})
+		last := append([]interface{}{row["_cursor"]}, key...)
+		deleted, _ := row["_deleted"].(bool)
+		delete(row, "_cursor")
+		delete(row, "_key")
+		delete(row, "_deleted")
+		if deleted {
+			changes.Deleted = append(changes.Deleted, row)
+		} else {
+			changes.Upserts = append(changes.Upserts, row)
+		}
+		if changes.Cursor, err = EncodeCursor(last); err != nil {
+			return
+		}
+	}
+	return
+}
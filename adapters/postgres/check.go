@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/statements"
+)
+
+// permissionOps operations of the table permissions
+var permissionOps = map[string]bool{"read": true, "insert": true, "update": true, "write": true, "delete": true}
+
+// CheckAccess verify that the tables of the access rules exist and that their
+// fields and masked columns are columns of them, returning the problems found.
+// The rules of patterns are not checked
+func CheckAccess() (warnings []string, err error) {
+	db := connection.MustGet()
+	for _, t := range config.Get().AccessConf.Tables {
+		if t.Pattern() {
+			continue
+		}
+		rows, err := db.Query(statements.AccessColumns, t.Database, t.Schema, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		var cols []string
+		for rows.Next() {
+			var col string
+			if err = rows.Scan(&col); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			cols = append(cols, col)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, accessWarnings(t, cols)...)
+	}
+	return
+}
+
+// accessWarnings check the rules of a table with its columns, none when the
+// table doesn't exist
+func accessWarnings(t config.TablesConf, cols []string) (warnings []string) {
+	var names []string
+	for _, name := range []string{t.Database, t.Schema, t.Name} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	table := strings.Join(names, ".")
+
+	for _, p := range t.Permissions {
+		if !permissionOps[p] {
+			warnings = append(warnings, fmt.Sprintf("access rules of %s: unknown permission %q, use read, insert, update, write or delete", table, p))
+		}
+	}
+	if len(cols) == 0 {
+		warnings = append(warnings, fmt.Sprintf("access rules of %s: table not found (or without columns visible to the user)", table))
+		return
+	}
+	for _, f := range t.Fields {
+		if f != "*" && !containsColumn(cols, f) {
+			warnings = append(warnings, fmt.Sprintf("access rules of %s: field %s is not a column of the table", table, f))
+		}
+	}
+	for _, m := range t.Masked {
+		if !containsColumn(cols, m) {
+			warnings = append(warnings, fmt.Sprintf("access rules of %s: masked column %s is not a column of the table", table, m))
+		}
+	}
+	if t.Owner != "" && !containsColumn(cols, t.Owner) {
+		warnings = append(warnings, fmt.Sprintf("access rules of %s: owner column %s is not a column of the table", table, t.Owner))
+	}
+	return
+}
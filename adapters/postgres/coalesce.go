@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// call query in flight shared by identical concurrent requests
+type call struct {
+	wg       sync.WaitGroup
+	jsonData []byte
+	err      error
+	dups     int
+}
+
+var (
+	calls   = make(map[string]*call)
+	callsMu sync.Mutex
+)
+
+// queryKey identify a query by its SQL and parameters (with their types)
+func queryKey(SQL string, params []interface{}) string {
+	var key bytes.Buffer
+	key.WriteString(SQL)
+	for _, p := range params {
+		fmt.Fprintf(&key, "\x00%T:%v", p, p)
+	}
+	return key.String()
+}
+
+// coalesce execute fn once for concurrent calls with the same key, all of
+// them receive the same result
+func coalesce(key string, fn func() ([]byte, error)) ([]byte, error) {
+	callsMu.Lock()
+	if c, ok := calls[key]; ok {
+		c.dups++
+		callsMu.Unlock()
+		c.wg.Wait()
+		if c.err != nil {
+			return nil, c.err
+		}
+		// callers may change the response, each one has its copy
+		return copyBytes(c.jsonData), nil
+	}
+	c := &call{}
+	c.wg.Add(1)
+	calls[key] = c
+	callsMu.Unlock()
+
+	c.jsonData, c.err = fn()
+	c.wg.Done()
+
+	callsMu.Lock()
+	delete(calls, key)
+	shared := c.dups > 0
+	callsMu.Unlock()
+
+	if shared && c.err == nil {
+		return copyBytes(c.jsonData), nil
+	}
+	return c.jsonData, c.err
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,295 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/statements"
+)
+
+// compositeField attribute of a composite type, Fields are the attributes of
+// the composite ones
+type compositeField struct {
+	Name   string
+	Type   string
+	Fields []compositeField
+}
+
+// compositeColumn column of a composite type, Type is the qualified name of
+// the type
+type compositeColumn struct {
+	Type   string
+	Fields []compositeField
+}
+
+var (
+	compositeCache   = make(map[string]map[string]compositeColumn)
+	compositeCacheMu sync.RWMutex
+)
+
+// numericTypes data types serialized as JSON numbers in composite values
+var numericTypes = map[string]bool{
+	"smallint":         true,
+	"integer":          true,
+	"bigint":           true,
+	"numeric":          true,
+	"real":             true,
+	"double precision": true,
+}
+
+// compositeColumns return the columns of composite types of a table (or
+// view) by name, the result is cached
+func compositeColumns(database, schema, table string) (map[string]compositeColumn, error) {
+	key := fmt.Sprintf("%s.%s.%s", database, schema, table)
+	compositeCacheMu.RLock()
+	cols, ok := compositeCache[key]
+	compositeCacheMu.RUnlock()
+	if ok {
+		return cols, nil
+	}
+
+	// don't cache unknown tables, they may be created later
+	tableColumns, err := TableColumns(database, schema, table)
+	if err != nil || len(tableColumns) == 0 {
+		return nil, err
+	}
+
+	db := connection.MustGet()
+	rows, err := db.Query(statements.CompositeColumns, database, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type column struct{ name, typeSchema, typeName string }
+	var found []column
+	for rows.Next() {
+		var c column
+		if err = rows.Scan(&c.name, &c.typeSchema, &c.typeName); err != nil {
+			return nil, err
+		}
+		found = append(found, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cols = make(map[string]compositeColumn, len(found))
+	for _, c := range found {
+		var fields []compositeField
+		fields, err = compositeFields(database, c.typeSchema, c.typeName)
+		if err != nil {
+			return nil, err
+		}
+		cols[c.name] = compositeColumn{
+			Type:   pq.QuoteIdentifier(c.typeSchema) + "." + pq.QuoteIdentifier(c.typeName),
+			Fields: fields,
+		}
+	}
+
+	compositeCacheMu.Lock()
+	compositeCache[key] = cols
+	compositeCacheMu.Unlock()
+	return cols, nil
+}
+
+// compositeFields return the attributes of a composite type
+func compositeFields(database, schema, name string) (fields []compositeField, err error) {
+	db := connection.MustGet()
+	rows, err := db.Query(statements.CompositeAttributes, database, schema, name)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	type attribute struct {
+		field                compositeField
+		typeSchema, typeName string
+		composite            bool
+	}
+	var attributes []attribute
+	for rows.Next() {
+		var a attribute
+		if err = rows.Scan(&a.field.Name, &a.field.Type, &a.typeSchema, &a.typeName, &a.composite); err != nil {
+			return
+		}
+		attributes = append(attributes, a)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, a := range attributes {
+		if a.composite {
+			a.field.Fields, err = compositeFields(database, a.typeSchema, a.typeName)
+			if err != nil {
+				return
+			}
+		}
+		fields = append(fields, a.field)
+	}
+	return
+}
+
+// CompositeResponse replace the values of the composite columns of a table (or
+// view), the text of the tuples as "(1,abc)", by JSON objects
+func CompositeResponse(database, schema, table string, jsonData []byte) ([]byte, error) {
+	fn, err := compositeRows(database, schema, table)
+	if err != nil || fn == nil {
+		return jsonData, err
+	}
+	return applyRows(jsonData, fn)
+}
+
+// compositeRows return the change of the rows of CompositeResponse, nil
+// without composite columns
+func compositeRows(database, schema, table string) (RowFunc, error) {
+	cols, err := compositeColumns(database, schema, table)
+	if err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	return func(row map[string]interface{}) (err error) {
+		for name, col := range cols {
+			// aggregates may be named as the column
+			text, ok := row[name].(string)
+			if !ok || !strings.HasPrefix(text, "(") {
+				continue
+			}
+			if row[name], err = compositeObject(text, col.Fields); err != nil {
+				return
+			}
+		}
+		return
+	}, nil
+}
+
+// compositeObject parse the text of a tuple as an object of the fields
+func compositeObject(text string, fields []compositeField) (map[string]interface{}, error) {
+	values, err := parseTuple(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("Composite value %s has %d fields, expected %d", text, len(values), len(fields))
+	}
+
+	object := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		v := values[i]
+		switch {
+		case v == nil:
+			object[f.Name] = nil
+		case f.Fields != nil:
+			if object[f.Name], err = compositeObject(*v, f.Fields); err != nil {
+				return nil, err
+			}
+		case numericTypes[f.Type]:
+			// NaN and Infinity are not JSON numbers
+			if _, err := strconv.ParseFloat(*v, 64); err != nil || strings.ContainsAny(*v, "NnIi") {
+				object[f.Name] = *v
+				continue
+			}
+			object[f.Name] = json.Number(*v)
+		case f.Type == "boolean":
+			object[f.Name] = *v == "t"
+		case f.Type == "json" || f.Type == "jsonb":
+			object[f.Name] = json.RawMessage(*v)
+		default:
+			object[f.Name] = *v
+		}
+	}
+	return object, nil
+}
+
+// parseTuple split the text of a composite value in the values of its
+// fields, nil are NULL
+func parseTuple(text string) (values []*string, err error) {
+	if len(text) < 2 || text[0] != '(' || text[len(text)-1] != ')' {
+		return nil, fmt.Errorf("Invalid composite value %s", text)
+	}
+	text = text[1 : len(text)-1]
+
+	var value bytes.Buffer
+	quoted, null := false, true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quoted && c == '"' && i+1 < len(text) && text[i+1] == '"':
+			value.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+			null = false
+		case c == '\\':
+			if i+1 == len(text) {
+				return nil, errors.New("Invalid composite value, unfinished escape")
+			}
+			value.WriteByte(text[i+1])
+			null = false
+			i++
+		case c == ',' && !quoted:
+			values = append(values, tupleValue(value.String(), null))
+			value.Reset()
+			null = true
+		default:
+			value.WriteByte(c)
+			null = false
+		}
+	}
+	if quoted {
+		return nil, errors.New("Invalid composite value, unfinished quote")
+	}
+	values = append(values, tupleValue(value.String(), null))
+	return
+}
+
+func tupleValue(value string, null bool) *string {
+	if null {
+		return nil
+	}
+	return &value
+}
+
+// compositePlaceholders return the placeholders of the values of the fields,
+// the objects of composite columns are bound as JSON and converted by
+// json_populate_record, the objects of range columns are bound as range
+// literals. The columns are only introspected when there are objects
+func compositePlaceholders(database, schema, table string, fields []string, values []interface{}, pid int) (placeholders []string, err error) {
+	var cols map[string]compositeColumn
+	var ranges map[string]rangeColumn
+	for i, field := range fields {
+		placeholder := fmt.Sprintf("$%d", pid+i)
+		if object, ok := values[i].(map[string]interface{}); ok {
+			if cols == nil {
+				if cols, err = compositeColumns(database, schema, table); err != nil {
+					return
+				}
+				if ranges, err = rangeColumns(database, schema, table); err != nil {
+					return
+				}
+			}
+			if col, ok := cols[field]; ok {
+				var b []byte
+				if b, err = json.Marshal(values[i]); err != nil {
+					return
+				}
+				values[i] = string(b)
+				placeholder = fmt.Sprintf("json_populate_record(NULL::%s, %s::json)", col.Type, placeholder)
+			} else if col, ok := ranges[field]; ok {
+				if values[i], err = rangeLiteral(object); err != nil {
+					return
+				}
+				placeholder = fmt.Sprintf("%s::%s", placeholder, col.Type)
+			}
+		}
+		placeholders = append(placeholders, placeholder)
+	}
+	return
+}
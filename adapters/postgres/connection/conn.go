@@ -2,6 +2,7 @@ package connection
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/nuveo/prest/config"
@@ -30,6 +31,15 @@ func MustGet() *sqlx.DB {
 		}
 		db.SetMaxIdleConns(cfg.PGMaxIdleConn)
 		db.SetMaxOpenConns(cfg.PGMAxOpenConn)
+		db.SetConnMaxLifetime(time.Duration(cfg.PGConnMaxLifetime) * time.Second)
 	}
 	return db
 }
+
+// Set replace the connection (e.g. by a mocked one in the tests), returning
+// the previous one, nil when there was none
+func Set(conn *sqlx.DB) *sqlx.DB {
+	previous := db
+	db = conn
+	return previous
+}
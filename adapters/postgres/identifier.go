@@ -0,0 +1,211 @@
+package postgres
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// reservedWords are the key words of postgres that can't be used as
+// identifiers without quotes
+var reservedWords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true,
+	"array": true, "as": true, "asc": true, "asymmetric": true, "authorization": true,
+	"binary": true, "both": true, "case": true, "cast": true, "check": true,
+	"collate": true, "collation": true, "column": true, "concurrently": true, "constraint": true,
+	"create": true, "cross": true, "current_catalog": true, "current_date": true, "current_role": true,
+	"current_schema": true, "current_time": true, "current_timestamp": true, "current_user": true, "default": true,
+	"deferrable": true, "desc": true, "distinct": true, "do": true, "else": true,
+	"end": true, "except": true, "false": true, "fetch": true, "for": true,
+	"foreign": true, "freeze": true, "from": true, "full": true, "grant": true,
+	"group": true, "having": true, "ilike": true, "in": true, "initially": true,
+	"inner": true, "intersect": true, "into": true, "is": true, "isnull": true,
+	"join": true, "lateral": true, "leading": true, "left": true, "like": true,
+	"limit": true, "localtime": true, "localtimestamp": true, "natural": true, "not": true,
+	"notnull": true, "null": true, "offset": true, "on": true, "only": true,
+	"or": true, "order": true, "outer": true, "overlaps": true, "placing": true,
+	"primary": true, "references": true, "returning": true, "right": true, "select": true,
+	"session_user": true, "similar": true, "some": true, "symmetric": true, "table": true,
+	"tablesample": true, "then": true, "to": true, "trailing": true, "true": true,
+	"union": true, "unique": true, "user": true, "using": true, "variadic": true,
+	"verbose": true, "when": true, "where": true, "window": true, "with": true,
+}
+
+var errInvalidIdentifier = errors.New("Invalid identifier")
+
+// splitIdentifier parse an identifier, qualified by dots, returning the names
+// of its parts. Parts are bare (letters, digits and underscores, not starting
+// with a digit) or double quoted ("Order Date", quotes doubled inside them)
+func splitIdentifier(identifier string) (names []string, err error) {
+	for len(identifier) > 0 {
+		var name string
+		if identifier[0] == '"' {
+			var b bytes.Buffer
+			i := 1
+			for ; i < len(identifier); i++ {
+				if identifier[i] != '"' {
+					b.WriteByte(identifier[i])
+					continue
+				}
+				if i+1 < len(identifier) && identifier[i+1] == '"' {
+					b.WriteByte('"')
+					i++
+					continue
+				}
+				break
+			}
+			if i == len(identifier) {
+				return nil, errInvalidIdentifier
+			}
+			name, identifier = b.String(), identifier[i+1:]
+			if strings.ContainsRune(name, 0) {
+				return nil, errInvalidIdentifier
+			}
+		} else {
+			end := strings.IndexByte(identifier, '.')
+			if end == -1 {
+				end = len(identifier)
+			}
+			name, identifier = identifier[:end], identifier[end:]
+			if !bareIdentifier(name) {
+				return nil, errInvalidIdentifier
+			}
+		}
+		if len(name) == 0 || len(name) > 63 {
+			return nil, errInvalidIdentifier
+		}
+		names = append(names, name)
+
+		if len(identifier) > 0 {
+			if identifier[0] != '.' || len(identifier) == 1 {
+				return nil, errInvalidIdentifier
+			}
+			identifier = identifier[1:]
+		}
+	}
+	if len(names) == 0 {
+		return nil, errInvalidIdentifier
+	}
+	return
+}
+
+// bareIdentifier check the names used without quotes
+func bareIdentifier(name string) bool {
+	for i, c := range name {
+		if (i == 0 && unicode.IsDigit(c)) ||
+			(!unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// chkInvalidIdentifier return true if identifier is invalid
+func chkInvalidIdentifier(identifier string) bool {
+	_, err := splitIdentifier(identifier)
+	return err != nil
+}
+
+// chkInvalidName return true if the name of a database, schema or table (as
+// in the URL, without quotes) can't be used, valid names are quoted by
+// QuoteTable
+func chkInvalidName(name string) bool {
+	return len(name) == 0 || len(name) > 63 || strings.ContainsRune(name, 0)
+}
+
+// quoteName return the name as used in SQL, quoted when it isn't a lower
+// case identifier (mixed case, reserved words, spaces...)
+func quoteName(name string) string {
+	if name == "" || reservedWords[name] || !bareIdentifier(name) {
+		return pq.QuoteIdentifier(name)
+	}
+	for _, c := range name {
+		if unicode.IsUpper(c) {
+			return pq.QuoteIdentifier(name)
+		}
+	}
+	return name
+}
+
+// quoteNames quote the names of the catalog (or of the config) to be used
+// as fields of the request, "*" (all the fields in the config) is kept
+func quoteNames(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = name
+		if name != "*" {
+			quoted[i] = quoteName(name)
+		}
+	}
+	return quoted
+}
+
+// dataColumns return the data of an insert or update by the names of the
+// columns, the keys of the body may be quoted ({"\"Order Date\"": ...})
+func dataColumns(data map[string]interface{}) (map[string]interface{}, error) {
+	columns := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		names, err := splitIdentifier(key)
+		if err != nil || len(names) != 1 {
+			return nil, errInvalidIdentifier
+		}
+		columns[names[0]] = value
+	}
+	return columns, nil
+}
+
+// quoteIdentifier validate the identifier of the request and return it as
+// used in SQL, the parts are quoted when they need
+func quoteIdentifier(identifier string) (string, error) {
+	names, err := splitIdentifier(identifier)
+	if err != nil {
+		return "", err
+	}
+	for i, name := range names {
+		names[i] = quoteName(name)
+	}
+	return strings.Join(names, "."), nil
+}
+
+// quoteField quote the fields of selects, "*" and "table.*" included
+func quoteField(field string) (string, error) {
+	if field == "*" {
+		return field, nil
+	}
+	if strings.HasSuffix(field, ".*") {
+		table, err := quoteIdentifier(field[:len(field)-2])
+		return table + ".*", err
+	}
+	return quoteIdentifier(field)
+}
+
+// quoteSelect validate the fields of a select returning them as used in SQL,
+// "*" and "table.*" included
+func quoteSelect(fields []string) ([]string, error) {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		var err error
+		if quoted[i], err = quoteField(field); err != nil {
+			return nil, err
+		}
+	}
+	return quoted, nil
+}
+
+// identifierName return the identifier without the quotes of its parts, as
+// the names of the catalog and of the config
+func identifierName(identifier string) string {
+	names, err := splitIdentifier(identifier)
+	if err != nil {
+		return identifier
+	}
+	return strings.Join(names, ".")
+}
+
+// QuoteTable return the qualified name of a table (or view) as used in SQL
+func QuoteTable(database, schema, table string) string {
+	return quoteName(database) + "." + quoteName(schema) + "." + quoteName(table)
+}
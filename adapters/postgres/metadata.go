@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/statements"
+)
+
+var (
+	columnsCache   = make(map[string][]string)
+	columnsCacheMu sync.RWMutex
+)
+
+// TableColumns return the columns of a table (or view), the result is cached
+func TableColumns(database, schema, table string) ([]string, error) {
+	return cachedColumns(statements.TableColumns, database, schema, table)
+}
+
+// PrimaryKey return the primary key columns of a table, the result is cached
+func PrimaryKey(database, schema, table string) ([]string, error) {
+	return cachedColumns(statements.PrimaryKeyColumns, database, schema, table)
+}
+
+// GeneratedColumns return the columns of a table the database always
+// generates (expressions and identities), the result is cached
+func GeneratedColumns(database, schema, table string) ([]string, error) {
+	return cachedColumns(statements.GeneratedColumns, database, schema, table)
+}
+
+// checkGenerated return an error when the body sets a generated column
+func checkGenerated(database, schema, table string, data map[string]interface{}) error {
+	generated, err := GeneratedColumns(database, schema, table)
+	if err != nil {
+		return err
+	}
+	for _, col := range generated {
+		if _, ok := data[col]; ok {
+			return fmt.Errorf("Column %s is generated by the database, it can't be set", col)
+		}
+	}
+	return nil
+}
+
+// UnknownColumnsError error of a body setting columns the table doesn't have
+type UnknownColumnsError struct {
+	Table   string
+	Columns []string
+}
+
+func (e *UnknownColumnsError) Error() string {
+	return fmt.Sprintf("Unknown columns of %s: %s", e.Table, strings.Join(e.Columns, ", "))
+}
+
+// checkColumns return an UnknownColumnsError when the body sets columns the
+// table doesn't have, unknown tables are left to the database
+func checkColumns(database, schema, table string, data map[string]interface{}) error {
+	cols, err := TableColumns(database, schema, table)
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+	known := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		known[col] = true
+	}
+	var unknown []string
+	for col := range data {
+		if !known[col] {
+			unknown = append(unknown, col)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return &UnknownColumnsError{Table: table, Columns: unknown}
+}
+
+// cachedColumns run the query listing columns of the table once
+func cachedColumns(query, database, schema, table string) ([]string, error) {
+	key := fmt.Sprintf("%s.%s.%s\n%s", database, schema, table, query)
+
+	columnsCacheMu.RLock()
+	cols, ok := columnsCache[key]
+	columnsCacheMu.RUnlock()
+	if ok {
+		return cols, nil
+	}
+
+	db := connection.MustGet()
+	rows, err := db.Query(query, database, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols = []string{}
+	for rows.Next() {
+		var col string
+		if err = rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// don't cache unknown tables, they may be created later
+	if len(cols) > 0 {
+		columnsCacheMu.Lock()
+		columnsCache[key] = cols
+		columnsCacheMu.Unlock()
+	}
+	return cols, nil
+}
+
+// Column of a table with its data type, the type underlying the domain when
+// the column has one
+type Column struct {
+	Name   string
+	Type   string
+	Domain string
+}
+
+// caseInsensitiveTypes data types compared ignoring the case
+var caseInsensitiveTypes = map[string]bool{
+	"citext": true,
+}
+
+// CaseInsensitive check if the values of the column are compared ignoring the
+// case, in filters, ordering, grouping and unique constraints
+func (c Column) CaseInsensitive() bool {
+	return caseInsensitiveTypes[c.Type]
+}
+
+// ColumnTypes return the columns of a table (or view) with their data types
+func ColumnTypes(database, schema, table string) (cols []Column, err error) {
+	db := connection.MustGet()
+	rows, err := db.Query(statements.ColumnTypes, database, schema, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var col Column
+		if err = rows.Scan(&col.Name, &col.Type, &col.Domain); err != nil {
+			return
+		}
+		cols = append(cols, col)
+	}
+	err = rows.Err()
+	return
+}
+
+// ForeignKey column of a table referencing a column of other table
+type ForeignKey struct {
+	Column    string
+	RefSchema string
+	RefTable  string
+	RefColumn string
+}
+
+// ForeignKeys return the foreign keys of a table
+func ForeignKeys(database, schema, table string) (fks []ForeignKey, err error) {
+	db := connection.MustGet()
+	rows, err := db.Query(statements.ForeignKeys, database, schema, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fk ForeignKey
+		if err = rows.Scan(&fk.Column, &fk.RefSchema, &fk.RefTable, &fk.RefColumn); err != nil {
+			return
+		}
+		fks = append(fks, fk)
+	}
+	err = rows.Err()
+	return
+}
+
+// findForeignKey return the foreign key of a nested object field, the field
+// is the foreign key column (or the column without the "_id" suffix) or the
+// referenced table
+func findForeignKey(fks []ForeignKey, field string) (ForeignKey, bool) {
+	for _, fk := range fks {
+		if fk.Column == field || fk.Column == field+"_id" {
+			return fk, true
+		}
+	}
+	for _, fk := range fks {
+		if fk.RefTable == field {
+			return fk, true
+		}
+	}
+	return ForeignKey{}, false
+}
+
+// containsColumn check if column is in cols, quoted names ("Name") are
+// compared without the quotes
+func containsColumn(cols []string, column string) bool {
+	column = identifierName(column)
+	for _, c := range cols {
+		if c == column || identifierName(c) == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nuveo/prest/adapters/postgres/connection"
+)
+
+// sqlMock answer the statements of the adapter with the expectations of the
+// test, in order, to check the generated SQL without a database
+type sqlMock struct {
+	mu       sync.Mutex
+	expected []*expectation
+}
+
+// expectation of a statement (begin, query, exec, commit or rollback)
+type expectation struct {
+	kind    string
+	sql     *regexp.Regexp
+	args    []driver.Value
+	columns []string
+	rows    [][]driver.Value
+	result  driver.Result
+	err     error
+	done    bool
+}
+
+var (
+	mocksMu   sync.Mutex
+	mocks     = make(map[string]*sqlMock)
+	mockCount int64
+)
+
+func init() {
+	sql.Register("prestmock", mockDriver{})
+}
+
+// newMock set a mocked connection, the returned function restores the
+// previous one
+func newMock() (*sqlMock, func()) {
+	mock := &sqlMock{}
+	name := fmt.Sprintf("mock%d", atomic.AddInt64(&mockCount, 1))
+	mocksMu.Lock()
+	mocks[name] = mock
+	mocksMu.Unlock()
+
+	conn, err := sql.Open("prestmock", name)
+	if err != nil {
+		panic(err)
+	}
+	db := sqlx.NewDb(conn, "postgres")
+	previous := connection.Set(db)
+	return mock, func() {
+		connection.Set(previous)
+		db.Close()
+		mocksMu.Lock()
+		delete(mocks, name)
+		mocksMu.Unlock()
+	}
+}
+
+func (m *sqlMock) expect(kind, sql string) *expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &expectation{kind: kind, result: driver.RowsAffected(0)}
+	if sql != "" {
+		e.sql = regexp.MustCompile(sql)
+	}
+	m.expected = append(m.expected, e)
+	return e
+}
+
+// ExpectBegin expect the start of a transaction
+func (m *sqlMock) ExpectBegin() *expectation {
+	return m.expect("begin", "")
+}
+
+// ExpectQuery expect a query matching the sql regular expression
+func (m *sqlMock) ExpectQuery(sql string) *expectation {
+	return m.expect("query", sql)
+}
+
+// ExpectExec expect a statement matching the sql regular expression
+func (m *sqlMock) ExpectExec(sql string) *expectation {
+	return m.expect("exec", sql)
+}
+
+// ExpectCommit expect the commit of the transaction
+func (m *sqlMock) ExpectCommit() *expectation {
+	return m.expect("commit", "")
+}
+
+// ExpectRollback expect the rollback of the transaction
+func (m *sqlMock) ExpectRollback() *expectation {
+	return m.expect("rollback", "")
+}
+
+// ExpectationsWereMet check that all the expected statements ran
+func (m *sqlMock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expected {
+		if !e.done {
+			return fmt.Errorf("%s %v was not run", e.kind, e.sql)
+		}
+	}
+	return nil
+}
+
+// next check the statement against the next expectation
+func (m *sqlMock) next(kind, query string, args []driver.Value) (*expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expected {
+		if e.done {
+			continue
+		}
+		if e.kind != kind || (e.sql != nil && !e.sql.MatchString(query)) {
+			return nil, fmt.Errorf("unexpected %s %q, expecting %s %v", kind, query, e.kind, e.sql)
+		}
+		if e.args != nil && !reflect.DeepEqual(e.args, args) {
+			return nil, fmt.Errorf("unexpected arguments %v of %q, expecting %v", args, query, e.args)
+		}
+		e.done = true
+		return e, e.err
+	}
+	return nil, fmt.Errorf("unexpected %s %q", kind, query)
+}
+
+// WithArgs expect the arguments of the statement
+func (e *expectation) WithArgs(args ...driver.Value) *expectation {
+	e.args = args
+	return e
+}
+
+// WillReturnRows answer the query with the rows
+func (e *expectation) WillReturnRows(columns []string, rows ...[]driver.Value) *expectation {
+	e.columns, e.rows = columns, rows
+	return e
+}
+
+// WillReturnResult answer the statement with the rows affected
+func (e *expectation) WillReturnResult(rowsAffected int64) *expectation {
+	e.result = driver.RowsAffected(rowsAffected)
+	return e
+}
+
+// WillReturnError fail the statement
+func (e *expectation) WillReturnError(err error) *expectation {
+	e.err = err
+	return e
+}
+
+type mockDriver struct{}
+
+func (mockDriver) Open(name string) (driver.Conn, error) {
+	mocksMu.Lock()
+	defer mocksMu.Unlock()
+	mock, ok := mocks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mock %s", name)
+	}
+	return &mockConn{mock: mock}, nil
+}
+
+type mockConn struct {
+	mock *sqlMock
+}
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockStmt{mock: c.mock, query: query}, nil
+}
+
+func (c *mockConn) Close() error {
+	return nil
+}
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	if _, err := c.mock.next("begin", "", nil); err != nil {
+		return nil, err
+	}
+	return &mockTx{mock: c.mock}, nil
+}
+
+type mockTx struct {
+	mock *sqlMock
+}
+
+func (t *mockTx) Commit() error {
+	_, err := t.mock.next("commit", "", nil)
+	return err
+}
+
+func (t *mockTx) Rollback() error {
+	_, err := t.mock.next("rollback", "", nil)
+	return err
+}
+
+type mockStmt struct {
+	mock  *sqlMock
+	query string
+}
+
+func (s *mockStmt) Close() error {
+	return nil
+}
+
+func (s *mockStmt) NumInput() int {
+	return -1
+}
+
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	e, err := s.mock.next("exec", s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return e.result, nil
+}
+
+func (s *mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	e, err := s.mock.next("query", s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &mockRows{columns: e.columns, rows: e.rows}, nil
+}
+
+type mockRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *mockRows) Columns() []string {
+	return r.columns
+}
+
+func (r *mockRows) Close() error {
+	return nil
+}
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nuveo/prest/config"
+)
+
+type ownerKey struct{}
+
+// ErrNoOwner error of the requests to tables with an owner column without an
+// authenticated subject
+var ErrNoOwner = errors.New("No owner for the request")
+
+// WithOwner return a context with the subject (user or API key) owning the
+// rows of the request
+func WithOwner(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, subject)
+}
+
+// OwnerFromContext return the subject owning the rows of the request
+func OwnerFromContext(ctx context.Context) (subject string, ok bool) {
+	subject, ok = ctx.Value(ownerKey{}).(string)
+	return
+}
+
+// OwnerColumn return the column of the table bound to the subject of the
+// requests, of the first rule matching the table with an owner
+func OwnerColumn(cfg *config.Prest, database, schema, table string) string {
+	for _, t := range cfg.AccessConf.Tables {
+		if t.Owner != "" && t.Matches(database, schema, table) {
+			return t.Owner
+		}
+	}
+	return ""
+}
+
+// owner return the owner column of the table and the subject of the request,
+// the column is empty when the table has no owner
+func owner(r *http.Request, database, schema, table string) (column, subject string, err error) {
+	column = OwnerColumn(config.FromContext(r.Context()), database, schema, table)
+	if column == "" {
+		return
+	}
+	subject, ok := OwnerFromContext(r.Context())
+	if !ok || subject == "" {
+		err = ErrNoOwner
+	}
+	return
+}
+
+// OwnerByRequest return the filter of the rows of the table owned by the
+// subject of the request, empty when the table has no owner column
+func OwnerByRequest(r *http.Request, database, schema, table string, initialPlaceholderID int) (where string, values []interface{}, err error) {
+	column, subject, err := owner(r, database, schema, table)
+	if err != nil || column == "" {
+		return
+	}
+	where = fmt.Sprintf("%s.%s=$%d", quoteName(table), quoteName(column), initialPlaceholderID)
+	values = []interface{}{subject}
+	return
+}
+
+// OwnerRequest set the owner column of the body of inserts and updates to the
+// subject of the request, the rows can't be given to other subjects
+func OwnerRequest(r *http.Request, database, schema, table string, data map[string]interface{}) (map[string]interface{}, error) {
+	column, subject, err := owner(r, database, schema, table)
+	if err != nil || column == "" {
+		return data, err
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	for key := range data {
+		if identifierName(key) == column {
+			delete(data, key)
+		}
+	}
+	data[column] = subject
+	return data, nil
+}
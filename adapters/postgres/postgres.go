@@ -1,19 +1,27 @@
 package postgres
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
-	"unicode"
+	"time"
 
 	"database/sql"
 
+	"github.com/lib/pq"
 	"github.com/nuveo/prest/adapters/postgres/connection"
 	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/apikey"
+	"github.com/nuveo/prest/authz"
 	"github.com/nuveo/prest/config"
 	"github.com/nuveo/prest/statements"
 )
@@ -24,28 +32,60 @@ const (
 	defaultPageSize = 10
 )
 
-// chkInvalidIdentifier return true if identifier is invalid
-func chkInvalidIdentifier(identifer string) bool {
-	if len(identifer) > 63 ||
-		unicode.IsDigit([]rune(identifer)[0]) {
-		return true
-	}
+var nullOperators = map[string]string{
+	"$null":    "IS NULL",
+	"$notnull": "IS NOT NULL",
+}
 
-	for _, v := range identifer {
-		if !unicode.IsLetter(v) &&
-			!unicode.IsDigit(v) &&
-			v != '_' &&
-			v != '.' {
-			return true
-		}
+// filterOperators are used in filter values with the `$operator.value` syntax
+var filterOperators = map[string]string{
+	"contains":    "%s @> $%d",
+	"containedby": "%s <@ $%d",
+	"overlap":     "%s && $%d",
+	"overlaps":    "%s && $%d",
+	"any":         "%s = ANY($%d)",
+	"jcontains":   "%s @> $%d::jsonb",
+	"jhas":        "%s ? $%d",
+	"jhasany":     "%s ?| $%d",
+	"jhasall":     "%s ?& $%d",
+	"regex":       "%s ~ $%d",
+	"iregex":      "%s ~* $%d",
+}
+
+// arrayOperators bind their value as postgres array literal
+var arrayOperators = map[string]bool{
+	"contains":    true,
+	"containedby": true,
+	"overlap":     true,
+	"overlaps":    true,
+	"any":         true,
+	"jhasany":     true,
+	"jhasall":     true,
+}
+
+// jsonbField parse the `field->>key` syntax of jsonb fields, the key is
+// returned escaped to be used in a SQL string ('key')
+func jsonbField(s string) (field, key string, err error) {
+	jsonField := strings.Split(s, "->>")
+	if len(jsonField) != 2 ||
+		jsonField[0] == "" || jsonField[1] == "" ||
+		chkInvalidIdentifier(jsonField[0]) {
+		err = errors.New("Invalid identifier")
+		return
 	}
-	return false
+	names, err := splitIdentifier(jsonField[1])
+	if err != nil || len(names) != 1 {
+		err = errors.New("Invalid identifier")
+		return
+	}
+	return jsonField[0], strings.Replace(names[0], "'", "''", -1), nil
 }
 
 // WhereByRequest create interface for queries + where
 func WhereByRequest(r *http.Request, initialPlaceholderID int) (whereSyntax string, values []interface{}, err error) {
 	whereKey := []string{}
-	whereValues := []string{}
+	whereValues := []interface{}{}
+	nullKey := []string{}
 
 	pid := initialPlaceholderID
 	for key, val := range r.URL.Query() {
@@ -54,30 +94,72 @@ func WhereByRequest(r *http.Request, initialPlaceholderID int) (whereSyntax stri
 			if len(keyInfo) > 1 {
 				switch keyInfo[1] {
 				case "jsonb":
-					jsonField := strings.Split(keyInfo[0], "->>")
-					if chkInvalidIdentifier(jsonField[0]) ||
-						chkInvalidIdentifier(jsonField[1]) {
-						err = errors.New("Invalid identifier")
+					var field, jsonKey string
+					field, jsonKey, err = jsonbField(keyInfo[0])
+					if err != nil {
+						return
+					}
+					if field, err = quoteIdentifier(field); err != nil {
 						return
 					}
-					whereKey = append(whereKey, fmt.Sprintf("%s->>'%s'=$%d", jsonField[0], jsonField[1], pid))
+					whereKey = append(whereKey, fmt.Sprintf("%s->>'%s'=$%d", field, jsonKey, pid))
 					whereValues = append(whereValues, val[0])
 				default:
-					if chkInvalidIdentifier(keyInfo[0]) {
-						err = errors.New("Invalid identifier")
+					var column string
+					if column, err = quoteIdentifier(keyInfo[0]); err != nil {
 						return
 					}
-					whereKey = append(whereKey, fmt.Sprintf("%s=$%d", keyInfo[0], pid))
+					whereKey = append(whereKey, fmt.Sprintf("%s=$%d", column, pid))
 					whereValues = append(whereValues, val[0])
 				}
+				pid++
+				continue
+			}
+			column, errQuote := quoteIdentifier(key)
+			if errQuote != nil {
+				err = errQuote
+				return
+			}
+
+			// IS NULL filters don't bind any value, useful for anti-joins
+			if nullCheck, ok := nullOperators[val[0]]; ok {
+				nullKey = append(nullKey, fmt.Sprintf("%s %s", column, nullCheck))
+				continue
+			}
+
+			clause, interval, ok, errDate := relativeDate(column, val[0], pid)
+			if errDate != nil {
+				err = errDate
+				return
+			}
+			if ok {
+				whereKey = append(whereKey, clause)
+				whereValues = append(whereValues, interval)
+				pid++
 				continue
 			}
-			if chkInvalidIdentifier(key) {
-				err = errors.New("Invalid identifier")
+
+			// lists bind each value as its own placeholder
+			clause, list, ok, errList := inFilter(column, val[0], pid)
+			if errList != nil {
+				err = errList
 				return
 			}
+			if ok {
+				whereKey = append(whereKey, clause)
+				whereValues = append(whereValues, list...)
+				pid += len(list)
+				continue
+			}
+
+			if clause, value, ok := filterByOperator(column, val[0], pid); ok {
+				whereKey = append(whereKey, clause)
+				whereValues = append(whereValues, value)
+				pid++
+				continue
+			}
 
-			whereKey = append(whereKey, fmt.Sprintf("%s=$%d", key, pid))
+			whereKey = append(whereKey, fmt.Sprintf("%s=$%d", column, pid))
 			whereValues = append(whereValues, val[0])
 
 			pid++
@@ -90,13 +172,161 @@ func WhereByRequest(r *http.Request, initialPlaceholderID int) (whereSyntax stri
 		} else {
 			whereSyntax += " AND " + whereKey[i]
 		}
+	}
+	values = append(values, whereValues...)
+
+	for _, n := range nullKey {
+		if whereSyntax == "" {
+			whereSyntax += n
+		} else {
+			whereSyntax += " AND " + n
+		}
+	}
+
+	return
+}
+
+// filterByOperator parse filter values like `$contains.{go,rest}` or `$jhas.key` and
+// return the where clause and the value to bind
+func filterByOperator(key, value string, pid int) (clause, bind string, ok bool) {
+	if !strings.HasPrefix(value, "$") {
+		return
+	}
+	opArgs := strings.SplitN(value[1:], ".", 2)
+	if len(opArgs) != 2 {
+		return
+	}
+	format, ok := filterOperators[opArgs[0]]
+	if !ok {
+		return
+	}
+	clause = fmt.Sprintf(format, key, pid)
+	bind = opArgs[1]
+	// range literals as [1,10) are bound as is
+	if arrayOperators[opArgs[0]] && !rangeFilter(bind) {
+		bind = arrayLiteral(bind)
+	}
+	return
+}
+
+// rangeFilter identify range literals, "[1,10)" or "(,5]", in the values
+// of the array operators
+func rangeFilter(value string) bool {
+	return len(value) > 2 &&
+		strings.ContainsAny(value[:1], "[(") &&
+		strings.ContainsAny(value[len(value)-1:], "])") &&
+		strings.Contains(value, ",")
+}
+
+// inOperators are the operators of lists, `$in.a,b` and `$nin.a,b`, the
+// clauses of empty lists
+var inOperators = map[string]struct{ op, empty string }{
+	"in":  {"IN", "false"},
+	"nin": {"NOT IN", "true"},
+}
+
+// inFilter parse filter values like `$in.1,2,3` or `$nin."a,b",c` and return
+// the where clause with one placeholder per value. Values are comma separated
+// as CSV, quoted values may have commas and doubled quotes ("a ""b"", c").
+// Empty lists match no row in `$in` and every row in `$nin`
+func inFilter(key, value string, pid int) (clause string, values []interface{}, ok bool, err error) {
+	if !strings.HasPrefix(value, "$") {
+		return
+	}
+	opArgs := strings.SplitN(value[1:], ".", 2)
+	operator, found := inOperators[opArgs[0]]
+	if len(opArgs) != 2 || !found {
+		return
+	}
+	ok = true
+	if opArgs[1] == "" {
+		clause = operator.empty
+		return
+	}
+
+	reader := csv.NewReader(strings.NewReader(opArgs[1]))
+	reader.LazyQuotes = true
+	records, errCSV := reader.ReadAll()
+	if errCSV != nil || len(records) != 1 {
+		err = fmt.Errorf("Invalid list in $%s", opArgs[0])
+		return
+	}
+
+	placeholders := make([]string, len(records[0]))
+	for i, v := range records[0] {
+		placeholders[i] = fmt.Sprintf("$%d", pid+i)
+		values = append(values, v)
+	}
+	clause = fmt.Sprintf("%s %s (%s)", key, operator.op, strings.Join(placeholders, ", "))
+	return
+}
+
+// relativeUnits are the units of relative dates, m are minutes and M months
+var relativeUnits = map[byte]string{
+	's': "seconds",
+	'm': "minutes",
+	'h': "hours",
+	'd': "days",
+	'w': "weeks",
+	'M': "months",
+	'y': "years",
+}
 
-		values = append(values, whereValues[i])
+// relativeDate parse filter values like `$gte:now-7d` or `$lt:now-1d+2h` and
+// return the where clause comparing with now() and the interval to bind (e.g.
+// "-7 days"), ok is false when the value isn't a relative date
+func relativeDate(key, value string, pid int) (clause, interval string, ok bool, err error) {
+	opArgs := strings.SplitN(value, ":", 2)
+	if len(opArgs) != 2 || !strings.HasPrefix(opArgs[0], "$") || !strings.HasPrefix(opArgs[1], "now") {
+		return
+	}
+	op, errOp := GetQueryOperator(opArgs[0])
+	if errOp != nil || op == "IN" || op == "NOT IN" {
+		return
+	}
+
+	terms := []string{}
+	expr := opArgs[1][len("now"):]
+	for len(expr) > 0 {
+		// + is decoded as space in query strings
+		sign := expr[0]
+		if sign == ' ' {
+			sign = '+'
+		}
+		digits := 1
+		for digits < len(expr) && expr[digits] >= '0' && expr[digits] <= '9' {
+			digits++
+		}
+		if (sign != '+' && sign != '-') || digits == 1 || digits == len(expr) {
+			err = fmt.Errorf("Invalid relative date %s", opArgs[1])
+			return
+		}
+		unit, found := relativeUnits[expr[digits]]
+		if !found {
+			err = fmt.Errorf("Invalid relative date %s", opArgs[1])
+			return
+		}
+		terms = append(terms, fmt.Sprintf("%c%s %s", sign, expr[1:digits], unit))
+		expr = expr[digits+1:]
+	}
+	if len(terms) == 0 {
+		terms = append(terms, "0 seconds")
 	}
 
+	clause = fmt.Sprintf("%s %s now() + $%d::interval", key, op, pid)
+	interval = strings.Join(terms, " ")
+	ok = true
 	return
 }
 
+// arrayLiteral wrap comma separated values as postgres array literal
+func arrayLiteral(value string) string {
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+		return value
+	}
+	return fmt.Sprintf("{%s}", value)
+}
+
 // DatabaseClause return a SELECT `query`
 func DatabaseClause(req *http.Request) (query string) {
 	queries := req.URL.Query()
@@ -124,317 +354,1687 @@ func SchemaClause(req *http.Request) (query string) {
 }
 
 // JoinByRequest implements join in queries
-func JoinByRequest(r *http.Request) (values []string, err error) {
-	joinValues := []string{}
+func JoinByRequest(r *http.Request, database, schema string, initialPlaceholderID int) (joins []string, values []interface{}, err error) {
 	joinStatements := r.URL.Query()["_join"]
+	cfg := config.FromContext(r.Context())
 
+	pid := initialPlaceholderID
 	for _, j := range joinStatements {
-		joinArgs := strings.Split(j, ":")
+		joinArgs := strings.SplitN(j, ":", 3)
+
+		var joinType string
+		joinType, err = GetJoinType(joinArgs[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if joinType == "CROSS" {
+			if len(joinArgs) != 2 {
+				err = errors.New("Invalid number of arguments in join statement")
+				return nil, nil, err
+			}
+			var joinName string
+			if joinName, err = quoteIdentifier(joinArgs[1]); err != nil {
+				return nil, nil, err
+			}
+			joinDatabase, joinSchema, joinTable := qualifiedTable(database, schema, joinArgs[1])
+			if !TablePermissions(cfg, joinDatabase, joinSchema, joinTable, "read") {
+				err = errors.New("Insuficient table permissions")
+				return nil, nil, err
+			}
+			joins = append(joins, fmt.Sprintf(" CROSS JOIN %s ", joinName))
+			continue
+		}
 
-		if len(joinArgs) != 5 {
+		if len(joinArgs) != 3 {
 			err = errors.New("Invalid number of arguments in join statement")
-			return nil, err
+			return nil, nil, err
 		}
 
-		op, err := GetQueryOperator(joinArgs[3])
-		if err != nil {
-			return nil, err
+		var joinName string
+		if joinName, err = quoteIdentifier(joinArgs[1]); err != nil {
+			return nil, nil, err
+		}
+
+		// joined relations follow the same access rules of the main table
+		joinDatabase, joinSchema, joinTable := qualifiedTable(database, schema, joinArgs[1])
+		if !TablePermissions(cfg, joinDatabase, joinSchema, joinTable, "read") {
+			err = errors.New("Insuficient table permissions")
+			return nil, nil, err
+		}
+
+		// multiple ON predicates are separated by comma and joined with AND
+		onPredicates := []string{}
+		var conds []string
+		if conds, err = splitJoin(joinArgs[2], ','); err != nil {
+			return nil, nil, err
+		}
+		for _, cond := range conds {
+			var condArgs []string
+			if condArgs, err = splitJoin(cond, ':'); err != nil {
+				return nil, nil, err
+			}
+			if len(condArgs) != 3 {
+				err = errors.New("Invalid number of arguments in join statement")
+				return nil, nil, err
+			}
+
+			var left string
+			if left, err = quoteIdentifier(condArgs[0]); err != nil {
+				return nil, nil, err
+			}
+
+			var op string
+			op, err = GetQueryOperator(condArgs[1])
+			if err != nil {
+				return nil, nil, err
+			}
+
+			right := condArgs[2]
+			if constant, ok := joinConstant(right); ok {
+				values = append(values, constant)
+				right = fmt.Sprintf("$%d", pid)
+				pid++
+			} else if right, err = quoteIdentifier(right); err != nil {
+				return nil, nil, err
+			}
+
+			// IN of a single value, the lists are separated by comma as the predicates
+			if op == "IN" || op == "NOT IN" {
+				right = fmt.Sprintf("(%s)", right)
+			}
+			onPredicates = append(onPredicates, fmt.Sprintf("%s %s %s", left, op, right))
 		}
 
-		joinQuery := fmt.Sprintf(" %s JOIN %s ON %s %s %s ", strings.ToUpper(joinArgs[0]), joinArgs[1], joinArgs[2], op, joinArgs[4])
-		joinValues = append(joinValues, joinQuery)
+		joinQuery := fmt.Sprintf(" %s JOIN %s ON %s ", joinType, joinName, strings.Join(onPredicates, " AND "))
+		joins = append(joins, joinQuery)
 	}
 
-	return joinValues, nil
+	return
 }
 
-func SelectFields(fields []string) (string, error) {
-	if len(fields) == 0 {
+// splitJoin split the join conditions by sep out of the quoted strings and
+// identifiers, so the constants can have commas and colons
+func splitJoin(value string, sep byte) (parts []string, err error) {
+	var quote byte
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			// a doubled quote is an escaped one, it is closed and opened again
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		err = errors.New("Invalid join condition, unterminated quote")
+		return
+	}
+	parts = append(parts, value[start:])
+	return
+}
+
+// joinConstant identify constants (quoted strings or numbers) in join
+// conditions, the quotes in the strings are doubled as in SQL
+func joinConstant(value string) (string, bool) {
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		constant := value[1 : len(value)-1]
+		if strings.Contains(strings.Replace(constant, "''", "", -1), "'") {
+			return "", false
+		}
+		return strings.Replace(constant, "''", "'", -1), true
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value, true
+	}
+	return "", false
+}
+
+// GetJoinType validate the join type requested and return its SQL form
+func GetJoinType(joinType string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(joinType)) {
+	case "inner":
+		return "INNER", nil
+	case "left":
+		return "LEFT", nil
+	case "right":
+		return "RIGHT", nil
+	case "full", "outer":
+		return "FULL OUTER", nil
+	case "cross":
+		return "CROSS", nil
+	}
+
+	err := errors.New("Invalid join type")
+	return "", err
+}
+
+func SelectFields(fields []string, expressions ...string) (string, error) {
+	if len(fields) == 0 && len(expressions) == 0 {
 		return "", errors.New("You must select at least one field.")
 	}
-	return fmt.Sprintf("SELECT %s FROM", strings.Join(fields, ",")), nil
+	quoted, err := quoteSelect(fields)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("SELECT %s FROM", strings.Join(append(quoted, expressions...), ",")), nil
+}
+
+// quoteFields validate the fields of the request returning them as used in
+// SQL, quoted when they need
+func quoteFields(fields []string) ([]string, error) {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		var err error
+		if quoted[i], err = quoteIdentifier(field); err != nil {
+			return nil, err
+		}
+	}
+	return quoted, nil
+}
+
+// DistinctByRequest implements DISTINCT and DISTINCT ON in queries
+func DistinctByRequest(r *http.Request, database, schema, table string) (distinct string, err error) {
+	queries := r.URL.Query()
+
+	distinctOn := queries.Get("_distinct_on")
+	if distinctOn != "" {
+		fields := strings.Split(distinctOn, ",")
+		var quoted []string
+		if quoted, err = quoteFields(fields); err != nil {
+			return
+		}
+
+		permitted := FieldsPermissions(config.FromContext(r.Context()), database, schema, table, fields, "read")
+		if len(permitted) != len(fields) {
+			err = errors.New("Insuficient field permissions in distinct on")
+			return
+		}
+
+		distinct = fmt.Sprintf("DISTINCT ON (%s)", strings.Join(quoted, ", "))
+		return
+	}
+
+	if queries.Get("_distinct") == "true" {
+		distinct = "DISTINCT"
+	}
+	return
+}
+
+// orderNulls NULLS FIRST/LAST modifiers of `_order` fields
+var orderNulls = map[string]string{
+	"nullsfirst": " NULLS FIRST",
+	"nullslast":  " NULLS LAST",
 }
 
-// OrderByRequest implements ORDER BY in queries
-func OrderByRequest(r *http.Request) (string, error) {
+// OrderByRequest implements ORDER BY in queries, when validColumns is not nil
+// every unqualified field must be one of them. Fields accept the `-` prefix
+// (DESC) and the `:nullsfirst`/`:nullslast` suffix, when table is informed
+// the fields must be readable by FieldsPermissions
+func OrderByRequest(r *http.Request, database, schema, table string, validColumns []string) (string, error) {
 	var values string
 	reqOrder := r.URL.Query()["_order"]
 
 	if len(reqOrder) > 0 {
-		values = " ORDER BY "
-
 		// get last order in request url
 		ordering := reqOrder[len(reqOrder)-1]
 		orderingArr := strings.Split(ordering, ",")
 
-		for i, s := range orderingArr {
+		fields := []string{}
+		for _, s := range orderingArr {
 			field := s
+			direction := ""
+			nulls := ""
+
+			if i := strings.Index(field, ":"); i != -1 {
+				var ok bool
+				nulls, ok = orderNulls[field[i+1:]]
+				if !ok {
+					return "", fmt.Errorf("Invalid order modifier: %s", field[i+1:])
+				}
+				field = field[:i]
+			}
 
-			if strings.HasPrefix(s, "-") {
-				field = fmt.Sprintf("%s DESC", s[1:])
+			if strings.HasPrefix(field, "-") {
+				field = field[1:]
+				direction = " DESC"
 			}
 
-			values = fmt.Sprintf("%s %s", values, field)
+			// jsonb fields are sorted by the `field->>key` text value
+			jsonKey := ""
+			if strings.Contains(field, "->>") {
+				var err error
+				field, jsonKey, err = jsonbField(field)
+				if err != nil {
+					return "", err
+				}
+			}
+
+			names, err := splitIdentifier(field)
+			if err != nil {
+				return "", err
+			}
+
+			// qualified fields may reference joined relations
+			if validColumns != nil && len(names) == 1 && !containsColumn(validColumns, names[0]) {
+				return "", fmt.Errorf("Unknown column in order: %s", field)
+			}
+
+			if table != "" {
+				fieldDatabase, fieldSchema, fieldTable := database, schema, table
+				if len(names) > 1 {
+					fieldDatabase, fieldSchema, fieldTable = qualifiedNames(database, schema, names[:len(names)-1])
+				}
+				if len(FieldsPermissions(config.FromContext(r.Context()), fieldDatabase, fieldSchema, fieldTable, names[len(names)-1:], "read")) == 0 {
+					return "", fmt.Errorf("You don't have permission to order by: %s", field)
+				}
+			}
 
-			// if have next order, append a comma
-			if i < len(orderingArr)-1 {
-				values = fmt.Sprintf("%s ,", values)
+			quoted, _ := quoteIdentifier(field)
+			if jsonKey != "" {
+				quoted = fmt.Sprintf("%s->>'%s'", quoted, jsonKey)
 			}
+			fields = append(fields, quoted+direction+nulls)
 		}
+
+		values = fmt.Sprintf(" ORDER BY %s", strings.Join(fields, ", "))
 	}
 	return values, nil
 }
 
-// CountByRequest implements COUNT(fields) OPERTATION
-func CountByRequest(req *http.Request) (countQuery string) {
-	queries := req.URL.Query()
-	countFields := queries.Get("_count")
+// GroupByRequest implements GROUP BY in queries
+func GroupByRequest(r *http.Request, database, schema, table string) (groupBySQL string, err error) {
+	groupBy := r.URL.Query().Get("_groupby")
+	if groupBy == "" {
+		return
+	}
+
+	fields := strings.Split(groupBy, ",")
+	quoted, err := quoteFields(fields)
+	if err != nil {
+		return
+	}
 
-	if countFields == "" {
+	permitted := FieldsPermissions(config.FromContext(r.Context()), database, schema, table, fields, "read")
+	if len(permitted) != len(fields) {
+		err = errors.New("Insuficient field permissions in group by")
 		return
 	}
-	countQuery = fmt.Sprintf("SELECT COUNT(%s) FROM", countFields)
 
+	groupBySQL = fmt.Sprintf(" GROUP BY %s", strings.Join(quoted, ", "))
 	return
 }
 
-// Query process queries
-func Query(SQL string, params ...interface{}) (jsonData []byte, err error) {
-	validQuery := chkInvalidIdentifier(SQL)
-	if !validQuery {
-		err = errors.New("Invalid characters in the query")
-		return
+// aggregateKeys are the aggregate functions accepted in the request
+var aggregateKeys = []string{"_sum", "_avg", "_min", "_max"}
+
+// Aggregated check if the request selects aggregates
+func Aggregated(r *http.Request) bool {
+	queries := r.URL.Query()
+	for _, key := range aggregateKeys {
+		if queries.Get(key) != "" {
+			return true
+		}
 	}
+	return false
+}
 
-	db := connection.MustGet()
+// AggregateByRequest implements SUM, AVG, MIN and MAX in queries, returning the
+// columns and the aggregate expressions to select. When no column was
+// requested the group by fields are used
+func AggregateByRequest(r *http.Request, database, schema, table string, cols []string) (fields, aggregates []string, err error) {
+	queries := r.URL.Query()
 
-	prepare, err := db.Prepare(SQL)
+	for _, key := range aggregateKeys {
+		aggFields := queries.Get(key)
+		if aggFields == "" {
+			continue
+		}
 
-	if err != nil {
-		return
-	}
+		aggregated := strings.Split(aggFields, ",")
+		var quoted []string
+		if quoted, err = quoteFields(aggregated); err != nil {
+			return
+		}
 
-	rows, err := prepare.Query(params...)
-	if err != nil {
-		return
-	}
-	defer rows.Close()
+		permitted := FieldsPermissions(config.FromContext(r.Context()), database, schema, table, aggregated, "read")
+		if len(permitted) != len(aggregated) {
+			err = errors.New("Insuficient field permissions in aggregate")
+			return
+		}
 
-	columns, err := rows.Columns()
-	if err != nil {
-		return
+		fn := strings.TrimPrefix(key, "_")
+		for i, field := range aggregated {
+			aggregates = append(aggregates, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(fn), quoted[i], aggregateAlias(fn, field)))
+		}
 	}
 
-	count := len(columns)
-	tableData := make([]map[string]interface{}, 0)
-	values := make([]interface{}, count)
-	valuePtrs := make([]interface{}, count)
-	for rows.Next() {
-		for i := 0; i < count; i++ {
-			valuePtrs[i] = &values[i]
-		}
-		rows.Scan(valuePtrs...)
-		entry := make(map[string]interface{})
-		for i, col := range columns {
-			var v interface{}
-			val := values[i]
-			b, ok := val.([]byte)
-			if ok {
-				v = string(b)
-			} else {
-				v = val
+	fields = cols
+	if len(aggregates) > 0 && len(cols) == 1 && cols[0] == "*" {
+		fields = []string{}
+		if groupBy := queries.Get("_groupby"); groupBy != "" {
+			if fields, err = quoteFields(strings.Split(groupBy, ",")); err != nil {
+				return
 			}
-			entry[col] = v
 		}
-		tableData = append(tableData, entry)
 	}
-	jsonData, err = json.Marshal(tableData)
-
 	return
 }
 
-// QueryCount process queries with count
-func QueryCount(SQL string, params ...interface{}) ([]byte, error) {
-	validQuery := chkInvalidIdentifier(SQL)
-	if !validQuery {
-		return nil, errors.New("Invalid characters in the query")
+// aggregateAlias return the alias of the aggregate of a field, as sum_amount
+// or sum_orders_amount of orders.amount
+func aggregateAlias(fn, field string) string {
+	return quoteName(fn + "_" + strings.Replace(identifierName(field), ".", "_", -1))
+}
+
+// metricFunctions are the functions accepted in aggregate metrics
+var metricFunctions = map[string]string{
+	"count": "COUNT",
+	"sum":   "SUM",
+	"avg":   "AVG",
+	"min":   "MIN",
+	"max":   "MAX",
+}
+
+// DuplicatesByRequest parse `by=email,phone`, the columns compared to find
+// duplicated rows
+func DuplicatesByRequest(r *http.Request, database, schema, table string) (by []string, err error) {
+	value := r.URL.Query().Get("by")
+	if value == "" {
+		err = errors.New("You must inform the columns in by")
+		return
 	}
 
-	db := connection.MustGet()
-	prepare, err := db.Prepare(SQL)
-	if err != nil {
-		return nil, err
+	fields := strings.Split(value, ",")
+	if by, err = quoteFields(fields); err != nil {
+		return
+	}
+	if len(FieldsPermissions(config.FromContext(r.Context()), database, schema, table, fields, "read")) != len(fields) {
+		err = errors.New("Insuficient field permissions in by")
 	}
+	return
+}
 
-	var result struct {
-		Count int64 `json:"count"`
+// MetricsByRequest parse `metrics=sum:amount,count:*` and `by=status` returning
+// the columns and the aggregate expressions to select and the GROUP BY clause
+func MetricsByRequest(r *http.Request, database, schema, table string) (cols, aggregates []string, groupBySQL string, err error) {
+	queries := r.URL.Query()
+	metrics := queries.Get("metrics")
+	if metrics == "" {
+		err = errors.New("You must inform at least one metric")
+		return
 	}
 
-	row := prepare.QueryRow(params...)
-	if err := row.Scan(&result.Count); err != nil {
-		return nil, err
+	by := []string{}
+	if queries.Get("by") != "" {
+		by = strings.Split(queries.Get("by"), ",")
 	}
 
-	return json.Marshal(result)
-}
+	fields := append([]string{}, by...)
+	if by, err = quoteFields(by); err != nil {
+		return
+	}
 
-// PaginateIfPossible func
-func PaginateIfPossible(r *http.Request) (paginatedQuery string, err error) {
-	values := r.URL.Query()
-	if _, ok := values[pageNumberKey]; !ok {
-		paginatedQuery = ""
+	for _, m := range strings.Split(metrics, ",") {
+		metricArgs := strings.Split(m, ":")
+		if len(metricArgs) != 2 {
+			err = errors.New("Invalid metric, use function:field")
+			return
+		}
+		fn, ok := metricFunctions[metricArgs[0]]
+		if !ok {
+			err = fmt.Errorf("Invalid metric function: %s", metricArgs[0])
+			return
+		}
+
+		field := metricArgs[1]
+		if field == "*" {
+			if fn != "COUNT" {
+				err = errors.New("Only count accepts *")
+				return
+			}
+			aggregates = append(aggregates, "COUNT(*) AS count_all")
+			continue
+		}
+		var quoted string
+		if quoted, err = quoteIdentifier(field); err != nil {
+			return
+		}
+		fields = append(fields, field)
+		aggregates = append(aggregates, fmt.Sprintf("%s(%s) AS %s", fn, quoted, aggregateAlias(metricArgs[0], field)))
+	}
+
+	if len(fields) > 0 {
+		permitted := FieldsPermissions(config.FromContext(r.Context()), database, schema, table, fields, "read")
+		if len(permitted) != len(fields) {
+			err = errors.New("Insuficient field permissions in metrics")
+			return
+		}
+	}
+
+	cols = by
+	if len(by) > 0 {
+		groupBySQL = fmt.Sprintf(" GROUP BY %s", strings.Join(by, ", "))
+	}
+	return
+}
+
+// bucketUnits are the units accepted in time series buckets, in seconds
+var bucketUnits = map[byte]int{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+}
+
+// TimeseriesByRequest parse `bucket=1h&ts=created_at&metric=count:*` returning
+// a query with one row per bucket, buckets without rows are filled with zero
+func TimeseriesByRequest(r *http.Request, database, schema, table, from, where string) (query string, err error) {
+	queries := r.URL.Query()
+
+	bucket := queries.Get("bucket")
+	if len(bucket) < 2 {
+		err = errors.New("Invalid bucket, use a number followed by s, m, h, d or w")
+		return
+	}
+	unit, ok := bucketUnits[bucket[len(bucket)-1]]
+	if !ok {
+		err = errors.New("Invalid bucket, use a number followed by s, m, h, d or w")
+		return
+	}
+	size, err := strconv.Atoi(bucket[:len(bucket)-1])
+	if err != nil || size <= 0 {
+		err = errors.New("Invalid bucket, use a number followed by s, m, h, d or w")
+		return
+	}
+	seconds := size * unit
+
+	fields := []string{queries.Get("ts")}
+	ts, err := quoteIdentifier(fields[0])
+	if err != nil {
+		return
+	}
+
+	metric := queries.Get("metric")
+	if metric == "" {
+		metric = "count:*"
+	}
+	metricArgs := strings.Split(metric, ":")
+	if len(metricArgs) != 2 {
+		err = errors.New("Invalid metric, use function:field")
+		return
+	}
+	fn, ok := metricFunctions[metricArgs[0]]
+	if !ok {
+		err = fmt.Errorf("Invalid metric function: %s", metricArgs[0])
+		return
+	}
+	field := metricArgs[1]
+	if field == "*" {
+		if fn != "COUNT" {
+			err = errors.New("Only count accepts *")
+			return
+		}
+	} else {
+		fields = append(fields, field)
+		if field, err = quoteIdentifier(field); err != nil {
+			return
+		}
+	}
+
+	permitted := FieldsPermissions(config.FromContext(r.Context()), database, schema, table, fields, "read")
+	if len(permitted) != len(fields) {
+		err = errors.New("Insuficient field permissions in time series")
+		return
+	}
+
+	if where != "" {
+		where = fmt.Sprint(" WHERE ", where)
+	}
+	query = fmt.Sprintf(statements.Timeseries, ts, seconds, seconds, fn, field, from, where, seconds)
+	return
+}
+
+// CountByRequest implements COUNT(fields) OPERTATION, the field (or "*")
+// must be readable by FieldsPermissions
+func CountByRequest(req *http.Request, database, schema, table string) (countQuery string, err error) {
+	queries := req.URL.Query()
+	countField := queries.Get("_count")
+
+	if countField == "" {
+		return
+	}
+	if countField == "*" {
+		countQuery = "SELECT COUNT(*) FROM"
 		return
 	}
-	pageNumber, err := strconv.Atoi(values[pageNumberKey][0])
+	fields := []string{countField}
+	quoted, err := quoteFields(fields)
 	if err != nil {
 		return
 	}
-	pageSize := defaultPageSize
+	permitted := FieldsPermissions(config.FromContext(req.Context()), database, schema, table, fields, "read")
+	if len(permitted) != len(fields) {
+		err = errors.New("Insuficient field permissions in count")
+		return
+	}
+	countQuery = fmt.Sprintf("SELECT COUNT(%s) FROM", quoted[0])
+	return
+}
+
+// Query process queries, identical concurrent queries (same SQL and params)
+// share one execution
+func Query(SQL string, params ...interface{}) (jsonData []byte, err error) {
+	return coalesce(queryKey(SQL, params), func() ([]byte, error) {
+		return query(SQL, params...)
+	})
+}
+
+// Available check if the database answers
+func Available() (ok bool) {
+	// the first connection panics when the database is unreachable
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return connection.MustGet().Ping() == nil
+}
+
+// PoolStats return the stats of the connection pool
+func PoolStats() sql.DBStats {
+	return connection.MustGet().Stats()
+}
+
+func query(SQL string, params ...interface{}) (jsonData []byte, err error) {
+	return queryWith(connection.MustGet(), SQL, params...)
+}
+
+// preparer is a connection or transaction
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+func queryWith(db preparer, SQL string, params ...interface{}) (jsonData []byte, err error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		err = errors.New("Invalid characters in the query")
+		return
+	}
+
+	prepare, err := db.Prepare(SQL)
+
+	if err != nil {
+		return
+	}
+
+	rows, err := prepare.Query(params...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+
+	count := len(columns)
+	tableData := make([]map[string]interface{}, 0)
+	values := make([]interface{}, count)
+	valuePtrs := make([]interface{}, count)
+	for rows.Next() {
+		for i := 0; i < count; i++ {
+			valuePtrs[i] = &values[i]
+		}
+		rows.Scan(valuePtrs...)
+		entry := make(map[string]interface{})
+		for i, col := range columns {
+			var v interface{}
+			val := values[i]
+			b, ok := val.([]byte)
+			if ok {
+				v = string(b)
+			} else {
+				v = val
+			}
+			entry[col] = v
+		}
+		tableData = append(tableData, entry)
+	}
+	jsonData, err = json.Marshal(tableData)
+
+	return
+}
+
+// QueryCSV process queries writing the rows as CSV with a header line
+func QueryCSV(w io.Writer, SQL string, params ...interface{}) (rowsCount int64, err error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		err = errors.New("Invalid characters in the query")
+		return
+	}
+
+	db := connection.MustGet()
+	prepare, err := db.Prepare(SQL)
+	if err != nil {
+		return
+	}
+
+	rows, err := prepare.Query(params...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+
+	writer := csv.NewWriter(w)
+	if err = writer.Write(columns); err != nil {
+		return
+	}
+
+	count := len(columns)
+	values := make([]interface{}, count)
+	valuePtrs := make([]interface{}, count)
+	record := make([]string, count)
+	for rows.Next() {
+		for i := 0; i < count; i++ {
+			valuePtrs[i] = &values[i]
+		}
+		if err = rows.Scan(valuePtrs...); err != nil {
+			return
+		}
+		for i := range columns {
+			record[i] = csvValue(values[i])
+		}
+		if err = writer.Write(record); err != nil {
+			return
+		}
+		rowsCount++
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	writer.Flush()
+	err = writer.Error()
+	return
+}
+
+// RowReader return the next row to copy, io.EOF when there are no more rows
+type RowReader func() ([]interface{}, error)
+
+// CopyFrom load rows into a table using the COPY protocol inside a transaction,
+// progress is called every 1000 rows
+func CopyFrom(cfg *config.Prest, database, schema, table string, columns []string, next RowReader, progress func(int64)) (rowsCount int64, err error) {
+	rowsCount, _, err = copyFrom(cfg, database, schema, table, columns, next, progress, false)
+	return
+}
+
+// CopyFromIgnoring load rows into a table like CopyFrom skipping the ones
+// conflicting with existing rows (ON CONFLICT DO NOTHING), the rows are
+// copied to a temporary table first. It returns the rows inserted and the
+// rows skipped
+func CopyFromIgnoring(cfg *config.Prest, database, schema, table string, columns []string, next RowReader, progress func(int64)) (rowsCount, skipped int64, err error) {
+	return copyFrom(cfg, database, schema, table, columns, next, progress, true)
+}
+
+// copyTemp table of the rows copied before being inserted
+const copyTemp = "prest_copy"
+
+func copyFrom(cfg *config.Prest, database, schema, table string, columns []string, next RowReader, progress func(int64), ignore bool) (rowsCount, skipped int64, err error) {
+	if !TablePermissions(cfg, database, schema, table, "insert") {
+		err = errors.New("Insuficient table permissions")
+		return
+	}
+
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
+		err = errors.New("Copy: Invalid identifier")
+		return
+	}
+	for _, col := range columns {
+		if chkInvalidIdentifier(col) {
+			err = errors.New("Copy: Invalid identifier")
+			return
+		}
+	}
+
+	db := connection.MustGet()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("could not begin transaction: %v\n", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = commit(cfg, tx)
+		if err != nil {
+			log.Printf("could not commit: %v\n", err)
+		}
+	}()
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = identifierName(col)
+	}
+	copyIn := pq.CopyInSchema(schema, table, names...)
+	cols := strings.Join(quoteNames(names), ", ")
+	if ignore {
+		_, err = tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", copyTemp, cols, QuoteTable(database, schema, table)))
+		if err != nil {
+			return
+		}
+		copyIn = pq.CopyIn(copyTemp, names...)
+	}
+	stmt, err := tx.Prepare(copyIn)
+	if err != nil {
+		return
+	}
+
+	for {
+		var row []interface{}
+		row, err = next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if _, err = stmt.Exec(row...); err != nil {
+			return
+		}
+		rowsCount++
+		if progress != nil && rowsCount%1000 == 0 {
+			progress(rowsCount)
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		return
+	}
+	if err = stmt.Close(); err != nil || !ignore {
+		return
+	}
+
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT DO NOTHING", QuoteTable(database, schema, table), cols, cols, copyTemp))
+	if err != nil {
+		return
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	rowsCount, skipped = inserted, rowsCount-inserted
+	return
+}
+
+// CSVRows read the header line as columns and return a reader of the
+// remaining lines, empty fields are NULL
+func CSVRows(r io.Reader) (columns []string, next RowReader, err error) {
+	reader := csv.NewReader(r)
+	columns, err = reader.Read()
+	if err != nil {
+		return
+	}
+
+	next = func() ([]interface{}, error) {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != "" {
+				row[i] = v
+			}
+		}
+		return row, nil
+	}
+	return
+}
+
+// NDJSONRows read JSON objects (one per line), the keys of the first object
+// are the columns
+func NDJSONRows(r io.Reader) (columns []string, next RowReader, err error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var first map[string]interface{}
+	if err = decoder.Decode(&first); err != nil {
+		return
+	}
+	for k := range first {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	toRow := func(obj map[string]interface{}) []interface{} {
+		row := make([]interface{}, len(columns))
+		for i, col := range columns {
+			switch v := obj[col].(type) {
+			case map[string]interface{}, []interface{}:
+				b, _ := json.Marshal(v)
+				row[i] = string(b)
+			default:
+				row[i] = v
+			}
+		}
+		return row
+	}
+
+	pending := first
+	next = func() ([]interface{}, error) {
+		if pending != nil {
+			row := toRow(pending)
+			pending = nil
+			return row, nil
+		}
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			return nil, err
+		}
+		return toRow(obj), nil
+	}
+	return
+}
+
+// csvValue format a database value as CSV field
+func csvValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(val)
+}
+
+// TransformResponse apply the response transformations configured for the table
+func TransformResponse(cfg *config.Prest, database, schema, table string, jsonData []byte) ([]byte, error) {
+	fn := transformRows(cfg, database, schema, table)
+	if fn == nil {
+		return jsonData, nil
+	}
+	return applyRows(jsonData, fn)
+}
+
+// transformRows return the change of the rows of TransformResponse, nil
+// without transformations of the table
+func transformRows(cfg *config.Prest, database, schema, table string) RowFunc {
+	var transform *config.TransformConf
+	transforms := cfg.Transforms
+	for i, t := range transforms {
+		if t.Matches(database, schema, table) {
+			transform = &transforms[i]
+			break
+		}
+	}
+	if transform == nil {
+		return nil
+	}
+
+	return func(row map[string]interface{}) error {
+		for _, field := range transform.Flatten {
+			var doc map[string]interface{}
+			raw, ok := row[field].(string)
+			if !ok || json.Unmarshal([]byte(raw), &doc) != nil {
+				continue
+			}
+			delete(row, field)
+			for k, v := range doc {
+				row[k] = v
+			}
+		}
+		for _, field := range transform.Drop {
+			delete(row, field)
+		}
+		for from, to := range transform.Rename {
+			if v, ok := row[from]; ok {
+				delete(row, from)
+				row[to] = v
+			}
+		}
+		return nil
+	}
+}
+
+// QueryCount process queries with count
+func QueryCount(SQL string, params ...interface{}) ([]byte, error) {
+	return queryCountWith(connection.MustGet(), SQL, params...)
+}
+
+func queryCountWith(db preparer, SQL string, params ...interface{}) ([]byte, error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		return nil, errors.New("Invalid characters in the query")
+	}
+
+	prepare, err := db.Prepare(SQL)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+
+	row := prepare.QueryRow(params...)
+	if err := row.Scan(&result.Count); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
+
+// QueryExists process queries wrapped in SELECT EXISTS
+func QueryExists(SQL string, params ...interface{}) ([]byte, bool, error) {
+	return queryExistsWith(connection.MustGet(), SQL, params...)
+}
+
+func queryExistsWith(db preparer, SQL string, params ...interface{}) ([]byte, bool, error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		return nil, false, errors.New("Invalid characters in the query")
+	}
+
+	prepare, err := db.Prepare(fmt.Sprintf("SELECT EXISTS(%s)", SQL))
+	if err != nil {
+		return nil, false, err
+	}
+
+	var result struct {
+		Exists bool `json:"exists"`
+	}
+
+	row := prepare.QueryRow(params...)
+	if err := row.Scan(&result.Exists); err != nil {
+		return nil, false, err
+	}
+
+	jsonData, err := json.Marshal(result)
+	return jsonData, result.Exists, err
+}
+
+// pageSizes return the default page size and the maximum page size (0 when
+// there is no ceiling) configured
+func pageSizes(cfg *config.Prest) (defaultSize, maxSize int) {
+	defaultSize = defaultPageSize
+	if cfg == nil {
+		return
+	}
+	if cfg.DefaultPageSize > 0 {
+		defaultSize = cfg.DefaultPageSize
+	}
+	maxSize = cfg.MaxPageSize
+	if maxSize > 0 && defaultSize > maxSize {
+		defaultSize = maxSize
+	}
+	return
+}
+
+// PaginationByRequest return the page number and page size requested, the
+// first page is used when there is no `_page` and a default page size is
+// configured. The page size never exceeds the configured maximum, the page
+// number and size below 1 are errors
+func PaginationByRequest(r *http.Request) (pageNumber, pageSize int, paginated bool, err error) {
+	values := r.URL.Query()
+	cfg := config.FromContext(r.Context())
+	defaultSize, maxSize := pageSizes(cfg)
+	if _, ok := values[pageNumberKey]; !ok {
+		if cfg == nil || cfg.DefaultPageSize <= 0 {
+			return
+		}
+		pageNumber = 1
+	} else {
+		pageNumber, err = strconv.Atoi(values[pageNumberKey][0])
+		if err != nil {
+			return
+		}
+		if pageNumber < 1 {
+			err = fmt.Errorf("Invalid %s: %d", pageNumberKey, pageNumber)
+			return
+		}
+	}
+	pageSize = defaultSize
 	if size, ok := values[pageSizeKey]; ok {
 		pageSize, err = strconv.Atoi(size[0])
 		if err != nil {
 			return
 		}
+		if pageSize < 1 {
+			err = fmt.Errorf("Invalid %s: %d", pageSizeKey, pageSize)
+			return
+		}
+	}
+	if maxSize > 0 && pageSize > maxSize {
+		pageSize = maxSize
+	}
+	paginated = true
+	return
+}
+
+// SearchPathByRequest return the schemas of `_schema_path`, they must be in
+// the configured allow-list
+func SearchPathByRequest(r *http.Request) (schemas []string, err error) {
+	value := r.URL.Query().Get("_schema_path")
+	if value == "" {
+		return
+	}
+	var allowed []string
+	if cfg := config.FromContext(r.Context()); cfg != nil {
+		allowed = cfg.AccessConf.SchemaPaths
+	}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if chkInvalidIdentifier(s) || !containsColumn(allowed, s) {
+			err = fmt.Errorf("Schema not allowed in _schema_path: %s", s)
+			return
+		}
+		schemas = append(schemas, s)
+	}
+	return
+}
+
+// PaginateIfPossible func
+func PaginateIfPossible(r *http.Request) (paginatedQuery string, err error) {
+	pageNumber, pageSize, paginated, err := PaginationByRequest(r)
+	if err != nil || !paginated {
+		return
 	}
 	paginatedQuery = fmt.Sprintf("LIMIT %d OFFSET(%d - 1) * %d", pageSize, pageNumber, pageSize)
 	return
 }
 
-// Insert execute insert sql into a table
-func Insert(database, schema, table string, body api.Request) (jsonData []byte, err error) {
-	allowed := TablePermissions(table, "write")
+// RowRange rows requested by the Range header, from Offset and up to Limit
+// rows (0 for all of them)
+type RowRange struct {
+	Offset int64
+	Limit  int64
+}
+
+// RowRangeByRequest return the rows of the Range header ("rows=100-199" or
+// "rows=100-"), nil without it or with other units. The rows never exceed
+// the configured maximum page size
+func RowRangeByRequest(r *http.Request) (rows *RowRange, err error) {
+	value := r.Header.Get("Range")
+	if !strings.HasPrefix(value, "rows=") {
+		return
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(value, "rows="), "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Invalid Range: %s", value)
+	}
+	first, err := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("Invalid Range: %s", value)
+	}
+	rows = &RowRange{Offset: first}
+	if last := strings.TrimSpace(bounds[1]); last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < first {
+			return nil, fmt.Errorf("Invalid Range: %s", value)
+		}
+		rows.Limit = n - first + 1
+	}
+	_, maxSize := pageSizes(config.FromContext(r.Context()))
+	if maxSize > 0 && (rows.Limit == 0 || rows.Limit > int64(maxSize)) {
+		rows.Limit = int64(maxSize)
+	}
+	return
+}
+
+// Clause return the LIMIT and OFFSET of the rows
+func (rr *RowRange) Clause() string {
+	if rr.Limit > 0 {
+		return fmt.Sprintf("LIMIT %d OFFSET %d", rr.Limit, rr.Offset)
+	}
+	return fmt.Sprintf("OFFSET %d", rr.Offset)
+}
+
+// Keyset cursor pagination requested by `_cursor` or `_after`
+type Keyset struct {
+	Where   string
+	Values  []interface{}
+	Columns []string
+	Limit   int
+}
+
+// KeysetByRequest implements keyset (cursor based) pagination, it returns nil
+// when the request doesn't use it. All `_order` fields must have the same direction
+func KeysetByRequest(r *http.Request, initialPlaceholderID int) (keyset *Keyset, err error) {
+	queries := r.URL.Query()
+	after := queries.Get("_after")
+	if after == "" && queries.Get("_cursor") == "" {
+		return
+	}
+
+	ordering := queries.Get("_order")
+	if ordering == "" {
+		err = errors.New("Cursor pagination requires _order")
+		return
+	}
+
+	defaultSize, maxSize := pageSizes(config.FromContext(r.Context()))
+	keyset = &Keyset{Limit: defaultSize}
+	if size := queries.Get(pageSizeKey); size != "" {
+		keyset.Limit, err = strconv.Atoi(size)
+		if err != nil {
+			return nil, err
+		}
+		if keyset.Limit < 1 {
+			return nil, fmt.Errorf("Invalid %s: %d", pageSizeKey, keyset.Limit)
+		}
+	}
+	if maxSize > 0 && keyset.Limit > maxSize {
+		keyset.Limit = maxSize
+	}
+
+	desc := strings.HasPrefix(ordering, "-")
+	for _, field := range strings.Split(ordering, ",") {
+		if strings.HasPrefix(field, "-") != desc {
+			return nil, errors.New("Cursor pagination requires the same direction in all _order fields")
+		}
+		field = strings.TrimPrefix(field, "-")
+		if field == "" || chkInvalidIdentifier(field) {
+			return nil, errors.New("Invalid identifier")
+		}
+		keyset.Columns = append(keyset.Columns, field)
+	}
+
+	if after == "" {
+		return
+	}
+
+	keyset.Values, err = DecodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyset.Values) != len(keyset.Columns) {
+		return nil, errors.New("Invalid cursor")
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	placeholders := []string{}
+	for i := range keyset.Values {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", initialPlaceholderID+i))
+	}
+	columns, err := quoteFields(keyset.Columns)
+	if err != nil {
+		return nil, err
+	}
+	keyset.Where = fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op, strings.Join(placeholders, ", "))
+	return
+}
+
+// EncodeCursor encode the key values of a row as cursor
+func EncodeCursor(values []interface{}) (string, error) {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor decode the key values of a cursor
+func DecodeCursor(cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("Invalid cursor")
+	}
+	var values []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err = decoder.Decode(&values); err != nil {
+		return nil, errors.New("Invalid cursor")
+	}
+	return values, nil
+}
+
+// NextCursor return the cursor of the last row when the page is full
+func (k *Keyset) NextCursor(jsonData []byte) (string, error) {
+	var rows []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
+		return "", err
+	}
+	if len(rows) == 0 || len(rows) < k.Limit {
+		return "", nil
+	}
+
+	last := rows[len(rows)-1]
+	values := []interface{}{}
+	for _, col := range k.Columns {
+		names, _ := splitIdentifier(col)
+		if len(names) == 0 {
+			names = []string{col}
+		}
+		v, ok := last[names[len(names)-1]]
+		if !ok {
+			return "", fmt.Errorf("Cursor pagination requires %s in the selected fields", col)
+		}
+		values = append(values, v)
+	}
+	return EncodeCursor(values)
+}
+
+// QueryTotal return the number of rows the query returns without pagination
+func QueryTotal(SQL string, params ...interface{}) (total int64, err error) {
+	return queryTotalWith(connection.MustGet(), SQL, params...)
+}
+
+// CountTable count the rows of a table
+func CountTable(database, schema, table string) (int64, error) {
+	return QueryTotal(fmt.Sprint("SELECT * FROM ", QuoteTable(database, schema, table)))
+}
+
+func queryTotalWith(db preparer, SQL string, params ...interface{}) (total int64, err error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		err = errors.New("Invalid characters in the query")
+		return
+	}
+
+	prepare, err := db.Prepare(fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS total", SQL))
+	if err != nil {
+		return
+	}
+
+	err = prepare.QueryRow(params...).Scan(&total)
+	return
+}
+
+// Transaction run fn in a transaction, it is committed when fn succeeds
+func Transaction(fn func(tx *sql.Tx) error) (err error) {
+	return Session{}.Transaction(fn)
+}
+
+// Transaction run fn in a transaction with the session settings, it is
+// committed when fn succeeds
+func (s Session) Transaction(fn func(tx *sql.Tx) error) (err error) {
+	tx, err := s.begin()
+	if err != nil {
+		log.Printf("could not begin transaction: %v\n", err)
+		return
+	}
+	if s.Tag != "" || s.Config != nil {
+		txSessionsMu.Lock()
+		txSessions[tx] = s
+		txSessionsMu.Unlock()
+		defer func() {
+			txSessionsMu.Lock()
+			delete(txSessions, tx)
+			txSessionsMu.Unlock()
+		}()
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = commit(s.config(), tx)
+		if err != nil {
+			log.Printf("could not commit: %v\n", err)
+		}
+	}()
+
+	err = fn(tx)
+	return
+}
+
+// commit the transaction, in sandbox mode the writes are always rolled back
+func commit(cfg *config.Prest, tx *sql.Tx) error {
+	if sandbox(cfg) {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+func sandbox(cfg *config.Prest) bool {
+	return cfg != nil && cfg.Sandbox
+}
+
+// Insert execute insert sql into a table
+func Insert(database, schema, table string, body api.Request) (jsonData []byte, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = InsertTx(tx, database, schema, table, body)
+		return
+	})
+	return
+}
+
+// InsertTx execute insert sql into a table in the transaction
+func InsertTx(tx *sql.Tx, database, schema, table string, body api.Request) (jsonData []byte, err error) {
+	body, err = nestedInserts(tx, database, schema, table, body)
+	if err != nil {
+		return
+	}
+	sql, _, values, err := insertSQL(txConfig(tx), database, schema, table, body)
+	if err != nil {
+		return
+	}
+
+	// the whole row, with defaults and columns set by triggers
+	returning, row := "1", "'{}'::json"
+	cols, err := readableColumns(txConfig(tx), database, schema, table, nil)
+	if err != nil {
+		return
+	}
+	if cols != "" {
+		returning, row = cols, "row_to_json(inserted)"
+	}
+	sql = fmt.Sprintf("WITH inserted AS (%s RETURNING %s) SELECT %s FROM inserted", sql, returning, row)
+
+	stmt, err := tx.Prepare(tagged(tx, sql))
+	if err != nil {
+		log.Printf("could not prepare sql: %s\n Error: %v\n", sql, err)
+		return
+	}
+
+	err = stmt.QueryRow(values...).Scan(&jsonData)
+	return
+}
+
+// insertSQL build the INSERT of the request body
+func insertSQL(cfg *config.Prest, database, schema, table string, body api.Request) (sql string, fields []string, values []interface{}, err error) {
+	allowed := TablePermissions(cfg, database, schema, table, "insert")
 	if !allowed {
-		return nil, errors.New("Insuficient table permissions")
+		err = errors.New("Insuficient table permissions")
+		return
 	}
 
-	if chkInvalidIdentifier(database) ||
-		chkInvalidIdentifier(schema) ||
-		chkInvalidIdentifier(table) {
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
 		err = errors.New("Insert: Invalid identifier")
 		return
 	}
 
-	fields := make([]string, 0)
-	values := make([]interface{}, 0)
-	for key, value := range body.Data {
-		if chkInvalidIdentifier(key) {
-			err = errors.New("Insert: Invalid identifier")
-			return
-		}
+	data, err := dataColumns(body.Data)
+	if err != nil {
+		err = errors.New("Insert: Invalid identifier")
+		return
+	}
+	if err = checkColumns(database, schema, table, data); err != nil {
+		return
+	}
+	if err = checkGenerated(database, schema, table, data); err != nil {
+		return
+	}
+
+	fields = make([]string, 0)
+	values = make([]interface{}, 0)
+	for key, value := range data {
 		fields = append(fields, key)
 		values = append(values, value)
 	}
 
-	colsName := strings.Join(fields, ", ")
-	colPlaceholder := ""
-	for i := 1; i < len(values)+1; i++ {
-		if colPlaceholder != "" {
-			colPlaceholder += ","
-		}
-		colPlaceholder += fmt.Sprintf("$%d", i)
+	colsName := strings.Join(quoteNames(fields), ", ")
+	placeholders, err := compositePlaceholders(database, schema, table, fields, values, 1)
+	if err != nil {
+		return
 	}
+	colPlaceholder := strings.Join(placeholders, ",")
 
-	sql := fmt.Sprintf("INSERT INTO %s.%s.%s (%s) VALUES (%s) RETURNING id;", database, schema, table, colsName, colPlaceholder)
+	sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", QuoteTable(database, schema, table), colsName, colPlaceholder)
+	return
+}
 
-	db := connection.MustGet()
-	tx, err := db.Begin()
+// InsertIgnoreTx execute insert sql into a table in the transaction skipping
+// the row when it conflicts with an existing one (ON CONFLICT DO NOTHING).
+// It returns the inserted row as a JSON array (empty when skipped) and the
+// number of rows inserted
+func InsertIgnoreTx(tx *sql.Tx, database, schema, table string, body api.Request, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	body, err = nestedInserts(tx, database, schema, table, body)
 	if err != nil {
-		log.Printf("could not begin transaction: %v\n", err)
 		return
 	}
-
-	stmt, err := tx.Prepare(sql)
+	query, _, values, err := insertSQL(txConfig(tx), database, schema, table, body)
 	if err != nil {
-		log.Printf("could not prepare sql: %s\n Error: %v\n", sql, err)
 		return
 	}
+	return execReturning(tx, database, schema, table, query+" ON CONFLICT DO NOTHING", values, returning)
+}
 
-	valuesAux := make([]interface{}, 0, len(values))
-
-	for i := 0; i < len(values); i++ {
-		valuesAux = append(valuesAux, values[i])
+// OnConflictByRequest parse `_on_conflict` of the inserts, "ignore" skips the
+// rows conflicting with existing ones
+func OnConflictByRequest(r *http.Request) (ignore bool, err error) {
+	switch value := r.URL.Query().Get("_on_conflict"); value {
+	case "":
+	case "ignore":
+		ignore = true
+	default:
+		err = fmt.Errorf("Invalid _on_conflict: %s", value)
 	}
+	return
+}
+
+// InsertReturning execute insert sql into a table returning the inserted row
+// as a JSON array, only with the returning fields when they are informed
+func InsertReturning(database, schema, table string, body api.Request, returning ...string) (jsonData []byte, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = InsertReturningTx(tx, database, schema, table, body, returning...)
+		return
+	})
+	return
+}
 
-	var lastID int
-	result := stmt.QueryRow(valuesAux...)
-	err = result.Scan(&lastID)
+// InsertReturningTx execute insert sql into a table in the transaction
+// returning the inserted row as a JSON array
+func InsertReturningTx(tx *sql.Tx, database, schema, table string, body api.Request, returning ...string) (jsonData []byte, err error) {
+	body, err = nestedInserts(tx, database, schema, table, body)
+	if err != nil {
+		return
+	}
+	query, _, values, err := insertSQL(txConfig(tx), database, schema, table, body)
 	if err != nil {
 		return
 	}
+	jsonData, _, err = execReturning(tx, database, schema, table, query, values, returning)
+	return
+}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
+// nestedInserts insert the objects of the body that are rows of related
+// tables (e.g. {"name": "x", "address": {"street": "y"}}) and replace them
+// with the foreign key column bound to the key of the inserted row
+func nestedInserts(tx *sql.Tx, database, schema, table string, body api.Request) (ret api.Request, err error) {
+	var fks []ForeignKey
+	ret.Data = make(map[string]interface{}, len(body.Data))
+	for key, value := range body.Data {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			ret.Data[key] = value
+			continue
+		}
+
+		if fks == nil {
+			if chkInvalidName(database) ||
+				chkInvalidName(schema) ||
+				chkInvalidName(table) {
+				err = errors.New("Insert: Invalid identifier")
+				return
+			}
+			if fks, err = ForeignKeys(database, schema, table); err != nil {
+				return
+			}
+		}
+		fk, ok := findForeignKey(fks, key)
+		if !ok {
+			// objects of composite and range columns are inserted as values
+			var composites map[string]compositeColumn
+			if composites, err = compositeColumns(database, schema, table); err != nil {
+				return
+			}
+			var ranges map[string]rangeColumn
+			if ranges, err = rangeColumns(database, schema, table); err != nil {
+				return
+			}
+			_, composite := composites[key]
+			if _, ok = ranges[key]; ok || composite {
+				ret.Data[key] = value
+				continue
+			}
+			err = fmt.Errorf("Insert: %s is not a foreign key of %s", key, table)
 			return
 		}
-		err = tx.Commit()
+
+		var ref interface{}
+		ref, err = insertChild(tx, database, fk, api.Request{Data: child})
 		if err != nil {
-			log.Printf("could not commit: %v\n", err)
+			return
 		}
-	}()
+		ret.Data[fk.Column] = ref
+	}
+	return
+}
 
-	data := make(map[string]interface{})
-	for i := range fields {
-		data[fields[i]] = values[i]
+// insertChild insert the row of a nested object returning the referenced
+// column value
+func insertChild(tx *sql.Tx, database string, fk ForeignKey, body api.Request) (ref interface{}, err error) {
+	body, err = nestedInserts(tx, database, fk.RefSchema, fk.RefTable, body)
+	if err != nil {
+		return
+	}
+	sql, _, values, err := insertSQL(txConfig(tx), database, fk.RefSchema, fk.RefTable, body)
+	if err != nil {
+		return
+	}
+	if chkInvalidIdentifier(fk.RefColumn) {
+		err = errors.New("Insert: Invalid identifier")
+		return
+	}
+	sql = fmt.Sprintf("%s RETURNING %s", sql, quoteName(fk.RefColumn))
+
+	err = tx.QueryRow(tagged(tx, sql), values...).Scan(&ref)
+	if b, ok := ref.([]byte); ok {
+		// uuid and other types without a Go type are scanned as bytes
+		ref = string(b)
 	}
-	data["id"] = lastID
-	jsonData, err = json.Marshal(data)
 	return
 }
 
-// Delete execute delete sql into a table
-func Delete(database, schema, table, where string, whereValues []interface{}) (jsonData []byte, err error) {
-	allowed := TablePermissions(table, "delete")
+// deleteSQL build the DELETE with the where clause
+func deleteSQL(cfg *config.Prest, database, schema, table, where string) (sql string, err error) {
+	allowed := TablePermissions(cfg, database, schema, table, "delete")
 	if !allowed {
-		return nil, errors.New("Insuficient table permissions")
+		err = errors.New("Insuficient table permissions")
+		return
 	}
 
-	var result sql.Result
-	var rowsAffected int64
-
-	if chkInvalidIdentifier(database) ||
-		chkInvalidIdentifier(schema) ||
-		chkInvalidIdentifier(table) {
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
 		err = errors.New("Delete: Invalid identifier")
 		return
 	}
 
-	sql := fmt.Sprintf("DELETE FROM %s.%s.%s", database, schema, table)
+	sql = fmt.Sprintf("DELETE FROM %s", QuoteTable(database, schema, table))
 	if where != "" {
 		sql = fmt.Sprint(
 			sql,
 			" WHERE ",
 			where)
 	}
+	return
+}
 
-	db := connection.MustGet()
-	tx, err := db.Begin()
+// DeleteReturning execute delete sql into a table returning the deleted rows
+// as a JSON array and the number of rows affected
+func DeleteReturning(database, schema, table, where string, whereValues []interface{}, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, rowsAffected, err = DeleteReturningTx(tx, database, schema, table, where, whereValues, returning...)
+		return
+	})
+	return
+}
+
+// DeleteReturningTx execute delete sql into a table in the transaction
+// returning the deleted rows as a JSON array and the number of rows affected
+func DeleteReturningTx(tx *sql.Tx, database, schema, table, where string, whereValues []interface{}, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	query, err := deleteSQL(txConfig(tx), database, schema, table, where)
 	if err != nil {
-		log.Printf("could not begin transaction: %v\n", err)
 		return
 	}
+	return execReturning(tx, database, schema, table, query, whereValues, returning)
+}
+
+// DeleteByKeys execute delete sql into a table of the rows with the primary
+// keys, the table must have a single column primary key
+func DeleteByKeys(database, schema, table string, keys []interface{}) (jsonData []byte, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = DeleteByKeysTx(tx, database, schema, table, keys, "", nil)
+		return
+	})
+	return
+}
 
-	result, err = tx.Exec(sql, whereValues...)
+// DeleteByKeysTx execute delete sql into a table in the transaction of the
+// rows with the primary keys, and of the where when it is informed (its
+// placeholders start at $2)
+func DeleteByKeysTx(tx *sql.Tx, database, schema, table string, keys []interface{}, where string, whereValues []interface{}) (jsonData []byte, err error) {
+	pk, err := PrimaryKey(database, schema, table)
 	if err != nil {
 		return
 	}
+	if len(pk) != 1 {
+		err = fmt.Errorf("Delete: %s must have a single column primary key", table)
+		return
+	}
+	keysWhere := fmt.Sprintf("%s = ANY($1)", quoteName(pk[0]))
+	if where != "" {
+		keysWhere = fmt.Sprint(keysWhere, " AND ", where)
+	}
+	return DeleteTx(tx, database, schema, table, keysWhere, append([]interface{}{pq.Array(keys)}, whereValues...))
+}
+
+// Delete execute delete sql into a table
+func Delete(database, schema, table, where string, whereValues []interface{}) (jsonData []byte, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = DeleteTx(tx, database, schema, table, where, whereValues)
+		return
+	})
+	return
+}
 
-	rowsAffected, err = result.RowsAffected()
+// DeleteTx execute delete sql into a table in the transaction
+func DeleteTx(tx *sql.Tx, database, schema, table, where string, whereValues []interface{}) (jsonData []byte, err error) {
+	sql, err := deleteSQL(txConfig(tx), database, schema, table, where)
 	if err != nil {
 		return
 	}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
+	result, err := tx.Exec(tagged(tx, sql), whereValues...)
+	if err != nil {
+		return
+	}
 
-		err = tx.Commit()
-		if err != nil {
-			log.Printf("could not commit: %v\n", err)
-		}
-	}()
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
 
 	data := make(map[string]interface{})
 	data["rows_affected"] = rowsAffected
@@ -442,34 +2042,113 @@ func Delete(database, schema, table, where string, whereValues []interface{}) (j
 	return
 }
 
-// Update execute update sql into a table
-func Update(database, schema, table, where string, whereValues []interface{}, body api.Request) (jsonData []byte, err error) {
-	allowed := TablePermissions(table, "write")
+// Update execute update sql into a table, it returns the updated rows (with
+// the readable fields) as a JSON array and the number of rows affected
+func Update(database, schema, table, where string, whereValues []interface{}, body api.Request, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, rowsAffected, err = UpdateTx(tx, database, schema, table, where, whereValues, body, returning...)
+		return
+	})
+	return
+}
+
+// UpdateTx execute update sql into a table in the transaction
+func UpdateTx(tx *sql.Tx, database, schema, table, where string, whereValues []interface{}, body api.Request, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	return updateTx(tx, database, schema, table, where, whereValues, body, nil, returning)
+}
+
+// Replace execute update sql into a table replacing the whole rows, the
+// columns missing in the body (except the primary key and the generated
+// columns) are set to their default value, or NULL when PutMissing is "null"
+func Replace(database, schema, table, where string, whereValues []interface{}, body api.Request, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	err = Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, rowsAffected, err = ReplaceTx(tx, database, schema, table, where, whereValues, body, returning...)
+		return
+	})
+	return
+}
+
+// ReplaceTx execute update sql into a table in the transaction replacing the
+// whole rows
+func ReplaceTx(tx *sql.Tx, database, schema, table, where string, whereValues []interface{}, body api.Request, returning ...string) (jsonData []byte, rowsAffected int64, err error) {
+	cols, err := TableColumns(database, schema, table)
+	if err != nil {
+		return
+	}
+	pk, err := PrimaryKey(database, schema, table)
+	if err != nil {
+		return
+	}
+	generated, err := GeneratedColumns(database, schema, table)
+	if err != nil {
+		return
+	}
+
+	if body.Data, err = dataColumns(body.Data); err != nil {
+		err = errors.New("Update: Invalid identifier")
+		return
+	}
+
+	missing := "DEFAULT"
+	if txConfig(tx).PutMissing == "null" {
+		missing = "NULL"
+	}
+	reset := []string{}
+	for _, col := range cols {
+		if _, ok := body.Data[col]; ok || containsColumn(pk, col) || containsColumn(generated, col) {
+			continue
+		}
+		reset = append(reset, fmt.Sprintf("%s=%s", quoteName(col), missing))
+	}
+
+	return updateTx(tx, database, schema, table, where, whereValues, body, reset, returning)
+}
+
+// updateTx execute update sql setting the body fields and the reset
+// assignments (e.g. "name=DEFAULT")
+func updateTx(tx *sql.Tx, database, schema, table, where string, whereValues []interface{}, body api.Request, reset []string, returning []string) (jsonData []byte, rowsAffected int64, err error) {
+	allowed := TablePermissions(txConfig(tx), database, schema, table, "update")
 	if !allowed {
-		return nil, errors.New("Insuficient table permissions")
+		return nil, 0, errors.New("Insuficient table permissions")
 	}
 
-	if chkInvalidIdentifier(database) ||
-		chkInvalidIdentifier(schema) ||
-		chkInvalidIdentifier(table) {
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
 		err = errors.New("Update: Invalid identifier")
 		return
 	}
 
-	var result sql.Result
-	var rowsAffected int64
+	data, err := dataColumns(body.Data)
+	if err != nil {
+		err = errors.New("Update: Invalid identifier")
+		return
+	}
+	if err = checkColumns(database, schema, table, data); err != nil {
+		return
+	}
+	if err = checkGenerated(database, schema, table, data); err != nil {
+		return
+	}
 
-	fields := []string{}
+	keys := []string{}
 	values := make([]interface{}, 0)
-	pid := len(whereValues) + 1 // placeholder id
-	for key, value := range body.Data {
-		fields = append(fields, fmt.Sprintf("%s=$%d", key, pid))
+	for key, value := range data {
+		keys = append(keys, key)
 		values = append(values, value)
-		pid++
 	}
+	placeholders, err := compositePlaceholders(database, schema, table, keys, values, len(whereValues)+1)
+	if err != nil {
+		return
+	}
+	fields := []string{}
+	for i, key := range keys {
+		fields = append(fields, fmt.Sprintf("%s=%s", quoteName(key), placeholders[i]))
+	}
+	fields = append(fields, reset...)
 	setSyntax := strings.Join(fields, ", ")
 
-	sql := fmt.Sprintf("UPDATE %s.%s.%s SET %s", database, schema, table, setSyntax)
+	sql := fmt.Sprintf("UPDATE %s SET %s", QuoteTable(database, schema, table), setSyntax)
 
 	if where != "" {
 		sql = fmt.Sprint(
@@ -479,50 +2158,55 @@ func Update(database, schema, table, where string, whereValues []interface{}, bo
 		values = append(whereValues, values...)
 	}
 
-	db := connection.MustGet()
-	tx, err := db.Begin()
+	return execReturning(tx, database, schema, table, sql, values, returning)
+}
+
+// execReturning execute an INSERT, UPDATE or DELETE returning the affected
+// rows, only with the fields the user can read, as a JSON array. fields
+// restrict the returned columns, all the readable ones when empty
+func execReturning(tx *sql.Tx, database, schema, table, sql string, values []interface{}, fields []string) (jsonData []byte, rowsAffected int64, err error) {
+	for _, f := range fields {
+		if chkInvalidIdentifier(f) {
+			err = fmt.Errorf("Invalid returning field: %s", f)
+			return
+		}
+	}
+
+	returning, rows := "1", "'[]'::json"
+	cols, err := readableColumns(txConfig(tx), database, schema, table, fields)
 	if err != nil {
-		log.Printf("could not begin transaction: %v\n", err)
 		return
 	}
+	if cols != "" {
+		returning, rows = cols, "COALESCE(json_agg(affected), '[]'::json)"
+	}
+	sql = fmt.Sprintf("WITH affected AS (%s RETURNING %s) SELECT %s, COUNT(*) FROM affected", sql, returning, rows)
 
-	stmt, err := tx.Prepare(sql)
+	stmt, err := tx.Prepare(tagged(tx, sql))
 	if err != nil {
 		log.Printf("could not prepare sql: %s\n Error: %v\n", sql, err)
 		return
 	}
 
-	valuesAux := make([]interface{}, 0, len(values))
+	err = stmt.QueryRow(values...).Scan(&jsonData, &rowsAffected)
+	return
+}
 
-	for i := 0; i < len(values); i++ {
-		valuesAux = append(valuesAux, values[i])
+// readableColumns return the RETURNING list of the fields (all when empty)
+// the user can read, it's empty when none can be read
+func readableColumns(cfg *config.Prest, database, schema, table string, fields []string) (string, error) {
+	if len(fields) == 0 {
+		fields = []string{"*"}
 	}
-
-	result, err = stmt.Exec(valuesAux...)
-	if err != nil {
-		return
+	if !TablePermissions(cfg, database, schema, table, "read") {
+		return "", nil
 	}
-
-	rowsAffected, err = result.RowsAffected()
+	cols, err := ReadableFields(cfg, database, schema, table, fields)
 	if err != nil {
-		return
+		return "", err
 	}
-
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-		err = tx.Commit()
-		if err != nil {
-			log.Printf("could not commit: %v\n", err)
-		}
-	}()
-
-	data := make(map[string]interface{})
-	data["rows_affected"] = rowsAffected
-	jsonData, err = json.Marshal(data)
-	return
+	quoted, err := quoteSelect(cols)
+	return strings.Join(quoted, ", "), err
 }
 
 // GetQueryOperator identify operator on a join
@@ -552,55 +2236,192 @@ func GetQueryOperator(op string) (string, error) {
 
 }
 
-// get tables permissions based in prest configuration
-func TablePermissions(table string, op string) bool {
-	restrict := config.PREST_CONF.AccessConf.Restrict
+// get tables permissions based in prest configuration, the authorizer
+// replaces them when it is set. The rules qualified by database and schema
+// are preferred to the ones of the table name only. The rules of the API key
+// of the request are always checked
+func TablePermissions(cfg *config.Prest, database, schema, table string, op string) bool {
+	if cfg.APIKey != nil && !apikey.Allowed(cfg.APIKey, database, schema, table, op) {
+		return false
+	}
+	restrict := cfg.AccessConf.Restrict && authz.Default == nil
 	if !restrict {
 		return true
 	}
 
-	tables := config.PREST_CONF.AccessConf.Tables
-	for _, t := range tables {
-		if t.Name == table {
-			for _, p := range t.Permissions {
-				if p == op {
-					return true
-				}
+	t, ok := cfg.TableAccess(database, schema, table)
+	return ok && config.HasPermission(t.Permissions, op)
+}
+
+// get fields permissions based in prest configuration, the authorizer
+// replaces them when it is set (the masked columns and the fields of the API
+// key are still checked)
+func FieldsPermissions(cfg *config.Prest, database, schema, table string, cols []string, op string) []string {
+	if cfg.APIKey != nil {
+		cols = keyFields(cfg.APIKey, database, schema, table, cols, op)
+	}
+	restrict := cfg.AccessConf.Restrict && authz.Default == nil
+	if !restrict {
+		return unmasked(cfg, database, schema, table, cols)
+	}
+
+	t, ok := cfg.TableAccess(database, schema, table)
+	if !ok {
+		return nil
+	}
+	var permittedCols []string
+	for _, f := range t.Fields {
+		for _, col := range cols {
+			// return all permitted fields if have "*" in SELECT
+			if op == "read" && col == "*" {
+				return quoteNames(unmasked(cfg, database, schema, table, t.Fields))
+			}
+
+			if identifierName(col) == f {
+				permittedCols = append(permittedCols, col)
 			}
 		}
 	}
-	return false
+	return unmasked(cfg, database, schema, table, permittedCols)
 }
 
-// get fields permissions based in prest configuration
-func FieldsPermissions(table string, cols []string, op string) []string {
-	restrict := config.PREST_CONF.AccessConf.Restrict
-	if !restrict {
+// keyFields return the columns the API key of the request can use, "*" is
+// replaced by the fields of its rules when they have some
+func keyFields(key *config.APIKeyConf, database, schema, table string, cols []string, op string) []string {
+	if !apikey.Allowed(key, database, schema, table, op) {
+		return nil
+	}
+	fields := apikey.Fields(key, database, schema, table, op)
+	if fields == nil {
 		return cols
 	}
+	var permitted []string
+	for _, col := range cols {
+		if op == "read" && col == "*" {
+			permitted = append(permitted, quoteNames(fields)...)
+			continue
+		}
+		for _, f := range fields {
+			if identifierName(col) == f {
+				permitted = append(permitted, col)
+				break
+			}
+		}
+	}
+	return permitted
+}
 
-	var permittedCols []string
-	tables := config.PREST_CONF.AccessConf.Tables
-	for _, t := range tables {
-		if t.Name == table {
-			for _, f := range t.Fields {
-				for _, col := range cols {
-					// return all permitted fields if have "*" in SELECT
-					if op == "read" && col == "*" {
-						return t.Fields
-					}
+// maskedColumns return the columns of the table that are never returned, of
+// all the rules matching the table
+func maskedColumns(cfg *config.Prest, database, schema, table string) (masked []string) {
+	for _, t := range cfg.AccessConf.Tables {
+		if t.Matches(database, schema, table) {
+			masked = append(masked, t.Masked...)
+		}
+	}
+	return
+}
 
-					if col == f {
-						permittedCols = append(permittedCols, col)
-					}
-				}
-			}
-			return permittedCols
+// maskedColumn check if the column is masked, the qualified columns
+// ("table.column", "schema.table.column") by the rules of their table
+func maskedColumn(cfg *config.Prest, database, schema, table, column string) bool {
+	names, err := splitIdentifier(column)
+	if err != nil {
+		return containsColumn(maskedColumns(cfg, database, schema, table), column)
+	}
+	if len(names) > 1 {
+		database, schema, table = qualifiedNames(database, schema, names[:len(names)-1])
+	}
+	return containsColumn(maskedColumns(cfg, database, schema, table), quoteName(names[len(names)-1]))
+}
+
+// unmasked remove the masked columns of the table from cols
+func unmasked(cfg *config.Prest, database, schema, table string, cols []string) []string {
+	var permitted []string
+	for _, col := range cols {
+		if !maskedColumn(cfg, database, schema, table, col) {
+			permitted = append(permitted, col)
+		}
+	}
+	return permitted
+}
+
+// MaskedByRequest check the filters of the request, the masked columns can't
+// filter the rows (the filters would reveal their values)
+func MaskedByRequest(r *http.Request, database, schema, table string) error {
+	cfg := config.FromContext(r.Context())
+	for key := range r.URL.Query() {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		field := strings.Split(key, ":")[0]
+		if jsonField, _, err := jsonbField(field); err == nil {
+			field = jsonField
+		}
+		if maskedColumn(cfg, database, schema, table, field) {
+			return fmt.Errorf("You don't have permission to filter by: %s", field)
 		}
 	}
 	return nil
 }
 
+// qualifiedTable split names as "schema.table" or "database.schema.table"
+// without the quotes, the database and schema default to the informed ones
+func qualifiedTable(database, schema, name string) (string, string, string) {
+	parts, err := splitIdentifier(name)
+	if err != nil {
+		return database, schema, name
+	}
+	return qualifiedNames(database, schema, parts)
+}
+
+// qualifiedNames return the database, schema and table of the names of a
+// qualified table
+func qualifiedNames(database, schema string, names []string) (string, string, string) {
+	switch len(names) {
+	case 1:
+		return database, schema, names[0]
+	case 2:
+		return database, names[0], names[1]
+	}
+	return names[len(names)-3], names[len(names)-2], names[len(names)-1]
+}
+
+// ReadableFields return the permitted fields of cols, a "*" (or "table.*")
+// is expanded to the columns of the table when some of them are masked
+func ReadableFields(cfg *config.Prest, database, schema, table string, cols []string) ([]string, error) {
+	cols = FieldsPermissions(cfg, database, schema, table, cols, "read")
+	var expanded []string
+	for _, col := range cols {
+		colDatabase, colSchema, colTable, prefix := database, schema, table, ""
+		if strings.HasSuffix(col, ".*") {
+			names, err := splitIdentifier(col[:len(col)-2])
+			if err != nil {
+				// refused by SelectFields
+				expanded = append(expanded, col)
+				continue
+			}
+			colDatabase, colSchema, colTable = qualifiedNames(database, schema, names)
+			prefix = strings.Join(quoteNames(names), ".") + "."
+		} else if col != "*" {
+			expanded = append(expanded, col)
+			continue
+		}
+		if len(maskedColumns(cfg, colDatabase, colSchema, colTable)) == 0 {
+			expanded = append(expanded, col)
+			continue
+		}
+		tableColumns, err := TableColumns(colDatabase, colSchema, colTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range quoteNames(unmasked(cfg, colDatabase, colSchema, colTable, tableColumns)) {
+			expanded = append(expanded, prefix+c)
+		}
+	}
+	return expanded, nil
+}
+
 // ColumnsByRequest extract columns and return as array of strings
 func ColumnsByRequest(r *http.Request) []string {
 	u, _ := r.URL.Parse(r.URL.String())
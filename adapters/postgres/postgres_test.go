@@ -1,11 +1,21 @@
 package postgres
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/nuveo/prest/api"
 	"github.com/nuveo/prest/config"
@@ -13,6 +23,60 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+// configWith return a copy of the current config changed by fn, the snapshot
+// of a test doesn't change the config of the others
+func configWith(fn func(cfg *config.Prest)) *config.Prest {
+	cfg := *config.Get()
+	fn(&cfg)
+	return &cfg
+}
+
+// unrestricted return a snapshot of the config without access restrictions
+func unrestricted() *config.Prest {
+	return configWith(func(cfg *config.Prest) { cfg.AccessConf.Restrict = false })
+}
+
+// withConfig return the request with the config snapshot in its context
+func withConfig(r *http.Request, cfg *config.Prest) *http.Request {
+	return r.WithContext(config.NewContext(r.Context(), cfg))
+}
+
+// insertWith insert in a transaction of a session with the config snapshot
+func insertWith(cfg *config.Prest, database, schema, table string, body api.Request) (jsonData []byte, err error) {
+	err = Session{Config: cfg}.Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = InsertTx(tx, database, schema, table, body)
+		return
+	})
+	return
+}
+
+// updateWith update in a transaction of a session with the config snapshot
+func updateWith(cfg *config.Prest, database, schema, table, where string, whereValues []interface{}, body api.Request) (jsonData []byte, rowsAffected int64, err error) {
+	err = Session{Config: cfg}.Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, rowsAffected, err = UpdateTx(tx, database, schema, table, where, whereValues, body)
+		return
+	})
+	return
+}
+
+// replaceWith replace in a transaction of a session with the config snapshot
+func replaceWith(cfg *config.Prest, database, schema, table, where string, whereValues []interface{}, body api.Request) (jsonData []byte, rowsAffected int64, err error) {
+	err = Session{Config: cfg}.Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, rowsAffected, err = ReplaceTx(tx, database, schema, table, where, whereValues, body)
+		return
+	})
+	return
+}
+
+// deleteWith delete in a transaction of a session with the config snapshot
+func deleteWith(cfg *config.Prest, database, schema, table, where string, whereValues []interface{}) (jsonData []byte, err error) {
+	err = Session{Config: cfg}.Transaction(func(tx *sql.Tx) (err error) {
+		jsonData, err = DeleteTx(tx, database, schema, table, where, whereValues)
+		return
+	})
+	return
+}
+
 func TestWhereByRequest(t *testing.T) {
 	Convey("Where by request without paginate", t, func() {
 		r, err := http.NewRequest("GET", "/databases?dbname=prest&test=cool", nil)
@@ -41,350 +105,2229 @@ func TestWhereByRequest(t *testing.T) {
 	})
 }
 
-func TestQuery(t *testing.T) {
-	Convey("Query execution", t, func() {
-		sql := "SELECT schema_name FROM information_schema.schemata ORDER BY schema_name ASC"
-		json, err := Query(sql)
+func TestWhereByRequestInOperators(t *testing.T) {
+	Convey("Where by request with in", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?id=$in.1,2,3", nil)
 		So(err, ShouldBeNil)
-		So(len(json), ShouldBeGreaterThan, 0)
-	})
 
-	Convey("Query execution with params", t, func() {
-		sql := "SELECT schema_name FROM information_schema.schemata WHERE schema_name = $1 ORDER BY schema_name ASC"
-		json, err := Query(sql, "public")
+		where, values, err := WhereByRequest(r, 1)
 		So(err, ShouldBeNil)
-		So(len(json), ShouldBeGreaterThan, 0)
-	})
-
-	Convey("Query with invalid characters", t, func() {
-		sql := "SELECT ~~, ``, ˜ schema_name FROM information_schema.schemata WHERE schema_name = $1 ORDER BY schema_name ASC"
-		json, err := Query(sql, "public")
-		So(err, ShouldNotBeNil)
-		So(json, ShouldBeNil)
+		So(where, ShouldEqual, "id IN ($1, $2, $3)")
+		So(values, ShouldResemble, []interface{}{"1", "2", "3"})
 	})
 
-}
-
-func TestPaginateIfPossible(t *testing.T) {
-	Convey("Paginate if possible", t, func() {
-		r, err := http.NewRequest("GET", "/databases?dbname=prest&test=cool&_page=1&_page_size=20", nil)
+	Convey("Where by request with not in and commas inside values", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?name="+url.QueryEscape(`$nin."a,b",c`), nil)
 		So(err, ShouldBeNil)
-		where, err := PaginateIfPossible(r)
+
+		where, values, err := WhereByRequest(r, 1)
 		So(err, ShouldBeNil)
-		So(where, ShouldContainSubstring, "LIMIT 20 OFFSET(1 - 1) * 20")
+		So(where, ShouldEqual, "name NOT IN ($1, $2)")
+		So(values, ShouldResemble, []interface{}{"a,b", "c"})
 	})
-}
-
-func TestInsert(t *testing.T) {
-	config.InitConf()
-	Convey("Insert data into a table", t, func() {
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest-test-insert"
 
-		r := api.Request{
-			Data: m,
-		}
-		jsonByte, err := Insert("prest", "public", "test4", r)
+	Convey("Quotes are bound as values", t, func() {
+		clause, values, ok, err := inFilter("name", `$in.O'Brien,"say ""hi""",a"b`, 4)
 		So(err, ShouldBeNil)
-		So(len(jsonByte), ShouldBeGreaterThan, 0)
+		So(ok, ShouldBeTrue)
+		So(clause, ShouldEqual, "name IN ($4, $5, $6)")
+		So(values, ShouldResemble, []interface{}{"O'Brien", `say "hi"`, `a"b`})
+	})
 
-		var toJSON map[string]interface{}
-		err = json.Unmarshal(jsonByte, &toJSON)
+	Convey("Empty lists", t, func() {
+		clause, values, ok, err := inFilter("id", "$in.", 1)
 		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(clause, ShouldEqual, "false")
+		So(values, ShouldBeEmpty)
 
-		So(toJSON["id"], ShouldEqual, 1)
+		clause, _, _, err = inFilter("id", "$nin.", 1)
+		So(err, ShouldBeNil)
+		So(clause, ShouldEqual, "true")
 	})
 
-	Convey("Insert data into a table with contraints", t, func() {
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest"
-
-		r := api.Request{
-			Data: m,
-		}
-		_, err := Insert("prest", "public", "test3", r)
+	Convey("Invalid lists and other values", t, func() {
+		_, _, _, err := inFilter("id", "$in.a\nb", 1)
 		So(err, ShouldNotBeNil)
-	})
-
-	Convey("Try to insert data in non-permitted table", t, func() {
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest-no-write"
 
-		r := api.Request{
-			Data: m,
-		}
-		jsonByte, err := Insert("prest", "public", "test_readonly_access", r)
-		So(err, ShouldNotBeNil)
-		So(len(jsonByte), ShouldEqual, 0)
+		_, _, ok, err := inFilter("id", "$any.1,2", 1)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
 	})
 }
 
-func TestDelete(t *testing.T) {
-	config.InitConf()
-	Convey("Delete data from table", t, func() {
-		json, err := Delete("prest", "public", "test", "name=$1", []interface{}{"nuveo"})
+func TestWhereByRequestRelativeDate(t *testing.T) {
+	Convey("Where by request with relative dates", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?created_at=$gte:now-7d", nil)
 		So(err, ShouldBeNil)
-		So(len(json), ShouldBeGreaterThan, 0)
-	})
-	Convey("Delete permission", t, func() {
-		json, err := Delete("prest", "public", "test_readonly_access", "name=$1", []interface{}{"test01"})
-		So(err, ShouldNotBeNil)
-		So(len(json), ShouldBeLessThanOrEqualTo, 0)
+
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "created_at >= now() + $1::interval")
+		So(values, ShouldContain, "-7 days")
 	})
-}
 
-func TestUpdate(t *testing.T) {
-	config.InitConf()
-	Convey("Update data into a table", t, func() {
+	Convey("Relative dates with many terms", t, func() {
+		clause, interval, ok, err := relativeDate("created_at", "$lt:now-1M+12h", 2)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(clause, ShouldEqual, "created_at < now() + $2::interval")
+		So(interval, ShouldEqual, "-1 months +12 hours")
 
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest"
+		_, interval, _, err = relativeDate("created_at", "$lt:now-1d 2h", 2)
+		So(err, ShouldBeNil)
+		So(interval, ShouldEqual, "-1 days +2 hours")
 
-		r := api.Request{
-			Data: m,
-		}
-		json, err := Update("prest", "public", "test", "name=$1", []interface{}{"prest"}, r)
+		_, interval, ok, err = relativeDate("created_at", "$eq:now", 1)
 		So(err, ShouldBeNil)
-		So(len(json), ShouldBeGreaterThan, 0)
+		So(ok, ShouldBeTrue)
+		So(interval, ShouldEqual, "0 seconds")
 	})
 
-	Convey("Update data into a table with constraints", t, func() {
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest"
-
-		r := api.Request{
-			Data: m,
-		}
-		_, err := Update("prest", "public", "test3", "name=$1", []interface{}{"prest tester"}, r)
-		So(err, ShouldNotBeNil)
+	Convey("Values that aren't relative dates", t, func() {
+		_, _, ok, err := relativeDate("name", "now-7d", 1)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+		_, _, ok, err = relativeDate("tags", "$contains.{now}", 1)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
 	})
-	Convey("Update permission", t, func() {
-		m := make(map[string]interface{}, 0)
-		m["name"] = "prest"
 
-		r := api.Request{
-			Data: m,
+	Convey("Invalid relative dates", t, func() {
+		for _, value := range []string{"$gte:now-7", "$gte:now-d", "$gte:now*7d", "$gte:now-7x", "$gte:now-7d;drop"} {
+			_, _, _, err := relativeDate("created_at", value, 1)
+			So(err, ShouldNotBeNil)
 		}
-		json, err := Update("prest", "public", "test_readonly_access", "name=$1", []interface{}{"test01"}, r)
-		So(err, ShouldNotBeNil)
-		So(len(json), ShouldBeLessThanOrEqualTo, 0)
 	})
 }
 
-func TestChkInvaidIdentifier(t *testing.T) {
-	Convey("Check invalid character on identifier", t, func() {
-		chk := chkInvalidIdentifier("fildName")
-		So(chk, ShouldBeFalse)
-		chk = chkInvalidIdentifier("_9fildName")
-		So(chk, ShouldBeFalse)
-		chk = chkInvalidIdentifier("_fild.Name")
-		So(chk, ShouldBeFalse)
-
-		chk = chkInvalidIdentifier("0fildName")
-		So(chk, ShouldBeTrue)
-		chk = chkInvalidIdentifier("fild'Name")
-		So(chk, ShouldBeTrue)
-		chk = chkInvalidIdentifier("fild\"Name")
-		So(chk, ShouldBeTrue)
-		chk = chkInvalidIdentifier("fild;Name")
-		So(chk, ShouldBeTrue)
-		chk = chkInvalidIdentifier("_123456789_123456789_123456789_123456789_123456789_123456789_12345")
-		So(chk, ShouldBeTrue)
+func TestWhereByRequestArrayOperators(t *testing.T) {
+	Convey("Where by request with array contains", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?tags=$contains.{go,rest}", nil)
+		So(err, ShouldBeNil)
 
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "tags @> $1")
+		So(values, ShouldContain, "{go,rest}")
 	})
-}
 
-func TestJoinByRequest(t *testing.T) {
-	Convey("Join by request", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq:test.name", nil)
+	Convey("Where by request with array contained by", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?tags=$containedby.go,rest", nil)
 		So(err, ShouldBeNil)
 
-		join, err := JoinByRequest(r)
-		joinStr := strings.Join(join, " ")
-
+		where, values, err := WhereByRequest(r, 1)
 		So(err, ShouldBeNil)
-		So(joinStr, ShouldContainSubstring, "INNER JOIN test2 ON test2.name = test.name")
+		So(where, ShouldEqual, "tags <@ $1")
+		So(values, ShouldContain, "{go,rest}")
 	})
-	Convey("Join missing param", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq", nil)
-		So(err, ShouldBeNil)
 
-		_, err = JoinByRequest(r)
-		So(err, ShouldNotBeNil)
-	})
-	Convey("Join invalid operator", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:notexist:test.name", nil)
+	Convey("Where by request with array overlap", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?tags=$overlap.{go}", nil)
 		So(err, ShouldBeNil)
 
-		_, err = JoinByRequest(r)
-		So(err, ShouldNotBeNil)
-	})
-	Convey("Join with where", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq:test.name&name=nuveo&data->>description:jsonb=bla", nil)
+		where, _, err := WhereByRequest(r, 1)
 		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "tags && $1")
+	})
 
-		join, err := JoinByRequest(r)
-		joinStr := strings.Join(join, " ")
-
+	Convey("Where by request with any", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?id=$any.1,2,3", nil)
 		So(err, ShouldBeNil)
-		So(joinStr, ShouldContainSubstring, "INNER JOIN test2 ON test2.name = test.name")
 
 		where, values, err := WhereByRequest(r, 1)
 		So(err, ShouldBeNil)
-		So(where, ShouldContainSubstring, "name=$")
-		So(where, ShouldContainSubstring, "data->>'description'=$")
-		So(where, ShouldContainSubstring, " AND ")
-		So(values, ShouldContain, "nuveo")
-		So(values, ShouldContain, "bla")
+		So(where, ShouldEqual, "id = ANY($1)")
+		So(values, ShouldContain, "{1,2,3}")
 	})
-
 }
 
-func TestCountFields(t *testing.T) {
-	Convey("Count fields from table", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test5?_count=celphone", nil)
+func TestWhereByRequestJSONBOperators(t *testing.T) {
+	Convey("Where by request with jsonb containment", t, func() {
+		r, err := http.NewRequest("GET", `/prest/public/test?data=$jcontains.{"a":1}`, nil)
 		So(err, ShouldBeNil)
 
-		countQuery := CountByRequest(r)
-		So(countQuery, ShouldContainSubstring, "SELECT COUNT(celphone) FROM")
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "data @> $1::jsonb")
+		So(values, ShouldContain, `{"a":1}`)
 	})
 
-	Convey("Count all from table", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test5?_count=*", nil)
+	Convey("Where by request with jsonb key existence", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?data=$jhas.description", nil)
 		So(err, ShouldBeNil)
 
-		countQuery := CountByRequest(r)
-		So(countQuery, ShouldContainSubstring, "SELECT COUNT(*) FROM")
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "data ? $1")
+		So(values, ShouldContain, "description")
 	})
 
-	Convey("Try Count with empty '_count' field", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test5?_count=", nil)
+	Convey("Where by request with jsonb any keys existence", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?data=$jhasany.a,b", nil)
 		So(err, ShouldBeNil)
 
-		countQuery := CountByRequest(r)
-		So(countQuery, ShouldEqual, "")
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "data ?| $1")
+		So(values, ShouldContain, "{a,b}")
 	})
 }
 
-func TestDatabaseClause(t *testing.T) {
-	Convey("Return appropriate SELECT clause", t, func() {
-		r, err := http.NewRequest("GET", "/databases", nil)
+func TestWhereByRequestRegexOperators(t *testing.T) {
+	Convey("Where by request with regex", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?name=$regex.^pre.*t$", nil)
 		So(err, ShouldBeNil)
 
-		countQuery := DatabaseClause(r)
-		So(countQuery, ShouldEqual, fmt.Sprintf(statements.DatabasesSelect, statements.FieldDatabaseName))
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "name ~ $1")
+		So(values, ShouldContain, "^pre.*t$")
 	})
 
-	Convey("Return appropriate COUNT clause", t, func() {
-		r, err := http.NewRequest("GET", "/databases?_count=*", nil)
+	Convey("Where by request with case insensitive regex", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?name=$iregex.^PREST", nil)
 		So(err, ShouldBeNil)
 
-		countQuery := DatabaseClause(r)
-		So(countQuery, ShouldEqual, fmt.Sprintf(statements.DatabasesSelect, statements.FieldCountDatabaseName))
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "name ~* $1")
+		So(values, ShouldContain, "^PREST")
 	})
 }
 
-func TestSchemaClause(t *testing.T) {
-	Convey("Return appropriate SELECT clause", t, func() {
-		r, err := http.NewRequest("GET", "/schemas", nil)
+func TestQuery(t *testing.T) {
+	Convey("Query execution", t, func() {
+		sql := "SELECT schema_name FROM information_schema.schemata ORDER BY schema_name ASC"
+		json, err := Query(sql)
 		So(err, ShouldBeNil)
-
-		countQuery := SchemaClause(r)
-		So(countQuery, ShouldEqual, fmt.Sprintf(statements.SchemasSelect, statements.FieldSchemaName))
+		So(len(json), ShouldBeGreaterThan, 0)
 	})
 
-	Convey("Return appropriate COUNT clause", t, func() {
-		r, err := http.NewRequest("GET", "/schemas?_count=*", nil)
+	Convey("Query execution with params", t, func() {
+		sql := "SELECT schema_name FROM information_schema.schemata WHERE schema_name = $1 ORDER BY schema_name ASC"
+		json, err := Query(sql, "public")
 		So(err, ShouldBeNil)
+		So(len(json), ShouldBeGreaterThan, 0)
+	})
 
-		countQuery := SchemaClause(r)
-		So(countQuery, ShouldEqual, fmt.Sprintf(statements.SchemasSelect, statements.FieldCountSchemaName))
+	Convey("Query with invalid characters", t, func() {
+		sql := "SELECT ~~, ``, ˜ schema_name FROM information_schema.schemata WHERE schema_name = $1 ORDER BY schema_name ASC"
+		json, err := Query(sql, "public")
+		So(err, ShouldNotBeNil)
+		So(json, ShouldBeNil)
 	})
+
 }
 
-func TestGetQueryOperator(t *testing.T) {
-	Convey("Query operator eq", t, func() {
-		op, err := GetQueryOperator("$eq")
+func TestTransformResponse(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) {
+		cfg.Transforms = []config.TransformConf{
+			{
+				Table:   "test5",
+				Rename:  map[string]string{"name": "full_name"},
+				Drop:    []string{"celphone"},
+				Flatten: []string{"data"},
+			},
+		}
+	})
+
+	Convey("Transform response of a configured table", t, func() {
+		jsonData := []byte(`[{"id":1,"name":"prest","celphone":"444444","data":"{\"a\":1}"}]`)
+		transformed, err := TransformResponse(cfg, "prest", "public", "test5", jsonData)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, "=")
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(transformed, &rows)
+		So(err, ShouldBeNil)
+		So(rows[0]["full_name"], ShouldEqual, "prest")
+		So(rows[0], ShouldNotContainKey, "name")
+		So(rows[0], ShouldNotContainKey, "celphone")
+		So(rows[0], ShouldNotContainKey, "data")
+		So(rows[0]["a"], ShouldEqual, 1)
 	})
-	Convey("Query operator gt", t, func() {
-		op, err := GetQueryOperator("$gt")
+
+	Convey("Keep response of a table without transforms", t, func() {
+		jsonData := []byte(`[{"id":1,"name":"prest"}]`)
+		transformed, err := TransformResponse(cfg, "prest", "public", "test", jsonData)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, ">")
+		So(string(transformed), ShouldEqual, string(jsonData))
 	})
-	Convey("Query operator gte", t, func() {
-		op, err := GetQueryOperator("$gte")
+
+	Convey("Keep response of a table of another schema", t, func() {
+		scoped := configWith(func(cfg *config.Prest) {
+			cfg.Transforms = []config.TransformConf{{Table: "test5", Schema: "public", Drop: []string{"celphone"}}}
+		})
+		jsonData := []byte(`[{"id":1,"celphone":"444444"}]`)
+		transformed, err := TransformResponse(scoped, "prest", "audit", "test5", jsonData)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, ">=")
+		So(string(transformed), ShouldEqual, string(jsonData))
+
+		transformed, err = TransformResponse(scoped, "prest", "public", "test5", jsonData)
+		So(err, ShouldBeNil)
+		So(string(transformed), ShouldEqual, `[{"id":1}]`)
 	})
+}
+
+func TestPaginateIfPossible(t *testing.T) {
+	Convey("Paginate if possible", t, func() {
+		r, err := http.NewRequest("GET", "/databases?dbname=prest&test=cool&_page=1&_page_size=20", nil)
+		So(err, ShouldBeNil)
+		where, err := PaginateIfPossible(r)
+		So(err, ShouldBeNil)
+		So(where, ShouldContainSubstring, "LIMIT 20 OFFSET(1 - 1) * 20")
+	})
+	Convey("Don't paginate with pages or page sizes below 1", t, func() {
+		for _, query := range []string{"_page=0", "_page=-1", "_page=1&_page_size=0", "_page=1&_page_size=-20"} {
+			r, err := http.NewRequest("GET", "/databases?"+query, nil)
+			So(err, ShouldBeNil)
+			where, err := PaginateIfPossible(r)
+			So(err, ShouldNotBeNil)
+			So(where, ShouldBeEmpty)
+		}
+	})
+}
+
+func TestPaginationByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Pagination by request", t, func() {
+		r, err := http.NewRequest("GET", "/databases?_page=3&_page_size=20", nil)
+		So(err, ShouldBeNil)
+		page, size, paginated, err := PaginationByRequest(r)
+		So(err, ShouldBeNil)
+		So(paginated, ShouldBeTrue)
+		So(page, ShouldEqual, 3)
+		So(size, ShouldEqual, 20)
+	})
+	Convey("Pagination with default page size", t, func() {
+		r, err := http.NewRequest("GET", "/databases?_page=1", nil)
+		So(err, ShouldBeNil)
+		_, size, paginated, err := PaginationByRequest(r)
+		So(err, ShouldBeNil)
+		So(paginated, ShouldBeTrue)
+		So(size, ShouldEqual, defaultPageSize)
+	})
+	Convey("Pagination with pages or page sizes below 1", t, func() {
+		for _, tc := range []struct {
+			query string
+			err   string
+		}{
+			{"_page=0", "Invalid _page: 0"},
+			{"_page=-1", "Invalid _page: -1"},
+			{"_page=1&_page_size=0", "Invalid _page_size: 0"},
+			{"_page=2&_page_size=-10", "Invalid _page_size: -10"},
+			{"_page_size=0", ""},
+		} {
+			r, err := http.NewRequest("GET", "/databases?"+tc.query, nil)
+			So(err, ShouldBeNil)
+			_, _, paginated, err := PaginationByRequest(r)
+			if tc.err == "" {
+				So(err, ShouldBeNil)
+				So(paginated, ShouldBeFalse)
+				continue
+			}
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, tc.err)
+			So(paginated, ShouldBeFalse)
+		}
+	})
+	Convey("Without pagination", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		_, _, paginated, err := PaginationByRequest(r)
+		So(err, ShouldBeNil)
+		So(paginated, ShouldBeFalse)
+	})
+	Convey("Pagination with configured default and maximum page size", t, func() {
+		cfg := *config.Get()
+		cfg.DefaultPageSize, cfg.MaxPageSize = 50, 100
+		ctx := config.NewContext(context.Background(), &cfg)
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		page, size, paginated, err := PaginationByRequest(r.WithContext(ctx))
+		So(err, ShouldBeNil)
+		So(paginated, ShouldBeTrue)
+		So(page, ShouldEqual, 1)
+		So(size, ShouldEqual, 50)
+
+		r, err = http.NewRequest("GET", "/databases?_page=2&_page_size=10000000", nil)
+		So(err, ShouldBeNil)
+		page, size, _, err = PaginationByRequest(r.WithContext(ctx))
+		So(err, ShouldBeNil)
+		So(page, ShouldEqual, 2)
+		So(size, ShouldEqual, 100)
+	})
+}
+
+func TestRowRangeByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Rows of the Range header", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		rows, err := RowRangeByRequest(r)
+		So(err, ShouldBeNil)
+		So(rows, ShouldBeNil)
+
+		r.Header.Set("Range", "bytes=0-99")
+		rows, err = RowRangeByRequest(r)
+		So(err, ShouldBeNil)
+		So(rows, ShouldBeNil)
+
+		r.Header.Set("Range", "rows=100-199")
+		rows, err = RowRangeByRequest(r)
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, &RowRange{Offset: 100, Limit: 100})
+		So(rows.Clause(), ShouldEqual, "LIMIT 100 OFFSET 100")
+
+		r.Header.Set("Range", "rows=100-")
+		rows, err = RowRangeByRequest(r)
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, &RowRange{Offset: 100})
+		So(rows.Clause(), ShouldEqual, "OFFSET 100")
+	})
+	Convey("Invalid rows of the Range header", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		for _, value := range []string{"rows=10-9", "rows=-10", "rows=a-", "rows=0-9,20-29", "rows=10"} {
+			r.Header.Set("Range", value)
+			_, err = RowRangeByRequest(r)
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("Rows of the Range header with maximum page size", t, func() {
+		cfg := *config.Get()
+		cfg.MaxPageSize = 100
+		ctx := config.NewContext(context.Background(), &cfg)
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Range", "rows=1000-")
+		rows, err := RowRangeByRequest(r.WithContext(ctx))
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, &RowRange{Offset: 1000, Limit: 100})
+	})
+}
+
+func TestKeysetByRequest(t *testing.T) {
+	Convey("Keyset without cursor", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=id", nil)
+		So(err, ShouldBeNil)
+		keyset, err := KeysetByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(keyset, ShouldBeNil)
+	})
+	Convey("Keyset first page", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=id&_cursor=true&_page_size=5", nil)
+		So(err, ShouldBeNil)
+		keyset, err := KeysetByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(keyset.Where, ShouldEqual, "")
+		So(keyset.Limit, ShouldEqual, 5)
+		So(keyset.Columns, ShouldResemble, []string{"id"})
+	})
+	Convey("Keyset with page sizes below 1", t, func() {
+		for _, tc := range []struct {
+			query string
+			err   string
+		}{
+			{"_page_size=0", "Invalid _page_size: 0"},
+			{"_page_size=-1", "Invalid _page_size: -1"},
+		} {
+			r, err := http.NewRequest("GET", "/prest/public/test?_order=id&_cursor=true&"+tc.query, nil)
+			So(err, ShouldBeNil)
+			keyset, err := KeysetByRequest(r, 1)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, tc.err)
+			So(keyset, ShouldBeNil)
+		}
+	})
+	Convey("Keyset after cursor", t, func() {
+		cursor, err := EncodeCursor([]interface{}{"prest", 10})
+		So(err, ShouldBeNil)
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=-name,-id&_after="+cursor, nil)
+		So(err, ShouldBeNil)
+		keyset, err := KeysetByRequest(r, 3)
+		So(err, ShouldBeNil)
+		So(keyset.Where, ShouldEqual, "(name, id) < ($3, $4)")
+		So(len(keyset.Values), ShouldEqual, 2)
+	})
+	Convey("Keyset without order", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_cursor=true", nil)
+		So(err, ShouldBeNil)
+		_, err = KeysetByRequest(r, 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Keyset with mixed directions", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=name,-id&_cursor=true", nil)
+		So(err, ShouldBeNil)
+		_, err = KeysetByRequest(r, 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Keyset with invalid cursor", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=id&_after=notacursor", nil)
+		So(err, ShouldBeNil)
+		_, err = KeysetByRequest(r, 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Next cursor of a full page", t, func() {
+		keyset := &Keyset{Columns: []string{"id"}, Limit: 2}
+		cursor, err := keyset.NextCursor([]byte(`[{"id":1},{"id":2}]`))
+		So(err, ShouldBeNil)
+		values, err := DecodeCursor(cursor)
+		So(err, ShouldBeNil)
+		So(fmt.Sprint(values[0]), ShouldEqual, "2")
+	})
+	Convey("Next cursor of the last page", t, func() {
+		keyset := &Keyset{Columns: []string{"id"}, Limit: 2}
+		cursor, err := keyset.NextCursor([]byte(`[{"id":1}]`))
+		So(err, ShouldBeNil)
+		So(cursor, ShouldEqual, "")
+	})
+}
+
+func TestCSVRows(t *testing.T) {
+	Convey("Read CSV rows", t, func() {
+		columns, next, err := CSVRows(strings.NewReader("id,name\n1,prest\n2,\n"))
+		So(err, ShouldBeNil)
+		So(columns, ShouldResemble, []string{"id", "name"})
+
+		row, err := next()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, []interface{}{"1", "prest"})
+
+		row, err = next()
+		So(err, ShouldBeNil)
+		So(row[1], ShouldBeNil)
+
+		_, err = next()
+		So(err, ShouldEqual, io.EOF)
+	})
+}
+
+func TestNDJSONRows(t *testing.T) {
+	Convey("Read NDJSON rows", t, func() {
+		columns, next, err := NDJSONRows(strings.NewReader("{\"name\":\"prest\",\"id\":1}\n{\"id\":2,\"data\":{\"a\":1}}\n"))
+		So(err, ShouldBeNil)
+		So(columns, ShouldResemble, []string{"id", "name"})
+
+		row, err := next()
+		So(err, ShouldBeNil)
+		So(fmt.Sprint(row[0]), ShouldEqual, "1")
+		So(row[1], ShouldEqual, "prest")
+
+		row, err = next()
+		So(err, ShouldBeNil)
+		So(row[1], ShouldBeNil)
+
+		_, err = next()
+		So(err, ShouldEqual, io.EOF)
+	})
+}
+
+func TestCopyFrom(t *testing.T) {
+	config.InitConf()
+	Convey("Copy rows into a table", t, func() {
+		_, next, err := CSVRows(strings.NewReader("name\ncopy01\ncopy02\n"))
+		So(err, ShouldBeNil)
+		rows, err := CopyFrom(config.Get(), "prest", "public", "test", []string{"name"}, next, nil)
+		So(err, ShouldBeNil)
+		So(rows, ShouldEqual, 2)
+	})
+	Convey("Copy rows into a table without permission", t, func() {
+		_, next, err := CSVRows(strings.NewReader("name\ncopy01\n"))
+		So(err, ShouldBeNil)
+		_, err = CopyFrom(config.Get(), "prest", "public", "test_readonly_access", []string{"name"}, next, nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Copy rows skipping the conflicting ones", t, func() {
+		_, next, err := CSVRows(strings.NewReader("name\nprest\ncopy_unique01\n"))
+		So(err, ShouldBeNil)
+		rows, skipped, err := CopyFromIgnoring(config.Get(), "prest", "public", "test3", []string{"name"}, next, nil)
+		So(err, ShouldBeNil)
+		So(rows, ShouldEqual, 1)
+		So(skipped, ShouldEqual, 1)
+	})
+}
+
+func TestOnConflictByRequest(t *testing.T) {
+	Convey("Conflicts of the inserts", t, func() {
+		r, err := http.NewRequest("POST", "/prest/public/test3", nil)
+		So(err, ShouldBeNil)
+		ignore, err := OnConflictByRequest(r)
+		So(err, ShouldBeNil)
+		So(ignore, ShouldBeFalse)
+
+		r, err = http.NewRequest("POST", "/prest/public/test3?_on_conflict=ignore", nil)
+		So(err, ShouldBeNil)
+		ignore, err = OnConflictByRequest(r)
+		So(err, ShouldBeNil)
+		So(ignore, ShouldBeTrue)
+
+		r, err = http.NewRequest("POST", "/prest/public/test3?_on_conflict=update", nil)
+		So(err, ShouldBeNil)
+		_, err = OnConflictByRequest(r)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	Convey("Split SQL script statements", t, func() {
+		script := `-- comment;
+CREATE TABLE a (id int); INSERT INTO a VALUES (1);
+INSERT INTO b VALUES ('it''s; fine');
+CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql;
+SELECT $1 ;;
+SELECT 1`
+		next := SplitStatements(strings.NewReader(script))
+		stmts := []string{}
+		for {
+			stmt, err := next()
+			if err == io.EOF {
+				break
+			}
+			So(err, ShouldBeNil)
+			stmts = append(stmts, stmt)
+		}
+		So(len(stmts), ShouldEqual, 6)
+		So(stmts[2], ShouldEqual, "INSERT INTO b VALUES ('it''s; fine')")
+		So(stmts[3], ShouldEqual, "CREATE FUNCTION f() RETURNS int AS $body$ BEGIN RETURN 1; END; $body$ LANGUAGE plpgsql")
+		So(stmts[5], ShouldEqual, "SELECT 1")
+	})
+	Convey("Split SQL script with unterminated quote", t, func() {
+		_, err := SplitStatements(strings.NewReader("SELECT 'abc"))()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExecScript(t *testing.T) {
+	Convey("Execute SQL script in a transaction", t, func() {
+		result, err := ExecScript(config.Get(), SplitStatements(strings.NewReader("SELECT 1; SELECT * FROM notexist; SELECT 2;")), true)
+		So(err, ShouldBeNil)
+		So(result.Statements, ShouldEqual, 3)
+		So(result.Applied, ShouldEqual, 0)
+		So(len(result.Errors), ShouldEqual, 1)
+		So(result.Errors[0].Statement, ShouldEqual, 2)
+	})
+	Convey("Execute SQL script without transaction", t, func() {
+		result, err := ExecScript(config.Get(), SplitStatements(strings.NewReader("SELECT 1; SELECT * FROM notexist; SELECT 2;")), false)
+		So(err, ShouldBeNil)
+		So(result.Applied, ShouldEqual, 2)
+		So(len(result.Errors), ShouldEqual, 1)
+	})
+}
+
+func TestCoalesce(t *testing.T) {
+	Convey("Concurrent calls with the same key share one execution", t, func() {
+		var executions int32
+		release := make(chan struct{})
+		fn := func() ([]byte, error) {
+			atomic.AddInt32(&executions, 1)
+			<-release
+			return []byte(`[{"id":1}]`), nil
+		}
+
+		var wg sync.WaitGroup
+		results := make([][]byte, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _ = coalesce("key", fn)
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		So(atomic.LoadInt32(&executions), ShouldEqual, 1)
+		for _, r := range results {
+			So(string(r), ShouldEqual, `[{"id":1}]`)
+		}
+	})
+	Convey("Query key depends on the parameters types", t, func() {
+		So(queryKey("SELECT $1", []interface{}{1}), ShouldNotEqual, queryKey("SELECT $1", []interface{}{"1"}))
+		So(queryKey("SELECT $1", []interface{}{1}), ShouldEqual, queryKey("SELECT $1", []interface{}{1}))
+	})
+}
+
+func TestQueryTotal(t *testing.T) {
+	Convey("Total of a query", t, func() {
+		total, err := QueryTotal("SELECT * FROM prest.public.test2 WHERE name=$1", "tester02")
+		So(err, ShouldBeNil)
+		So(total, ShouldEqual, 1)
+	})
+}
+
+func TestInsert(t *testing.T) {
+	config.InitConf()
+	Convey("Insert data into a table", t, func() {
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest-test-insert"
+
+		r := api.Request{
+			Data: m,
+		}
+		jsonByte, err := Insert("prest", "public", "test4", r)
+		So(err, ShouldBeNil)
+		So(len(jsonByte), ShouldBeGreaterThan, 0)
+
+		var toJSON map[string]interface{}
+		err = json.Unmarshal(jsonByte, &toJSON)
+		So(err, ShouldBeNil)
+
+		So(toJSON["id"], ShouldEqual, 1)
+		So(toJSON["name"], ShouldEqual, "prest-test-insert")
+	})
+
+	Convey("Insert with unknown columns", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "prest-unknown", "nmae": "x", "age": 1},
+		}
+		_, err := Insert("prest", "public", "test4", r)
+		So(err, ShouldNotBeNil)
+		unknown, ok := err.(*UnknownColumnsError)
+		So(ok, ShouldBeTrue)
+		So(unknown.Table, ShouldEqual, "test4")
+		So(unknown.Columns, ShouldResemble, []string{"age", "nmae"})
+	})
+
+	Convey("Insert returns the columns with default values", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "prest-default"},
+		}
+		jsonByte, err := insertWith(unrestricted(), "prest", "public", "test_put", r)
+		So(err, ShouldBeNil)
+
+		var toJSON map[string]interface{}
+		err = json.Unmarshal(jsonByte, &toJSON)
+		So(err, ShouldBeNil)
+		So(toJSON["celphone"], ShouldEqual, "unknown")
+		So(toJSON["id"], ShouldNotBeNil)
+	})
+
+	Convey("Insert data into a table with contraints", t, func() {
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest"
+
+		r := api.Request{
+			Data: m,
+		}
+		_, err := Insert("prest", "public", "test3", r)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Try to insert data in non-permitted table", t, func() {
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest-no-write"
+
+		r := api.Request{
+			Data: m,
+		}
+		jsonByte, err := Insert("prest", "public", "test_readonly_access", r)
+		So(err, ShouldNotBeNil)
+		So(len(jsonByte), ShouldEqual, 0)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	config.InitConf()
+	Convey("Delete data from table", t, func() {
+		json, err := Delete("prest", "public", "test", "name=$1", []interface{}{"nuveo"})
+		So(err, ShouldBeNil)
+		So(len(json), ShouldBeGreaterThan, 0)
+	})
+	Convey("Delete permission", t, func() {
+		json, err := Delete("prest", "public", "test_readonly_access", "name=$1", []interface{}{"test01"})
+		So(err, ShouldNotBeNil)
+		So(len(json), ShouldBeLessThanOrEqualTo, 0)
+	})
+}
+
+func TestReturning(t *testing.T) {
+	config.InitConf()
+	Convey("Insert returning the row", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "returning"},
+		}
+		data, err := InsertReturning("prest", "public", "test", r)
+		So(err, ShouldBeNil)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldEqual, 1)
+		So(rows[0]["name"], ShouldEqual, "returning")
+	})
+	Convey("Delete returning the rows", t, func() {
+		data, rowsAffected, err := DeleteReturning("prest", "public", "test", "name=$1", []interface{}{"returning"})
+		So(err, ShouldBeNil)
+		So(rowsAffected, ShouldBeGreaterThan, 0)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(int64(len(rows)), ShouldEqual, rowsAffected)
+	})
+	Convey("Insert returning only the informed fields", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "returning"},
+		}
+		data, err := InsertReturning("prest", "public", "test", r, "id")
+		So(err, ShouldBeNil)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldEqual, 1)
+		So(rows[0], ShouldContainKey, "id")
+		So(rows[0], ShouldNotContainKey, "name")
+	})
+	Convey("Insert returning with invalid field", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "returning"},
+		}
+		_, err := InsertReturning("prest", "public", "test", r, "id;")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Delete returning without permission", t, func() {
+		_, _, err := DeleteReturning("prest", "public", "test_readonly_access", "name=$1", []interface{}{"test01"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSandbox(t *testing.T) {
+	config.InitConf()
+	sandbox := configWith(func(cfg *config.Prest) { cfg.Sandbox = true })
+	Convey("Insert in sandbox mode is rolled back", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "sandbox"},
+		}
+		_, err := insertWith(sandbox, "prest", "public", "test", r)
+		So(err, ShouldBeNil)
+
+		data, err := Query("SELECT * FROM test WHERE name=$1", "sandbox")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "[]")
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	config.InitConf()
+	Convey("Update data into a table", t, func() {
+
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest"
+
+		r := api.Request{
+			Data: m,
+		}
+		data, rowsAffected, err := Update("prest", "public", "test", "name=$1", []interface{}{"prest"}, r)
+		So(err, ShouldBeNil)
+		So(len(data), ShouldBeGreaterThan, 0)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(int64(len(rows)), ShouldEqual, rowsAffected)
+	})
+
+	Convey("Update data into a table with constraints", t, func() {
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest"
+
+		r := api.Request{
+			Data: m,
+		}
+		_, _, err := Update("prest", "public", "test3", "name=$1", []interface{}{"prest tester"}, r)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Update permission", t, func() {
+		m := make(map[string]interface{}, 0)
+		m["name"] = "prest"
+
+		r := api.Request{
+			Data: m,
+		}
+		json, _, err := Update("prest", "public", "test_readonly_access", "name=$1", []interface{}{"test01"}, r)
+		So(err, ShouldNotBeNil)
+		So(len(json), ShouldBeLessThanOrEqualTo, 0)
+	})
+}
+
+func TestReplace(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Replace sets the missing columns to default", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"name": "prest"},
+		}
+		data, rowsAffected, err := replaceWith(cfg, "prest", "public", "test_put", "id=$1", []interface{}{1}, r)
+		So(err, ShouldBeNil)
+		So(rowsAffected, ShouldEqual, 1)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(rows[0]["id"], ShouldEqual, float64(1))
+		So(rows[0]["celphone"], ShouldEqual, "unknown")
+	})
+	Convey("Replace sets the missing columns to NULL", t, func() {
+		nulls := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.PutMissing = "null"
+		})
+		r := api.Request{
+			Data: map[string]interface{}{"name": "prest"},
+		}
+		data, _, err := replaceWith(nulls, "prest", "public", "test_put", "id=$1", []interface{}{1}, r)
+		So(err, ShouldBeNil)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(rows[0]["celphone"], ShouldBeNil)
+	})
+}
+
+func TestGeneratedColumns(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Generated columns of the table", t, func() {
+		cols, err := GeneratedColumns("prest", "public", "test_generated")
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"id", "total"})
+	})
+	Convey("Insert returns the generated columns", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"price": 2.5, "quantity": 4},
+		}
+		data, err := insertWith(cfg, "prest", "public", "test_generated", r)
+		So(err, ShouldBeNil)
+
+		var row map[string]interface{}
+		err = json.Unmarshal(data, &row)
+		So(err, ShouldBeNil)
+		So(row["id"], ShouldEqual, float64(1))
+		So(row["total"], ShouldEqual, float64(10))
+	})
+	Convey("Insert of a generated column", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"price": 2.5, "quantity": 4, "total": 1},
+		}
+		_, err := insertWith(cfg, "prest", "public", "test_generated", r)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "Column total is generated")
+	})
+	Convey("Update of a generated column", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{"id": 2},
+		}
+		_, _, err := updateWith(cfg, "prest", "public", "test_generated", "id=$1", []interface{}{1}, r)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "Column id is generated")
+	})
+	Convey("Replace keeps the generated columns", t, func() {
+		nulls := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.PutMissing = "null"
+		})
+		r := api.Request{
+			Data: map[string]interface{}{"price": 3, "quantity": 2},
+		}
+		data, _, err := replaceWith(nulls, "prest", "public", "test_generated", "id=$1", []interface{}{1}, r)
+		So(err, ShouldBeNil)
+
+		var rows []map[string]interface{}
+		err = json.Unmarshal(data, &rows)
+		So(err, ShouldBeNil)
+		So(rows[0]["total"], ShouldEqual, float64(6))
+	})
+}
+
+func TestParseTuple(t *testing.T) {
+	Convey("Values of a tuple", t, func() {
+		values, err := parseTuple(`(1,,"a ""b"", c","",x\\y)`)
+		So(err, ShouldBeNil)
+		So(len(values), ShouldEqual, 5)
+		So(*values[0], ShouldEqual, "1")
+		So(values[1], ShouldBeNil)
+		So(*values[2], ShouldEqual, `a "b", c`)
+		So(*values[3], ShouldEqual, "")
+		So(*values[4], ShouldEqual, `x\y`)
+	})
+	Convey("Invalid tuples", t, func() {
+		_, err := parseTuple("1,2")
+		So(err, ShouldNotBeNil)
+		_, err = parseTuple(`("1,2)`)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Object of a nested tuple", t, func() {
+		fields := []compositeField{
+			{Name: "label", Type: "text"},
+			{Name: "point", Type: "USER-DEFINED", Fields: []compositeField{{Name: "x", Type: "numeric"}, {Name: "y", Type: "numeric"}}},
+			{Name: "active", Type: "boolean"},
+		}
+		object, err := compositeObject(`(home,"(1.5,NaN)",t)`, fields)
+		So(err, ShouldBeNil)
+		b, err := json.Marshal(object)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"active":true,"label":"home","point":{"x":1.5,"y":"NaN"}}`)
+
+		_, err = compositeObject(`(home)`, fields)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestComposite(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Composite columns as objects", t, func() {
+		jsonData, err := Query("SELECT id, location FROM prest.public.test_composite WHERE id = $1", 1)
+		So(err, ShouldBeNil)
+		jsonData, err = CompositeResponse("prest", "public", "test_composite", jsonData)
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `[{"id":1,"location":{"active":true,"label":"home, \"main\"","point":{"x":1.5,"y":2}}}]`)
+	})
+	Convey("Tables without composite columns are not changed", t, func() {
+		jsonData := []byte(`[{"id":1,"name":"prest"}]`)
+		transformed, err := CompositeResponse("prest", "public", "test", jsonData)
+		So(err, ShouldBeNil)
+		So(transformed, ShouldResemble, jsonData)
+	})
+	Convey("Insert and update objects of composite columns", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{
+				"location": map[string]interface{}{"label": "office", "point": map[string]interface{}{"x": 3, "y": 4}},
+			},
+		}
+		jsonData, err := insertWith(cfg, "prest", "public", "test_composite", r)
+		So(err, ShouldBeNil)
+
+		var row map[string]interface{}
+		So(json.Unmarshal(jsonData, &row), ShouldBeNil)
+		location := row["location"].(map[string]interface{})
+		So(location["label"], ShouldEqual, "office")
+		So(location["active"], ShouldBeNil)
+
+		r.Data["location"] = map[string]interface{}{"label": "moved", "active": true}
+		jsonData, rowsAffected, err := updateWith(cfg, "prest", "public", "test_composite", "id=$1", []interface{}{row["id"]}, r)
+		So(err, ShouldBeNil)
+		So(rowsAffected, ShouldEqual, 1)
+
+		var rows []map[string]interface{}
+		So(json.Unmarshal(jsonData, &rows), ShouldBeNil)
+		location = rows[0]["location"].(map[string]interface{})
+		So(location["label"], ShouldEqual, "moved")
+		So(location["point"], ShouldBeNil)
+	})
+}
+
+func TestParseRange(t *testing.T) {
+	Convey("Object of a range", t, func() {
+		object, err := rangeObject("[1,10)", "integer")
+		So(err, ShouldBeNil)
+		b, err := json.Marshal(object)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldEqual, `{"bounds":"[)","lower":1,"upper":10}`)
+
+		object, err = rangeObject(`("2017-01-01 00:00:00+00",]`, "timestamp with time zone")
+		So(err, ShouldBeNil)
+		So(object["lower"], ShouldEqual, "2017-01-01 00:00:00+00")
+		So(object["upper"], ShouldBeNil)
+		So(object["bounds"], ShouldEqual, "(]")
+
+		object, err = rangeObject("empty", "integer")
+		So(err, ShouldBeNil)
+		So(object["bounds"], ShouldEqual, "empty")
+	})
+	Convey("Invalid ranges", t, func() {
+		_, err := rangeObject("[1,2,3)", "integer")
+		So(err, ShouldNotBeNil)
+		_, err = rangeObject("{1,2}", "integer")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Literal of a range object", t, func() {
+		literal, err := rangeLiteral(map[string]interface{}{"lower": 1.0, "upper": json.Number("10"), "bounds": "[]"})
+		So(err, ShouldBeNil)
+		So(literal, ShouldEqual, "[1,10]")
+
+		literal, err = rangeLiteral(map[string]interface{}{"lower": `a "b"`})
+		So(err, ShouldBeNil)
+		So(literal, ShouldEqual, `["a \"b\"",)`)
+
+		literal, err = rangeLiteral(map[string]interface{}{"bounds": "empty"})
+		So(err, ShouldBeNil)
+		So(literal, ShouldEqual, "empty")
+
+		_, err = rangeLiteral(map[string]interface{}{"bounds": "[["})
+		So(err, ShouldNotBeNil)
+		_, err = rangeLiteral(map[string]interface{}{"lower": true})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Range filters", t, func() {
+		clause, bind, ok := filterByOperator("seats", "$contains.[2,4)", 1)
+		So(ok, ShouldBeTrue)
+		So(clause, ShouldEqual, "seats @> $1")
+		So(bind, ShouldEqual, "[2,4)")
+
+		clause, bind, ok = filterByOperator("seats", "$overlaps.(,5]", 2)
+		So(ok, ShouldBeTrue)
+		So(clause, ShouldEqual, "seats && $2")
+		So(bind, ShouldEqual, "(,5]")
+
+		_, bind, _ = filterByOperator("tags", "$overlaps.go,rest", 1)
+		So(bind, ShouldEqual, "{go,rest}")
+	})
+}
+
+func TestRange(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Range columns as objects", t, func() {
+		jsonData, err := Query("SELECT id, seats, period FROM prest.public.test_range WHERE id = $1", 1)
+		So(err, ShouldBeNil)
+		jsonData, err = RangeResponse("prest", "public", "test_range", jsonData)
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `[{"id":1,"period":{"bounds":"[)","lower":"2017-01-01 00:00:00+00","upper":null},"seats":{"bounds":"[)","lower":1,"upper":10}}]`)
+	})
+	Convey("Insert and filter range columns", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{
+				"seats": map[string]interface{}{"lower": 20, "upper": 30, "bounds": "[]"},
+			},
+		}
+		jsonData, err := insertWith(cfg, "prest", "public", "test_range", r)
+		So(err, ShouldBeNil)
+		var row map[string]interface{}
+		So(json.Unmarshal(jsonData, &row), ShouldBeNil)
+		So(row["seats"], ShouldEqual, "[20,31)")
+
+		jsonData, err = Query("SELECT id FROM prest.public.test_range WHERE seats && $1", "[25,26)")
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldContainSubstring, fmt.Sprintf(`"id":%v`, row["id"]))
+	})
+}
+
+func TestBytea(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Bytea columns in base64 and hex", t, func() {
+		jsonData, err := Query("SELECT id, content FROM prest.public.test_bytea WHERE id = $1", 1)
+		So(err, ShouldBeNil)
+		base64Data, err := ByteaResponse("prest", "public", "test_bytea", ByteaBase64, jsonData)
+		So(err, ShouldBeNil)
+		So(string(base64Data), ShouldEqual, `[{"content":"AQL/","id":1}]`)
+		hexData, err := ByteaResponse("prest", "public", "test_bytea", ByteaHex, jsonData)
+		So(err, ShouldBeNil)
+		So(string(hexData), ShouldEqual, `[{"content":"0102ff","id":1}]`)
+	})
+	Convey("Insert bytea columns in base64", t, func() {
+		r := api.Request{Data: map[string]interface{}{"content": "AQL/"}}
+		So(ByteaRequest("prest", "public", "test_bytea", ByteaBase64, r.Data), ShouldBeNil)
+		jsonData, err := insertWith(cfg, "prest", "public", "test_bytea", r)
+		So(err, ShouldBeNil)
+		var row map[string]interface{}
+		So(json.Unmarshal(jsonData, &row), ShouldBeNil)
+		So(row["content"], ShouldEqual, `\x0102ff`)
+	})
+}
+
+func TestByteaValue(t *testing.T) {
+	Convey("Postgres text of the bytea values", t, func() {
+		value, err := byteaValue(`\x0102ff`)
+		So(err, ShouldBeNil)
+		So(value, ShouldResemble, []byte{1, 2, 255})
+
+		value, err = byteaValue(`a\001\\b\377`)
+		So(err, ShouldBeNil)
+		So(value, ShouldResemble, []byte{'a', 1, '\\', 'b', 255})
+
+		value, err = byteaValue(`\x`)
+		So(err, ShouldBeNil)
+		So(value, ShouldBeEmpty)
+
+		_, err = byteaValue(`\xzz`)
+		So(err, ShouldNotBeNil)
+		_, err = byteaValue(`a\9`)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestTimestamp(t *testing.T) {
+	config.InitConf()
+	Convey("Timestamp columns as epoch in a timezone", t, func() {
+		jsonData, err := Query("SELECT id, created, day FROM prest.public.test_timestamp WHERE id = $1", 1)
+		So(err, ShouldBeNil)
+		location, err := time.LoadLocation("America/Sao_Paulo")
+		So(err, ShouldBeNil)
+		epochData, err := TimestampResponse("prest", "public", "test_timestamp", TimestampFormat{Layout: TimestampEpoch}, jsonData)
+		So(err, ShouldBeNil)
+		So(string(epochData), ShouldEqual, `[{"created":1483326245.5,"day":1483315200,"id":1}]`)
+		zoneData, err := TimestampResponse("prest", "public", "test_timestamp", TimestampFormat{Location: location}, jsonData)
+		So(err, ShouldBeNil)
+		So(string(zoneData), ShouldEqual, `[{"created":"2017-01-02T01:04:05.5-02:00","day":"2017-01-02T00:00:00-02:00","id":1}]`)
+	})
+}
+
+func TestTimestampValue(t *testing.T) {
+	location, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Convey("JSON of the timestamps", t, func() {
+		So(timestampValue("2017-01-02T03:04:05.5Z", TimestampFormat{Layout: TimestampRFC3339}, true), ShouldEqual, "2017-01-02T03:04:05.5Z")
+		So(timestampValue("2017-01-02T03:04:05.5Z", TimestampFormat{Layout: TimestampRFC3339, Location: location}, true), ShouldEqual, "2017-01-02T01:04:05.5-02:00")
+		So(timestampValue("2017-01-02T01:04:05.5-02:00", TimestampFormat{Layout: TimestampRFC3339}, true), ShouldEqual, "2017-01-02T03:04:05.5Z")
+		So(timestampValue("2017-01-02T03:04:05Z", TimestampFormat{Location: location}, false), ShouldEqual, "2017-01-02T03:04:05-02:00")
+		So(timestampValue("2017-01-02T03:04:05.5Z", TimestampFormat{Layout: TimestampEpoch}, true), ShouldEqual, json.Number("1483326245.5"))
+		So(timestampValue("1969-12-31T23:59:59.5Z", TimestampFormat{Layout: TimestampEpoch}, true), ShouldEqual, json.Number("-0.5"))
+		So(timestampValue("1969-12-31T23:59:58.75Z", TimestampFormat{Layout: TimestampEpoch}, true), ShouldEqual, json.Number("-1.25"))
+		So(timestampValue("2017-01-02T03:04:05Z", TimestampFormat{Layout: "02/01/2006 15:04"}, true), ShouldEqual, "02/01/2017 03:04")
+		So(timestampValue("infinity", TimestampFormat{Layout: TimestampEpoch}, true), ShouldEqual, "infinity")
+	})
+}
+
+func TestTimestampByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Format and timezone of the timestamp columns", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_timestamp", nil)
+		So(err, ShouldBeNil)
+		format, err := TimestampByRequest(r)
+		So(err, ShouldBeNil)
+		So(format.Layout, ShouldEqual, "")
+		So(format.Location, ShouldBeNil)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_timestamp?_timestamp=epoch&_tz=America/Sao_Paulo", nil)
+		So(err, ShouldBeNil)
+		format, err = TimestampByRequest(r)
+		So(err, ShouldBeNil)
+		So(format.Layout, ShouldEqual, TimestampEpoch)
+		So(format.Location.String(), ShouldEqual, "America/Sao_Paulo")
+
+		r, err = http.NewRequest("GET", "/prest/public/test_timestamp?_timestamp=2006-01-02", nil)
+		So(err, ShouldBeNil)
+		format, err = TimestampByRequest(r)
+		So(err, ShouldBeNil)
+		So(format.Layout, ShouldEqual, "2006-01-02")
+
+		r, err = http.NewRequest("GET", "/prest/public/test_timestamp?_timestamp=unix", nil)
+		So(err, ShouldBeNil)
+		_, err = TimestampByRequest(r)
+		So(err, ShouldNotBeNil)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_timestamp?_tz=Mars/Olympus", nil)
+		So(err, ShouldBeNil)
+		_, err = TimestampByRequest(r)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Configured format and timezone of the timestamp columns", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.TimestampFormat = TimestampRFC3339
+			cfg.TimestampZone = "UTC"
+		})
+		r, err := http.NewRequest("GET", "/prest/public/test_timestamp", nil)
+		So(err, ShouldBeNil)
+		r = withConfig(r, cfg)
+		format, err := TimestampByRequest(r)
+		So(err, ShouldBeNil)
+		So(format.Layout, ShouldEqual, TimestampRFC3339)
+		So(format.Location, ShouldEqual, time.UTC)
+	})
+}
+
+func TestByteaByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Encoding of the bytea columns", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_bytea", nil)
+		So(err, ShouldBeNil)
+		encoding, err := ByteaByRequest(r)
+		So(err, ShouldBeNil)
+		So(encoding, ShouldEqual, "")
+
+		r, err = http.NewRequest("GET", "/prest/public/test_bytea?_bytea=base64", nil)
+		So(err, ShouldBeNil)
+		encoding, err = ByteaByRequest(r)
+		So(err, ShouldBeNil)
+		So(encoding, ShouldEqual, ByteaBase64)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_bytea?_bytea=octal", nil)
+		So(err, ShouldBeNil)
+		_, err = ByteaByRequest(r)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Configured encoding of the bytea columns", t, func() {
+		cfg := configWith(func(cfg *config.Prest) { cfg.Bytea = ByteaHex })
+		r, err := http.NewRequest("GET", "/prest/public/test_bytea", nil)
+		So(err, ShouldBeNil)
+		r = withConfig(r, cfg)
+		encoding, err := ByteaByRequest(r)
+		So(err, ShouldBeNil)
+		So(encoding, ShouldEqual, ByteaHex)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_bytea?_bytea=base64", nil)
+		So(err, ShouldBeNil)
+		encoding, err = ByteaByRequest(withConfig(r, cfg))
+		So(err, ShouldBeNil)
+		So(encoding, ShouldEqual, ByteaBase64)
+	})
+}
+
+func TestNestedInsert(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Insert with a nested row of the referenced table", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{
+				"name":    "prest",
+				"address": map[string]interface{}{"street": "nested"},
+			},
+		}
+		data, err := insertWith(cfg, "prest", "public", "test_person", r)
+		So(err, ShouldBeNil)
+
+		var row map[string]interface{}
+		err = json.Unmarshal(data, &row)
+		So(err, ShouldBeNil)
+		So(row["address_id"], ShouldNotBeNil)
+
+		data, err = Query("SELECT street FROM test_address WHERE id=$1", row["address_id"])
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `[{"street":"nested"}]`)
+	})
+	Convey("Insert with a nested object that is not a foreign key", t, func() {
+		r := api.Request{
+			Data: map[string]interface{}{
+				"name":  "prest",
+				"other": map[string]interface{}{"street": "nested"},
+			},
+		}
+		_, err := insertWith(cfg, "prest", "public", "test_person", r)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Insert with a nested row of a table the API key can't write", t, func() {
+		keyCfg := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.APIKey = &config.APIKeyConf{Name: "people", Tables: []config.TablesConf{
+				{Name: "test_person", Permissions: []string{"read", "write"}},
+			}}
+		})
+		r := api.Request{
+			Data: map[string]interface{}{
+				"name":    "prest",
+				"address": map[string]interface{}{"street": "nested"},
+			},
+		}
+		_, err := insertWith(keyCfg, "prest", "public", "test_person", r)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestFindForeignKey(t *testing.T) {
+	fks := []ForeignKey{
+		{Column: "address_id", RefSchema: "public", RefTable: "addresses", RefColumn: "id"},
+		{Column: "owner", RefSchema: "public", RefTable: "users", RefColumn: "id"},
+	}
+	Convey("Find foreign key of nested objects", t, func() {
+		fk, ok := findForeignKey(fks, "address")
+		So(ok, ShouldBeTrue)
+		So(fk.Column, ShouldEqual, "address_id")
+
+		fk, ok = findForeignKey(fks, "owner")
+		So(ok, ShouldBeTrue)
+		So(fk.RefTable, ShouldEqual, "users")
+
+		fk, ok = findForeignKey(fks, "users")
+		So(ok, ShouldBeTrue)
+		So(fk.Column, ShouldEqual, "owner")
+
+		_, ok = findForeignKey(fks, "other")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestPrimaryKey(t *testing.T) {
+	Convey("Primary key columns", t, func() {
+		cols, err := PrimaryKey("prest", "public", "test_put")
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"id"})
+	})
+}
+
+func TestChkInvaidIdentifier(t *testing.T) {
+	Convey("Check invalid character on identifier", t, func() {
+		chk := chkInvalidIdentifier("fildName")
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier("")
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier("_9fildName")
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier("_fild.Name")
+		So(chk, ShouldBeFalse)
+
+		chk = chkInvalidIdentifier("0fildName")
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier("fild'Name")
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier("fild\"Name")
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier("fild;Name")
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier("_123456789_123456789_123456789_123456789_123456789_123456789_12345")
+		So(chk, ShouldBeTrue)
+
+		chk = chkInvalidIdentifier(`"first name"`)
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier(`"Order"`)
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier(`a."b.c"`)
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier(`"say ""hi"""`)
+		So(chk, ShouldBeFalse)
+		chk = chkInvalidIdentifier(`"first name`)
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier(`""`)
+		So(chk, ShouldBeTrue)
+		chk = chkInvalidIdentifier(`"a"b`)
+		So(chk, ShouldBeTrue)
+	})
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	Convey("Quote identifiers", t, func() {
+		for identifier, quoted := range map[string]string{
+			"name":         "name",
+			"User":         `"User"`,
+			"order":        `"order"`,
+			"test.name":    "test.name",
+			`"first name"`: `"first name"`,
+			`"Order".Date`: `"Order"."Date"`,
+			`"name"`:       "name",
+			`a."b.c"`:      `a."b.c"`,
+		} {
+			q, err := quoteIdentifier(identifier)
+			So(err, ShouldBeNil)
+			So(q, ShouldEqual, quoted)
+		}
+		_, err := quoteIdentifier("fild;Name")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Quote tables", t, func() {
+		So(QuoteTable("prest", "public", "test"), ShouldEqual, "prest.public.test")
+		So(QuoteTable("prest", "public", "Order Items"), ShouldEqual, `prest.public."Order Items"`)
+		So(QuoteTable("prest", "public", `a"b`), ShouldEqual, `prest.public."a""b"`)
+	})
+	Convey("Where by request with quoted identifiers", t, func() {
+		r, err := http.NewRequest("GET", `/prest/public/test?"first name"=nuveo&Order=1`, nil)
+		So(err, ShouldBeNil)
+
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldContainSubstring, `"first name"=$`)
+		So(where, ShouldContainSubstring, `"Order"=$`)
+		So(values, ShouldContain, "nuveo")
+	})
+	Convey("Order by request with quoted identifiers", t, func() {
+		r, err := http.NewRequest("GET", `/prest/public/test?_order="first name",-Order`, nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(r, "", "", "", []string{"first name", "Order"})
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, ` ORDER BY "first name", "Order" DESC`)
+	})
+}
+
+func TestJoinByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Join by request", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		join, _, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "INNER JOIN test2 ON test2.name = test.name")
+	})
+	Convey("Join of a table the API key can't read", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.APIKey = &config.APIKeyConf{Name: "reports", Tables: []config.TablesConf{
+				{Name: "test", Permissions: []string{"read"}},
+			}}
+		})
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(withConfig(r, cfg), "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join missing param", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join invalid operator", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:notexist:test.name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join with outer join types", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=left:test2:test2.name:$eq:test.name&_join=full:test3:test3.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		join, _, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "LEFT JOIN test2 ON test2.name = test.name")
+		So(joinStr, ShouldContainSubstring, "FULL OUTER JOIN test3 ON test3.name = test.name")
+	})
+	Convey("Join cross", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=cross:test2", nil)
+		So(err, ShouldBeNil)
+
+		join, _, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "CROSS JOIN test2")
+	})
+	Convey("Join with constant and multiple predicates", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=left:test2:test2.name:$eq:test.name,test2.number:$gt:'1'", nil)
+		So(err, ShouldBeNil)
+
+		join, values, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "LEFT JOIN test2 ON test2.name = test.name AND test2.number > $1")
+		So(values, ShouldContain, "1")
+	})
+	Convey("Join with constants with commas, colons and quotes", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join="+url.QueryEscape("left:test2:test2.name:$eq:'a,b:c',test2.name:$gt:'it''s'"), nil)
+		So(err, ShouldBeNil)
+
+		join, values, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "LEFT JOIN test2 ON test2.name = $1 AND test2.name > $2")
+		So(values, ShouldResemble, []interface{}{"a,b:c", "it's"})
+	})
+	Convey("Join with unterminated constant", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join="+url.QueryEscape("left:test2:test2.name:$eq:'a,b"), nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join a table without read permission", t, func() {
+		config.InitConf()
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test_write_and_delete_access:test_write_and_delete_access.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join invalid type", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=sideways:test2:test2.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Join invalid identifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2;drop:test2.name:$eq:test.name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = JoinByRequest(r, "prest", "public", 1)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Anti-join with IS NULL filter", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=left:test2:test2.name:$eq:test.name&test2.name=$null&name=nuveo", nil)
+		So(err, ShouldBeNil)
+
+		join, _, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "LEFT JOIN test2 ON test2.name = test.name")
+
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "name=$1 AND test2.name IS NULL")
+		So(len(values), ShouldEqual, 1)
+	})
+	Convey("Join with where", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_join=inner:test2:test2.name:$eq:test.name&name=nuveo&data->>description:jsonb=bla", nil)
+		So(err, ShouldBeNil)
+
+		join, _, err := JoinByRequest(r, "prest", "public", 1)
+		joinStr := strings.Join(join, " ")
+
+		So(err, ShouldBeNil)
+		So(joinStr, ShouldContainSubstring, "INNER JOIN test2 ON test2.name = test.name")
+
+		where, values, err := WhereByRequest(r, 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldContainSubstring, "name=$")
+		So(where, ShouldContainSubstring, "data->>'description'=$")
+		So(where, ShouldContainSubstring, " AND ")
+		So(values, ShouldContain, "nuveo")
+		So(values, ShouldContain, "bla")
+	})
+
+}
+
+func TestCountFields(t *testing.T) {
+	config.InitConf()
+	Convey("Count fields from table", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5?_count=celphone", nil)
+		So(err, ShouldBeNil)
+
+		countQuery, err := CountByRequest(r, "prest", "public", "test5")
+		So(err, ShouldBeNil)
+		So(countQuery, ShouldContainSubstring, "SELECT COUNT(celphone) FROM")
+	})
+
+	Convey("Count all from table", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5?_count=*", nil)
+		So(err, ShouldBeNil)
+
+		countQuery, err := CountByRequest(r, "prest", "public", "test5")
+		So(err, ShouldBeNil)
+		So(countQuery, ShouldContainSubstring, "SELECT COUNT(*) FROM")
+	})
+
+	Convey("Try Count with empty '_count' field", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5?_count=", nil)
+		So(err, ShouldBeNil)
+
+		countQuery, err := CountByRequest(r, "prest", "public", "test5")
+		So(err, ShouldBeNil)
+		So(countQuery, ShouldEqual, "")
+	})
+
+	Convey("Try Count with an expression", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5?_count=length(name)", nil)
+		So(err, ShouldBeNil)
+
+		countQuery, err := CountByRequest(r, "prest", "public", "test5")
+		So(err, ShouldNotBeNil)
+		So(countQuery, ShouldEqual, "")
+	})
+
+	Convey("Try Count with a field without permission", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_list_only_id?_count=name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = CountByRequest(r, "prest", "public", "test_list_only_id")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Try Count with a masked field", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_masked?_count=password_hash", nil)
+		So(err, ShouldBeNil)
+
+		_, err = CountByRequest(withConfig(r, unrestricted()), "prest", "public", "test_masked")
+		So(err, ShouldNotBeNil)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_masked?_count=name", nil)
+		So(err, ShouldBeNil)
+
+		countQuery, err := CountByRequest(withConfig(r, unrestricted()), "prest", "public", "test_masked")
+		So(err, ShouldBeNil)
+		So(countQuery, ShouldEqual, "SELECT COUNT(name) FROM")
+	})
+}
+
+func TestDatabaseClause(t *testing.T) {
+	Convey("Return appropriate SELECT clause", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+
+		countQuery := DatabaseClause(r)
+		So(countQuery, ShouldEqual, fmt.Sprintf(statements.DatabasesSelect, statements.FieldDatabaseName))
+	})
+
+	Convey("Return appropriate COUNT clause", t, func() {
+		r, err := http.NewRequest("GET", "/databases?_count=*", nil)
+		So(err, ShouldBeNil)
+
+		countQuery := DatabaseClause(r)
+		So(countQuery, ShouldEqual, fmt.Sprintf(statements.DatabasesSelect, statements.FieldCountDatabaseName))
+	})
+}
+
+func TestSchemaClause(t *testing.T) {
+	Convey("Return appropriate SELECT clause", t, func() {
+		r, err := http.NewRequest("GET", "/schemas", nil)
+		So(err, ShouldBeNil)
+
+		countQuery := SchemaClause(r)
+		So(countQuery, ShouldEqual, fmt.Sprintf(statements.SchemasSelect, statements.FieldSchemaName))
+	})
+
+	Convey("Return appropriate COUNT clause", t, func() {
+		r, err := http.NewRequest("GET", "/schemas?_count=*", nil)
+		So(err, ShouldBeNil)
+
+		countQuery := SchemaClause(r)
+		So(countQuery, ShouldEqual, fmt.Sprintf(statements.SchemasSelect, statements.FieldCountSchemaName))
+	})
+}
+
+func TestGetQueryOperator(t *testing.T) {
+	Convey("Query operator eq", t, func() {
+		op, err := GetQueryOperator("$eq")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, "=")
+	})
+	Convey("Query operator gt", t, func() {
+		op, err := GetQueryOperator("$gt")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, ">")
+	})
+	Convey("Query operator gte", t, func() {
+		op, err := GetQueryOperator("$gte")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, ">=")
+	})
+
+	Convey("Query operator lt", t, func() {
+		op, err := GetQueryOperator("$lt")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, "<")
+	})
+	Convey("Query operator lte", t, func() {
+		op, err := GetQueryOperator("$lte")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, "<=")
+	})
+	Convey("Query operator IN", t, func() {
+		op, err := GetQueryOperator("$in")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, "IN")
+	})
+	Convey("Query operator NIN", t, func() {
+		op, err := GetQueryOperator("$nin")
+		So(err, ShouldBeNil)
+		So(op, ShouldEqual, "NOT IN")
+	})
+}
+
+func TestOrderByRequest(t *testing.T) {
+	Convey("Query ORDER BY", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=name,-number", nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(r, "", "", "", nil)
+		So(err, ShouldBeNil)
+		So(order, ShouldContainSubstring, "ORDER BY")
+		So(order, ShouldContainSubstring, "name")
+		So(order, ShouldContainSubstring, "number DESC")
+	})
+	Convey("Query ORDER BY with valid columns", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=name,-number,test2.name", nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(r, "", "", "", []string{"name", "number"})
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, " ORDER BY name, number DESC, test2.name")
+	})
+	Convey("Query ORDER BY with unknown column", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=name,-notexist", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(r, "", "", "", []string{"name", "number"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "notexist")
+	})
+	Convey("Query ORDER BY with invalid identifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=--name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(r, "", "", "", nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Query ORDER BY with NULLS FIRST/LAST", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=-created_at:nullslast,name,number:nullsfirst", nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(r, "", "", "", nil)
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, " ORDER BY created_at DESC NULLS LAST, name, number NULLS FIRST")
+	})
+	Convey("Query ORDER BY jsonb field", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=-data->>priority:nullslast,name", nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(r, "", "", "", []string{"data", "name"})
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, " ORDER BY data->>'priority' DESC NULLS LAST, name")
+	})
+	Convey("Query ORDER BY invalid jsonb field", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=data->>a'b", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(r, "", "", "", nil)
+		So(err, ShouldNotBeNil)
+
+		r, err = http.NewRequest("GET", "/prest/public/test?_order=notexist->>a", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(r, "", "", "", []string{"data", "name"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Query ORDER BY with invalid modifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_order=name:nullsmiddle", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(r, "", "", "", nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Query ORDER BY without field permission", t, func() {
+		config.InitConf()
+		cfg := configWith(func(cfg *config.Prest) { cfg.AccessConf.Restrict = true })
+		r, err := http.NewRequest("GET", "/prest/public/test_readonly_access?_order=-name:nullslast", nil)
+		So(err, ShouldBeNil)
+
+		_, err = OrderByRequest(withConfig(r, cfg), "prest", "public", "test_list_only_id", nil)
+		So(err, ShouldNotBeNil)
+
+		r, err = http.NewRequest("GET", "/prest/public/test_readonly_access?_order=-name:nullslast", nil)
+		So(err, ShouldBeNil)
+
+		order, err := OrderByRequest(withConfig(r, cfg), "prest", "public", "test_readonly_access", nil)
+		So(err, ShouldBeNil)
+		So(order, ShouldEqual, " ORDER BY name DESC NULLS LAST")
+	})
+}
+
+func TestSearchPathByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) { cfg.AccessConf.SchemaPaths = []string{"analytics", "public"} })
+	Convey("Search path of the request", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_schema_path=analytics,public", nil)
+		So(err, ShouldBeNil)
+		schemas, err := SearchPathByRequest(withConfig(r, cfg))
+		So(err, ShouldBeNil)
+		So(schemas, ShouldResemble, []string{"analytics", "public"})
+	})
+	Convey("Search path not allowed", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_schema_path=pg_catalog", nil)
+		So(err, ShouldBeNil)
+		_, err = SearchPathByRequest(withConfig(r, cfg))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Request without search path", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		schemas, err := SearchPathByRequest(withConfig(r, cfg))
+		So(err, ShouldBeNil)
+		So(schemas, ShouldBeEmpty)
+	})
+}
+
+func TestSessionQuery(t *testing.T) {
+	Convey("Query with unqualified names resolved by the search path", t, func() {
+		s := Session{SearchPath: []string{"public"}}
+		data, err := s.Query("SELECT name FROM test2 WHERE name=$1", "tester02")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `[{"name":"tester02"}]`)
+	})
+	Convey("Query with the role of the session", t, func() {
+		s := Session{Role: "test_rls_reader"}
+		data, err := s.Query("SELECT name FROM test_rls")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, `[{"name":"test_rls_reader"}]`)
+
+		total, err := s.QueryTotal("SELECT name FROM test_rls")
+		So(err, ShouldBeNil)
+		So(total, ShouldEqual, 1)
+	})
+	Convey("Query with an unknown role", t, func() {
+		s := Session{Role: "test_rls_unknown"}
+		_, err := s.Query("SELECT name FROM test_rls")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSessionByRequest(t *testing.T) {
+	Convey("Session with the role of the request context", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(WithRole(r.Context(), "reader"))
+
+		s, err := SessionByRequest(r)
+		So(err, ShouldBeNil)
+		So(s.Role, ShouldEqual, "reader")
+		So(s.SearchPath, ShouldBeEmpty)
+	})
+	Convey("Session with invalid role", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(WithRole(r.Context(), "reader; RESET ROLE"))
+
+		_, err = SessionByRequest(r)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Session with the tag of the request context", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(WithTag(r.Context(), "/* user=prest */"))
+
+		s, err := SessionByRequest(r)
+		So(err, ShouldBeNil)
+		So(s.empty(), ShouldBeTrue)
+		So(s.tagged("SELECT 1"), ShouldEqual, "/* user=prest */ SELECT 1")
+	})
+}
+
+func TestFormatTag(t *testing.T) {
+	Convey("Tag of the key and value pairs", t, func() {
+		tag := FormatTag("route", "GET /{database}/{schema}/{table}", "request_id", "abc-123", "user", "")
+		So(tag, ShouldEqual, "/* route=GET /{database}/{schema}/{table}, request_id=abc-123 */")
+	})
+	Convey("Tag without values", t, func() {
+		So(FormatTag("user", ""), ShouldBeEmpty)
+	})
+	Convey("Tag can't close the comment", t, func() {
+		tag := FormatTag("request_id", "x */ DROP TABLE test; /*")
+		So(tag, ShouldEqual, "/* request_id=x / DROP TABLE test / */")
+	})
+}
+
+func TestQueryTagged(t *testing.T) {
+	config.InitConf()
+	Convey("Query with tag", t, func() {
+		s := Session{Tag: FormatTag("user", "prest")}
+		jsonData, err := s.Query("SELECT current_query() AS query")
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldContainSubstring, "/* user=prest */ SELECT current_query()")
+	})
+	Convey("Transaction with tag", t, func() {
+		s := Session{Tag: FormatTag("user", "prest")}
+		err := s.Transaction(func(tx *sql.Tx) error {
+			var query string
+			err := tx.QueryRow(tagged(tx, "SELECT current_query()")).Scan(&query)
+			So(query, ShouldStartWith, "/* user=prest */")
+			return err
+		})
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestTableColumns(t *testing.T) {
+	Convey("Table columns", t, func() {
+		cols, err := TableColumns("prest", "public", "test2")
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"name", "number"})
+	})
+}
+
+func TestGroupByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Query GROUP BY", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_groupby=name,number", nil)
+		So(err, ShouldBeNil)
+
+		groupBy, err := GroupByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldBeNil)
+		So(groupBy, ShouldEqual, " GROUP BY name, number")
+	})
+	Convey("Query without GROUP BY", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+
+		groupBy, err := GroupByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldBeNil)
+		So(groupBy, ShouldEqual, "")
+	})
+	Convey("Query GROUP BY with invalid identifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_groupby=0name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = GroupByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Query GROUP BY with non permitted field", t, func() {
+		config.InitConf()
+		r, err := http.NewRequest("GET", "/prest/public/test_list_only_id?_groupby=name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = GroupByRequest(r, "prest", "public", "test_list_only_id")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAggregateByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Aggregate alone", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2?_sum=number&_max=number", nil)
+		So(err, ShouldBeNil)
+
+		cols, aggregates, err := AggregateByRequest(withConfig(r, cfg), "prest", "public", "test2", []string{"*"})
+		So(err, ShouldBeNil)
+		So(cols, ShouldBeEmpty)
+		So(aggregates, ShouldResemble, []string{"SUM(number) AS sum_number", "MAX(number) AS max_number"})
+	})
+	Convey("Aggregate with group by", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2?_avg=number&_groupby=name", nil)
+		So(err, ShouldBeNil)
+
+		cols, aggregates, err := AggregateByRequest(withConfig(r, cfg), "prest", "public", "test2", []string{"*"})
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"name"})
+		So(aggregates, ShouldResemble, []string{"AVG(number) AS avg_number"})
+	})
+	Convey("Without aggregate", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2", nil)
+		So(err, ShouldBeNil)
+
+		cols, aggregates, err := AggregateByRequest(withConfig(r, cfg), "prest", "public", "test2", []string{"*"})
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"*"})
+		So(aggregates, ShouldBeEmpty)
+	})
+	Convey("Aggregate with invalid identifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2?_min=0number", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = AggregateByRequest(withConfig(r, cfg), "prest", "public", "test2", []string{"*"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Aggregate with non permitted field", t, func() {
+		config.InitConf()
+		r, err := http.NewRequest("GET", "/prest/public/test_list_only_id?_sum=name", nil)
+		So(err, ShouldBeNil)
+
+		_, _, err = AggregateByRequest(r, "prest", "public", "test_list_only_id", []string{"*"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDistinctByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Query DISTINCT", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_distinct=true", nil)
+		So(err, ShouldBeNil)
+
+		distinct, err := DistinctByRequest(withConfig(r, cfg), "prest", "public", "test")
+		So(err, ShouldBeNil)
+		So(distinct, ShouldEqual, "DISTINCT")
+	})
+	Convey("Query DISTINCT ON", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_distinct_on=name,number", nil)
+		So(err, ShouldBeNil)
+
+		distinct, err := DistinctByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldBeNil)
+		So(distinct, ShouldEqual, "DISTINCT ON (name, number)")
+	})
+	Convey("Query without DISTINCT", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_distinct=false", nil)
+		So(err, ShouldBeNil)
+
+		distinct, err := DistinctByRequest(withConfig(r, cfg), "prest", "public", "test")
+		So(err, ShouldBeNil)
+		So(distinct, ShouldEqual, "")
+	})
+	Convey("Query DISTINCT ON with non permitted field", t, func() {
+		config.InitConf()
+		r, err := http.NewRequest("GET", "/prest/public/test_list_only_id?_distinct_on=name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = DistinctByRequest(r, "prest", "public", "test_list_only_id")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDuplicatesByRequest(t *testing.T) {
+	config.InitConf()
+	Convey("Duplicates by fields", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5/_duplicates?by=name,celphone", nil)
+		So(err, ShouldBeNil)
+
+		by, err := DuplicatesByRequest(r, "prest", "public", "test5")
+		So(err, ShouldBeNil)
+		So(by, ShouldResemble, []string{"name", "celphone"})
+	})
+	Convey("Duplicates without by", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5/_duplicates", nil)
+		So(err, ShouldBeNil)
+
+		_, err = DuplicatesByRequest(r, "prest", "public", "test5")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Duplicates by invalid identifier", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test5/_duplicates?by=name,na%20me", nil)
+		So(err, ShouldBeNil)
+
+		_, err = DuplicatesByRequest(r, "prest", "public", "test5")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Duplicates by field without permission", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_list_only_id/_duplicates?by=name", nil)
+		So(err, ShouldBeNil)
+
+		_, err = DuplicatesByRequest(r, "prest", "public", "test_list_only_id")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMetricsByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Metrics grouped by fields", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2/_aggregate?metrics=sum:number,count:*&by=name", nil)
+		So(err, ShouldBeNil)
+
+		cols, aggregates, groupBy, err := MetricsByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []string{"name"})
+		So(aggregates, ShouldResemble, []string{"SUM(number) AS sum_number", "COUNT(*) AS count_all"})
+		So(groupBy, ShouldEqual, " GROUP BY name")
+	})
+	Convey("Metrics without group", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2/_aggregate?metrics=max:number", nil)
+		So(err, ShouldBeNil)
 
-	Convey("Query operator lt", t, func() {
-		op, err := GetQueryOperator("$lt")
+		cols, aggregates, groupBy, err := MetricsByRequest(withConfig(r, cfg), "prest", "public", "test2")
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, "<")
+		So(cols, ShouldBeEmpty)
+		So(aggregates, ShouldResemble, []string{"MAX(number) AS max_number"})
+		So(groupBy, ShouldEqual, "")
 	})
-	Convey("Query operator lte", t, func() {
-		op, err := GetQueryOperator("$lte")
+	Convey("Metrics missing", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2/_aggregate?by=name", nil)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, "<=")
+
+		_, _, _, err = MetricsByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldNotBeNil)
 	})
-	Convey("Query operator IN", t, func() {
-		op, err := GetQueryOperator("$in")
+	Convey("Metrics with invalid function", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2/_aggregate?metrics=median:number", nil)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, "IN")
+
+		_, _, _, err = MetricsByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldNotBeNil)
 	})
-	Convey("Query operator NIN", t, func() {
-		op, err := GetQueryOperator("$nin")
+	Convey("Metrics with * in function other than count", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test2/_aggregate?metrics=sum:*", nil)
 		So(err, ShouldBeNil)
-		So(op, ShouldEqual, "NOT IN")
+
+		_, _, _, err = MetricsByRequest(withConfig(r, cfg), "prest", "public", "test2")
+		So(err, ShouldNotBeNil)
 	})
 }
 
-func TestOrderByRequest(t *testing.T) {
-	Convey("Query ORDER BY", t, func() {
-		r, err := http.NewRequest("GET", "/prest/public/test?_order=name,-number", nil)
+func TestTimeseriesByRequest(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	Convey("Time series with count", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test/_timeseries?bucket=1h&ts=created_at&metric=count:*", nil)
 		So(err, ShouldBeNil)
 
-		order, err := OrderByRequest(r)
+		query, err := TimeseriesByRequest(withConfig(r, cfg), "prest", "public", "test", "prest.public.test", "name=$1")
 		So(err, ShouldBeNil)
-		So(order, ShouldContainSubstring, "ORDER BY")
-		So(order, ShouldContainSubstring, "name")
-		So(order, ShouldContainSubstring, "number DESC")
+		So(query, ShouldContainSubstring, "floor(extract(epoch FROM created_at) / 3600) * 3600")
+		So(query, ShouldContainSubstring, "COUNT(*) AS value")
+		So(query, ShouldContainSubstring, "prest.public.test WHERE name=$1")
+		So(query, ShouldContainSubstring, "interval '3600 seconds'")
+	})
+	Convey("Time series with sum", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test/_timeseries?bucket=2d&ts=created_at&metric=sum:amount", nil)
+		So(err, ShouldBeNil)
+
+		query, err := TimeseriesByRequest(withConfig(r, cfg), "prest", "public", "test", "prest.public.test", "")
+		So(err, ShouldBeNil)
+		So(query, ShouldContainSubstring, "SUM(amount) AS value")
+		So(query, ShouldContainSubstring, "interval '172800 seconds'")
+	})
+	Convey("Time series with invalid bucket", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test/_timeseries?bucket=1y&ts=created_at", nil)
+		So(err, ShouldBeNil)
+
+		_, err = TimeseriesByRequest(withConfig(r, cfg), "prest", "public", "test", "prest.public.test", "")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Time series without ts", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test/_timeseries?bucket=1h", nil)
+		So(err, ShouldBeNil)
+
+		_, err = TimeseriesByRequest(withConfig(r, cfg), "prest", "public", "test", "prest.public.test", "")
+		So(err, ShouldNotBeNil)
 	})
 }
 
 func TestTablePermissions(t *testing.T) {
 	config.InitConf()
 	Convey("Read", t, func() {
-		p := TablePermissions("test_readonly_access", "read")
+		p := TablePermissions(config.Get(), "prest", "public", "test_readonly_access", "read")
 		So(p, ShouldBeTrue)
 	})
 	Convey("Try to read without permission", t, func() {
-		p := TablePermissions("test_write_and_delete_access", "read")
+		p := TablePermissions(config.Get(), "prest", "public", "test_write_and_delete_access", "read")
 		So(p, ShouldBeFalse)
 	})
 	Convey("Write", t, func() {
-		p := TablePermissions("test_write_and_delete_access", "write")
+		p := TablePermissions(config.Get(), "prest", "public", "test_write_and_delete_access", "write")
 		So(p, ShouldBeTrue)
 	})
 	Convey("Try to write without permission", t, func() {
-		p := TablePermissions("test_readonly_access", "write")
+		p := TablePermissions(config.Get(), "prest", "public", "test_readonly_access", "write")
 		So(p, ShouldBeFalse)
 	})
+	Convey("Write allows insert and update", t, func() {
+		So(TablePermissions(config.Get(), "prest", "public", "test_write_and_delete_access", "insert"), ShouldBeTrue)
+		So(TablePermissions(config.Get(), "prest", "public", "test_write_and_delete_access", "update"), ShouldBeTrue)
+	})
+	Convey("Insert only", t, func() {
+		So(TablePermissions(config.Get(), "prest", "public", "test_insert_only", "insert"), ShouldBeTrue)
+		So(TablePermissions(config.Get(), "prest", "public", "test_insert_only", "update"), ShouldBeFalse)
+		So(TablePermissions(config.Get(), "prest", "public", "test_insert_only", "delete"), ShouldBeFalse)
+	})
 	Convey("Delete", t, func() {
-		p := TablePermissions("test_write_and_delete_access", "delete")
+		p := TablePermissions(config.Get(), "prest", "public", "test_write_and_delete_access", "delete")
 		So(p, ShouldBeTrue)
 	})
 	Convey("Try to delete without permission", t, func() {
-		p := TablePermissions("test_readonly_access", "delete")
+		p := TablePermissions(config.Get(), "prest", "public", "test_readonly_access", "delete")
 		So(p, ShouldBeFalse)
 	})
+	Convey("Rules qualified by schema", t, func() {
+		So(TablePermissions(config.Get(), "prest", "private", "test_readonly_access", "delete"), ShouldBeTrue)
+		So(TablePermissions(config.Get(), "prest", "public", "test_readonly_access", "delete"), ShouldBeFalse)
+		So(FieldsPermissions(config.Get(), "prest", "private", "test_readonly_access", []string{"*"}, "read"), ShouldResemble, []string{"id"})
+		So(FieldsPermissions(config.Get(), "prest", "public", "test_readonly_access", []string{"*"}, "read"), ShouldResemble, []string{"id", "name"})
+	})
 	Convey("Restrict disabled", t, func() {
-		config.PREST_CONF.AccessConf.Restrict = false
-		p := TablePermissions("test_readonly_access", "delete")
+		p := TablePermissions(unrestricted(), "prest", "public", "test_readonly_access", "delete")
 		So(p, ShouldBeTrue)
 	})
+	Convey("Rules of the API key", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.APIKey = &config.APIKeyConf{Name: "reports", Tables: []config.TablesConf{
+				{Name: "test", Schema: "public", Permissions: []string{"read"}},
+			}}
+		})
+		So(TablePermissions(cfg, "prest", "public", "test", "read"), ShouldBeTrue)
+		So(TablePermissions(cfg, "prest", "public", "test", "insert"), ShouldBeFalse)
+		So(TablePermissions(cfg, "prest", "private", "test", "read"), ShouldBeFalse)
+		So(TablePermissions(cfg, "prest", "public", "test2", "read"), ShouldBeFalse)
+
+		cfg.AccessConf.Restrict = true
+		So(TablePermissions(cfg, "prest", "public", "test_readonly_access", "read"), ShouldBeFalse)
+	})
 
 }
 
@@ -392,28 +2335,239 @@ func TestFieldsPermissions(t *testing.T) {
 	config.InitConf()
 
 	Convey("Read valid field", t, func() {
-		p := FieldsPermissions("test_list_only_id", []string{"id"}, "read")
+		p := FieldsPermissions(config.Get(), "prest", "public", "test_list_only_id", []string{"id"}, "read")
 		So(len(p), ShouldEqual, 1)
 	})
 	Convey("Read invalid field", t, func() {
-		p := FieldsPermissions("test_list_only_id", []string{"name"}, "read")
+		p := FieldsPermissions(config.Get(), "prest", "public", "test_list_only_id", []string{"name"}, "read")
 		So(len(p), ShouldEqual, 0)
 	})
 	Convey("Read non existing field", t, func() {
-		p := FieldsPermissions("test_list_only_id", []string{"non_existing_field"}, "read")
+		p := FieldsPermissions(config.Get(), "prest", "public", "test_list_only_id", []string{"non_existing_field"}, "read")
 		So(len(p), ShouldEqual, 0)
 	})
 	Convey("Select with *", t, func() {
-		p := FieldsPermissions("test_list_only_id", []string{"*"}, "read")
+		p := FieldsPermissions(config.Get(), "prest", "public", "test_list_only_id", []string{"*"}, "read")
 		So(len(p), ShouldEqual, 1)
 	})
 	Convey("Read unrestrict", t, func() {
-		config.PREST_CONF.AccessConf.Restrict = false
-		p := FieldsPermissions("test_list_only_id", []string{"*"}, "read")
+		p := FieldsPermissions(unrestricted(), "prest", "public", "test_list_only_id", []string{"*"}, "read")
 		So(p[0], ShouldEqual, "*")
 	})
+	Convey("Fields of the API key", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.APIKey = &config.APIKeyConf{Name: "reports", Tables: []config.TablesConf{
+				{Name: "test", Permissions: []string{"read"}, Fields: []string{"id"}},
+				{Name: "test2", Permissions: []string{"read"}},
+			}}
+		})
+		So(FieldsPermissions(cfg, "prest", "public", "test", []string{"*"}, "read"), ShouldResemble, []string{"id"})
+		So(FieldsPermissions(cfg, "prest", "public", "test", []string{"id", "name"}, "read"), ShouldResemble, []string{"id"})
+		So(FieldsPermissions(cfg, "prest", "public", "test2", []string{"*"}, "read"), ShouldResemble, []string{"*"})
+		So(FieldsPermissions(cfg, "prest", "public", "test3", []string{"*"}, "read"), ShouldBeEmpty)
+	})
+	Convey("Read masked field unrestrict", t, func() {
+		p := FieldsPermissions(unrestricted(), "prest", "public", "test_masked", []string{"id", "password_hash"}, "read")
+		So(p, ShouldResemble, []string{"id"})
+	})
+	Convey("Read qualified masked field unrestrict", t, func() {
+		p := FieldsPermissions(unrestricted(), "prest", "public", "test", []string{"test_masked.password_hash", "public.test_masked.password_hash", "test_masked.name", "test.password_hash"}, "read")
+		So(p, ShouldResemble, []string{"test_masked.name", "test.password_hash"})
+	})
+	Convey("Read masked field", t, func() {
+		p := FieldsPermissions(config.Get(), "prest", "public", "test_masked", []string{"password_hash"}, "read")
+		So(len(p), ShouldEqual, 0)
+	})
+}
+
+func TestReadableFields(t *testing.T) {
+	config.InitConf()
+
+	Convey("Expand * without the masked columns", t, func() {
+		p, err := ReadableFields(config.Get(), "prest", "public", "test_masked", []string{"*"})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []string{"id", "name"})
+	})
+	Convey("Keep * without masked columns", t, func() {
+		p, err := ReadableFields(config.Get(), "prest", "public", "test_list_only_id", []string{"*"})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []string{"id"})
+	})
+	Convey("Masked column in returning", t, func() {
+		cols, err := readableColumns(config.Get(), "prest", "public", "test_masked", nil)
+		So(err, ShouldBeNil)
+		So(cols, ShouldEqual, "id, name")
+	})
+	Convey("Expand table.* without the masked columns", t, func() {
+		p, err := ReadableFields(config.Get(), "prest", "public", "test_masked", []string{"test_masked.*"})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []string{"test_masked.id", "test_masked.name"})
+	})
+	Convey("Expand * unrestrict", t, func() {
+		p, err := ReadableFields(unrestricted(), "prest", "public", "test_masked", []string{"*", "name"})
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, []string{"id", "name", "name"})
+	})
+}
+func TestMaskedByRequest(t *testing.T) {
+	config.InitConf()
+
+	Convey("Filters by masked columns", t, func() {
+		filters := []url.Values{
+			{"password_hash": {"$regex.^a"}},
+			{"test_masked.password_hash": {"x"}},
+			{"public.test_masked.password_hash": {"x"}},
+			{"password_hash->>key:jsonb": {"x"}},
+		}
+		for _, filter := range filters {
+			r, err := http.NewRequest("GET", "/prest/public/test_masked?"+filter.Encode(), nil)
+			So(err, ShouldBeNil)
+			So(MaskedByRequest(r, "prest", "public", "test_masked"), ShouldNotBeNil)
+		}
+	})
+	Convey("Filters by other columns", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_masked?name=prest&test.password_hash=x&_order=name", nil)
+		So(err, ShouldBeNil)
+		So(MaskedByRequest(r, "prest", "public", "test_masked"), ShouldBeNil)
+	})
+}
+
+func TestProfile(t *testing.T) {
+	config.InitConf()
+
+	Convey("Profile a table", t, func() {
+		jsonData, err := Profile("prest", "public", "test_masked", 100, 3)
+		So(err, ShouldBeNil)
+		var profile struct {
+			Rows    int64 `json:"rows"`
+			Columns []struct {
+				Column   string        `json:"column"`
+				NullRate float64       `json:"null_rate"`
+				Distinct int64         `json:"distinct"`
+				Min      interface{}   `json:"min"`
+				Top      []interface{} `json:"top"`
+			} `json:"columns"`
+		}
+		So(json.Unmarshal(jsonData, &profile), ShouldBeNil)
+		So(profile.Rows, ShouldEqual, 1)
+		So(len(profile.Columns), ShouldEqual, 2)
+		So(profile.Columns[0].Column, ShouldEqual, "id")
+		So(profile.Columns[0].Min, ShouldEqual, float64(1))
+		So(profile.Columns[1].Column, ShouldEqual, "name")
+		So(profile.Columns[1].Distinct, ShouldEqual, 1)
+		So(len(profile.Columns[1].Top), ShouldEqual, 1)
+	})
+	Convey("Profile of domain and citext columns", t, func() {
+		jsonData, err := Session{Config: unrestricted()}.Profile("prest", "public", "test_citext", 100, 3)
+		So(err, ShouldBeNil)
+		var profile struct {
+			Columns []struct {
+				Column          string        `json:"column"`
+				Type            string        `json:"type"`
+				Domain          *string       `json:"domain"`
+				CaseInsensitive bool          `json:"case_insensitive"`
+				Distinct        int64         `json:"distinct"`
+				Min             interface{}   `json:"min"`
+				Top             []interface{} `json:"top"`
+			} `json:"columns"`
+		}
+		So(json.Unmarshal(jsonData, &profile), ShouldBeNil)
+		So(len(profile.Columns), ShouldEqual, 2)
+		So(profile.Columns[0].Type, ShouldEqual, "integer")
+		So(*profile.Columns[0].Domain, ShouldEqual, "positive_int")
+		So(profile.Columns[0].Min, ShouldEqual, float64(1))
+		So(profile.Columns[1].Type, ShouldEqual, "citext")
+		So(profile.Columns[1].Domain, ShouldBeNil)
+		So(profile.Columns[1].CaseInsensitive, ShouldBeTrue)
+		So(profile.Columns[1].Distinct, ShouldEqual, 2)
+		So(profile.Columns[1].Min, ShouldNotBeNil)
+	})
+	Convey("Column types of domain and citext columns", t, func() {
+		cols, err := ColumnTypes("prest", "public", "test_citext")
+		So(err, ShouldBeNil)
+		So(cols, ShouldResemble, []Column{
+			{Name: "id", Type: "integer", Domain: "positive_int"},
+			{Name: "email", Type: "citext"},
+		})
+		So(cols[1].CaseInsensitive(), ShouldBeTrue)
+	})
+	Convey("Profile a table without permission", t, func() {
+		_, err := Profile("prest", "public", "test_write_and_delete_access", 100, 3)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Profile parameters of the request", t, func() {
+		r, err := http.NewRequest("GET", "/_profile/prest/public/test?_sample=50&_top=2", nil)
+		So(err, ShouldBeNil)
+		sample, top, err := ProfileByRequest(r)
+		So(err, ShouldBeNil)
+		So(sample, ShouldEqual, 50)
+		So(top, ShouldEqual, 2)
+
+		r, err = http.NewRequest("GET", "/_profile/prest/public/test?_sample=100000", nil)
+		So(err, ShouldBeNil)
+		sample, _, err = ProfileByRequest(r)
+		So(err, ShouldBeNil)
+		So(sample, ShouldEqual, 10000)
+
+		r, err = http.NewRequest("GET", "/_profile/prest/public/test?_top=x", nil)
+		So(err, ShouldBeNil)
+		_, _, err = ProfileByRequest(r)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAnonymizedFields(t *testing.T) {
+	config.InitConf()
+
+	Convey("Anonymize the configured columns", t, func() {
+		fields, err := AnonymizedFields(config.Get(), "prest", "public", "test5", []string{"id", "name"})
+		So(err, ShouldBeNil)
+		So(fields[0], ShouldEqual, "id")
+		So(fields[1], ShouldEqual, "'name_' || substr(md5(name::text), 1, 8) AS name")
+	})
+	Convey("Anonymize qualified columns", t, func() {
+		fields, err := AnonymizedFields(config.Get(), "prest", "public", "test5", []string{"test5.name", "test.name"})
+		So(err, ShouldBeNil)
+		So(fields[0], ShouldEqual, "'name_' || substr(md5(test5.name::text), 1, 8) AS name")
+		So(fields[1], ShouldEqual, "test.name")
+	})
+	Convey("Anonymize expanding *", t, func() {
+		fields, err := AnonymizedFields(config.Get(), "prest", "public", "test5", []string{"*"})
+		So(err, ShouldBeNil)
+		So(len(fields), ShouldEqual, 3)
+		So(fields[2], ShouldStartWith, "'555' || ")
+	})
+	Convey("Table without anonymized columns", t, func() {
+		fields, err := AnonymizedFields(config.Get(), "prest", "public", "test", []string{"*"})
+		So(err, ShouldBeNil)
+		So(fields, ShouldResemble, []string{"*"})
+	})
+	Convey("Anonymize invalid field", t, func() {
+		_, err := AnonymizedFields(config.Get(), "prest", "public", "test5", []string{"name; DROP TABLE test5"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Unknown anonymization function", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.Anonymize = []config.AnonymizeConf{{Table: "test", Columns: map[string]string{"name": "scramble"}}}
+		})
+		_, err := AnonymizedFields(cfg, "prest", "public", "test", []string{"name"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Anonymize the columns of the table of the schema", t, func() {
+		cfg := configWith(func(cfg *config.Prest) {
+			cfg.Anonymize = []config.AnonymizeConf{{Table: "test", Schema: "public", Columns: map[string]string{"name": "null"}}}
+		})
+		fields, err := AnonymizedFields(cfg, "prest", "public", "test", []string{"name"})
+		So(err, ShouldBeNil)
+		So(fields, ShouldResemble, []string{"NULL AS name"})
 
+		fields, err = AnonymizedFields(cfg, "prest", "audit", "test", []string{"name"})
+		So(err, ShouldBeNil)
+		So(fields, ShouldResemble, []string{"name"})
+	})
 }
+
 func TestSelectFields(t *testing.T) {
 	Convey("One field", t, func() {
 		s, err := SelectFields([]string{"test"})
@@ -432,6 +2586,27 @@ func TestSelectFields(t *testing.T) {
 		_, err := SelectFields([]string{})
 		So(err, ShouldNotBeNil)
 	})
+	Convey("Quoted and qualified fields", t, func() {
+		s, err := SelectFields([]string{"Name", "test.*", "*"})
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, `SELECT "Name",test.*,* FROM`)
+	})
+	Convey("Invalid fields", t, func() {
+		for _, field := range []string{"name; DROP TABLE test", "(SELECT 1)", "count(*)", "a b"} {
+			_, err := SelectFields([]string{field})
+			So(err, ShouldNotBeNil)
+		}
+	})
+	Convey("Fields and expressions", t, func() {
+		s, err := SelectFields([]string{"name"}, "SUM(number) AS sum_number")
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, "SELECT name,SUM(number) AS sum_number FROM")
+	})
+	Convey("Only expressions", t, func() {
+		s, err := SelectFields(nil, "COUNT(*) AS count_all")
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, "SELECT COUNT(*) AS count_all FROM")
+	})
 
 }
 
@@ -473,3 +2648,460 @@ func TestColumnsByRequest(t *testing.T) {
 		So(selectStr, ShouldContainSubstring, "celphone,battery")
 	})
 }
+
+func TestCheckPassword(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) { cfg.AuthTable = "test_users" })
+	Convey("Valid credentials", t, func() {
+		valid, err := CheckPassword(cfg, "prest", "secret")
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+	})
+	Convey("Wrong password", t, func() {
+		valid, err := CheckPassword(cfg, "prest", "wrong")
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+	})
+	Convey("Unknown user", t, func() {
+		valid, err := CheckPassword(cfg, "nobody", "secret")
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+	})
+	Convey("Invalid users table", t, func() {
+		invalid := configWith(func(cfg *config.Prest) { cfg.AuthTable = "test_users;" })
+		_, err := CheckPassword(invalid, "prest", "secret")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestLoadAccessTable(t *testing.T) {
+	config.InitConf()
+	Convey("Load the rules of the access table", t, func() {
+		defer config.Set(config.Get())
+		count, err := LoadAccessTable("public.prest_access")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+		So(TablePermissions(config.Get(), "prest", "public", "test_access_table", "read"), ShouldBeTrue)
+		So(TablePermissions(config.Get(), "prest", "public", "test_access_table", "delete"), ShouldBeFalse)
+		So(FieldsPermissions(config.Get(), "prest", "public", "test_access_table", []string{"id", "celphone"}, "read"), ShouldResemble, []string{"id"})
+	})
+	Convey("Load without access table", t, func() {
+		_, err := LoadAccessTable("")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestOwner(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) {
+		cfg.AccessConf.Tables = append([]config.TablesConf{{Name: "test_owned", Owner: "user_id"}}, cfg.AccessConf.Tables...)
+	})
+	Convey("Owner column of the tables", t, func() {
+		So(OwnerColumn(cfg, "prest", "public", "test_owned"), ShouldEqual, "user_id")
+		So(OwnerColumn(cfg, "prest", "public", "test"), ShouldEqual, "")
+	})
+	Convey("Filter of the rows owned by the subject", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_owned", nil)
+		So(err, ShouldBeNil)
+		r = withConfig(r, cfg)
+		_, _, err = OwnerByRequest(r, "prest", "public", "test_owned", 1)
+		So(err, ShouldEqual, ErrNoOwner)
+
+		where, values, err := OwnerByRequest(r, "prest", "public", "test", 1)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "")
+		So(values, ShouldBeEmpty)
+
+		r = r.WithContext(WithOwner(r.Context(), "ana"))
+		where, values, err = OwnerByRequest(r, "prest", "public", "test_owned", 3)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "test_owned.user_id=$3")
+		So(values, ShouldResemble, []interface{}{"ana"})
+	})
+	Convey("Owner set in the body", t, func() {
+		r, err := http.NewRequest("POST", "/prest/public/test_owned", nil)
+		So(err, ShouldBeNil)
+		r = withConfig(r, cfg)
+		_, err = OwnerRequest(r, "prest", "public", "test_owned", map[string]interface{}{"name": "prest"})
+		So(err, ShouldEqual, ErrNoOwner)
+
+		r = r.WithContext(WithOwner(r.Context(), "ana"))
+		data, err := OwnerRequest(r, "prest", "public", "test_owned", map[string]interface{}{"name": "prest", `"user_id"`: "bob"})
+		So(err, ShouldBeNil)
+		So(data, ShouldResemble, map[string]interface{}{"name": "prest", "user_id": "ana"})
+
+		data, err = OwnerRequest(r, "prest", "public", "test_owned", nil)
+		So(err, ShouldBeNil)
+		So(data, ShouldResemble, map[string]interface{}{"user_id": "ana"})
+
+		data, err = OwnerRequest(r, "prest", "public", "test", map[string]interface{}{"name": "prest"})
+		So(err, ShouldBeNil)
+		So(data, ShouldResemble, map[string]interface{}{"name": "prest"})
+	})
+}
+
+func TestAccessWarnings(t *testing.T) {
+	Convey("Consistent access rules", t, func() {
+		rules := config.TablesConf{Name: "test", Permissions: []string{"read", "write"}, Fields: []string{"id", "name"}}
+		So(accessWarnings(rules, []string{"id", "name", "number"}), ShouldBeEmpty)
+		rules.Fields = []string{"*"}
+		So(accessWarnings(rules, []string{"id"}), ShouldBeEmpty)
+	})
+	Convey("Unknown tables, columns and permissions", t, func() {
+		rules := config.TablesConf{Name: "test", Schema: "public", Permissions: []string{"read", "select"}, Fields: []string{"id", "nmae"}, Masked: []string{"secret"}, Owner: "user_id"}
+		warnings := accessWarnings(rules, []string{"id", "name"})
+		So(warnings, ShouldHaveLength, 4)
+		So(warnings[0], ShouldContainSubstring, `unknown permission "select"`)
+		So(warnings[1], ShouldContainSubstring, "public.test: field nmae")
+		So(warnings[2], ShouldContainSubstring, "masked column secret")
+		So(warnings[3], ShouldContainSubstring, "owner column user_id")
+
+		warnings = accessWarnings(rules, nil)
+		So(warnings, ShouldHaveLength, 2)
+		So(warnings[1], ShouldContainSubstring, "public.test: table not found")
+	})
+	Convey("Check the access rules of the config", t, func() {
+		_, err := CheckAccess()
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestQueryWithMock(t *testing.T) {
+	config.InitConf()
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Rows of the query as JSON", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test WHERE name = \$1$`).
+			WithArgs("prest").
+			WillReturnRows([]string{"id", "name"}, []driver.Value{int64(1), []byte("prest")})
+		jsonData, err := Query(`SELECT * FROM prest.public.test WHERE name = $1`, "prest")
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `[{"id":1,"name":"prest"}]`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Count and total wrap the query", t, func() {
+		mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM \(SELECT id FROM prest\.public\.test\) AS total$`).
+			WillReturnRows([]string{"count"}, []driver.Value{int64(3)})
+		total, err := QueryTotal(`SELECT id FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(total, ShouldEqual, 3)
+
+		mock.ExpectQuery(`^SELECT COUNT\(id\) FROM prest\.public\.test$`).
+			WillReturnRows([]string{"count"}, []driver.Value{int64(3)})
+		jsonData, err := QueryCount(`SELECT COUNT(id) FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"count":3}`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Invalid queries don't reach the database", t, func() {
+		_, err := Query("SELECT * FROM test;")
+		So(err, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+// flushWriter record the response written at each flush
+type flushWriter struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (w *flushWriter) Flush() {
+	w.flushed = append(w.flushed, w.String())
+}
+
+func TestQueryStreamWithMock(t *testing.T) {
+	config.InitConf()
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Rows of the query streamed as a JSON array", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).
+			WillReturnRows([]string{"id", "name"}, []driver.Value{int64(1), []byte("prest")}, []driver.Value{int64(2), nil})
+		var b bytes.Buffer
+		rows, err := QueryStream(&b, false, nil, `SELECT * FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(rows, ShouldEqual, 2)
+		So(b.String(), ShouldEqual, `[{"id":1,"name":"prest"},{"id":2,"name":null}]`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Rows of the query streamed as JSON lines", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).
+			WillReturnRows([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+		var b bytes.Buffer
+		_, err := QueryStream(&b, true, nil, `SELECT * FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, "{\"id\":1}\n{\"id\":2}\n")
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("JSON lines are flushed one by one", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).
+			WillReturnRows([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+		w := &flushWriter{}
+		_, err := QueryStream(w, true, nil, `SELECT * FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(w.flushed, ShouldResemble, []string{"{\"id\":1}\n", "{\"id\":1}\n{\"id\":2}\n"})
+	})
+	Convey("No rows are an empty array", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).WillReturnRows([]string{"id"})
+		var b bytes.Buffer
+		_, err := QueryStream(&b, false, nil, `SELECT * FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `[]`)
+	})
+	Convey("The rows are changed by the funcs", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).
+			WillReturnRows([]string{"id", "name"}, []driver.Value{int64(1), []byte("prest")})
+		upper := func(row map[string]interface{}) error {
+			row["name"] = strings.ToUpper(row["name"].(string))
+			return nil
+		}
+		var b bytes.Buffer
+		_, err := QueryStream(&b, false, []RowFunc{upper}, `SELECT * FROM prest.public.test`)
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `[{"id":1,"name":"PREST"}]`)
+	})
+	Convey("Nothing is written when the query fails", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).WillReturnError(errors.New("permission denied"))
+		var b bytes.Buffer
+		_, err := QueryStream(&b, false, nil, `SELECT * FROM prest.public.test`)
+		So(err, ShouldNotBeNil)
+		_, ok := err.(*StreamError)
+		So(ok, ShouldBeFalse)
+		So(b.Len(), ShouldEqual, 0)
+	})
+	Convey("Errors of the funcs interrupt the stream", t, func() {
+		mock.ExpectQuery(`^SELECT \* FROM prest\.public\.test$`).
+			WillReturnRows([]string{"id"}, []driver.Value{int64(1)})
+		fail := func(row map[string]interface{}) error { return errors.New("invalid row") }
+		var b bytes.Buffer
+		_, err := QueryStream(&b, false, []RowFunc{fail}, `SELECT * FROM prest.public.test`)
+		_, ok := err.(*StreamError)
+		So(ok, ShouldBeTrue)
+		So(b.String(), ShouldEqual, `[`)
+	})
+}
+
+func TestApplyRows(t *testing.T) {
+	Convey("Change the rows or the row of the JSON", t, func() {
+		drop := func(row map[string]interface{}) error {
+			delete(row, "secret")
+			return nil
+		}
+		jsonData, err := applyRows([]byte(`[{"id":12345678901234567890,"secret":"x"}]`), drop)
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `[{"id":12345678901234567890}]`)
+
+		jsonData, err = applyRows([]byte(`{"id":1,"secret":"x"}`), drop)
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"id":1}`)
+	})
+}
+
+func TestDeleteWithMock(t *testing.T) {
+	config.InitConf()
+	cfg := unrestricted()
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Delete in a transaction", t, func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`^DELETE FROM prest\.public\.test WHERE name = \$1$`).
+			WithArgs("prest").
+			WillReturnResult(2)
+		mock.ExpectCommit()
+		jsonData, err := deleteWith(cfg, "prest", "public", "test", "name = $1", []interface{}{"prest"})
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"rows_affected":2}`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Failed delete is rolled back", t, func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`^DELETE FROM prest\.public\.test$`).WillReturnError(errors.New("permission denied"))
+		mock.ExpectRollback()
+		_, err := deleteWith(cfg, "prest", "public", "test", "", nil)
+		So(err, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Sandbox rolls back the writes", t, func() {
+		sandbox := configWith(func(cfg *config.Prest) {
+			cfg.AccessConf.Restrict = false
+			cfg.Sandbox = true
+		})
+		mock.ExpectBegin()
+		mock.ExpectExec(`^DELETE FROM prest\.public\.test$`).WillReturnResult(1)
+		mock.ExpectRollback()
+		_, err := deleteWith(sandbox, "prest", "public", "test", "", nil)
+		So(err, ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+func TestCopyFromIgnoringWithMock(t *testing.T) {
+	config.InitConf()
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Copy into a temporary table and insert skipping the conflicts", t, func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`^CREATE TEMP TABLE prest_copy ON COMMIT DROP AS SELECT name FROM prest\.public\.test3 WITH NO DATA$`)
+		mock.ExpectExec(`^COPY "prest_copy" \("name"\) FROM STDIN$`).WithArgs("prest")
+		mock.ExpectExec(`^COPY "prest_copy" \("name"\) FROM STDIN$`).WithArgs("copy01")
+		mock.ExpectExec(`^COPY "prest_copy" \("name"\) FROM STDIN$`).WithArgs()
+		mock.ExpectExec(`^INSERT INTO prest\.public\.test3 \(name\) SELECT name FROM prest_copy ON CONFLICT DO NOTHING$`).WillReturnResult(1)
+		mock.ExpectCommit()
+		_, next, err := CSVRows(strings.NewReader("name\nprest\ncopy01\n"))
+		So(err, ShouldBeNil)
+		rows, skipped, err := CopyFromIgnoring(unrestricted(), "prest", "public", "test3", []string{"name"}, next, nil)
+		So(err, ShouldBeNil)
+		So(rows, ShouldEqual, 1)
+		So(skipped, ShouldEqual, 1)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+func TestByteaWithMock(t *testing.T) {
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Bytea columns encoded and decoded", t, func() {
+		mock.ExpectQuery(`information_schema\.columns`).WithArgs("prest", "public", "test_bytea_mock").
+			WillReturnRows([]string{"column_name"}, []driver.Value{"content"})
+		jsonData, err := ByteaResponse("prest", "public", "test_bytea_mock", ByteaBase64, []byte(`{"id":1,"content":"\\x0102ff","name":"\\x01"}`))
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"content":"AQL/","id":1,"name":"\\x01"}`)
+
+		data := map[string]interface{}{"content": "0102ff", "name": "prest"}
+		So(ByteaRequest("prest", "public", "test_bytea_mock", ByteaHex, data), ShouldBeNil)
+		So(data, ShouldResemble, map[string]interface{}{"content": `\x0102ff`, "name": "prest"})
+
+		data = map[string]interface{}{"content": "not base64"}
+		So(ByteaRequest("prest", "public", "test_bytea_mock", ByteaBase64, data), ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Nothing changes without encoding", t, func() {
+		jsonData, err := ByteaResponse("prest", "public", "test_bytea_mock", "", []byte(`{"content":"\\x0102ff"}`))
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"content":"\\x0102ff"}`)
+	})
+}
+
+func TestDeleteByKeysWithMock(t *testing.T) {
+	config.InitConf()
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Delete the rows of the keys owned by the subject", t, func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`PRIMARY KEY`).WithArgs("prest", "public", "test_keys_mock").
+			WillReturnRows([]string{"column_name"}, []driver.Value{"id"})
+		mock.ExpectExec(`^DELETE FROM prest\.public\.test_keys_mock WHERE id = ANY\(\$1\) AND test_keys_mock\.user_id=\$2$`).
+			WithArgs("{1,2}", "ana").WillReturnResult(1)
+		mock.ExpectCommit()
+		var jsonData []byte
+		err := Session{Config: unrestricted()}.Transaction(func(tx *sql.Tx) (err error) {
+			jsonData, err = DeleteByKeysTx(tx, "prest", "public", "test_keys_mock", []interface{}{1, 2}, "test_keys_mock.user_id=$2", []interface{}{"ana"})
+			return
+		})
+		So(err, ShouldBeNil)
+		So(string(jsonData), ShouldEqual, `{"rows_affected":1}`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Delete the rows of mixed case and reserved word keys", t, func() {
+		for table, key := range map[string]string{"test_keys_mixed": "OrderID", "test_keys_reserved": "order"} {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`PRIMARY KEY`).WithArgs("prest", "public", table).
+				WillReturnRows([]string{"column_name"}, []driver.Value{key})
+			mock.ExpectExec(`^DELETE FROM prest\.public\.` + table + ` WHERE "` + key + `" = ANY\(\$1\)$`).
+				WithArgs("{1,2}").WillReturnResult(2)
+			mock.ExpectCommit()
+			err := Session{Config: unrestricted()}.Transaction(func(tx *sql.Tx) (err error) {
+				_, err = DeleteByKeysTx(tx, "prest", "public", table, []interface{}{1, 2}, "", nil)
+				return
+			})
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		}
+	})
+}
+
+func TestChangesSinceWithMock(t *testing.T) {
+	config.InitConf()
+	mock, restore := newMock()
+	defer restore()
+	cfg := configWith(func(cfg *config.Prest) {
+		cfg.AccessConf.Restrict = false
+		cfg.Changes = []config.ChangesConf{{Table: "test_changes_mock", Schema: "audit", Column: "updated_at"}}
+	})
+
+	Convey("Changes in cursor and primary key order", t, func() {
+		mock.ExpectQuery(`PRIMARY KEY`).WithArgs("prest", "public", "test_changes_mock").
+			WillReturnRows([]string{"column_name"}, []driver.Value{"id"})
+		mock.ExpectQuery(`SELECT \*, xmin::text::bigint AS _cursor, json_build_array\(id\) AS _key, false AS _deleted FROM prest\.public\.test_changes_mock ORDER BY xmin::text::bigint, id LIMIT 2\) c$`).
+			WillReturnRows([]string{"json_agg"}, []driver.Value{[]byte(`[{"id":1,"_cursor":7,"_key":[1],"_deleted":false},{"id":2,"_cursor":7,"_key":[2],"_deleted":false}]`)})
+		changes, err := ChangesSince(cfg, "prest", "public", "test_changes_mock", "", 2)
+		So(err, ShouldBeNil)
+		So(len(changes.Upserts), ShouldEqual, 2)
+		So(changes.Upserts[1], ShouldResemble, map[string]interface{}{"id": json.Number("2")})
+		last, err := DecodeCursor(changes.Cursor)
+		So(err, ShouldBeNil)
+		So(last, ShouldResemble, []interface{}{json.Number("7"), json.Number("2")})
+
+		// the third row of the transaction is after the last key
+		mock.ExpectQuery(`FROM prest\.public\.test_changes_mock WHERE \(xmin::text::bigint, id\) > \(\$1, \$2\) ORDER BY xmin::text::bigint, id LIMIT 2\) c$`).
+			WithArgs("7", "2").
+			WillReturnRows([]string{"json_agg"}, []driver.Value{[]byte(`[{"id":3,"_cursor":7,"_key":[3],"_deleted":false}]`)})
+		changes, err = ChangesSince(cfg, "prest", "public", "test_changes_mock", changes.Cursor, 2)
+		So(err, ShouldBeNil)
+		So(changes.Upserts, ShouldResemble, []map[string]interface{}{{"id": json.Number("3")}})
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Cursors without the key return the rows of the last cursor again", t, func() {
+		cursor, err := EncodeCursor([]interface{}{7})
+		So(err, ShouldBeNil)
+		mock.ExpectQuery(`WHERE xmin::text::bigint >= \$1 ORDER BY xmin::text::bigint, id LIMIT 2\) c$`).
+			WithArgs("7").
+			WillReturnRows([]string{"json_agg"}, []driver.Value{[]byte(`[]`)})
+		_, err = ChangesSince(cfg, "prest", "public", "test_changes_mock", cursor, 2)
+		So(err, ShouldBeNil)
+
+		cursor, err = EncodeCursor([]interface{}{7, 2, 1})
+		So(err, ShouldBeNil)
+		_, err = ChangesSince(cfg, "prest", "public", "test_changes_mock", cursor, 2)
+		So(err, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Delta sync config of the schema of the table", t, func() {
+		mock.ExpectQuery(`PRIMARY KEY`).WithArgs("prest", "audit", "test_changes_mock").
+			WillReturnRows([]string{"column_name"}, []driver.Value{"id"})
+		mock.ExpectQuery(`SELECT \*, updated_at AS _cursor, json_build_array\(id\) AS _key, false AS _deleted FROM prest\.audit\.test_changes_mock ORDER BY updated_at, id`).
+			WillReturnRows([]string{"json_agg"}, []driver.Value{[]byte(`[]`)})
+		_, err := ChangesSince(cfg, "prest", "audit", "test_changes_mock", "", 2)
+		So(err, ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+	Convey("Tables without primary key", t, func() {
+		mock.ExpectQuery(`PRIMARY KEY`).WithArgs("prest", "public", "test_changes_nokey").
+			WillReturnRows([]string{"column_name"})
+		_, err := ChangesSince(cfg, "prest", "public", "test_changes_nokey", "", 2)
+		So(err, ShouldNotBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+func TestSessionWithMock(t *testing.T) {
+	mock, restore := newMock()
+	defer restore()
+
+	Convey("Role and search path of the session", t, func() {
+		mock.ExpectBegin()
+		mock.ExpectExec(`^SET LOCAL ROLE "reader"$`)
+		mock.ExpectExec(`^SET LOCAL search_path TO "analytics", "public"$`)
+		mock.ExpectCommit()
+		session := Session{Role: "reader", SearchPath: []string{"analytics", "public"}}
+		err := session.Transaction(func(tx *sql.Tx) error { return nil })
+		So(err, ShouldBeNil)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nuveo/prest/config"
+)
+
+// orderedTypes data types with min and max in the profile
+var orderedTypes = map[string]bool{
+	"smallint":                    true,
+	"integer":                     true,
+	"bigint":                      true,
+	"numeric":                     true,
+	"real":                        true,
+	"double precision":            true,
+	"money":                       true,
+	"date":                        true,
+	"time without time zone":      true,
+	"time with time zone":         true,
+	"timestamp without time zone": true,
+	"timestamp with time zone":    true,
+	"interval":                    true,
+	"text":                        true,
+	"character varying":           true,
+	"character":                   true,
+	"citext":                      true,
+}
+
+// ProfileByRequest parse `_sample` (rows read, up to the configured sample)
+// and `_top` (top values of each column) of the profile request
+func ProfileByRequest(r *http.Request) (sample, top int, err error) {
+	cfg := config.FromContext(r.Context())
+	sample, top = cfg.ProfileSample, cfg.ProfileTop
+	queries := r.URL.Query()
+	if v := queries.Get("_sample"); v != "" {
+		var n int
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 {
+			err = errors.New("Invalid _sample")
+			return
+		}
+		if sample <= 0 || n < sample {
+			sample = n
+		}
+	}
+	if v := queries.Get("_top"); v != "" {
+		top, err = strconv.Atoi(v)
+		if err != nil || top < 0 {
+			err = errors.New("Invalid _top")
+			return
+		}
+	}
+	return
+}
+
+// Profile return the row count and, by readable column, the null rate,
+// distinct count, min, max and top values of a sample of the table
+func Profile(database, schema, table string, sample, top int) (jsonData []byte, err error) {
+	return Session{}.Profile(database, schema, table, sample, top)
+}
+
+// Profile the table with the session settings
+func (s Session) Profile(database, schema, table string, sample, top int) (jsonData []byte, err error) {
+	if !TablePermissions(s.config(), database, schema, table, "read") {
+		err = errors.New("Insuficient table permissions")
+		return
+	}
+
+	if chkInvalidName(database) ||
+		chkInvalidName(schema) ||
+		chkInvalidName(table) {
+		err = errors.New("Profile: Invalid identifier")
+		return
+	}
+
+	SQL, values, err := profileSQL(s.config(), database, schema, table, sample, top)
+	if err != nil {
+		return
+	}
+
+	err = s.read(func(db preparer) (err error) {
+		stmt, err := db.Prepare(s.tagged(SQL))
+		if err != nil {
+			return
+		}
+		defer stmt.Close()
+		return stmt.QueryRow(values...).Scan(&jsonData)
+	})
+	return
+}
+
+// profileSQL build a statement profiling the readable columns of a sample of
+// the table, the statement returns a JSON object
+func profileSQL(cfg *config.Prest, database, schema, table string, sample, top int) (SQL string, values []interface{}, err error) {
+	columns, err := ColumnTypes(database, schema, table)
+	if err != nil {
+		return
+	}
+	readable, err := ReadableFields(cfg, database, schema, table, []string{"*"})
+	if err != nil {
+		return
+	}
+	var names []string
+	types := make(map[string]Column, len(columns))
+	for _, col := range columns {
+		if containsColumn(readable, "*") || containsColumn(readable, col.Name) {
+			names = append(names, col.Name)
+			types[col.Name] = col
+		}
+	}
+	if len(names) == 0 {
+		err = errors.New("Insuficient field permissions")
+		return
+	}
+
+	limit := ""
+	if sample > 0 {
+		values = append(values, sample)
+		limit = fmt.Sprintf(" LIMIT $%d", len(values))
+	}
+	values = append(values, top)
+	topParam := len(values)
+
+	var profiles []string
+	for _, name := range names {
+		col := types[name]
+		ident := quoteName(name)
+		minMax := "NULL::json, NULL::json"
+		if orderedTypes[col.Type] {
+			minMax = fmt.Sprintf("to_json(MIN(%[1]s)), to_json(MAX(%[1]s))", ident)
+		}
+		// values differing only in the case are the same value
+		value := ident + "::text"
+		if col.CaseInsensitive() {
+			value = fmt.Sprintf("lower(%s::text)", ident)
+		}
+		domain := "NULL::text"
+		if col.Domain != "" {
+			values = append(values, col.Domain)
+			domain = fmt.Sprintf("$%d::text", len(values))
+		}
+		values = append(values, name, col.Type)
+		profiles = append(profiles, fmt.Sprintf(
+			"SELECT $%[2]d::text, $%[3]d::text, %[6]s, %[7]t, COALESCE(AVG((%[1]s IS NULL)::int), 0), COUNT(DISTINCT %[8]s), %[4]s, "+
+				"(SELECT COALESCE(json_agg(t), '[]'::json) FROM (SELECT %[8]s AS value, COUNT(*) AS count FROM sample "+
+				"WHERE %[1]s IS NOT NULL GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $%[5]d) t) FROM sample",
+			ident, len(values)-1, len(values), minMax, topParam, domain, col.CaseInsensitive(), value))
+	}
+
+	SQL = fmt.Sprintf(
+		"WITH sample AS (SELECT %s FROM %s%s) "+
+			"SELECT json_build_object('rows', (SELECT COUNT(*) FROM sample), 'columns', "+
+			"(SELECT json_agg(json_build_object('column', p.name, 'type', p.data_type, 'domain', p.domain_name, "+
+			"'case_insensitive', p.case_insensitive, 'null_rate', p.null_rate, "+
+			"'distinct', p.distinct_count, 'min', p.min_value, 'max', p.max_value, 'top', p.top_values)) "+
+			"FROM (%s) AS p(name, data_type, domain_name, case_insensitive, null_rate, distinct_count, min_value, max_value, top_values)))",
+		strings.Join(quoteNames(names), ", "), QuoteTable(database, schema, table), limit, strings.Join(profiles, " UNION ALL "))
+	return
+}
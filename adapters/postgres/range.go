@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/statements"
+)
+
+// emptyBounds are the bounds of empty ranges
+const emptyBounds = "empty"
+
+// rangeColumn column of a range type, Type is the qualified name of the type
+// and Subtype the data type of its bounds
+type rangeColumn struct {
+	Type    string
+	Subtype string
+}
+
+var (
+	rangeCache   = make(map[string]map[string]rangeColumn)
+	rangeCacheMu sync.RWMutex
+)
+
+// rangeColumns return the columns of range types of a table (or view) by
+// name, the result is cached
+func rangeColumns(database, schema, table string) (map[string]rangeColumn, error) {
+	key := fmt.Sprintf("%s.%s.%s", database, schema, table)
+	rangeCacheMu.RLock()
+	cols, ok := rangeCache[key]
+	rangeCacheMu.RUnlock()
+	if ok {
+		return cols, nil
+	}
+
+	// don't cache unknown tables, they may be created later
+	tableColumns, err := TableColumns(database, schema, table)
+	if err != nil || len(tableColumns) == 0 {
+		return nil, err
+	}
+
+	db := connection.MustGet()
+	rows, err := db.Query(statements.RangeColumns, database, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols = make(map[string]rangeColumn)
+	for rows.Next() {
+		var name, typeSchema, typeName, subtype string
+		if err = rows.Scan(&name, &typeSchema, &typeName, &subtype); err != nil {
+			return nil, err
+		}
+		cols[name] = rangeColumn{
+			Type:    pq.QuoteIdentifier(typeSchema) + "." + pq.QuoteIdentifier(typeName),
+			Subtype: subtype,
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rangeCacheMu.Lock()
+	rangeCache[key] = cols
+	rangeCacheMu.Unlock()
+	return cols, nil
+}
+
+// RangeResponse replace the values of the range columns of a table (or view),
+// the text of the ranges as "[1,10)", by {"lower", "upper", "bounds"} objects
+func RangeResponse(database, schema, table string, jsonData []byte) ([]byte, error) {
+	fn, err := rangeRows(database, schema, table)
+	if err != nil || fn == nil {
+		return jsonData, err
+	}
+	return applyRows(jsonData, fn)
+}
+
+// rangeRows return the change of the rows of RangeResponse, nil without range
+// columns
+func rangeRows(database, schema, table string) (RowFunc, error) {
+	cols, err := rangeColumns(database, schema, table)
+	if err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	return func(row map[string]interface{}) (err error) {
+		for name, col := range cols {
+			// aggregates may be named as the column
+			text, ok := row[name].(string)
+			if !ok || (text != emptyBounds && !strings.HasPrefix(text, "[") && !strings.HasPrefix(text, "(")) {
+				continue
+			}
+			if row[name], err = rangeObject(text, col.Subtype); err != nil {
+				return
+			}
+		}
+		return
+	}, nil
+}
+
+// rangeObject parse the text of a range as an object of its bounds, the
+// infinite bounds are null
+func rangeObject(text, subtype string) (map[string]interface{}, error) {
+	if text == emptyBounds {
+		return map[string]interface{}{"lower": nil, "upper": nil, "bounds": emptyBounds}, nil
+	}
+	if len(text) < 3 {
+		return nil, fmt.Errorf("Invalid range value %s", text)
+	}
+	// the bounds are separated as the fields of a tuple
+	values, err := parseTuple("(" + text[1:len(text)-1] + ")")
+	if err != nil || len(values) != 2 || !validBounds(text[:1]+text[len(text)-1:]) {
+		return nil, fmt.Errorf("Invalid range value %s", text)
+	}
+
+	object := map[string]interface{}{"bounds": text[:1] + text[len(text)-1:]}
+	for i, name := range []string{"lower", "upper"} {
+		v := values[i]
+		switch {
+		case v == nil:
+			object[name] = nil
+		case numericTypes[subtype]:
+			object[name] = json.Number(*v)
+		default:
+			object[name] = *v
+		}
+	}
+	return object, nil
+}
+
+// validBounds check the bounds of a range, "[)" or "empty"
+func validBounds(bounds string) bool {
+	if bounds == emptyBounds {
+		return true
+	}
+	return len(bounds) == 2 && strings.ContainsAny(bounds[:1], "[(") && strings.ContainsAny(bounds[1:], "])")
+}
+
+// rangeLiteral return the text of a range of an object with the lower and
+// upper bounds, missing or null bounds are infinite and bounds default to "[)"
+func rangeLiteral(object map[string]interface{}) (string, error) {
+	bounds := "[)"
+	if b, ok := object["bounds"]; ok && b != nil {
+		s, ok := b.(string)
+		if !ok || !validBounds(s) {
+			return "", fmt.Errorf("Invalid range bounds %v", b)
+		}
+		bounds = s
+	}
+	if bounds == emptyBounds {
+		return emptyBounds, nil
+	}
+
+	var limits [2]string
+	for i, name := range []string{"lower", "upper"} {
+		switch v := object[name].(type) {
+		case nil:
+		case json.Number:
+			limits[i] = v.String()
+		case float64:
+			limits[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int:
+			limits[i] = strconv.Itoa(v)
+		case string:
+			limits[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		default:
+			return "", fmt.Errorf("Invalid range %s bound %v", name, v)
+		}
+	}
+	return fmt.Sprintf("%c%s,%s%c", bounds[0], limits[0], limits[1], bounds[1]), nil
+}
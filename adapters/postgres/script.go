@@ -0,0 +1,206 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// ScriptError error of a statement of a SQL script, Statement starts at 1
+type ScriptError struct {
+	Statement int    `json:"statement"`
+	Error     string `json:"error"`
+}
+
+// ScriptResult result of ExecScript
+type ScriptResult struct {
+	Statements int           `json:"statements"`
+	Applied    int           `json:"applied"`
+	Errors     []ScriptError `json:"errors,omitempty"`
+}
+
+// StatementReader return the next statement of a SQL script, io.EOF at the end
+type StatementReader func() (string, error)
+
+// SplitStatements read the statements of a SQL script separated by `;`,
+// ignoring the ones inside quotes, comments and dollar quoted bodies
+func SplitStatements(r io.Reader) StatementReader {
+	reader := bufio.NewReader(r)
+	return func() (string, error) {
+		var stmt bytes.Buffer
+		var quote rune
+		var dollarTag string
+		lineComment, blockComment := false, false
+
+		for {
+			c, _, err := reader.ReadRune()
+			if err == io.EOF {
+				if quote != 0 || dollarTag != "" || blockComment {
+					return "", errors.New("Unterminated statement in SQL script")
+				}
+				s := strings.TrimSpace(stmt.String())
+				if s == "" {
+					return "", io.EOF
+				}
+				return s, nil
+			}
+			if err != nil {
+				return "", err
+			}
+
+			switch {
+			case lineComment:
+				if c == '\n' {
+					lineComment = false
+				}
+			case blockComment:
+				if c == '*' && peek(reader) == '/' {
+					reader.ReadRune()
+					stmt.WriteRune(c)
+					c = '/'
+					blockComment = false
+				}
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case dollarTag != "":
+				if c == '$' {
+					tag, ok := readDollarTag(reader)
+					stmt.WriteRune(c)
+					stmt.WriteString(tag)
+					if ok && tag == dollarTag[1:] {
+						dollarTag = ""
+					}
+					continue
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '-' && peek(reader) == '-':
+				lineComment = true
+			case c == '/' && peek(reader) == '*':
+				blockComment = true
+			case c == '$':
+				tag, ok := readDollarTag(reader)
+				stmt.WriteRune(c)
+				stmt.WriteString(tag)
+				if ok {
+					dollarTag = "$" + tag
+				}
+				continue
+			case c == ';':
+				s := strings.TrimSpace(stmt.String())
+				stmt.Reset()
+				if s != "" {
+					return s, nil
+				}
+				continue
+			}
+			stmt.WriteRune(c)
+		}
+	}
+}
+
+func peek(reader *bufio.Reader) rune {
+	c, _, err := reader.ReadRune()
+	if err != nil {
+		return 0
+	}
+	reader.UnreadRune()
+	return c
+}
+
+// readDollarTag read the rest of a `$tag$` delimiter after the first `$`,
+// it returns false (and what was read) when it is not a delimiter, e.g. `$1`
+func readDollarTag(reader *bufio.Reader) (string, bool) {
+	var tag bytes.Buffer
+	for {
+		c, _, err := reader.ReadRune()
+		if err != nil {
+			return tag.String(), false
+		}
+		if c == '$' {
+			tag.WriteRune(c)
+			return tag.String(), true
+		}
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && tag.Len() > 0) {
+			reader.UnreadRune()
+			return tag.String(), false
+		}
+		tag.WriteRune(c)
+	}
+}
+
+// ExecScript execute the statements of a SQL script. In a transaction all
+// statements are rolled back when any of them fails, otherwise each statement
+// is applied on its own. Errors of statements are reported in the result, in
+// sandbox mode of cfg nothing is applied
+func ExecScript(cfg *config.Prest, next StatementReader, transaction bool) (result ScriptResult, err error) {
+	db := connection.MustGet()
+	// in sandbox mode the statements must run in a transaction to be rolled back
+	if !transaction && !sandbox(cfg) {
+		for {
+			stmt, err := next()
+			if err == io.EOF {
+				return result, nil
+			}
+			if err != nil {
+				return result, err
+			}
+			result.Statements++
+			if _, err := db.Exec(stmt); err != nil {
+				result.Errors = append(result.Errors, ScriptError{Statement: result.Statements, Error: err.Error()})
+				continue
+			}
+			result.Applied++
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil || len(result.Errors) > 0 {
+			tx.Rollback()
+			result.Applied = 0
+			return
+		}
+		err = commit(cfg, tx)
+	}()
+
+	for {
+		var stmt string
+		stmt, err = next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		result.Statements++
+		// savepoints keep the transaction usable to report the next errors
+		if _, err = tx.Exec("SAVEPOINT prest_script"); err != nil {
+			return
+		}
+		if _, execErr := tx.Exec(stmt); execErr != nil {
+			result.Errors = append(result.Errors, ScriptError{Statement: result.Statements, Error: execErr.Error()})
+			if _, err = tx.Exec("ROLLBACK TO SAVEPOINT prest_script"); err != nil {
+				return
+			}
+			continue
+		}
+		if _, err = tx.Exec("RELEASE SAVEPOINT prest_script"); err != nil {
+			return
+		}
+		result.Applied++
+	}
+}
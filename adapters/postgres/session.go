@@ -0,0 +1,242 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// Session settings of the statements of a request, the database role of the
+// principal (to enforce row level security policies) and the search_path.
+// The zero value runs the statements with the settings of the connection
+type Session struct {
+	Role       string
+	SearchPath []string
+	// Tag SQL comment prepended to the statements, to trace them back to
+	// the request in the logs and pg_stat_activity
+	Tag string
+	// Config snapshot of the request, the current configuration when nil
+	Config *config.Prest
+}
+
+type roleKey struct{}
+
+type tagKey struct{}
+
+var (
+	txSessions   = make(map[*sql.Tx]Session)
+	txSessionsMu sync.RWMutex
+)
+
+// WithTag return a context with the SQL comment tag of the request
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// FormatTag build the SQL comment of the key and value pairs, the characters
+// that could close the comment are removed from the values
+func FormatTag(pairs ...string) string {
+	var tags []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.:@{}/", r) {
+				return r
+			}
+			return -1
+		}, pairs[i+1])
+		if value != "" {
+			tags = append(tags, fmt.Sprintf("%s=%s", pairs[i], value))
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/* %s */", strings.Join(tags, ", "))
+}
+
+// tagged prepend the tag of the session to the statement
+func (s Session) tagged(SQL string) string {
+	if s.Tag == "" {
+		return SQL
+	}
+	return fmt.Sprint(s.Tag, " ", SQL)
+}
+
+// config return the config snapshot of the session
+func (s Session) config() *config.Prest {
+	if s.Config != nil {
+		return s.Config
+	}
+	return config.Get()
+}
+
+// txSession return the session of the transaction, the zero value when the
+// transaction was not started by Session.Transaction
+func txSession(tx *sql.Tx) Session {
+	txSessionsMu.RLock()
+	defer txSessionsMu.RUnlock()
+	return txSessions[tx]
+}
+
+// tagged prepend the tag of the session of the transaction to the statement
+func tagged(tx *sql.Tx, SQL string) string {
+	return txSession(tx).tagged(SQL)
+}
+
+// txConfig return the config snapshot of the session of the transaction
+func txConfig(tx *sql.Tx) *config.Prest {
+	return txSession(tx).config()
+}
+
+// WithRole return a context with the database role of the request principal
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext return the database role of the request principal
+func RoleFromContext(ctx context.Context) (role string, ok bool) {
+	role, ok = ctx.Value(roleKey{}).(string)
+	return
+}
+
+// SessionByRequest return the session of the request, with the role of the
+// principal and the search_path of `_schema_path`
+func SessionByRequest(r *http.Request) (s Session, err error) {
+	s.Role, _ = RoleFromContext(r.Context())
+	if s.Role != "" && chkInvalidIdentifier(s.Role) {
+		err = fmt.Errorf("Invalid role: %s", s.Role)
+		return
+	}
+	s.Tag, _ = r.Context().Value(tagKey{}).(string)
+	s.Config = config.FromContext(r.Context())
+	s.SearchPath, err = SearchPathByRequest(r)
+	return
+}
+
+// empty is true when the statements don't need a transaction with the
+// session settings
+func (s Session) empty() bool {
+	return s.Role == "" && len(s.SearchPath) == 0
+}
+
+// begin a transaction with the session settings
+func (s Session) begin() (tx *sql.Tx, err error) {
+	db := connection.MustGet()
+	tx, err = db.Begin()
+	if err != nil {
+		return
+	}
+	if s.Role != "" {
+		if _, err = tx.Exec(fmt.Sprintf("SET LOCAL ROLE %s", pq.QuoteIdentifier(s.Role))); err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	if len(s.SearchPath) > 0 {
+		quoted := make([]string, len(s.SearchPath))
+		for i, schema := range s.SearchPath {
+			quoted[i] = pq.QuoteIdentifier(schema)
+		}
+		if _, err = tx.Exec(fmt.Sprintf("SET LOCAL search_path TO %s", strings.Join(quoted, ", "))); err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	return
+}
+
+// read run fn in a transaction with the session settings, it is always
+// rolled back
+func (s Session) read(fn func(db preparer) error) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// Query process queries with the session settings, identical concurrent
+// queries of the same session share one execution
+func (s Session) Query(SQL string, params ...interface{}) (jsonData []byte, err error) {
+	// the tag is not in the key, the requests share the execution
+	key := queryKey(SQL, params)
+	SQL = s.tagged(SQL)
+	if s.empty() {
+		return coalesce(key, func() ([]byte, error) {
+			return query(SQL, params...)
+		})
+	}
+	key = fmt.Sprint(s.Role, "\n", strings.Join(s.SearchPath, ","), "\n", key)
+	return coalesce(key, func() (jsonData []byte, err error) {
+		err = s.read(func(db preparer) (err error) {
+			jsonData, err = queryWith(db, SQL, params...)
+			return
+		})
+		return
+	})
+}
+
+// QueryStream process queries writing the rows to w one by one with the
+// session settings, the streams don't share executions
+func (s Session) QueryStream(w io.Writer, ndjson bool, funcs []RowFunc, SQL string, params ...interface{}) (rowsCount int64, err error) {
+	SQL = s.tagged(SQL)
+	if s.empty() {
+		return QueryStream(w, ndjson, funcs, SQL, params...)
+	}
+	err = s.read(func(db preparer) (err error) {
+		rowsCount, err = queryStreamWith(db, w, ndjson, funcs, SQL, params...)
+		return
+	})
+	return
+}
+
+// QueryCount process queries with count with the session settings
+func (s Session) QueryCount(SQL string, params ...interface{}) (jsonData []byte, err error) {
+	SQL = s.tagged(SQL)
+	if s.empty() {
+		return QueryCount(SQL, params...)
+	}
+	err = s.read(func(db preparer) (err error) {
+		jsonData, err = queryCountWith(db, SQL, params...)
+		return
+	})
+	return
+}
+
+// QueryExists process queries wrapped in SELECT EXISTS with the session
+// settings
+func (s Session) QueryExists(SQL string, params ...interface{}) (jsonData []byte, exists bool, err error) {
+	SQL = s.tagged(SQL)
+	if s.empty() {
+		return QueryExists(SQL, params...)
+	}
+	err = s.read(func(db preparer) (err error) {
+		jsonData, exists, err = queryExistsWith(db, SQL, params...)
+		return
+	})
+	return
+}
+
+// QueryTotal return the number of rows the query returns without pagination
+// with the session settings
+func (s Session) QueryTotal(SQL string, params ...interface{}) (total int64, err error) {
+	SQL = s.tagged(SQL)
+	if s.empty() {
+		return QueryTotal(SQL, params...)
+	}
+	err = s.read(func(db preparer) (err error) {
+		total, err = queryTotalWith(db, SQL, params...)
+		return
+	})
+	return
+}
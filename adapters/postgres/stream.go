@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nuveo/prest/adapters/postgres/connection"
+	"github.com/nuveo/prest/config"
+)
+
+// streamFlushRows rows written between the flushes of a streamed JSON array,
+// the JSON lines are flushed one by one
+const streamFlushRows = 1000
+
+// RowFunc change a row of the response of a select, the values are the ones
+// of the JSON (strings, json.Number, objects...)
+type RowFunc func(row map[string]interface{}) error
+
+// StreamError error of a streamed response after its first byte, the JSON
+// written is incomplete
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// applyRows change the rows (or the row) of the JSON with the funcs
+func applyRows(jsonData []byte, funcs ...RowFunc) ([]byte, error) {
+	var data interface{}
+	d := json.NewDecoder(bytes.NewReader(jsonData))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		return nil, err
+	}
+	rows, ok := data.([]interface{})
+	if !ok {
+		rows = []interface{}{data}
+	}
+
+	for _, item := range rows {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, fn := range funcs {
+			if err := fn(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(data)
+}
+
+// ResponseRows return the changes of the rows of the selects of a table (or
+// view) done by CompositeResponse, RangeResponse, ByteaResponse,
+// TimestampResponse and TransformResponse, in this order
+func ResponseRows(cfg *config.Prest, database, schema, table, bytea string, timestamps TimestampFormat) (funcs []RowFunc, err error) {
+	composites, err := compositeRows(database, schema, table)
+	if err != nil {
+		return
+	}
+	ranges, err := rangeRows(database, schema, table)
+	if err != nil {
+		return
+	}
+	byteas, err := byteaRows(database, schema, table, bytea)
+	if err != nil {
+		return
+	}
+	times, err := timestampRows(database, schema, table, timestamps)
+	if err != nil {
+		return
+	}
+	for _, fn := range []RowFunc{composites, ranges, byteas, times, transformRows(cfg, database, schema, table)} {
+		if fn != nil {
+			funcs = append(funcs, fn)
+		}
+	}
+	return
+}
+
+// QueryStream process queries writing the rows to w one by one, as a JSON
+// array or as JSON lines (ndjson), changed by the funcs. Only a row is in
+// memory at a time, the writer is flushed after each JSON line or every 1000
+// rows of the array. Nothing is written when the query fails, the errors after that are *StreamError
+func QueryStream(w io.Writer, ndjson bool, funcs []RowFunc, SQL string, params ...interface{}) (rowsCount int64, err error) {
+	return queryStreamWith(connection.MustGet(), w, ndjson, funcs, SQL, params...)
+}
+
+func queryStreamWith(db preparer, w io.Writer, ndjson bool, funcs []RowFunc, SQL string, params ...interface{}) (rowsCount int64, err error) {
+	validQuery := chkInvalidIdentifier(SQL)
+	if !validQuery {
+		err = errors.New("Invalid characters in the query")
+		return
+	}
+
+	prepare, err := db.Prepare(SQL)
+	if err != nil {
+		return
+	}
+
+	rows, err := prepare.Query(params...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+
+	start, separator, end := "[", ",", "]"
+	if ndjson {
+		start, separator, end = "", "", ""
+	}
+	flusher, _ := w.(http.Flusher)
+	write := func(s string) {
+		if err == nil {
+			_, err = io.WriteString(w, s)
+		}
+	}
+	defer func() {
+		if err != nil {
+			err = &StreamError{Err: err}
+		}
+	}()
+
+	write(start)
+	count := len(columns)
+	values := make([]interface{}, count)
+	valuePtrs := make([]interface{}, count)
+	for err == nil && rows.Next() {
+		for i := 0; i < count; i++ {
+			valuePtrs[i] = &values[i]
+		}
+		if err = rows.Scan(valuePtrs...); err != nil {
+			return
+		}
+		entry := make(map[string]interface{}, count)
+		for i, col := range columns {
+			// the same values of Query
+			if b, ok := values[i].([]byte); ok {
+				entry[col] = string(b)
+			} else {
+				entry[col] = values[i]
+			}
+		}
+
+		var row []byte
+		if row, err = json.Marshal(entry); err != nil {
+			return
+		}
+		if len(funcs) > 0 {
+			if row, err = applyRows(row, funcs...); err != nil {
+				return
+			}
+		}
+
+		if rowsCount > 0 {
+			write(separator)
+		}
+		write(string(row))
+		if ndjson {
+			write("\n")
+		}
+		rowsCount++
+		if flusher != nil && (ndjson || rowsCount%streamFlushRows == 0) {
+			flusher.Flush()
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	write(end)
+	return
+}
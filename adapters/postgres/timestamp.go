@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/statements"
+)
+
+// formats of the timestamp columns in JSON, RFC 3339 in UTC
+// ("2017-01-02T03:04:05.5Z") by default, any other format is a Go layout
+const (
+	TimestampRFC3339 = "rfc3339"
+	TimestampEpoch   = "epoch"
+)
+
+// TimestampFormat format and timezone of the timestamp columns in JSON
+type TimestampFormat struct {
+	Layout   string
+	Location *time.Location
+}
+
+// timestampLayout layout of the timestamps in the JSON of the selects, the
+// ones without time zone are in UTC
+const timestampLayout = "2006-01-02T15:04:05.999999999Z07:00"
+
+// TimestampColumns return the columns of timestamp types of a table (or
+// view), the result is cached
+func TimestampColumns(database, schema, table string) ([]string, error) {
+	return cachedColumns(statements.TimestampColumns, database, schema, table)
+}
+
+// TimestampByRequest return the format and timezone of the timestamp
+// columns, `_timestamp` and `_tz` or else the configured ones
+func TimestampByRequest(r *http.Request) (format TimestampFormat, err error) {
+	cfg := config.FromContext(r.Context())
+	format.Layout = r.URL.Query().Get("_timestamp")
+	if format.Layout == "" {
+		format.Layout = cfg.TimestampFormat
+	}
+	switch format.Layout {
+	case "", TimestampRFC3339, TimestampEpoch:
+	default:
+		// a layout without any element formats to itself
+		if time.Unix(0, 0).UTC().Format(format.Layout) == format.Layout {
+			err = fmt.Errorf("Invalid _timestamp: %s", format.Layout)
+			return
+		}
+	}
+
+	zone := r.URL.Query().Get("_tz")
+	if zone == "" {
+		zone = cfg.TimestampZone
+	}
+	if zone != "" {
+		format.Location, err = time.LoadLocation(zone)
+		if err != nil {
+			err = fmt.Errorf("Invalid _tz: %s", zone)
+		}
+	}
+	return
+}
+
+// TimestampResponse format the values of the timestamp columns of a table (or
+// view) of the rows (or the row), nothing changes without format or timezone
+func TimestampResponse(database, schema, table string, format TimestampFormat, jsonData []byte) ([]byte, error) {
+	fn, err := timestampRows(database, schema, table, format)
+	if err != nil || fn == nil {
+		return jsonData, err
+	}
+	return applyRows(jsonData, fn)
+}
+
+// timestampRows return the change of the rows of TimestampResponse, nil
+// without format, timezone or timestamp columns
+func timestampRows(database, schema, table string, format TimestampFormat) (RowFunc, error) {
+	if format.Layout == "" && format.Location == nil {
+		return nil, nil
+	}
+	cols, err := TimestampColumns(database, schema, table)
+	if err != nil || len(cols) == 0 {
+		return nil, err
+	}
+	zoned, err := cachedColumns(statements.TimestampTZColumns, database, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	return func(row map[string]interface{}) error {
+		for _, name := range cols {
+			text, ok := row[name].(string)
+			if !ok {
+				continue
+			}
+			row[name] = timestampValue(text, format, containsColumn(zoned, name))
+		}
+		return nil
+	}, nil
+}
+
+// timestampValue format the JSON of a timestamp, the ones with time zone
+// (zoned) are converted to the timezone and the ones without keep their
+// time. Infinity is kept
+func timestampValue(text string, format TimestampFormat, zoned bool) interface{} {
+	location := format.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t, err := time.Parse(timestampLayout, text)
+	if err != nil {
+		return text
+	}
+	if zoned {
+		t = t.In(location)
+	} else {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), location)
+	}
+
+	switch format.Layout {
+	case "", TimestampRFC3339:
+		return t.Format(time.RFC3339Nano)
+	case TimestampEpoch:
+		sec, nsec := t.Unix(), int64(t.Nanosecond())
+		if nsec == 0 {
+			return json.Number(strconv.FormatInt(sec, 10))
+		}
+		sign := ""
+		if sec < 0 {
+			// Unix rounds down before 1970, -0.5 is -1 and 5e8 nanoseconds
+			sign, sec, nsec = "-", -sec-1, 1e9-nsec
+		}
+		return json.Number(sign + strconv.FormatInt(sec, 10) + strings.TrimRight(fmt.Sprintf(".%09d", nsec), "0"))
+	}
+	return t.Format(format.Layout)
+}
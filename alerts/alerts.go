@@ -0,0 +1,136 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// Rule alert of more than Rows rows of an operation (insert, update or
+// delete) on the tables matching Table (a glob pattern) within Window, sent
+// to the log and to the Webhook (when not empty)
+type Rule struct {
+	Table     string
+	Operation string
+	Rows      int64
+	Window    time.Duration
+	Webhook   string
+}
+
+// Alert rows of a rule crossing its threshold
+type Alert struct {
+	Table     string    `json:"table"`
+	Operation string    `json:"operation"`
+	Rows      int64     `json:"rows"`
+	Threshold int64     `json:"threshold"`
+	Window    int       `json:"window"`
+	Time      time.Time `json:"time"`
+	Webhook   string    `json:"-"`
+}
+
+type event struct {
+	time time.Time
+	rows int64
+}
+
+// Monitor count the rows of the rules by table in sliding windows, a rule
+// alerts once by window of each table
+type Monitor struct {
+	Rules  []Rule
+	Client *http.Client
+
+	mu     sync.Mutex
+	events map[string][]event
+	fired  map[string]time.Time
+}
+
+// New return a monitor of the rules, the webhooks time out in 10 seconds
+func New(rules []Rule) *Monitor {
+	return &Monitor{
+		Rules:  rules,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		events: make(map[string][]event),
+		fired:  make(map[string]time.Time),
+	}
+}
+
+// Track count the rows of an operation on the table at now, returning the
+// alerts of the rules crossing their threshold
+func (m *Monitor) Track(table, operation string, rows int64, now time.Time) (alerts []Alert) {
+	if rows <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, rule := range m.Rules {
+		if rule.Operation != operation || !matchTable(rule.Table, table) {
+			continue
+		}
+		// the rules with patterns count each table apart
+		key := fmt.Sprintf("%d\n%s", i, table)
+		events := append(m.events[key], event{now, rows})
+		var total int64
+		for len(events) > 0 && now.Sub(events[0].time) >= rule.Window {
+			events = events[1:]
+		}
+		for _, e := range events {
+			total += e.rows
+		}
+		m.events[key] = events
+
+		if total <= rule.Rows {
+			continue
+		}
+		if fired, ok := m.fired[key]; ok && now.Sub(fired) < rule.Window {
+			continue
+		}
+		m.fired[key] = now
+		alerts = append(alerts, Alert{
+			Table:     table,
+			Operation: operation,
+			Rows:      total,
+			Threshold: rule.Rows,
+			Window:    int(rule.Window.Seconds()),
+			Time:      now,
+			Webhook:   rule.Webhook,
+		})
+	}
+	return
+}
+
+// Send log the alert and post it (as JSON) to its webhook
+func (m *Monitor) Send(alert Alert) {
+	log.Printf("[alerts] %d rows of %s on %s in %ds, more than %d\n", alert.Rows, alert.Operation, alert.Table, alert.Window, alert.Threshold)
+	if alert.Webhook == "" {
+		return
+	}
+	b, err := json.Marshal(alert)
+	if err != nil {
+		log.Println("[alerts]", err)
+		return
+	}
+	resp, err := m.Client.Post(alert.Webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Println("[alerts]", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[alerts] webhook %s answered %d\n", alert.Webhook, resp.StatusCode)
+	}
+}
+
+// matchTable match a table with a glob pattern, invalid patterns only match
+// the same name
+func matchTable(pattern, table string) bool {
+	ok, err := path.Match(pattern, table)
+	if err != nil {
+		return pattern == table
+	}
+	return ok
+}
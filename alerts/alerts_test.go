@@ -0,0 +1,82 @@
+package alerts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMonitor(t *testing.T) {
+	now := time.Now()
+	Convey("Alert when the rows cross the threshold", t, func() {
+		m := New([]Rule{{Table: "test", Operation: "delete", Rows: 10, Window: time.Minute}})
+		So(m.Track("test", "delete", 10, now), ShouldBeEmpty)
+		alerts := m.Track("test", "delete", 1, now.Add(time.Second))
+		So(len(alerts), ShouldEqual, 1)
+		So(alerts[0].Table, ShouldEqual, "test")
+		So(alerts[0].Operation, ShouldEqual, "delete")
+		So(alerts[0].Rows, ShouldEqual, 11)
+		So(alerts[0].Threshold, ShouldEqual, 10)
+		So(alerts[0].Window, ShouldEqual, 60)
+	})
+	Convey("Alert once by window", t, func() {
+		m := New([]Rule{{Table: "test", Operation: "delete", Rows: 1, Window: time.Minute}})
+		So(len(m.Track("test", "delete", 2, now)), ShouldEqual, 1)
+		So(m.Track("test", "delete", 2, now.Add(time.Second)), ShouldBeEmpty)
+		So(len(m.Track("test", "delete", 2, now.Add(time.Minute))), ShouldEqual, 1)
+	})
+	Convey("Rows out of the window aren't counted", t, func() {
+		m := New([]Rule{{Table: "test", Operation: "delete", Rows: 10, Window: time.Minute}})
+		So(m.Track("test", "delete", 10, now), ShouldBeEmpty)
+		So(m.Track("test", "delete", 10, now.Add(time.Minute)), ShouldBeEmpty)
+	})
+	Convey("Other tables and operations aren't counted", t, func() {
+		m := New([]Rule{{Table: "test", Operation: "delete", Rows: 1, Window: time.Minute}})
+		So(m.Track("test", "insert", 2, now), ShouldBeEmpty)
+		So(m.Track("test2", "delete", 2, now), ShouldBeEmpty)
+	})
+	Convey("Tables of a pattern are counted apart", t, func() {
+		m := New([]Rule{{Table: "log_*", Operation: "update", Rows: 1, Window: time.Minute}})
+		So(m.Track("log_a", "update", 1, now), ShouldBeEmpty)
+		So(m.Track("log_b", "update", 1, now), ShouldBeEmpty)
+		alerts := m.Track("log_b", "update", 1, now)
+		So(len(alerts), ShouldEqual, 1)
+		So(alerts[0].Table, ShouldEqual, "log_b")
+	})
+}
+
+func TestSend(t *testing.T) {
+	Convey("Post the alert to the webhook", t, func() {
+		type request struct {
+			contentType string
+			alert       Alert
+			err         error
+		}
+		received := make(chan request, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := request{contentType: r.Header.Get("Content-Type")}
+			req.err = json.NewDecoder(r.Body).Decode(&req.alert)
+			received <- req
+		}))
+		defer server.Close()
+
+		m := New(nil)
+		m.Send(Alert{Table: "test", Operation: "delete", Rows: 11, Threshold: 10, Window: 60, Webhook: server.URL})
+		var req request
+		select {
+		case req = <-received:
+		case <-time.After(5 * time.Second):
+			So("webhook not called", ShouldBeEmpty)
+			return
+		}
+		So(req.contentType, ShouldEqual, "application/json")
+		So(req.err, ShouldBeNil)
+		So(req.alert.Table, ShouldEqual, "test")
+		So(req.alert.Rows, ShouldEqual, 11)
+		So(req.alert.Webhook, ShouldEqual, "")
+	})
+}
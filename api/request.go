@@ -4,3 +4,25 @@ package api
 type Request struct {
 	Data map[string]interface{} `json:"data"`
 }
+
+// ImportRequest body representation of import jobs
+type ImportRequest struct {
+	Key    string `json:"key"`
+	Format string `json:"format"`
+}
+
+// BatchOperation insert, update or delete of a batch request, Where has the
+// filters in query string syntax (e.g. "name=prest&deleted_at=$null")
+type BatchOperation struct {
+	Op       string                 `json:"op"`
+	Database string                 `json:"database"`
+	Schema   string                 `json:"schema"`
+	Table    string                 `json:"table"`
+	Where    string                 `json:"where"`
+	Data     map[string]interface{} `json:"data"`
+}
+
+// BatchRequest body representation of batch requests
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
@@ -0,0 +1,104 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/nuveo/prest/config"
+)
+
+// Header where the clients send the API key
+const Header = "X-API-Key"
+
+type contextKey struct{}
+
+// Find return the configured key with the value, nil when there is none
+func Find(keys []config.APIKeyConf, value string) *config.APIKeyConf {
+	var found *config.APIKeyConf
+	for i := range keys {
+		// compare all of them to not leak which key matched by timing
+		if subtle.ConstantTimeCompare([]byte(keys[i].Key), []byte(value)) == 1 {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+// Allowed check if the key has the permission (read, insert, update or
+// delete) in the table
+func Allowed(key *config.APIKeyConf, database, schema, table, op string) bool {
+	for _, t := range key.Tables {
+		if t.Matches(database, schema, table) && config.HasPermission(t.Permissions, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields return the fields of the table the key can use with the operation,
+// nil when its rules don't restrict them
+func Fields(key *config.APIKeyConf, database, schema, table, op string) (fields []string) {
+	for _, t := range key.Tables {
+		if !t.Matches(database, schema, table) || !config.HasPermission(t.Permissions, op) {
+			continue
+		}
+		if len(t.Fields) == 0 {
+			return nil
+		}
+		fields = append(fields, t.Fields...)
+	}
+	return
+}
+
+// Resource return the table (or view) and the operation of the request, an
+// empty table is an endpoint without table (e.g. /databases) and ok is false
+// for the endpoints API keys can't use
+func Resource(r *http.Request) (database, schema, table, op string, ok bool) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "_VIEW" && len(parts) == 4:
+		return parts[1], parts[2], parts[3], "read", r.Method == "GET"
+	case parts[0] == "_profile" && len(parts) == 4:
+		return parts[1], parts[2], parts[3], "read", r.Method == "GET"
+	case parts[0] == "_jobs":
+		return "", "", "", "read", true
+	case strings.HasPrefix(parts[0], "_"):
+		return "", "", "", "", false
+	case len(parts) <= 2:
+		return "", "", "", "read", r.Method == "GET"
+	case len(parts) == 3:
+		switch r.Method {
+		case "GET":
+			return parts[0], parts[1], parts[2], "read", true
+		case "POST":
+			return parts[0], parts[1], parts[2], "insert", true
+		case "PUT", "PATCH":
+			return parts[0], parts[1], parts[2], "update", true
+		case "DELETE":
+			return parts[0], parts[1], parts[2], "delete", true
+		}
+	case len(parts) == 4:
+		switch parts[3] {
+		case "_import", "_copy":
+			return parts[0], parts[1], parts[2], "insert", true
+		case "_batch":
+			return parts[0], parts[1], parts[2], "delete", r.Method == "DELETE"
+		default:
+			return parts[0], parts[1], parts[2], "read", true
+		}
+	}
+	return "", "", "", "", false
+}
+
+// NewContext return a context with the key of the request
+func NewContext(ctx context.Context, key *config.APIKeyConf) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext return the key of the request, if it was authenticated by one
+func FromContext(ctx context.Context) (*config.APIKeyConf, bool) {
+	key, ok := ctx.Value(contextKey{}).(*config.APIKeyConf)
+	return key, ok
+}
@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var keys = []config.APIKeyConf{
+	{
+		Name: "reports",
+		Key:  "reportskey",
+		Tables: []config.TablesConf{
+			{Name: "test", Permissions: []string{"read"}},
+			{Name: "test5", Permissions: []string{"read", "write"}},
+			{Name: "test6", Schema: "reports", Permissions: []string{"read"}, Fields: []string{"id", "total"}},
+		},
+	},
+	{
+		Name: "cleaner",
+		Key:  "cleanerkey",
+		Tables: []config.TablesConf{
+			{Name: "test", Permissions: []string{"delete"}},
+		},
+	},
+}
+
+func TestFind(t *testing.T) {
+	Convey("Find the configured key", t, func() {
+		key := Find(keys, "cleanerkey")
+		So(key, ShouldNotBeNil)
+		So(key.Name, ShouldEqual, "cleaner")
+
+		So(Find(keys, "otherkey"), ShouldBeNil)
+		So(Find(keys, ""), ShouldBeNil)
+	})
+}
+
+func TestAllowed(t *testing.T) {
+	Convey("Table permissions of the key", t, func() {
+		So(Allowed(&keys[0], "prest", "public", "test", "read"), ShouldBeTrue)
+		So(Allowed(&keys[0], "prest", "public", "test", "insert"), ShouldBeFalse)
+		So(Allowed(&keys[0], "prest", "public", "test5", "insert"), ShouldBeTrue)
+		So(Allowed(&keys[0], "prest", "public", "test5", "update"), ShouldBeTrue)
+		So(Allowed(&keys[0], "prest", "public", "test2", "read"), ShouldBeFalse)
+		So(Allowed(&keys[1], "prest", "public", "test", "delete"), ShouldBeTrue)
+	})
+	Convey("Rules scoped by schema", t, func() {
+		So(Allowed(&keys[0], "prest", "reports", "test6", "read"), ShouldBeTrue)
+		So(Allowed(&keys[0], "prest", "public", "test6", "read"), ShouldBeFalse)
+	})
+}
+
+func TestFields(t *testing.T) {
+	Convey("Fields of the key", t, func() {
+		So(Fields(&keys[0], "prest", "reports", "test6", "read"), ShouldResemble, []string{"id", "total"})
+		So(Fields(&keys[0], "prest", "public", "test", "read"), ShouldBeNil)
+		So(Fields(&keys[0], "prest", "reports", "test6", "insert"), ShouldBeNil)
+	})
+}
+
+func TestResource(t *testing.T) {
+	cases := []struct {
+		method, path, schema, table, op string
+		ok                              bool
+	}{
+		{"GET", "/prest/public/test", "public", "test", "read", true},
+		{"POST", "/prest/public/test", "public", "test", "insert", true},
+		{"PATCH", "/prest/public/test", "public", "test", "update", true},
+		{"DELETE", "/prest/public/test", "public", "test", "delete", true},
+		{"GET", "/prest/public/test/_count", "public", "test", "read", true},
+		{"POST", "/prest/public/test/_copy", "public", "test", "insert", true},
+		{"DELETE", "/prest/public/test/_batch", "public", "test", "delete", true},
+		{"GET", "/_VIEW/prest/reports/view_test", "reports", "view_test", "read", true},
+		{"GET", "/_profile/prest/public/test", "public", "test", "read", true},
+		{"GET", "/databases", "", "", "read", true},
+		{"GET", "/prest/public", "", "", "read", true},
+		{"GET", "/_jobs/1", "", "", "read", true},
+		{"POST", "/_batch", "", "", "", false},
+		{"POST", "/_backup/prest", "", "", "", false},
+	}
+	Convey("Table and operation of the requests", t, func() {
+		for _, c := range cases {
+			r, err := http.NewRequest(c.method, c.path, nil)
+			So(err, ShouldBeNil)
+			database, schema, table, op, ok := Resource(r)
+			if c.table != "" {
+				So(database, ShouldEqual, "prest")
+			}
+			So(schema, ShouldEqual, c.schema)
+			So(table, ShouldEqual, c.table)
+			So(op, ShouldEqual, c.op)
+			So(ok, ShouldEqual, c.ok)
+		}
+	})
+}
+
+func TestContext(t *testing.T) {
+	Convey("Key of the request context", t, func() {
+		_, ok := FromContext(context.Background())
+		So(ok, ShouldBeFalse)
+
+		key, ok := FromContext(NewContext(context.Background(), &keys[0]))
+		So(ok, ShouldBeTrue)
+		So(key.Name, ShouldEqual, "reports")
+	})
+}
@@ -0,0 +1,40 @@
+package authz
+
+import "context"
+
+// Authorizer decide if the principal of a request can run the action (read,
+// insert, update or delete) in the columns of a table (or view), returning
+// the reason when it can't. Reads have the requested columns ("*" for all of
+// them), inserts and updates the columns of the body (none in the bulk loads)
+// and deletes none
+type Authorizer interface {
+	Authorize(principal, database, schema, table, action string, columns []string) error
+}
+
+// AuthorizerFunc adapt a function to Authorizer
+type AuthorizerFunc func(principal, database, schema, table, action string, columns []string) error
+
+// Authorize call f
+func (f AuthorizerFunc) Authorize(principal, database, schema, table, action string, columns []string) error {
+	return f(principal, database, schema, table, action, columns)
+}
+
+// Default authorizer of pREST, set by the applications embedding it before
+// serving. When nil the table permissions of the config are used, when set
+// they are ignored
+var Default Authorizer
+
+type contextKey struct{}
+
+// NewContext return a context with the principal of the request
+func NewContext(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext return the principal of the request, the API key name
+// ("apikey:NAME"), the basic auth username or the JWT subject, empty for
+// anonymous requests
+func FromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(contextKey{}).(string)
+	return principal
+}
@@ -0,0 +1,28 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthz(t *testing.T) {
+	Convey("Principal of the context", t, func() {
+		So(FromContext(context.Background()), ShouldBeEmpty)
+		ctx := NewContext(context.Background(), "apikey:reports")
+		So(FromContext(ctx), ShouldEqual, "apikey:reports")
+	})
+	Convey("Authorizer function", t, func() {
+		var a Authorizer = AuthorizerFunc(func(principal, database, schema, table, action string, columns []string) error {
+			if principal != "admin" && action != "read" {
+				return errors.New("read only")
+			}
+			return nil
+		})
+		So(a.Authorize("admin", "prest", "public", "test", "delete", nil), ShouldBeNil)
+		So(a.Authorize("guest", "prest", "public", "test", "read", []string{"*"}), ShouldBeNil)
+		So(a.Authorize("guest", "prest", "public", "test", "insert", []string{"name"}), ShouldNotBeNil)
+	})
+}
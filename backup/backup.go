@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/nuveo/prest/config"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_\-\.]*$`)
+
+// FileName return the dump file name of a database (and schema)
+func FileName(database, schema string, t time.Time) string {
+	name := database
+	if schema != "" {
+		name = fmt.Sprint(name, "_", schema)
+	}
+	return fmt.Sprintf("%s_%s.dump", name, t.UTC().Format("20060102T150405Z"))
+}
+
+// Command build the pg_dump (custom format) command of a database, only the
+// schema is dumped when informed
+func Command(cfg *config.Prest, database, schema, file string) (*exec.Cmd, error) {
+	if !validName.MatchString(database) {
+		return nil, errors.New("Invalid database name")
+	}
+	if schema != "" && !validName.MatchString(schema) {
+		return nil, errors.New("Invalid schema name")
+	}
+
+	args := []string{
+		"--format=custom",
+		fmt.Sprint("--host=", cfg.PGHost),
+		fmt.Sprint("--port=", strconv.Itoa(cfg.PGPort)),
+		fmt.Sprint("--file=", file),
+	}
+	if cfg.PGUser != "" {
+		args = append(args, fmt.Sprint("--username=", cfg.PGUser))
+	}
+	if schema != "" {
+		args = append(args, fmt.Sprint("--schema=", schema))
+	}
+	args = append(args, fmt.Sprint("--dbname=", database))
+
+	cmd := exec.Command(cfg.BackupPGDump, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprint("PGPASSWORD=", cfg.PGPass))
+	return cmd, nil
+}
+
+// Dump run pg_dump writing the backup in the configured path, it returns the
+// created file
+func Dump(cfg *config.Prest, database, schema string) (file string, err error) {
+	if cfg.BackupPath == "" {
+		err = errors.New("Backup path is not configured")
+		return
+	}
+	err = os.MkdirAll(cfg.BackupPath, 0700)
+	if err != nil {
+		return
+	}
+
+	file = filepath.Join(cfg.BackupPath, FileName(database, schema, time.Now()))
+	cmd, err := Command(cfg, database, schema, file)
+	if err != nil {
+		return
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(file)
+		err = fmt.Errorf("pg_dump: %v: %s", err, out)
+		return
+	}
+	return
+}
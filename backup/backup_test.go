@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileName(t *testing.T) {
+	Convey("Dump file name", t, func() {
+		now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		So(FileName("prest", "", now), ShouldEqual, "prest_20170102T030405Z.dump")
+		So(FileName("prest", "public", now), ShouldEqual, "prest_public_20170102T030405Z.dump")
+	})
+}
+
+func TestCommand(t *testing.T) {
+	cfg := &config.Prest{
+		PGHost:       "127.0.0.1",
+		PGPort:       5432,
+		PGUser:       "postgres",
+		PGPass:       "secret",
+		BackupPGDump: "pg_dump",
+	}
+	Convey("Build pg_dump command", t, func() {
+		cmd, err := Command(cfg, "prest", "public", "/tmp/prest.dump")
+		So(err, ShouldBeNil)
+		So(cmd.Args, ShouldResemble, []string{
+			"pg_dump",
+			"--format=custom",
+			"--host=127.0.0.1",
+			"--port=5432",
+			"--file=/tmp/prest.dump",
+			"--username=postgres",
+			"--schema=public",
+			"--dbname=prest",
+		})
+		So(cmd.Env, ShouldContain, "PGPASSWORD=secret")
+	})
+	Convey("Build pg_dump command with invalid database", t, func() {
+		_, err := Command(cfg, "-prest", "", "/tmp/prest.dump")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Build pg_dump command with invalid schema", t, func() {
+		_, err := Command(cfg, "prest", "public;", "/tmp/prest.dump")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestDump(t *testing.T) {
+	Convey("Dump without backup path", t, func() {
+		_, err := Dump(&config.Prest{}, "prest", "")
+		So(err, ShouldNotBeNil)
+	})
+}
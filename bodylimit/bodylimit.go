@@ -0,0 +1,52 @@
+package bodylimit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// exempt endpoints streaming bodies bigger than the limit, the bulk load of
+// CSV files and the restore of SQL scripts
+func exempt(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	return parts[0] == "_restore" || parts[len(parts)-1] == "_copy"
+}
+
+// body of the request read in memory, closing the original one
+type body struct {
+	io.Reader
+	io.Closer
+}
+
+// New return a negroni handler refusing with 413 the requests with bodies
+// over max bytes (0 disables the limit). Bodies of unknown length are read
+// up to the limit before the next handlers
+func New(max int64) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if max <= 0 || r.Body == nil || r.Body == http.NoBody || exempt(r.URL.Path) {
+			next(w, r)
+			return
+		}
+		if r.ContentLength > max {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.ContentLength < 0 {
+			b, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if int64(len(b)) > max {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = body{bytes.NewReader(b), r.Body}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next(w, r)
+	}
+}
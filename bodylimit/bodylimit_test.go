@@ -0,0 +1,60 @@
+package bodylimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serve(max int64, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	New(max)(w, r, func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(b)
+	})
+	return w
+}
+
+// chunked request, without the length of the body
+func chunked(path, data string) *http.Request {
+	r := httptest.NewRequest("POST", path, ioutil.NopCloser(strings.NewReader(data)))
+	r.ContentLength = -1
+	return r
+}
+
+func TestBodyLimit(t *testing.T) {
+	Convey("Bodies up to the limit", t, func() {
+		w := serve(10, httptest.NewRequest("POST", "/prest/public/test", strings.NewReader(`{"a":1}`)))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `{"a":1}`)
+
+		w = serve(10, chunked("/prest/public/test", "0123456789"))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "0123456789")
+	})
+	Convey("Bodies over the limit", t, func() {
+		w := serve(10, httptest.NewRequest("POST", "/prest/public/test", strings.NewReader(`{"name":"nuveo"}`)))
+		So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+		w = serve(10, chunked("/prest/public/test", "0123456789A"))
+		So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+	})
+	Convey("Without limit", t, func() {
+		w := serve(0, httptest.NewRequest("POST", "/prest/public/test", strings.NewReader(`{"name":"nuveo"}`)))
+		So(w.Code, ShouldEqual, http.StatusOK)
+	})
+	Convey("Exempt endpoints", t, func() {
+		w := serve(10, httptest.NewRequest("POST", "/prest/public/test/_copy", strings.NewReader("id,name\n1,nuveo\n")))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		w = serve(10, httptest.NewRequest("POST", "/_restore/prest", strings.NewReader("SELECT 1;")))
+		So(w.Code, ShouldEqual, http.StatusOK)
+	})
+}
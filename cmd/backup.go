@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nuveo/prest/backup"
+	"github.com/nuveo/prest/config"
+	"github.com/spf13/cobra"
+)
+
+var backupDatabase string
+var backupSchema string
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Run pg_dump of a database",
+	Long:  `Run pg_dump (custom format) of a database or schema into the backup path`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.Get()
+		database := backupDatabase
+		if database == "" {
+			database = cfg.PGDatabase
+		}
+		timerStart = time.Now()
+		file, err := backup.Dump(cfg, database, backupSchema)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		fmt.Println(file)
+		printTimer()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+	backupCmd.Flags().StringVar(&backupDatabase, "database", "", "Database to dump (default PREST_PG_DATABASE)")
+	backupCmd.Flags().StringVar(&backupSchema, "schema", "", "Dump only this schema")
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
+	"github.com/spf13/cobra"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the access rules of the config",
+	Long:  `Check that the tables of the access rules exist and that their fields and masked columns are columns of them`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if config.Get().AccessConf.Table != "" {
+			if _, err := postgres.LoadAccessTable(config.Get().AccessConf.Table); err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		}
+		warnings, err := postgres.CheckAccess()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		for _, w := range warnings {
+			fmt.Println(w)
+		}
+		if len(warnings) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("The access rules are consistent with the database")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+}
+
+// loadAccessTable load the rules of the access table on boot, the server
+// starts even when the database is unavailable (the rules can be loaded
+// later with /_access/refresh)
+func loadAccessTable() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("access table not loaded:", err)
+		}
+	}()
+	count, err := postgres.LoadAccessTable(config.Get().AccessConf.Table)
+	if err != nil {
+		log.Println("access table not loaded:", err)
+		return
+	}
+	log.Printf("%d access rules loaded from %s\n", count, config.Get().AccessConf.Table)
+}
+
+// logAccessWarnings log the problems of the access rules on boot, the server
+// starts even when the database is unavailable
+func logAccessWarnings() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("access rules not checked:", err)
+		}
+	}()
+	warnings, err := postgres.CheckAccess()
+	if err != nil {
+		log.Println("access rules not checked:", err)
+		return
+	}
+	for _, w := range warnings {
+		log.Println("warning:", w)
+	}
+}
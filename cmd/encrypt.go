@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nuveo/prest/config"
+	"github.com/spf13/cobra"
+)
+
+var encryptGenKey bool
+
+// encryptCmd represents the encrypt command
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt [value]",
+	Short: "Encrypt a value of the config",
+	Long:  `Encrypt a value (e.g. the database password) with the key of PREST_SECRET_KEY, the result can be used in the config file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if encryptGenKey {
+			key, err := config.NewSecretKey()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			fmt.Println(key)
+			return
+		}
+		if len(args) != 1 {
+			fmt.Println("You must inform the value to encrypt")
+			os.Exit(-1)
+		}
+		key, err := config.SecretKey()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		secret, err := config.EncryptSecret(key, args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		fmt.Println(secret)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(encryptCmd)
+	encryptCmd.Flags().BoolVar(&encryptGenKey, "genkey", false, "Generate a new key for PREST_SECRET_KEY")
+}
@@ -1,17 +1,44 @@
 package cmd
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/auth0/go-jwt-middleware"
 	"github.com/dgrijalva/jwt-go"
+	gcontext "github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	// postgres driver for migrate
 	_ "github.com/mattes/migrate/driver/postgres"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/alerts"
+	"github.com/nuveo/prest/apikey"
+	"github.com/nuveo/prest/authz"
+	"github.com/nuveo/prest/bodylimit"
 	"github.com/nuveo/prest/config"
 	"github.com/nuveo/prest/controllers"
+	"github.com/nuveo/prest/cors"
+	"github.com/nuveo/prest/counts"
+	"github.com/nuveo/prest/ipfilter"
+	"github.com/nuveo/prest/maintenance"
+	"github.com/nuveo/prest/pool"
+	"github.com/nuveo/prest/pretty"
+	"github.com/nuveo/prest/ratelimit"
+	"github.com/nuveo/prest/signedurl"
+	"github.com/nuveo/prest/stale"
+	"github.com/nuveo/prest/usage"
 	"github.com/spf13/cobra"
 	"github.com/urfave/negroni"
 )
@@ -44,18 +71,150 @@ func init() {
 
 func app() {
 	cfg := config.Prest{}
-	config.Parse(&cfg)
+	if err := config.Parse(&cfg); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.AccessConf.Table != "" {
+		loadAccessTable()
+	}
+	if len(config.Get().AccessConf.Tables) > 0 {
+		logAccessWarnings()
+	}
+	go reloadOnHangup()
 
 	n := negroni.Classic()
 	n.Use(negroni.HandlerFunc(handlerSet))
+	n.Use(negroni.HandlerFunc(configContext))
+	n.Use(negroni.HandlerFunc(pretty.New(cfg.Pretty)))
+	if cfg.MaxBodySize > 0 {
+		n.Use(negroni.HandlerFunc(bodylimit.New(cfg.MaxBodySize)))
+	}
+	if len(cfg.IPAllow) > 0 || len(cfg.IPDeny) > 0 {
+		filter, err := ipfilter.New(cfg.IPAllow, cfg.IPDeny)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.Use(ipFilterMiddleware(filter, cfg.IPHeader))
+	}
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		n.Use(cors.Options{
+			AllowedOrigins:   cfg.CORSAllowedOrigins,
+			AllowedMethods:   cfg.CORSAllowedMethods,
+			AllowedHeaders:   cfg.CORSAllowedHeaders,
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           cfg.CORSMaxAge,
+		})
+	}
+	if cfg.Sandbox {
+		log.Println("sandbox mode: all writes are rolled back")
+		n.Use(negroni.HandlerFunc(sandboxHeader))
+	}
+	maintenance.Set(maintenance.State{
+		Enabled:    cfg.Maintenance,
+		Message:    cfg.MaintenanceMessage,
+		RetryAfter: cfg.MaintenanceRetryAfter,
+	})
+	n.Use(negroni.HandlerFunc(maintenance.ServeHTTP))
+	if len(cfg.APIKeys) > 0 {
+		n.Use(apiKeyMiddleware(cfg.APIKeys))
+	}
+	if cfg.RateLimit > 0 {
+		limiter := ratelimit.New(cfg.RateLimit, cfg.RateLimitBurst)
+		n.Use(rateLimitMiddleware(limiter, cfg.RateLimitBy, cfg.RateLimitIPHeader))
+	}
+	if cfg.Usage {
+		tracker, err := usage.New(cfg.UsageFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		usage.Default = tracker
+		if cfg.UsageFile != "" && cfg.UsageInterval > 0 {
+			go tracker.Run(time.Duration(cfg.UsageInterval) * time.Second)
+		}
+		n.Use(usageMiddleware(tracker))
+	}
+	if cfg.SignKey != "" && (cfg.AuthTable != "" || cfg.JWTKey != "") {
+		n.Use(signedURLMiddleware(cfg.SignKey))
+	}
+	if cfg.AuthTable != "" {
+		n.Use(basicAuthMiddleware(cfg.JWTKey != ""))
+	}
 	if cfg.JWTKey != "" {
 		n.Use(jwtMiddleware(cfg.JWTKey))
 	}
+	n.Use(ownerMiddleware(cfg.OwnerClaim))
+	if cfg.RLS {
+		n.Use(rlsMiddleware(cfg.RLSClaim, cfg.RLSAnonymous))
+	}
+	if authz.Default != nil {
+		n.Use(negroni.HandlerFunc(principalContext))
+	}
+	if cfg.StaleMaxAge > 0 {
+		n.Use(stale.New(time.Duration(cfg.StaleMaxAge)*time.Second, cfg.StaleMaxEntries, cfg.StaleMaxEntrySize, postgres.Available))
+	}
 	r := mux.NewRouter()
+	if len(cfg.QueryTags) > 0 {
+		for _, tag := range cfg.QueryTags {
+			if !queryTags[tag] {
+				log.Fatalf("unknown query tag %s, use route, request_id or user\n", tag)
+			}
+		}
+		n.Use(queryTagsMiddleware(r, cfg.QueryTags))
+	}
+	if len(cfg.Expose) > 0 || cfg.AccessConf.DenyUnknown {
+		n.Use(exposeMiddleware(r))
+	}
+	if cfg.PoolInterval > 0 {
+		pool.Default = pool.New(time.Duration(cfg.PoolWaitThreshold) * time.Millisecond)
+		go pool.Default.Run(postgres.PoolStats, time.Duration(cfg.PoolInterval)*time.Second)
+		n.Use(routeStats(r, pool.Default))
+	}
+	if len(cfg.CountTables) > 0 {
+		counts.Default = counts.New(cfg.CountTables)
+		if cfg.CountInterval > 0 {
+			go counts.Default.Run(postgres.CountTable, time.Duration(cfg.CountInterval)*time.Second)
+		}
+	}
+	if len(cfg.Alerts) > 0 {
+		rules := make([]alerts.Rule, len(cfg.Alerts))
+		for i, a := range cfg.Alerts {
+			if !alertOperations[a.Operation] {
+				log.Fatalf("unknown alert operation %s, use insert, update or delete\n", a.Operation)
+			}
+			window := a.Window
+			if window <= 0 {
+				window = 60
+			}
+			rules[i] = alerts.Rule{Table: a.Table, Operation: a.Operation, Rows: a.Rows, Window: time.Duration(window) * time.Second, Webhook: a.Webhook}
+		}
+		n.Use(alertsMiddleware(r, alerts.New(rules)))
+	}
+	r.HandleFunc("/_health", controllers.Health).Methods("GET")
+	r.HandleFunc("/_maintenance", controllers.GetMaintenance).Methods("GET")
+	r.HandleFunc("/_maintenance", controllers.SetMaintenance).Methods("PUT")
+	r.HandleFunc("/_pool", controllers.PoolMetrics).Methods("GET")
+	r.HandleFunc("/_usage", controllers.Usage).Methods("GET")
+	r.HandleFunc("/_access/refresh", controllers.RefreshAccess).Methods("POST")
+	r.HandleFunc("/_jobs/{id}", controllers.GetJob).Methods("GET")
+	r.HandleFunc("/_backup/{database}", controllers.BackupDatabase).Methods("POST")
+	r.HandleFunc("/_restore/{database}", controllers.RestoreDatabase).Methods("POST")
+	r.HandleFunc("/_sign/{database}/{schema}/{table}", controllers.SignURL).Methods("GET")
+	r.HandleFunc("/_profile/{database}/{schema}/{table}", controllers.ProfileTable).Methods("GET")
+	r.HandleFunc("/_batch", controllers.Batch).Methods("POST")
 	r.HandleFunc("/databases", controllers.GetDatabases).Methods("GET")
 	r.HandleFunc("/schemas", controllers.GetSchemas).Methods("GET")
 	r.HandleFunc("/tables", controllers.GetTables).Methods("GET")
 	r.HandleFunc("/{database}/{schema}", controllers.GetTablesByDatabaseAndSchema).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_count", controllers.CountFromTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_exists", controllers.ExistsInTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_aggregate", controllers.AggregateFromTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_timeseries", controllers.TimeseriesFromTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_duplicates", controllers.DuplicatesFromTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_changes", controllers.ChangesFromTable).Methods("GET")
+	r.HandleFunc("/{database}/{schema}/{table}/_export", controllers.ExportFromTable).Methods("POST")
+	r.HandleFunc("/{database}/{schema}/{table}/_import", controllers.ImportInTable).Methods("POST")
+	r.HandleFunc("/{database}/{schema}/{table}/_copy", controllers.CopyToTable).Methods("POST")
+	r.HandleFunc("/{database}/{schema}/{table}/_batch", controllers.DeleteByKeysFromTable).Methods("DELETE")
 	r.HandleFunc("/{database}/{schema}/{table}", controllers.SelectFromTables).Methods("GET")
 	r.HandleFunc("/{database}/{schema}/{table}", controllers.InsertInTables).Methods("POST")
 	r.HandleFunc("/{database}/{schema}/{table}", controllers.DeleteFromTable).Methods("DELETE")
@@ -63,7 +222,43 @@ func app() {
 	r.HandleFunc("/_VIEW/{database}/{schema}/{view}", controllers.SelectFromViews).Methods("GET")
 
 	n.UseHandler(r)
-	n.Run(fmt.Sprintf(":%v", cfg.HTTPPort))
+
+	addr := fmt.Sprintf(":%v", cfg.HTTPPort)
+	if cfg.HTTPSCert == "" && cfg.HTTPSKey == "" {
+		n.Run(addr)
+		return
+	}
+	if cfg.HTTPSCert == "" || cfg.HTTPSKey == "" {
+		log.Fatal("https needs both the cert and the key")
+	}
+	if cfg.HTTPSRedirectPort > 0 {
+		go func() {
+			redirectAddr := fmt.Sprintf(":%v", cfg.HTTPSRedirectPort)
+			log.Printf("redirecting %s to https\n", redirectAddr)
+			log.Fatal(http.ListenAndServe(redirectAddr, httpsRedirect(cfg.HTTPPort)))
+		}()
+	}
+	log.Printf("listening on %s (https)\n", addr)
+	log.Fatal(http.ListenAndServeTLS(addr, cfg.HTTPSCert, cfg.HTTPSKey, n))
+}
+
+// httpsRedirect redirect the requests to the same URL with https in the port
+func httpsRedirect(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		// 308 keeps the method and the body of writes
+		status := http.StatusPermanentRedirect
+		if r.Method == "GET" || r.Method == "HEAD" {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
 }
 
 func handlerSet(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
@@ -71,6 +266,375 @@ func handlerSet(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	next(w, r)
 }
 
+// configContext set the current config in the request context, the request
+// keeps it when the config is reloaded
+func configContext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	next(w, r.WithContext(config.NewContext(r.Context(), config.Get())))
+}
+
+// reloadOnHangup reload the config on SIGHUP, the middlewares keep the
+// settings they started with (ports, rate limit, CORS...)
+func reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := config.Reload(); err != nil {
+			log.Println("config not reloaded:", err)
+			continue
+		}
+		log.Println("config reloaded")
+	}
+}
+
+// sandboxHeader tell the clients that the writes are not persisted
+func sandboxHeader(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	w.Header().Set("X-Prest-Sandbox", "true")
+	next(w, r)
+}
+
+type basicUserKey struct{}
+
+type signedURLKey struct{}
+
+// authenticated check if the request was authenticated by an API key, basic
+// auth or a signed URL
+func authenticated(r *http.Request) bool {
+	if _, ok := apikey.FromContext(r.Context()); ok {
+		return true
+	}
+	if r.Context().Value(signedURLKey{}) != nil {
+		return true
+	}
+	return r.Context().Value(basicUserKey{}) != nil
+}
+
+// signedURLMiddleware authenticate the GET requests of signed URLs, temporary
+// reads without credentials, before basic auth and JWT
+func signedURLMiddleware(signKey string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if authenticated(r) || r.Method != "GET" || !signedurl.IsSigned(r.URL) {
+			next(w, r)
+			return
+		}
+		if err := signedurl.Verify(signKey, r.URL, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), signedURLKey{}, true)))
+	})
+}
+
+// public check if the endpoint of the request answers without credentials,
+// the health checks
+func public(r *http.Request) bool {
+	return r.URL.Path == "/_health"
+}
+
+// basicAuthMiddleware authenticate the requests with HTTP Basic Auth against
+// the users table, requests without credentials go to JWT when it is enabled
+func basicAuthMiddleware(jwt bool) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if authenticated(r) || public(r) {
+			next(w, r)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok && jwt {
+			next(w, r)
+			return
+		}
+		if ok {
+			valid, err := postgres.CheckPassword(config.FromContext(r.Context()), username, password)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if valid {
+				next(w, r.WithContext(context.WithValue(r.Context(), basicUserKey{}, username)))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="prest"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// rlsMiddleware set the database role of the request principal, the role of
+// the API key or the claim of the JWT, to run the statements with it and let
+// the row level security policies filter the rows
+func rlsMiddleware(claim, anonymous string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if public(r) {
+			next(w, r)
+			return
+		}
+		role := anonymous
+		if key, ok := apikey.FromContext(r.Context()); ok {
+			role = key.Role
+		} else if token, ok := gcontext.Get(r, "user").(*jwt.Token); ok {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				role, _ = claims[claim].(string)
+			}
+		}
+		if role == "" {
+			http.Error(w, "No database role for the request", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(postgres.WithRole(r.Context(), role)))
+	})
+}
+
+// ownerMiddleware set the subject owning the rows of the request, the name of
+// the API key, the user of HTTP Basic Auth or else the claim of JWT (strings
+// and numbers)
+func ownerMiddleware(claim string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var subject string
+		if key, ok := apikey.FromContext(r.Context()); ok {
+			subject = key.Name
+		} else if username, ok := r.Context().Value(basicUserKey{}).(string); ok {
+			subject = username
+		} else if token, ok := gcontext.Get(r, "user").(*jwt.Token); ok {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				switch value := claims[claim].(type) {
+				case string:
+					subject = value
+				case float64:
+					subject = strconv.FormatFloat(value, 'f', -1, 64)
+				}
+			}
+		}
+		if subject == "" {
+			next(w, r)
+			return
+		}
+		next(w, r.WithContext(postgres.WithOwner(r.Context(), subject)))
+	})
+}
+
+// apiKeyMiddleware authenticate the requests with the X-API-Key header and
+// check the tables the key can access
+func apiKeyMiddleware(keys []config.APIKeyConf) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		value := r.Header.Get(apikey.Header)
+		if value == "" {
+			next(w, r)
+			return
+		}
+		key := apikey.Find(keys, value)
+		if key == nil {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		database, schema, table, op, ok := apikey.Resource(r)
+		if !ok || (table != "" && !apikey.Allowed(key, database, schema, table, op)) {
+			http.Error(w, "API key without permission", http.StatusForbidden)
+			return
+		}
+		// the adapter checks the rules of the key in the joined, nested and
+		// copied tables
+		cfg := *config.FromContext(r.Context())
+		cfg.APIKey = key
+		ctx := config.NewContext(apikey.NewContext(r.Context(), key), &cfg)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// usageMiddleware count the requests of the API keys and their rows, the
+// X-Affected-Rows of the writes or the rows counted by the controllers
+func usageMiddleware(tracker *usage.Tracker) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		key, ok := apikey.FromContext(r.Context())
+		if !ok {
+			next(w, r)
+			return
+		}
+		ctx, rows := usage.NewContext(r.Context())
+		next(w, r.WithContext(ctx))
+		count := atomic.LoadInt64(rows)
+		if affected, err := strconv.ParseInt(w.Header().Get("X-Affected-Rows"), 10, 64); err == nil {
+			count = affected
+		}
+		tracker.Track(key.Name, count)
+	})
+}
+
+// routeTemplate return the method and the path template of the route of the
+// request, the middlewares run before the router
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	route := "unmatched"
+	var match mux.RouteMatch
+	if router.Match(r, &match) {
+		if tpl, err := match.Route.GetPathTemplate(); err == nil {
+			route = tpl
+		}
+	}
+	return r.Method + " " + route
+}
+
+// exposeMiddleware answer 404 to the requests of databases, schemas and
+// tables (or views, or joined tables) not exposed by the config
+func exposeMiddleware(router *mux.Router) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			database, schema := match.Vars["database"], match.Vars["schema"]
+			table := match.Vars["table"]
+			if view, ok := match.Vars["view"]; ok {
+				table = view
+			}
+			cfg := config.FromContext(r.Context())
+			if database != "" && !cfg.Exposed(database, schema, table) {
+				http.NotFound(w, r)
+				return
+			}
+			for _, j := range r.URL.Query()["_join"] {
+				args := strings.SplitN(j, ":", 3)
+				if len(args) > 1 && database != "" && !cfg.Exposed(database, schema, args[1]) {
+					http.NotFound(w, r)
+					return
+				}
+			}
+		}
+		next(w, r)
+	})
+}
+
+// routeStats track the time of the requests by route in the pool monitor
+func routeStats(router *mux.Router, monitor *pool.Monitor) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		route := routeTemplate(router, r)
+		start := time.Now()
+		next(w, r)
+		monitor.Track(route, time.Since(start))
+	})
+}
+
+// alertOperations operations of the alerts
+var alertOperations = map[string]bool{"insert": true, "update": true, "delete": true}
+
+// alertRoutes operations of the writes on tables, the imports run as jobs
+// and aren't counted
+var alertRoutes = map[string]string{
+	"POST /{database}/{schema}/{table}":          "insert",
+	"POST /{database}/{schema}/{table}/_copy":    "insert",
+	"PUT /{database}/{schema}/{table}":           "update",
+	"PATCH /{database}/{schema}/{table}":         "update",
+	"DELETE /{database}/{schema}/{table}":        "delete",
+	"DELETE /{database}/{schema}/{table}/_batch": "delete",
+}
+
+// alertsMiddleware count the rows written on the tables in the alert rules,
+// the X-Affected-Rows of the successful writes or one row without it
+func alertsMiddleware(router *mux.Router, monitor *alerts.Monitor) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var match mux.RouteMatch
+		operation, table := "", ""
+		if router.Match(r, &match) {
+			if tpl, err := match.Route.GetPathTemplate(); err == nil {
+				operation, table = alertRoutes[r.Method+" "+tpl], match.Vars["table"]
+			}
+		}
+		next(w, r)
+		if operation == "" {
+			return
+		}
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() >= http.StatusMultipleChoices {
+			return
+		}
+		rows := int64(1)
+		if affected, err := strconv.ParseInt(w.Header().Get("X-Affected-Rows"), 10, 64); err == nil {
+			rows = affected
+		}
+		for _, alert := range monitor.Track(table, operation, rows, time.Now()) {
+			go monitor.Send(alert)
+		}
+	})
+}
+
+// queryTags names of the tags of the statements
+var queryTags = map[string]bool{"route": true, "request_id": true, "user": true}
+
+// queryTagsMiddleware tag the statements of the request with a SQL comment
+// of the route, the request id (X-Request-Id, generated when the client
+// doesn't send it) and the user
+func queryTagsMiddleware(router *mux.Router, tags []string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var pairs []string
+		for _, tag := range tags {
+			switch tag {
+			case "route":
+				pairs = append(pairs, tag, routeTemplate(router, r))
+			case "request_id":
+				id := r.Header.Get("X-Request-Id")
+				if id == "" {
+					b := make([]byte, 8)
+					rand.Read(b)
+					id = hex.EncodeToString(b)
+				}
+				w.Header().Set("X-Request-Id", id)
+				pairs = append(pairs, tag, id)
+			case "user":
+				pairs = append(pairs, tag, requestUser(r))
+			}
+		}
+		next(w, r.WithContext(postgres.WithTag(r.Context(), postgres.FormatTag(pairs...))))
+	})
+}
+
+// requestUser return the principal of the request, the API key name, the
+// basic auth username or the JWT subject
+func requestUser(r *http.Request) string {
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		return "apikey:" + key.Name
+	}
+	if username, ok := r.Context().Value(basicUserKey{}).(string); ok {
+		return username
+	}
+	if token, ok := gcontext.Get(r, "user").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			sub, _ := claims["sub"].(string)
+			return sub
+		}
+	}
+	return ""
+}
+
+// principalContext set the principal of the request for the authorizer
+func principalContext(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	next(w, r.WithContext(authz.NewContext(r.Context(), requestUser(r))))
+}
+
+// ipFilterMiddleware refuse the requests of clients not allowed by the filter
+func ipFilterMiddleware(filter *ipfilter.Filter, ipHeader string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if !filter.Allowed(ratelimit.ClientIP(r, ipHeader)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// rateLimitMiddleware refuse the requests of clients (by IP or API key) over
+// the rate limit
+func rateLimitMiddleware(limiter *ratelimit.Limiter, by, ipHeader string) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		client := "ip:" + ratelimit.ClientIP(r, ipHeader)
+		if key, ok := apikey.FromContext(r.Context()); ok && by == "apikey" {
+			client = "apikey:" + key.Name
+		}
+		ok, wait := limiter.Allow(client, time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	})
+}
+
 func jwtMiddleware(key string) negroni.Handler {
 	jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options{
 		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
@@ -78,5 +642,13 @@ func jwtMiddleware(key string) negroni.Handler {
 		},
 		SigningMethod: jwt.SigningMethodHS256,
 	})
-	return negroni.HandlerFunc(jwtMiddleware.HandlerWithNext)
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		// requests authenticated by API keys, basic auth or signed URLs
+		// don't have JWT
+		if authenticated(r) || public(r) {
+			next(w, r)
+			return
+		}
+		jwtMiddleware.HandlerWithNext(w, r, next)
+	})
 }
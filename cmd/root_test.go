@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nuveo/prest/signedurl"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/urfave/negroni"
+)
+
+func TestSignedURLMiddleware(t *testing.T) {
+	n := negroni.New()
+	n.Use(signedURLMiddleware("mysignkey"))
+	n.Use(basicAuthMiddleware(false))
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(method, target string) int {
+		r := httptest.NewRequest(method, target, nil)
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, r)
+		return w.Code
+	}
+	u := httptest.NewRequest("GET", "/prest/public/test?name=prest", nil).URL
+
+	Convey("Signed URLs skip basic auth", t, func() {
+		signed := signedurl.Sign("mysignkey", u, time.Now().Add(time.Hour))
+		So(serve("GET", signed.String()), ShouldEqual, http.StatusOK)
+	})
+	Convey("Signed URLs only allow reads", t, func() {
+		signed := signedurl.Sign("mysignkey", u, time.Now().Add(time.Hour))
+		So(serve("DELETE", signed.String()), ShouldEqual, http.StatusUnauthorized)
+	})
+	Convey("Invalid and expired signed URLs are refused", t, func() {
+		signed := signedurl.Sign("otherkey", u, time.Now().Add(time.Hour))
+		So(serve("GET", signed.String()), ShouldEqual, http.StatusUnauthorized)
+		signed = signedurl.Sign("mysignkey", u, time.Now().Add(-time.Hour))
+		So(serve("GET", signed.String()), ShouldEqual, http.StatusUnauthorized)
+	})
+	Convey("Requests without credentials are refused", t, func() {
+		So(serve("GET", u.String()), ShouldEqual, http.StatusUnauthorized)
+	})
+}
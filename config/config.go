@@ -1,8 +1,12 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"os"
 
@@ -10,14 +14,233 @@ import (
 )
 
 type TablesConf struct {
-	Name        string   `mapstructure:"name"`
+	Name string `mapstructure:"name"`
+	// Database and Schema qualify the table, empty matches any of them
+	Database    string   `mapstructure:"database"`
+	Schema      string   `mapstructure:"schema"`
 	Permissions []string `mapstructure:"permissions"`
 	Fields      []string `mapstructure:"fields"`
+	// Masked columns never returned, even when access isn't restricted
+	Masked []string `mapstructure:"masked"`
+	// Owner column bound to the subject of the requests, the reads only see
+	// the rows of the subject and the writes set it
+	Owner string `mapstructure:"owner"`
+}
+
+// Matches check if the rules are of the table, an empty database or schema
+// (in the rules or in the arguments) matches any of them. The names of the
+// rules can be glob patterns (e.g. "report_*")
+func (t TablesConf) Matches(database, schema, table string) bool {
+	return matchTable(t.Database, t.Schema, t.Name, database, schema, table)
+}
+
+// matchTable match a table with the database, schema and table name of the
+// settings of a table, as TablesConf.Matches
+func matchTable(confDatabase, confSchema, confTable, database, schema, table string) bool {
+	return matchName(confTable, table) &&
+		(confDatabase == "" || database == "" || matchName(confDatabase, database)) &&
+		(confSchema == "" || schema == "" || matchName(confSchema, schema))
+}
+
+// Pattern check if the table name of the rules is a glob pattern
+func (t TablesConf) Pattern() bool {
+	return strings.ContainsAny(t.Name, "*?[")
+}
+
+// matchName match a name with a glob pattern, invalid patterns only match
+// the same name
+func matchName(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	if err != nil {
+		return pattern == name
+	}
+	return ok
+}
+
+// TableAccess return the access rules of the table, the most qualified ones
+// when there are many (e.g. schema "public" and "users" before only "users",
+// "users" before "user*"). The default rules are used for the tables without
+// rules
+func (cfg *Prest) TableAccess(database, schema, table string) (access TablesConf, ok bool) {
+	best := -1
+	for _, t := range cfg.AccessConf.Tables {
+		if !t.Matches(database, schema, table) {
+			continue
+		}
+		score := 0
+		if !t.Pattern() {
+			score += 4
+		}
+		if t.Database != "" {
+			score += 2
+		}
+		if t.Schema != "" {
+			score++
+		}
+		if score > best {
+			access, ok, best = t, true, score
+		}
+	}
+	if !ok && cfg.AccessConf.Default != nil {
+		access, ok = *cfg.AccessConf.Default, true
+	}
+	return
+}
+
+// SetTableRules replace the rules loaded from the access table, the ones of
+// the config file are kept (and win over the table ones as qualified)
+func SetTableRules(rules []TablesConf) {
+	Update(func(cfg *Prest) {
+		access := &cfg.AccessConf
+		access.Tables = append(append([]TablesConf{}, access.configTables...), rules...)
+	})
+}
+
+// HasPermission check if the table permissions allow the operation (read,
+// insert, update or delete), "write" allows insert and update
+func HasPermission(permissions []string, op string) bool {
+	for _, p := range permissions {
+		if p == op || (p == "write" && (op == "insert" || op == "update")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listed check if some access rule (not the default ones) is of the table
+func (cfg *Prest) Listed(database, schema, table string) bool {
+	for _, t := range cfg.AccessConf.Tables {
+		if t.Matches(database, schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// HidesTables check if the config hides tables, with the exposed list or
+// denying the tables without access rules
+func (cfg *Prest) HidesTables() bool {
+	return len(cfg.Expose) > 0 || cfg.AccessConf.DenyUnknown
+}
+
+// Exposed check if the table is in the exposed list of the config, an empty
+// table (or schema) checks if some table of the schema (or database) is
+// exposed. Everything is exposed when the list is empty. With DenyUnknown
+// the tables without access rules are not exposed
+func (cfg *Prest) Exposed(database, schema, table string) bool {
+	if cfg.AccessConf.DenyUnknown && table != "" && !cfg.Listed(database, schema, table) {
+		return false
+	}
+	if len(cfg.Expose) == 0 {
+		return true
+	}
+	names := []string{database, schema, table}
+	for _, entry := range cfg.Expose {
+		if exposedBy(strings.Split(entry, "."), names) {
+			return true
+		}
+	}
+	return false
+}
+
+// exposedBy match the names with the parts of an entry, "*" matches any name
+// and shorter entries expose everything below them
+func exposedBy(parts, names []string) bool {
+	for i, name := range names {
+		if i == len(parts) || name == "" {
+			return true
+		}
+		if parts[i] != "*" && parts[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// TransformConf response transformations applied to a table
+type TransformConf struct {
+	Table string `mapstructure:"table"`
+	// Database and Schema qualify the table, empty matches any of them
+	Database string            `mapstructure:"database"`
+	Schema   string            `mapstructure:"schema"`
+	Rename   map[string]string `mapstructure:"rename"`
+	Drop     []string          `mapstructure:"drop"`
+	Flatten  []string          `mapstructure:"flatten"`
+}
+
+// Matches check if the transformations are of the table, as
+// TablesConf.Matches
+func (t TransformConf) Matches(database, schema, table string) bool {
+	return matchTable(t.Database, t.Schema, t.Table, database, schema, table)
+}
+
+// AlertConf alert of more than Rows rows of an operation (insert, update or
+// delete) on a table (or the tables of a glob pattern) within Window seconds
+// (60 when 0), sent to the log and to the Webhook (when not empty)
+type AlertConf struct {
+	Table     string `mapstructure:"table"`
+	Operation string `mapstructure:"operation"`
+	Rows      int64  `mapstructure:"rows"`
+	Window    int    `mapstructure:"window"`
+	Webhook   string `mapstructure:"webhook"`
+}
+
+// AnonymizeConf anonymization functions (hash, null, name, email or phone)
+// applied to the columns of a table in anonymized exports
+type AnonymizeConf struct {
+	Table string `mapstructure:"table"`
+	// Database and Schema qualify the table, empty matches any of them
+	Database string            `mapstructure:"database"`
+	Schema   string            `mapstructure:"schema"`
+	Columns  map[string]string `mapstructure:"columns"`
+}
+
+// Matches check if the anonymization is of the table, as TablesConf.Matches
+func (a AnonymizeConf) Matches(database, schema, table string) bool {
+	return matchTable(a.Database, a.Schema, a.Table, database, schema, table)
+}
+
+// ChangesConf delta sync of a table, Column is the cursor (xmin when empty)
+// and Deleted the soft delete column
+type ChangesConf struct {
+	Table string `mapstructure:"table"`
+	// Database and Schema qualify the table, empty matches any of them
+	Database string `mapstructure:"database"`
+	Schema   string `mapstructure:"schema"`
+	Column   string `mapstructure:"column"`
+	Deleted  string `mapstructure:"deleted"`
+}
+
+// Matches check if the delta sync is of the table, as TablesConf.Matches
+func (c ChangesConf) Matches(database, schema, table string) bool {
+	return matchTable(c.Database, c.Schema, c.Table, database, schema, table)
 }
 
 type AccessConf struct {
 	Restrict bool
 	Tables   []TablesConf
+	// Table with rules added to the ones of the config ("schema.table")
+	Table string
+	// configTables rules of the config file, kept when the rules of the
+	// table are reloaded
+	configTables []TablesConf
+	// Default rules of the tables without rules, nil refuses them
+	Default *TablesConf
+	// DenyUnknown hides (404) the tables without rules, even when access
+	// isn't restricted
+	DenyUnknown bool
+	// SchemaPaths schemas allowed in the `_schema_path` of requests
+	SchemaPaths []string
+}
+
+// APIKeyConf static API key of machine to machine clients, with the tables
+// it can access
+type APIKeyConf struct {
+	Name   string       `mapstructure:"name"`
+	Key    string       `mapstructure:"key"`
+	Tables []TablesConf `mapstructure:"tables"`
+	// Role database role of the key with row level security
+	Role string `mapstructure:"role"`
 }
 
 // Prest basic config
@@ -34,9 +257,188 @@ type Prest struct {
 	JWTKey         string
 	MigrationsPath string
 	AccessConf     AccessConf
+	Transforms     []TransformConf
+	Changes        []ChangesConf
+	Alerts         []AlertConf
+	Anonymize      []AnonymizeConf
+	// PGConnMaxLifetime seconds a connection is reused before being closed
+	// (0 reuses it forever)
+	PGConnMaxLifetime int
+	// DefaultPageSize page size used when the request has no `_page` (0 returns all rows)
+	DefaultPageSize int
+	// MaxPageSize ceiling of `_page_size` (0 is unlimited)
+	MaxPageSize int
+	// Storage S3 compatible object storage used by export and import jobs
+	StorageEndpoint   string
+	StorageRegion     string
+	StorageBucket     string
+	StorageAccessKey  string
+	StorageSecretKey  string
+	StoragePrefix     string
+	StorageURLExpires int
+	// Backup directory where pg_dump writes the backups
+	BackupPath   string
+	BackupPGDump string
+	// AdminKey enable the admin endpoints (backup, restore) to requests with
+	// this key in the X-Admin-Key header
+	AdminKey string
+	// SignKey HMAC key of signed URLs, temporary reads without credentials
+	SignKey string
+	// RequireWhere reject delete and update without filters, unless the
+	// request has `_force=true`
+	RequireWhere bool
+	// Sandbox roll back all writes, reads work normally
+	Sandbox bool
+	// PutMissing value of the columns missing in the body of PUT requests,
+	// "default" or "null"
+	PutMissing string
+	APIKeys    []APIKeyConf
+	// APIKey key of the request, only in the config of the request context.
+	// Its table rules are checked with the access ones in every table
+	APIKey *APIKeyConf
+	// AuthTable users table of HTTP Basic Auth, with the username and the
+	// bcrypt hash of the password
+	AuthTable    string
+	AuthSchema   string
+	AuthUsername string
+	AuthPassword string
+	// RLS run the statements with the database role of the principal (the
+	// RLSClaim of JWT or the role of the API key), RLSAnonymous is the role
+	// of requests without principal
+	RLS          bool
+	RLSClaim     string
+	RLSAnonymous string
+	// OwnerClaim JWT claim of the subject owning the rows of the tables with
+	// an owner column, the requests with API keys are of the key name
+	OwnerClaim string
+	// ProfileSample max rows read by the profile endpoint, ProfileTop number
+	// of top values of each column
+	ProfileSample int
+	ProfileTop    int
+	// RateLimit requests per second of each client (0 is unlimited), with
+	// bursts of RateLimitBurst requests. RateLimitBy is "ip" or "apikey" (the
+	// IP of requests without API key) and RateLimitIPHeader the header with
+	// the client IP set by a reverse proxy
+	RateLimit         float64
+	RateLimitBurst    int
+	RateLimitBy       string
+	RateLimitIPHeader string
+	// Maintenance start in maintenance mode, the data endpoints answer 503
+	// with MaintenanceMessage and the MaintenanceRetryAfter seconds
+	Maintenance           bool
+	MaintenanceMessage    string
+	MaintenanceRetryAfter int
+	// Expose databases, schemas and tables reachable by the API
+	// ("database.schema.table", "*" matches any name), the others answer 404.
+	// Everything is exposed when empty
+	Expose []string
+	// IPAllow and IPDeny CIDRs of the clients, the denied win and with allowed
+	// networks any other client is denied. IPHeader the header with the
+	// client IP set by a reverse proxy
+	IPAllow  []string
+	IPDeny   []string
+	IPHeader string
+	// CORS cross-origin requests of the browsers, enabled when there are
+	// allowed origins
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           int
+	// PoolInterval seconds between the checks of the connection pool (0
+	// disables them), PoolWaitThreshold average wait for a connection, in
+	// milliseconds, logged as a warning
+	PoolInterval      int
+	PoolWaitThreshold int
+	// HTTPSCert and HTTPSKey files serve HTTPS in the HTTPPort, with
+	// HTTPSRedirectPort a HTTP server redirects the requests to HTTPS
+	HTTPSCert         string
+	HTTPSKey          string
+	HTTPSRedirectPort int
+	// MaxBodySize bytes of the request bodies, bigger ones are refused with
+	// 413 (0 disables the limit)
+	MaxBodySize int64
+	// Pretty indent the JSON responses unless the requests have
+	// `_pretty=false`, without it only the ones with `_pretty=true`
+	Pretty bool
+	// Bytea encoding of the bytea columns in JSON, "hex" or "base64" (the
+	// Postgres text by default), the requests may change it with `_bytea`
+	Bytea string
+	// TimestampFormat of the timestamp columns in JSON, "rfc3339", "epoch" or
+	// a Go layout (the Postgres text by default), and TimestampZone the
+	// timezone they are displayed in, the requests may change them with
+	// `_timestamp` and `_tz`
+	TimestampFormat string
+	TimestampZone   string
+	// QueryTags values (route, request_id and user) of the SQL comment
+	// prepended to the statements of the requests
+	QueryTags []string
+	// StaleMaxAge seconds the cached GET responses are served when the
+	// database is unavailable (0 disables the cache), StaleMaxEntries
+	// number of cached responses and StaleMaxEntrySize bytes of the
+	// biggest one (0 is unlimited)
+	StaleMaxAge       int
+	StaleMaxEntries   int
+	StaleMaxEntrySize int
+	// Usage count the requests and rows of each API key, saved in UsageFile
+	// (when set) every UsageInterval seconds
+	Usage         bool
+	UsageFile     string
+	UsageInterval int
+	// CountTables names (or glob patterns) of huge tables, the total of their
+	// paginated selects without filters is a count cached and refreshed every
+	// CountInterval seconds
+	CountTables   []string
+	CountInterval int
+}
+
+// current config, replaced as a whole (never changed) so the readers
+// always see a consistent one
+var (
+	current  atomic.Value
+	updateMu sync.Mutex
+)
+
+// Get return the current config, nil before InitConf. It must not be
+// changed, use Update
+func Get() *Prest {
+	cfg, _ := current.Load().(*Prest)
+	return cfg
+}
+
+// Set replace the current config
+func Set(cfg *Prest) {
+	current.Store(cfg)
+}
+
+// Update replace the current config by a copy changed by fn, returning the
+// previous one (to restore it with Set)
+func Update(fn func(cfg *Prest)) *Prest {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	previous := Get()
+	cfg := *previous
+	fn(&cfg)
+	Set(&cfg)
+	return previous
 }
 
-var PREST_CONF *Prest
+type configKey struct{}
+
+// NewContext return a context with the config, the request keeps it even
+// when the config is reloaded
+func NewContext(ctx context.Context, cfg *Prest) context.Context {
+	return context.WithValue(ctx, configKey{}, cfg)
+}
+
+// FromContext return the config of the context, the current one when the
+// context has none
+func FromContext(ctx context.Context) *Prest {
+	if cfg, ok := ctx.Value(configKey{}).(*Prest); ok {
+		return cfg
+	}
+	return Get()
+}
 
 func init() {
 	viperCfg()
@@ -54,26 +456,120 @@ func viperCfg() {
 	viper.SetConfigFile(filePath)
 	viper.SetConfigType("toml")
 	viper.SetDefault("http.port", 3000)
+	viper.SetDefault("http.maxbodysize", "1MB")
 	viper.SetDefault("pg.host", "127.0.0.1")
 	viper.SetDefault("pg.port", 5432)
 	viper.SetDefault("pg.maxidleconn", 10)
 	viper.SetDefault("pg.maxopenconn", 10)
+	viper.SetDefault("storage.endpoint", "https://s3.amazonaws.com")
+	viper.SetDefault("storage.region", "us-east-1")
+	viper.SetDefault("storage.urlexpires", 3600)
+	viper.SetDefault("backup.pgdump", "pg_dump")
+	viper.SetDefault("access.requirewhere", true)
+	viper.SetDefault("put.missing", "default")
+	viper.SetDefault("auth.schema", "public")
+	viper.SetDefault("auth.username", "username")
+	viper.SetDefault("auth.password", "password")
+	viper.SetDefault("rls.claim", "role")
+	viper.SetDefault("owner.claim", "sub")
+	viper.SetDefault("profile.sample", 10000)
+	viper.SetDefault("profile.top", 5)
+	viper.SetDefault("ratelimit.by", "ip")
+	viper.SetDefault("stale.maxentries", 1000)
+	viper.SetDefault("stale.maxentrysize", 1024*1024)
+	viper.SetDefault("maintenance.message", "Service under maintenance")
+	viper.SetDefault("maintenance.retryafter", 300)
+	viper.SetDefault("pool.interval", 10)
+	viper.SetDefault("pool.waitthreshold", 100)
+	viper.SetDefault("usage.interval", 60)
+	viper.SetDefault("counts.interval", 300)
+	viper.SetDefault("cors.allowed_methods", []string{"GET", "POST", "PUT", "PATCH", "DELETE"})
+	viper.SetDefault("cors.allowed_headers", []string{"Content-Type", "Authorization", "Prefer", "X-API-Key"})
 }
 
 // Parse pREST config
 func Parse(cfg *Prest) (err error) {
 	err = viper.ReadInConfig()
+	if errSecrets := decryptSecrets(); errSecrets != nil {
+		return errSecrets
+	}
 	cfg.HTTPPort = viper.GetInt("http.port")
 	cfg.PGHost = viper.GetString("pg.host")
 	cfg.PGPort = viper.GetInt("pg.port")
 	cfg.PGUser = viper.GetString("pg.user")
 	cfg.PGPass = viper.GetString("pg.pass")
 	cfg.PGDatabase = viper.GetString("pg.database")
-	cfg.PGMaxIdleConn = viper.GetInt("pg.maxidleconn")
-	cfg.PGMAxOpenConn = viper.GetInt("pg.maxopenconn")
+	// the former keys are the defaults of the pool options
+	viper.SetDefault("pg.max_idle_conns", viper.GetInt("pg.maxidleconn"))
+	viper.SetDefault("pg.max_open_conns", viper.GetInt("pg.maxopenconn"))
+	cfg.PGMaxIdleConn = viper.GetInt("pg.max_idle_conns")
+	cfg.PGMAxOpenConn = viper.GetInt("pg.max_open_conns")
+	cfg.PGConnMaxLifetime = viper.GetInt("pg.conn_max_lifetime")
 	cfg.JWTKey = viper.GetString("jwt.key")
+	cfg.AdminKey = viper.GetString("admin.key")
+	cfg.SignKey = viper.GetString("sign.key")
 	cfg.MigrationsPath = viper.GetString("migrations")
+	cfg.DefaultPageSize = viper.GetInt("default_page_size")
+	cfg.MaxPageSize = viper.GetInt("max_page_size")
 	cfg.AccessConf.Restrict = viper.GetBool("access.restrict")
+	cfg.AccessConf.SchemaPaths = viper.GetStringSlice("access.schemapaths")
+	cfg.RequireWhere = viper.GetBool("access.requirewhere")
+	cfg.Sandbox = viper.GetBool("sandbox")
+	cfg.PutMissing = viper.GetString("put.missing")
+	cfg.AuthTable = viper.GetString("auth.table")
+	cfg.AuthSchema = viper.GetString("auth.schema")
+	cfg.AuthUsername = viper.GetString("auth.username")
+	cfg.AuthPassword = viper.GetString("auth.password")
+	cfg.RLS = viper.GetBool("rls.enabled")
+	cfg.RLSClaim = viper.GetString("rls.claim")
+	cfg.RLSAnonymous = viper.GetString("rls.anonymous")
+	cfg.OwnerClaim = viper.GetString("owner.claim")
+	cfg.ProfileSample = viper.GetInt("profile.sample")
+	cfg.ProfileTop = viper.GetInt("profile.top")
+	cfg.RateLimit = viper.GetFloat64("ratelimit.rate")
+	cfg.RateLimitBurst = viper.GetInt("ratelimit.burst")
+	cfg.RateLimitBy = viper.GetString("ratelimit.by")
+	cfg.RateLimitIPHeader = viper.GetString("ratelimit.ipheader")
+	cfg.Maintenance = viper.GetBool("maintenance.enabled")
+	cfg.MaintenanceMessage = viper.GetString("maintenance.message")
+	cfg.MaintenanceRetryAfter = viper.GetInt("maintenance.retryafter")
+	cfg.Expose = stringSlice("expose.tables")
+	cfg.IPAllow = stringSlice("ipfilter.allow")
+	cfg.IPDeny = stringSlice("ipfilter.deny")
+	cfg.IPHeader = viper.GetString("ipfilter.ipheader")
+	cfg.CORSAllowedOrigins = stringSlice("cors.allowed_origins")
+	cfg.CORSAllowedMethods = stringSlice("cors.allowed_methods")
+	cfg.CORSAllowedHeaders = stringSlice("cors.allowed_headers")
+	cfg.CORSAllowCredentials = viper.GetBool("cors.allow_credentials")
+	cfg.CORSMaxAge = viper.GetInt("cors.max_age")
+	cfg.PoolInterval = viper.GetInt("pool.interval")
+	cfg.PoolWaitThreshold = viper.GetInt("pool.waitthreshold")
+	cfg.HTTPSCert = viper.GetString("https.cert")
+	cfg.HTTPSKey = viper.GetString("https.key")
+	cfg.HTTPSRedirectPort = viper.GetInt("https.redirectport")
+	cfg.MaxBodySize = int64(viper.GetSizeInBytes("http.maxbodysize"))
+	cfg.Pretty = viper.GetBool("http.pretty")
+	cfg.Bytea = viper.GetString("bytea.encoding")
+	cfg.TimestampFormat = viper.GetString("timestamp.format")
+	cfg.TimestampZone = viper.GetString("timestamp.timezone")
+	cfg.QueryTags = stringSlice("querytags")
+	cfg.StaleMaxAge = viper.GetInt("stale.maxage")
+	cfg.StaleMaxEntries = viper.GetInt("stale.maxentries")
+	cfg.StaleMaxEntrySize = viper.GetInt("stale.maxentrysize")
+	cfg.Usage = viper.GetBool("usage.enabled")
+	cfg.UsageFile = viper.GetString("usage.file")
+	cfg.UsageInterval = viper.GetInt("usage.interval")
+	cfg.CountTables = stringSlice("counts.tables")
+	cfg.CountInterval = viper.GetInt("counts.interval")
+	cfg.StorageEndpoint = viper.GetString("storage.endpoint")
+	cfg.StorageRegion = viper.GetString("storage.region")
+	cfg.StorageBucket = viper.GetString("storage.bucket")
+	cfg.StorageAccessKey = viper.GetString("storage.accesskey")
+	cfg.StorageSecretKey = viper.GetString("storage.secretkey")
+	cfg.StoragePrefix = viper.GetString("storage.prefix")
+	cfg.StorageURLExpires = viper.GetInt("storage.urlexpires")
+	cfg.BackupPath = viper.GetString("backup.path")
+	cfg.BackupPGDump = viper.GetString("backup.pgdump")
 
 	var t []TablesConf
 	err = viper.UnmarshalKey("access.tables", &t)
@@ -82,17 +578,102 @@ func Parse(cfg *Prest) (err error) {
 	}
 
 	cfg.AccessConf.Tables = t
+	cfg.AccessConf.configTables = t
+	cfg.AccessConf.Table = viper.GetString("access.table")
+	cfg.AccessConf.DenyUnknown = viper.GetBool("access.denyunknown")
+
+	cfg.AccessConf.Default = nil
+	if viper.IsSet("access.default") {
+		var d TablesConf
+		if err = viper.UnmarshalKey("access.default", &d); err != nil {
+			return err
+		}
+		cfg.AccessConf.Default = &d
+	}
+
+	var tr []TransformConf
+	err = viper.UnmarshalKey("transforms", &tr)
+	if err != nil {
+		return err
+	}
+
+	cfg.Transforms = tr
+
+	var ch []ChangesConf
+	err = viper.UnmarshalKey("changes", &ch)
+	if err != nil {
+		return err
+	}
+
+	cfg.Changes = ch
 
+	var an []AnonymizeConf
+	err = viper.UnmarshalKey("anonymize", &an)
+	if err != nil {
+		return err
+	}
+
+	cfg.Anonymize = an
+
+	var al []AlertConf
+	err = viper.UnmarshalKey("alerts", &al)
+	if err != nil {
+		return err
+	}
+
+	cfg.Alerts = al
+
+	var keys []APIKeyConf
+	err = viper.UnmarshalKey("apikeys", &keys)
+	if err != nil {
+		return err
+	}
+
+	cfg.APIKeys = keys
+
+	return
+}
+
+// stringSlice return a list of the config, the environment variables are
+// comma separated (e.g. PREST_CORS_ALLOWED_ORIGINS="https://a.com,https://b.com")
+func stringSlice(key string) (values []string) {
+	for _, v := range viper.GetStringSlice(key) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
 	return
 }
 
 func InitConf() {
 	viperCfg()
 	prestConfig := Prest{}
-	Parse(&prestConfig)
-	PREST_CONF = &prestConfig
+	if err := Parse(&prestConfig); err != nil {
+		fmt.Println(err)
+	}
+	Set(&prestConfig)
 
 	if !prestConfig.AccessConf.Restrict {
 		fmt.Println("You are running pREST in public mode.")
 	}
 }
+
+// Reload parse the config again and replace the current one, the rules
+// loaded from the access table are kept. The requests in progress finish
+// with the config they started with
+func Reload() error {
+	cfg := Prest{}
+	if err := Parse(&cfg); err != nil {
+		return err
+	}
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	if previous := Get(); previous != nil && len(previous.AccessConf.Tables) >= len(previous.AccessConf.configTables) {
+		loaded := previous.AccessConf.Tables[len(previous.AccessConf.configTables):]
+		cfg.AccessConf.Tables = append(append([]TablesConf{}, cfg.AccessConf.configTables...), loaded...)
+	}
+	Set(&cfg)
+	return nil
+}
@@ -1,22 +1,45 @@
 package config
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"os"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
 )
 
 func TestInitConf(t *testing.T) {
 	os.Setenv("PREST_CONF", "../testdata/prest.toml")
 	Convey("Check tables parser", t, func() {
 		InitConf()
-		So(len(PREST_CONF.AccessConf.Tables), ShouldBeGreaterThanOrEqualTo, 2)
+		So(len(Get().AccessConf.Tables), ShouldBeGreaterThanOrEqualTo, 2)
+	})
+	Convey("Check transforms parser", t, func() {
+		InitConf()
+		So(len(Get().Transforms), ShouldEqual, 1)
+		So(Get().Transforms[0].Table, ShouldEqual, "test5")
+		So(Get().Transforms[0].Rename["name"], ShouldEqual, "full_name")
+	})
+	Convey("Check anonymize parser", t, func() {
+		InitConf()
+		So(len(Get().Anonymize), ShouldEqual, 1)
+		So(Get().Anonymize[0].Table, ShouldEqual, "test5")
+		So(Get().Anonymize[0].Columns["celphone"], ShouldEqual, "phone")
+	})
+	Convey("Check alerts parser", t, func() {
+		InitConf()
+		So(len(Get().Alerts), ShouldEqual, 1)
+		So(Get().Alerts[0].Table, ShouldEqual, "test5")
+		So(Get().Alerts[0].Operation, ShouldEqual, "delete")
+		So(Get().Alerts[0].Rows, ShouldEqual, 100)
+		So(Get().Alerts[0].Window, ShouldEqual, 0)
 	})
 	Convey("Check restrict parser", t, func() {
 		InitConf()
-		So(PREST_CONF.AccessConf.Restrict, ShouldBeTrue)
+		So(Get().AccessConf.Restrict, ShouldBeTrue)
 	})
 }
 
@@ -48,4 +71,392 @@ func TestParse(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(cfg.HTTPPort, ShouldEqual, 4000)
 	})
+	Convey("Verify page sizes from env", t, func() {
+		os.Setenv("PREST_DEFAULT_PAGE_SIZE", "50")
+		os.Setenv("PREST_MAX_PAGE_SIZE", "1000")
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.DefaultPageSize, ShouldEqual, 50)
+		So(cfg.MaxPageSize, ShouldEqual, 1000)
+		os.Unsetenv("PREST_DEFAULT_PAGE_SIZE")
+		os.Unsetenv("PREST_MAX_PAGE_SIZE")
+	})
+	Convey("Verify max body size", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.MaxBodySize, ShouldEqual, 1<<20)
+
+		os.Setenv("PREST_HTTP_MAXBODYSIZE", "10KB")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.MaxBodySize, ShouldEqual, 10<<10)
+		os.Unsetenv("PREST_HTTP_MAXBODYSIZE")
+	})
+	Convey("Verify pretty JSON", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Pretty, ShouldBeFalse)
+
+		os.Setenv("PREST_HTTP_PRETTY", "true")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Pretty, ShouldBeTrue)
+		os.Unsetenv("PREST_HTTP_PRETTY")
+	})
+	Convey("Verify bytea encoding", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Bytea, ShouldEqual, "")
+
+		os.Setenv("PREST_BYTEA_ENCODING", "base64")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Bytea, ShouldEqual, "base64")
+		os.Unsetenv("PREST_BYTEA_ENCODING")
+	})
+	Convey("Verify timestamp format and timezone", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.TimestampFormat, ShouldEqual, "")
+		So(cfg.TimestampZone, ShouldEqual, "")
+
+		os.Setenv("PREST_TIMESTAMP_FORMAT", "epoch")
+		os.Setenv("PREST_TIMESTAMP_TIMEZONE", "America/Sao_Paulo")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.TimestampFormat, ShouldEqual, "epoch")
+		So(cfg.TimestampZone, ShouldEqual, "America/Sao_Paulo")
+		os.Unsetenv("PREST_TIMESTAMP_FORMAT")
+		os.Unsetenv("PREST_TIMESTAMP_TIMEZONE")
+	})
+	Convey("Verify owner claim", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.OwnerClaim, ShouldEqual, "sub")
+
+		os.Setenv("PREST_OWNER_CLAIM", "user_id")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.OwnerClaim, ShouldEqual, "user_id")
+		os.Unsetenv("PREST_OWNER_CLAIM")
+	})
+	Convey("Verify connection pool", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.PGMAxOpenConn, ShouldEqual, 10)
+		So(cfg.PGMaxIdleConn, ShouldEqual, 10)
+		So(cfg.PGConnMaxLifetime, ShouldEqual, 0)
+
+		os.Setenv("PREST_PG_MAX_OPEN_CONNS", "50")
+		os.Setenv("PREST_PG_MAX_IDLE_CONNS", "5")
+		os.Setenv("PREST_PG_CONN_MAX_LIFETIME", "300")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.PGMAxOpenConn, ShouldEqual, 50)
+		So(cfg.PGMaxIdleConn, ShouldEqual, 5)
+		So(cfg.PGConnMaxLifetime, ShouldEqual, 300)
+		os.Unsetenv("PREST_PG_MAX_OPEN_CONNS")
+		os.Unsetenv("PREST_PG_MAX_IDLE_CONNS")
+		os.Unsetenv("PREST_PG_CONN_MAX_LIFETIME")
+	})
+	Convey("Verify usage", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Usage, ShouldBeFalse)
+		So(cfg.UsageInterval, ShouldEqual, 60)
+
+		os.Setenv("PREST_USAGE_ENABLED", "true")
+		os.Setenv("PREST_USAGE_FILE", "/var/lib/prest/usage.json")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.Usage, ShouldBeTrue)
+		So(cfg.UsageFile, ShouldEqual, "/var/lib/prest/usage.json")
+		os.Unsetenv("PREST_USAGE_ENABLED")
+		os.Unsetenv("PREST_USAGE_FILE")
+	})
+	Convey("Verify count cache", t, func() {
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.CountTables, ShouldBeEmpty)
+		So(cfg.CountInterval, ShouldEqual, 300)
+
+		os.Setenv("PREST_COUNTS_TABLES", "events,logs_*")
+		os.Setenv("PREST_COUNTS_INTERVAL", "60")
+		viperCfg()
+		err = Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.CountTables, ShouldResemble, []string{"events", "logs_*"})
+		So(cfg.CountInterval, ShouldEqual, 60)
+		os.Unsetenv("PREST_COUNTS_TABLES")
+		os.Unsetenv("PREST_COUNTS_INTERVAL")
+	})
+}
+
+func TestCORSConf(t *testing.T) {
+	Convey("CORS origins from env", t, func() {
+		os.Setenv("PREST_CONF", "../testdata/prest.toml")
+		os.Setenv("PREST_CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+		viperCfg()
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.CORSAllowedOrigins, ShouldResemble, []string{"https://a.example.com", "https://b.example.com"})
+		So(cfg.CORSAllowedMethods, ShouldContain, "PATCH")
+		os.Unsetenv("PREST_CORS_ALLOWED_ORIGINS")
+	})
+}
+
+func TestExposed(t *testing.T) {
+	InitConf()
+	Convey("Everything is exposed without list", t, func() {
+		So(Get().Exposed("prest", "public", "test"), ShouldBeTrue)
+	})
+	Convey("Exposed databases, schemas and tables", t, func() {
+		cfg := *Get()
+		cfg.Expose = []string{"prest.public.test", "prest.api.*", "reports"}
+		So(cfg.Exposed("prest", "public", "test"), ShouldBeTrue)
+		So(cfg.Exposed("prest", "public", "test2"), ShouldBeFalse)
+		So(cfg.Exposed("prest", "public", ""), ShouldBeTrue)
+		So(cfg.Exposed("prest", "private", ""), ShouldBeFalse)
+		So(cfg.Exposed("prest", "api", "orders"), ShouldBeTrue)
+		So(cfg.Exposed("reports", "public", "sales"), ShouldBeTrue)
+		So(cfg.Exposed("reports", "", ""), ShouldBeTrue)
+		So(cfg.Exposed("postgres", "", ""), ShouldBeFalse)
+	})
+	Convey("Tables without access rules are denied", t, func() {
+		cfg := *Get()
+		cfg.AccessConf.DenyUnknown = true
+		So(cfg.HidesTables(), ShouldBeTrue)
+		So(cfg.Exposed("prest", "public", "test"), ShouldBeTrue)
+		So(cfg.Exposed("prest", "public", "unknown_table"), ShouldBeFalse)
+		So(cfg.Exposed("prest", "public", ""), ShouldBeTrue)
+		So(cfg.Listed("prest", "public", "test_readonly_access"), ShouldBeTrue)
+		So(cfg.Listed("prest", "private", "test_readonly_access"), ShouldBeTrue)
+	})
+}
+
+func TestHasPermission(t *testing.T) {
+	Convey("Table permissions by operation", t, func() {
+		So(HasPermission([]string{"read", "write"}, "insert"), ShouldBeTrue)
+		So(HasPermission([]string{"read", "write"}, "update"), ShouldBeTrue)
+		So(HasPermission([]string{"read", "write"}, "delete"), ShouldBeFalse)
+		So(HasPermission([]string{"insert"}, "insert"), ShouldBeTrue)
+		So(HasPermission([]string{"insert"}, "update"), ShouldBeFalse)
+		So(HasPermission([]string{"insert"}, "write"), ShouldBeFalse)
+		So(HasPermission(nil, "read"), ShouldBeFalse)
+	})
+}
+
+func TestTableAccess(t *testing.T) {
+	cfg := &Prest{}
+	cfg.AccessConf.Tables = []TablesConf{
+		{Name: "users", Permissions: []string{"read"}},
+		{Name: "users", Schema: "internal", Permissions: []string{"delete"}},
+		{Name: "users", Database: "prest", Schema: "internal", Permissions: []string{"insert"}},
+	}
+	Convey("Unqualified rules match any schema", t, func() {
+		access, ok := cfg.TableAccess("prest", "public", "users")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"read"})
+	})
+	Convey("The most qualified rules win", t, func() {
+		access, ok := cfg.TableAccess("other", "internal", "users")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"delete"})
+		access, ok = cfg.TableAccess("prest", "internal", "users")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"insert"})
+	})
+	Convey("Tables without rules", t, func() {
+		_, ok := cfg.TableAccess("prest", "public", "orders")
+		So(ok, ShouldBeFalse)
+		So(TablesConf{Name: "users", Schema: "internal"}.Matches("prest", "public", "users"), ShouldBeFalse)
+	})
+	Convey("Delta sync of the tables qualified by schema", t, func() {
+		So(ChangesConf{Table: "users", Schema: "public"}.Matches("prest", "public", "users"), ShouldBeTrue)
+		So(ChangesConf{Table: "users", Schema: "public"}.Matches("prest", "audit", "users"), ShouldBeFalse)
+		So(ChangesConf{Table: "users"}.Matches("prest", "audit", "users"), ShouldBeTrue)
+	})
+	Convey("Transforms and anonymization of the tables qualified by schema", t, func() {
+		So(TransformConf{Table: "users", Schema: "public"}.Matches("prest", "public", "users"), ShouldBeTrue)
+		So(TransformConf{Table: "users", Schema: "public"}.Matches("prest", "audit", "users"), ShouldBeFalse)
+		So(AnonymizeConf{Table: "users", Database: "prest"}.Matches("prest", "public", "users"), ShouldBeTrue)
+		So(AnonymizeConf{Table: "users", Database: "prest"}.Matches("other", "public", "users"), ShouldBeFalse)
+	})
+}
+
+func TestSetTableRules(t *testing.T) {
+	InitConf()
+	defer Set(Get())
+	tables := Get().AccessConf.Tables
+	Convey("The rules of the table are added to the ones of the config", t, func() {
+		SetTableRules([]TablesConf{{Name: "from_table", Permissions: []string{"read"}}})
+		So(len(Get().AccessConf.Tables), ShouldEqual, len(tables)+1)
+		access, ok := Get().TableAccess("prest", "public", "from_table")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"read"})
+	})
+	Convey("Reloaded rules replace the ones of the table", t, func() {
+		SetTableRules([]TablesConf{{Name: "other", Permissions: []string{"read"}}})
+		So(len(Get().AccessConf.Tables), ShouldEqual, len(tables)+1)
+		_, ok := Get().TableAccess("prest", "public", "from_table")
+		So(ok, ShouldBeFalse)
+		SetTableRules(nil)
+		So(Get().AccessConf.Tables, ShouldResemble, tables)
+	})
+}
+
+func TestTableAccessPatterns(t *testing.T) {
+	cfg := &Prest{}
+	cfg.AccessConf.Tables = []TablesConf{
+		{Name: "report_*", Permissions: []string{"read"}},
+		{Name: "report_sales", Permissions: []string{"read", "write"}},
+		{Name: "*", Schema: "audit_*", Permissions: []string{"insert"}},
+	}
+	Convey("Patterns match the names", t, func() {
+		access, ok := cfg.TableAccess("prest", "public", "report_2017")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"read"})
+		access, ok = cfg.TableAccess("prest", "audit_2017", "logins")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"insert"})
+		_, ok = cfg.TableAccess("prest", "public", "reports")
+		So(ok, ShouldBeFalse)
+	})
+	Convey("Names win over patterns", t, func() {
+		access, ok := cfg.TableAccess("prest", "public", "report_sales")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"read", "write"})
+		So(TablesConf{Name: "report_*"}.Pattern(), ShouldBeTrue)
+		So(TablesConf{Name: "report_sales"}.Pattern(), ShouldBeFalse)
+	})
+	Convey("Default rules of the tables without rules", t, func() {
+		withDefault := *cfg
+		withDefault.AccessConf.Default = &TablesConf{Permissions: []string{"read"}, Fields: []string{"id"}}
+		access, ok := withDefault.TableAccess("prest", "public", "orders")
+		So(ok, ShouldBeTrue)
+		So(access.Fields, ShouldResemble, []string{"id"})
+		access, ok = withDefault.TableAccess("prest", "public", "report_sales")
+		So(ok, ShouldBeTrue)
+		So(access.Permissions, ShouldResemble, []string{"read", "write"})
+	})
+	Convey("Default rules from the config", t, func() {
+		os.Setenv("PREST_CONF", "../testdata/prest.toml")
+		viperCfg()
+		viper.Set("access.default", map[string]interface{}{"permissions": []string{"read"}, "fields": []string{"*"}})
+		defer viper.Set("access.default", nil)
+		cfg := &Prest{}
+		err := Parse(cfg)
+		So(err, ShouldBeNil)
+		So(cfg.AccessConf.Default, ShouldNotBeNil)
+		So(cfg.AccessConf.Default.Permissions, ShouldResemble, []string{"read"})
+	})
+}
+
+func TestSecrets(t *testing.T) {
+	key, err := NewSecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(SecretKeyEnv, key)
+	defer os.Unsetenv(SecretKeyEnv)
+
+	Convey("Encrypt and decrypt values", t, func() {
+		k, err := SecretKey()
+		So(err, ShouldBeNil)
+		secret, err := EncryptSecret(k, "s3cr3t, pass")
+		So(err, ShouldBeNil)
+		So(IsSecret(secret), ShouldBeTrue)
+		So(secret, ShouldNotContainSubstring, "s3cr3t")
+
+		value, err := DecryptSecret(k, secret)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "s3cr3t, pass")
+	})
+	Convey("Wrong keys and modified values", t, func() {
+		k, _ := SecretKey()
+		secret, _ := EncryptSecret(k, "pass")
+		other := make([]byte, 32)
+		_, err := DecryptSecret(other, secret)
+		So(err, ShouldNotBeNil)
+		_, err = DecryptSecret(k, strings.Replace(secret, "data:", "data:AA", 1))
+		So(err, ShouldNotBeNil)
+		_, err = DecryptSecret(k, "pass")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Decrypt the values of the config", t, func() {
+		k, _ := SecretKey()
+		secret, _ := EncryptSecret(k, "dbpass")
+		viper.Set("pg.pass", secret)
+		defer viper.Set("pg.pass", "")
+		So(decryptSecrets(), ShouldBeNil)
+		So(viper.GetString("pg.pass"), ShouldEqual, "dbpass")
+	})
+	Convey("The key is required with encrypted values", t, func() {
+		k, _ := SecretKey()
+		secret, _ := EncryptSecret(k, "dbpass")
+		viper.Set("pg.pass", secret)
+		defer viper.Set("pg.pass", "")
+		os.Unsetenv(SecretKeyEnv)
+		defer os.Setenv(SecretKeyEnv, key)
+		So(decryptSecrets(), ShouldNotBeNil)
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	os.Setenv("PREST_CONF", "../testdata/prest.toml")
+	InitConf()
+	Convey("Update replace the config by a changed copy", t, func() {
+		current := Get()
+		previous := Update(func(cfg *Prest) { cfg.AdminKey = "secret" })
+		So(previous, ShouldEqual, current)
+		So(previous.AdminKey, ShouldEqual, "")
+		So(Get().AdminKey, ShouldEqual, "secret")
+		Set(previous)
+		So(Get(), ShouldEqual, current)
+	})
+	Convey("Config of the context", t, func() {
+		So(FromContext(context.Background()), ShouldEqual, Get())
+		cfg := *Get()
+		cfg.AdminKey = "other"
+		ctx := NewContext(context.Background(), &cfg)
+		So(FromContext(ctx).AdminKey, ShouldEqual, "other")
+		So(Get().AdminKey, ShouldEqual, "")
+	})
+	Convey("Reload keep the rules of the access table", t, func() {
+		defer Set(Get())
+		tables := Get().AccessConf.Tables
+		SetTableRules([]TablesConf{{Name: "from_table", Permissions: []string{"read"}}})
+		Update(func(cfg *Prest) { cfg.AdminKey = "changed" })
+		So(Reload(), ShouldBeNil)
+		So(Get().AdminKey, ShouldEqual, "")
+		So(len(Get().AccessConf.Tables), ShouldEqual, len(tables)+1)
+		_, ok := Get().TableAccess("prest", "public", "from_table")
+		So(ok, ShouldBeTrue)
+	})
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SecretKeyEnv is the environment variable of the key of the encrypted
+// values of the config, 32 bytes encoded in base64
+const SecretKeyEnv = "PREST_SECRET_KEY"
+
+// encrypted values look like SOPS ones:
+// ENC[AES256_GCM,data:BASE64,iv:BASE64,tag:BASE64]
+const (
+	secretPrefix = "ENC[AES256_GCM,"
+	secretSuffix = "]"
+)
+
+// SecretKey return the key of the encrypted values from the environment
+func SecretKey() ([]byte, error) {
+	encoded := os.Getenv(SecretKeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("%s is not set", SecretKeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 32 bytes encoded in base64", SecretKeyEnv)
+	}
+	return key, nil
+}
+
+// NewSecretKey generate a random key encoded in base64
+func NewSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsSecret check if the value of the config is encrypted
+func IsSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) && strings.HasSuffix(value, secretSuffix)
+}
+
+// EncryptSecret encrypt the value with AES-256-GCM returning the text to
+// use in the config
+func EncryptSecret(key []byte, value string) (string, error) {
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(value), nil)
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("%sdata:%s,iv:%s,tag:%s%s", secretPrefix,
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		secretSuffix), nil
+}
+
+// DecryptSecret decrypt a value encrypted by EncryptSecret
+func DecryptSecret(key []byte, secret string) (string, error) {
+	if !IsSecret(secret) {
+		return "", errors.New("Invalid encrypted value")
+	}
+	fields := map[string][]byte{}
+	for _, field := range strings.Split(secret[len(secretPrefix):len(secret)-len(secretSuffix)], ",") {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			return "", errors.New("Invalid encrypted value")
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", errors.New("Invalid encrypted value")
+		}
+		fields[parts[0]] = value
+	}
+
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(fields["iv"]) != gcm.NonceSize() || len(fields["tag"]) != gcm.Overhead() {
+		return "", errors.New("Invalid encrypted value")
+	}
+	value, err := gcm.Open(nil, fields["iv"], append(fields["data"], fields["tag"]...), nil)
+	if err != nil {
+		return "", errors.New("Can't decrypt the value, wrong key or modified value")
+	}
+	return string(value), nil
+}
+
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecrets replace the encrypted values (and the encrypted items of
+// lists) of the config by the decrypted ones, the key is only required when
+// there are encrypted values
+func decryptSecrets() error {
+	var key []byte
+	decrypt := func(name, value string) (string, error) {
+		if !IsSecret(value) {
+			return value, nil
+		}
+		if key == nil {
+			var err error
+			if key, err = SecretKey(); err != nil {
+				return "", fmt.Errorf("Can't decrypt %s: %v", name, err)
+			}
+		}
+		decrypted, err := DecryptSecret(key, value)
+		if err != nil {
+			return "", fmt.Errorf("Can't decrypt %s: %v", name, err)
+		}
+		return decrypted, nil
+	}
+
+	for _, name := range viper.AllKeys() {
+		switch value := viper.Get(name).(type) {
+		case string:
+			if !IsSecret(value) {
+				continue
+			}
+			decrypted, err := decrypt(name, value)
+			if err != nil {
+				return err
+			}
+			viper.Set(name, decrypted)
+		case []interface{}:
+			items := make([]interface{}, len(value))
+			changed := false
+			for i, item := range value {
+				items[i] = item
+				if s, ok := item.(string); ok && IsSecret(s) {
+					decrypted, err := decrypt(name, s)
+					if err != nil {
+						return err
+					}
+					items[i], changed = decrypted, true
+				}
+			}
+			if changed {
+				viper.Set(name, items)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
+)
+
+// RefreshAccess reload the rules of the access table, after changing them
+func RefreshAccess(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	table := config.FromContext(r.Context()).AccessConf.Table
+	if table == "" {
+		http.Error(w, "Access table not configured", http.StatusBadRequest)
+		return
+	}
+
+	count, err := postgres.LoadAccessTable(table)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%d access rules loaded from %s\n", count, table)
+
+	object, err := json.Marshal(map[string]int{"rules": count})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRefreshAccess(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	router := mux.NewRouter()
+	router.HandleFunc("/_access/refresh", RefreshAccess).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	do := func(key string) (*http.Response, error) {
+		req, err := http.NewRequest("POST", server.URL+"/_access/refresh", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Admin-Key", key)
+		return http.DefaultClient.Do(req)
+	}
+
+	Convey("Refresh without admin key", t, func() {
+		resp, err := do("")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+	})
+	Convey("Refresh without access table", t, func() {
+		resp, err := do("secret")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("Refresh the rules of the access table", t, func() {
+		tables := config.Get().AccessConf.Tables
+		defer config.Set(config.Get())
+		cfg := configWith(func(cfg *config.Prest) { cfg.AccessConf.Table = "public.prest_access" })
+		server := httptest.NewServer(withConfig(router, cfg))
+		defer server.Close()
+		req, err := http.NewRequest("POST", server.URL+"/_access/refresh", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("X-Admin-Key", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		var result map[string]int
+		So(json.NewDecoder(resp.Body).Decode(&result), ShouldBeNil)
+		So(result["rules"], ShouldEqual, 1)
+		So(len(config.Get().AccessConf.Tables), ShouldEqual, len(tables)+1)
+	})
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/backup"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/jobs"
+)
+
+// BackupDatabase start a job running pg_dump of the database (or the schema
+// informed by `schema` query string) into the configured backup path
+func BackupDatabase(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema := r.URL.Query().Get("schema")
+
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	cfg := config.FromContext(r.Context())
+	if cfg.BackupPath == "" {
+		err := errors.New("Backup path is not configured")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	_, err := backup.Command(cfg, database, schema, "")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobs.New("backup")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		file, err := backup.Dump(cfg, database, schema)
+		if err != nil {
+			log.Printf("backup job %s: %v\n", job.Info().ID, err)
+			job.Fail(err)
+			return
+		}
+		job.Done(filepath.Base(file))
+	}()
+
+	object, err := json.Marshal(job.Info())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(object)
+}
+
+// RestoreDatabase execute the SQL script of the request body (a plain
+// pg_dump, migrations...) in the database, in a transaction unless
+// `_transaction=false`. Each failed statement is reported in the response
+func RestoreDatabase(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	if database != config.FromContext(r.Context()).PGDatabase {
+		http.Error(w, "Restore is only supported in the configured database", http.StatusBadRequest)
+		return
+	}
+
+	transaction := true
+	if t := r.URL.Query().Get("_transaction"); t != "" {
+		var err error
+		transaction, err = strconv.ParseBool(t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := postgres.ExecScript(config.FromContext(r.Context()), postgres.SplitStatements(r.Body), transaction)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	object, err := json.Marshal(result)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write(object)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackupDatabase(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/_backup/{database}", BackupDatabase).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Backup without admin key", t, func() {
+		resp, err := http.Post(server.URL+"/_backup/prest", "application/json", nil)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+	})
+	Convey("Backup without backup path configured", t, func() {
+		defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+		resp, err := doAdminRequest(server.URL+"/_backup/prest", "secret", "")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+	})
+}
+
+func TestRestoreDatabase(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	router := mux.NewRouter()
+	router.HandleFunc("/_restore/{database}", RestoreDatabase).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Restore with wrong admin key", t, func() {
+		resp, err := doAdminRequest(server.URL+"/_restore/prest", "wrong", "SELECT 1;")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+	})
+	Convey("Restore SQL script", t, func() {
+		resp, err := doAdminRequest(server.URL+"/_restore/prest", "secret", "CREATE TEMP TABLE restore_test (id int); INSERT INTO restore_test VALUES (1);")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+	})
+	Convey("Restore SQL script with errors", t, func() {
+		resp, err := doAdminRequest(server.URL+"/_restore/prest", "secret", "SELECT 1; SELECT * FROM notexist;")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		var result postgres.ScriptResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		So(err, ShouldBeNil)
+		So(result.Statements, ShouldEqual, 2)
+		So(result.Applied, ShouldEqual, 0)
+		So(result.Errors[0].Statement, ShouldEqual, 2)
+	})
+	Convey("Restore in another database", t, func() {
+		resp, err := doAdminRequest(server.URL+"/_restore/other", "secret", "SELECT 1;")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
+
+func doAdminRequest(url, key, body string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Admin-Key", key)
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
+)
+
+// batchOperation operation of a batch with the parsed filters
+type batchOperation struct {
+	api.BatchOperation
+	where  string
+	values []interface{}
+}
+
+// Batch execute the insert, update and delete operations of the request in
+// a single transaction, all of them are rolled back when one fails
+func Batch(w http.ResponseWriter, r *http.Request) {
+	req := api.BatchRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println("Batch:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.FromContext(r.Context())
+	operations := make([]batchOperation, 0, len(req.Operations))
+	for i, op := range req.Operations {
+		switch op.Op {
+		case "insert", "update", "delete":
+		default:
+			http.Error(w, fmt.Sprintf("Operation %d: invalid op %q", i+1, op.Op), http.StatusBadRequest)
+			return
+		}
+		if !cfg.Exposed(op.Database, op.Schema, op.Table) {
+			http.Error(w, fmt.Sprintf("Operation %d: table not found", i+1), http.StatusNotFound)
+			return
+		}
+
+		filter, err := http.NewRequest("GET", "/?"+op.Where, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Operation %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		filter = filter.WithContext(r.Context())
+		if err = postgres.MaskedByRequest(filter, op.Database, op.Schema, op.Table); err != nil {
+			http.Error(w, fmt.Sprintf("Operation %d: %v", i+1, err), http.StatusForbidden)
+			return
+		}
+		where, values, err := postgres.WhereByRequest(filter, 1)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Operation %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		if op.Op == "insert" {
+			op.Data, err = postgres.OwnerRequest(r, op.Database, op.Schema, op.Table, op.Data)
+		} else {
+			where, values, err = ownedRows(r, op.Database, op.Schema, op.Table, where, values)
+			if err == nil && op.Op == "update" {
+				op.Data, err = postgres.OwnerRequest(r, op.Database, op.Schema, op.Table, op.Data)
+			}
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Operation %d: %v", i+1, err), http.StatusForbidden)
+			return
+		}
+		operations = append(operations, batchOperation{BatchOperation: op, where: where, values: values})
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]json.RawMessage, len(operations))
+	failed := 0
+	err = session.Transaction(func(tx *sql.Tx) error {
+		for i, op := range operations {
+			body := api.Request{Data: op.Data}
+			if err := authorize(r, op.Database, op.Schema, op.Table, op.Op, bodyColumns(op.Data)); err != nil {
+				return fmt.Errorf("Operation %d: %v", i+1, err)
+			}
+			var object []byte
+			var err error
+			switch op.Op {
+			case "insert":
+				object, err = postgres.InsertTx(tx, op.Database, op.Schema, op.Table, body)
+			case "update":
+				object, _, err = postgres.UpdateTx(tx, op.Database, op.Schema, op.Table, op.where, op.values, body)
+			case "delete":
+				object, err = postgres.DeleteTx(tx, op.Database, op.Schema, op.Table, op.where, op.values)
+			}
+			if err != nil {
+				failed = i + 1
+				if _, ok := err.(*postgres.UnknownColumnsError); ok {
+					return err
+				}
+				return fmt.Errorf("Operation %d: %v", i+1, err)
+			}
+			results[i] = object
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		if writeUnknownColumns(w, err, failed) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	object, err := json.Marshal(results)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
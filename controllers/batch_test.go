@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func doBatchRequest(url string, req api.BatchRequest) (*http.Response, error) {
+	byt, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(url, "application/json", bytes.NewBuffer(byt))
+}
+
+func TestBatch(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/_batch", Batch).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Execute batch of operations", t, func() {
+		resp, err := doBatchRequest(server.URL+"/_batch", api.BatchRequest{
+			Operations: []api.BatchOperation{
+				{Op: "insert", Database: "prest", Schema: "public", Table: "test", Data: map[string]interface{}{"name": "batch"}},
+				{Op: "update", Database: "prest", Schema: "public", Table: "test", Where: "name=batch", Data: map[string]interface{}{"name": "batch updated"}},
+				{Op: "delete", Database: "prest", Schema: "public", Table: "test", Where: "name=batch updated"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+
+		var results []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&results)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 3)
+	})
+	Convey("Batch with invalid operation", t, func() {
+		resp, err := doBatchRequest(server.URL+"/_batch", api.BatchRequest{
+			Operations: []api.BatchOperation{
+				{Op: "select", Database: "prest", Schema: "public", Table: "test"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("Batch is rolled back when an operation fails", t, func() {
+		resp, err := doBatchRequest(server.URL+"/_batch", api.BatchRequest{
+			Operations: []api.BatchOperation{
+				{Op: "insert", Database: "prest", Schema: "public", Table: "test", Data: map[string]interface{}{"name": "batch rollback"}},
+				{Op: "delete", Database: "prest", Schema: "public", Table: "test_readonly_access", Where: "name=test01"},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+		doValidGetRequest(server.URL+"/prest/public/test?name=batch%20rollback", "Batch")
+	})
+	Convey("Batch with unknown columns", t, func() {
+		resp, err := doBatchRequest(server.URL+"/_batch", api.BatchRequest{
+			Operations: []api.BatchOperation{
+				{Op: "insert", Database: "prest", Schema: "public", Table: "test", Data: map[string]interface{}{"name": "batch"}},
+				{Op: "insert", Database: "prest", Schema: "public", Table: "test", Data: map[string]interface{}{"name": "batch", "nmae": "batch"}},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		var details map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&details)
+		So(err, ShouldBeNil)
+		So(details["operation"], ShouldEqual, 2)
+		So(details["table"], ShouldEqual, "test")
+		So(details["unknown_columns"], ShouldResemble, []interface{}{"nmae"})
+	})
+}
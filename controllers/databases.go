@@ -6,11 +6,15 @@ import (
 	"net/http"
 
 	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
 	"github.com/nuveo/prest/statements"
 )
 
 // GetDatabases list all (or filter) databases
 func GetDatabases(w http.ResponseWriter, r *http.Request) {
+	if exposedCount(w, r) {
+		return
+	}
 	requestWhere, values, err := postgres.WhereByRequest(r, 1)
 	if err != nil {
 		log.Println(err)
@@ -25,7 +29,12 @@ func GetDatabases(w http.ResponseWriter, r *http.Request) {
 		sqlDatabases = fmt.Sprint(sqlDatabases, " AND ", requestWhere)
 	}
 
-	order, _ := postgres.OrderByRequest(r)
+	order, err := postgres.OrderByRequest(r, "", "", "", nil)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if order != "" {
 		sqlDatabases = fmt.Sprint(sqlDatabases, order)
 	} else {
@@ -40,6 +49,7 @@ func GetDatabases(w http.ResponseWriter, r *http.Request) {
 
 	sqlDatabases = fmt.Sprint(sqlDatabases, " ", page)
 
+	traceSQL(r, sqlDatabases, values...)
 	object, err := postgres.Query(sqlDatabases, values...)
 	if err != nil {
 		log.Println(err)
@@ -47,5 +57,15 @@ func GetDatabases(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(object)
+	object, err = exposedRows(config.FromContext(r.Context()), object, func(row map[string]interface{}) (string, string, string) {
+		return rowString(row, "datname"), "", ""
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setLinkHeader(w, r, -1)
+	writeRows(w, r, "databases", object)
 }
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/jobs"
+	"github.com/nuveo/prest/storage"
+)
+
+// GetJob return the status of an export/import job
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		log.Println("Unable to parse job in URI")
+		http.Error(w, "Unable to parse job in URI", http.StatusInternalServerError)
+		return
+	}
+
+	info, ok := jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	object, err := json.Marshal(info)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
+
+// ExportFromTable start a job writing the select result as CSV in the
+// configured object storage, the job has a signed URL to download it when done.
+// The configured columns are anonymized with `_anonymize=true`
+func ExportFromTable(w http.ResponseWriter, r *http.Request) {
+	if rlsUnsupported(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("_format")
+	if format != "" && format != "csv" {
+		http.Error(w, fmt.Sprintf("Unsupported export format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.FromContext(r.Context())
+	client, err := storage.New(cfg)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	anonymize := r.URL.Query().Get("_anonymize") == "true"
+	query, values, status, err := selectQuery(r, database, schema, table, anonymize)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	job, err := jobs.New("export")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	key := fmt.Sprintf("%s.%s.%s-%s.csv", database, schema, table, job.Info().ID)
+	if cfg.StoragePrefix != "" {
+		key = fmt.Sprintf("%s/%s", cfg.StoragePrefix, key)
+	}
+	expires := time.Duration(cfg.StorageURLExpires) * time.Second
+
+	go func() {
+		url, err := exportCSV(client, job, key, expires, query, values)
+		if err != nil {
+			log.Printf("export job %s: %v\n", job.Info().ID, err)
+			job.Fail(err)
+			return
+		}
+		job.Done(url)
+	}()
+
+	object, err := json.Marshal(job.Info())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(object)
+}
+
+// exportCSV write the query result in a temporary file and upload it
+func exportCSV(client *storage.Client, job *jobs.Job, key string, expires time.Duration, query string, values []interface{}) (string, error) {
+	tmp, err := ioutil.TempFile("", "prest-export-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rows, err := postgres.QueryCSV(tmp, query, values...)
+	if err != nil {
+		return "", err
+	}
+	job.Progress(rows)
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if err = client.Put(key, tmp, size, "text/csv"); err != nil {
+		return "", err
+	}
+	return client.PresignGet(key, expires)
+}
+
+// ImportInTable start a job loading a CSV or NDJSON object of the configured
+// object storage into a table
+func ImportInTable(w http.ResponseWriter, r *http.Request) {
+	if rlsUnsupported(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "insert", nil); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if ownerUnsupported(w, r, database, schema, table) {
+		return
+	}
+
+	req := api.ImportRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println("ImportInTable:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Key == "" {
+		http.Error(w, "You must inform the object key", http.StatusBadRequest)
+		return
+	}
+
+	readRows := postgres.CSVRows
+	format := req.Format
+	if format == "" && (strings.HasSuffix(req.Key, ".ndjson") || strings.HasSuffix(req.Key, ".jsonl")) {
+		format = "ndjson"
+	}
+	switch format {
+	case "", "csv":
+	case "ndjson":
+		readRows = postgres.NDJSONRows
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported import format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	client, err := storage.New(config.FromContext(r.Context()))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	job, err := jobs.New("import")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		rows, err := importRows(config.FromContext(r.Context()), client, job, req.Key, readRows, database, schema, table)
+		if err != nil {
+			log.Printf("import job %s: %v\n", job.Info().ID, err)
+			job.Fail(err)
+			return
+		}
+		job.Progress(rows)
+		job.Done("")
+	}()
+
+	object, err := json.Marshal(job.Info())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(object)
+}
+
+// importRows stream the object into the table
+func importRows(cfg *config.Prest, client *storage.Client, job *jobs.Job, key string, readRows func(io.Reader) ([]string, postgres.RowReader, error), database, schema, table string) (int64, error) {
+	body, err := client.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	columns, next, err := readRows(body)
+	if err != nil {
+		return 0, err
+	}
+	return postgres.CopyFrom(cfg, database, schema, table, columns, next, job.Progress)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/jobs"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetJob(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/_jobs/{id}", GetJob).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	j, err := jobs.New("export")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Get job status", t, func() {
+		doValidGetRequest(server.URL+"/_jobs/"+j.Info().ID, "GetJob")
+	})
+	Convey("Get unknown job", t, func() {
+		doRequest(server.URL+"/_jobs/notexist", api.Request{}, "GET", 404, "GetJob")
+	})
+}
+
+func TestExportFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_export", ExportFromTable).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Export without storage configured", t, func() {
+		doRequest(server.URL+"/prest/public/test/_export", api.Request{}, "POST", 501, "ExportFromTable")
+	})
+	Convey("Export with unsupported format", t, func() {
+		doRequest(server.URL+"/prest/public/test/_export?_format=parquet", api.Request{}, "POST", 400, "ExportFromTable")
+	})
+}
+
+func TestImportInTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_import", ImportInTable).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Import without object key", t, func() {
+		doRequest(server.URL+"/prest/public/test/_import", api.Request{}, "POST", 400, "ImportInTable")
+	})
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/maintenance"
+)
+
+// Health check if pREST and the database answer, it's answered in
+// maintenance
+func Health(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	health := map[string]interface{}{
+		"status":      "ok",
+		"database":    true,
+		"maintenance": maintenance.Get().Enabled,
+	}
+	if !postgres.Available() {
+		status = http.StatusServiceUnavailable
+		health["status"] = "unavailable"
+		health["database"] = false
+	}
+
+	object, err := json.Marshal(health)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(object)
+}
+
+// GetMaintenance return the state of the maintenance mode
+func GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	writeMaintenance(w)
+}
+
+// SetMaintenance enable or disable the maintenance mode, the message and the
+// Retry-After are the configured ones when not sent
+func SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	var state maintenance.State
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := config.FromContext(r.Context())
+	if state.Message == "" {
+		state.Message = cfg.MaintenanceMessage
+	}
+	if state.RetryAfter == 0 {
+		state.RetryAfter = cfg.MaintenanceRetryAfter
+	}
+	maintenance.Set(state)
+	log.Printf("maintenance mode enabled: %v\n", state.Enabled)
+
+	writeMaintenance(w)
+}
+
+func writeMaintenance(w http.ResponseWriter) {
+	object, err := json.Marshal(maintenance.Get())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/maintenance"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMaintenance(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	router := mux.NewRouter()
+	router.HandleFunc("/_health", Health).Methods("GET")
+	router.HandleFunc("/_maintenance", GetMaintenance).Methods("GET")
+	router.HandleFunc("/_maintenance", SetMaintenance).Methods("PUT")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	do := func(method, key, body string) (*http.Response, error) {
+		req, err := http.NewRequest(method, server.URL+"/_maintenance", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Admin-Key", key)
+		return http.DefaultClient.Do(req)
+	}
+
+	Convey("Maintenance without admin key", t, func() {
+		resp, err := do("PUT", "", `{"enabled": true}`)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		So(maintenance.Get().Enabled, ShouldBeFalse)
+	})
+	Convey("Enable maintenance with the configured message", t, func() {
+		resp, err := do("PUT", "secret", `{"enabled": true}`)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		var state maintenance.State
+		So(json.NewDecoder(resp.Body).Decode(&state), ShouldBeNil)
+		So(state.Enabled, ShouldBeTrue)
+		So(state.Message, ShouldEqual, "Service under maintenance")
+		So(state.RetryAfter, ShouldEqual, 300)
+	})
+	Convey("Health in maintenance", t, func() {
+		resp, err := http.Get(server.URL + "/_health")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		var health map[string]interface{}
+		So(json.NewDecoder(resp.Body).Decode(&health), ShouldBeNil)
+		So(health["maintenance"], ShouldBeTrue)
+	})
+	Convey("Disable maintenance", t, func() {
+		resp, err := do("PUT", "secret", `{"enabled": false, "message": "Done", "retry_after": 60}`)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(maintenance.Get(), ShouldResemble, maintenance.State{Message: "Done", RetryAfter: 60})
+
+		resp, err = do("GET", "secret", "")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+	})
+	Convey("Invalid maintenance state", t, func() {
+		resp, err := do("PUT", "secret", `enabled`)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+	maintenance.Set(maintenance.State{})
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/pool"
+)
+
+// PoolMetrics return the stats of the connection pool, the saturation
+// warnings and the time of the requests by route
+func PoolMetrics(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	if pool.Default == nil {
+		err := errors.New("Pool monitor is not enabled")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	object, err := json.Marshal(pool.Default.Metrics(postgres.PoolStats()))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/pool"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPoolMetrics(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	router := mux.NewRouter()
+	router.HandleFunc("/_pool", PoolMetrics).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	get := func(key string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", server.URL+"/_pool", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Admin-Key", key)
+		return http.DefaultClient.Do(req)
+	}
+
+	Convey("Pool metrics without admin key", t, func() {
+		resp, err := get("")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+	})
+	Convey("Pool metrics without monitor", t, func() {
+		resp, err := get("secret")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+	})
+	Convey("Pool metrics with the routes", t, func() {
+		pool.Default = pool.New(100 * time.Millisecond)
+		pool.Default.Track("GET /{database}/{schema}/{table}", time.Second)
+		resp, err := get("secret")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		var metrics pool.Metrics
+		So(json.NewDecoder(resp.Body).Decode(&metrics), ShouldBeNil)
+		So(metrics.MaxOpenConnections, ShouldEqual, 10)
+		So(len(metrics.Routes), ShouldEqual, 1)
+		pool.Default = nil
+	})
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/usage"
+)
+
+// renderers of the media types of the Accept header
+var renderers = map[string]string{
+	"text/csv":                 "csv",
+	"application/x-ndjson":     "ndjson",
+	"application/vnd.api+json": "jsonapi",
+	xlsxContentType:            "xlsx",
+}
+
+// renderer return the format the client asks for (json, csv, ndjson, jsonapi
+// or xlsx), with
+// `_renderer` or with the Accept header
+func renderer(r *http.Request) string {
+	if format := r.URL.Query().Get("_renderer"); format != "" {
+		return format
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if format, ok := renderers[mediaType]; err == nil && ok {
+			return format
+		}
+	}
+	return "json"
+}
+
+// writeRows write the rows of a select in the format the client asks for,
+// CSV and Excel are downloaded as name.csv and name.xlsx. The rows of a
+// Range request (with Content-Range) are a 206 Partial Content
+func writeRows(w http.ResponseWriter, r *http.Request, name string, object []byte) {
+	if usage.Tracked(r.Context()) {
+		usage.AddRows(r.Context(), countRows(object))
+	}
+	writeHeader := func() {
+		if w.Header().Get("Content-Range") != "" {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+	}
+	var err error
+	switch renderer(r) {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		writeHeader()
+		err = writeCSV(w, object)
+	case "xlsx":
+		var workbook bytes.Buffer
+		if err = writeXLSX(&workbook, name, object); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", xlsxContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xlsx"))
+		writeHeader()
+		w.Write(workbook.Bytes())
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writeHeader()
+		err = writeNDJSON(w, object)
+	case "jsonapi":
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		writeHeader()
+		err = writeJSONAPI(w, name, jsonAPIKeys(r), object)
+	default:
+		writeHeader()
+		w.Write(object)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// streamable check if the rows of a select can be written one by one, only
+// the JSON and ndjson renderers don't need the whole result
+func streamable(r *http.Request) bool {
+	format := renderer(r)
+	return format == "json" || format == "ndjson"
+}
+
+// streamRows run a select writing its rows to the client one by one, the
+// memory doesn't grow with the rows. The errors after the first row can only
+// be logged, the response is incomplete
+func streamRows(w http.ResponseWriter, r *http.Request, session postgres.Session, funcs []postgres.RowFunc, SQL string, values []interface{}) {
+	ndjson := renderer(r) == "ndjson"
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	traceSQL(r, SQL, values...)
+	rows, err := session.QueryStream(w, ndjson, funcs, SQL, values...)
+	if usage.Tracked(r.Context()) {
+		usage.AddRows(r.Context(), rows)
+	}
+	if err != nil {
+		log.Println(err)
+		if _, ok := err.(*postgres.StreamError); !ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// countRows return the number of elements of a JSON array, 1 for other values
+func countRows(object []byte) int64 {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(object, &rows); err != nil {
+		return 1
+	}
+	return int64(len(rows))
+}
+
+// writeNDJSON write a JSON array (or a single object) as a JSON line by
+// element, flushing each one to the client
+func writeNDJSON(w io.Writer, object []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(object))
+	flusher, _ := w.(http.Flusher)
+	var line bytes.Buffer
+	write := func(value json.RawMessage) error {
+		line.Reset()
+		if err := json.Compact(&line, value); err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return write(object)
+	}
+	for dec.More() {
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return err
+		}
+		if err = write(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonAPIResource resource object of the JSON:API documents
+type jsonAPIResource struct {
+	Type       string                     `json:"type"`
+	ID         string                     `json:"id,omitempty"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// jsonAPIKeys return the columns identifying the rows of the request, the
+// primary key of the table or else `id`
+func jsonAPIKeys(r *http.Request) []string {
+	vars := mux.Vars(r)
+	if vars["table"] != "" {
+		pk, err := postgres.PrimaryKey(vars["database"], vars["schema"], vars["table"])
+		if err == nil && len(pk) > 0 {
+			return pk
+		}
+	}
+	return []string{"id"}
+}
+
+// writeJSONAPI write a JSON array of objects (or a single object) as a
+// JSON:API document, each row a resource of the type name with the key
+// columns as id (joined by commas) and the other fields as attributes
+func writeJSONAPI(w io.Writer, name string, keys []string, object []byte) error {
+	resource := func(row map[string]json.RawMessage) jsonAPIResource {
+		ids := make([]string, 0, len(keys))
+		for _, key := range keys {
+			value, ok := row[key]
+			if !ok || string(value) == "null" {
+				ids = nil
+				break
+			}
+			ids = append(ids, csvCell(value))
+		}
+		delete(row, "id")
+		return jsonAPIResource{Type: name, ID: strings.Join(ids, ","), Attributes: row}
+	}
+
+	var document struct {
+		Data interface{} `json:"data"`
+	}
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(object, &rows); err == nil {
+		data := make([]jsonAPIResource, len(rows))
+		for i, row := range rows {
+			data[i] = resource(row)
+		}
+		document.Data = data
+	} else {
+		var row map[string]json.RawMessage
+		if err = json.Unmarshal(object, &row); err != nil || row == nil {
+			return errors.New("JSON:API renderer needs rows of objects")
+		}
+		document.Data = resource(row)
+	}
+	return json.NewEncoder(w).Encode(document)
+}
+
+// writeCSV write a JSON array of objects (or a single object) as CSV, with
+// a header line of the keys of the first object. The rows are written as
+// they are decoded
+func writeCSV(w io.Writer, object []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(object))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	var header []string
+	write := func() error {
+		keys, values, err := csvObject(dec)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			header = keys
+			if err = writer.Write(header); err != nil {
+				return err
+			}
+		}
+		record := make([]string, len(header))
+		for i, key := range header {
+			record[i] = csvCell(values[key])
+		}
+		return writer.Write(record)
+	}
+
+	switch t {
+	case json.Delim('['):
+		for dec.More() {
+			if t, err = dec.Token(); err != nil {
+				return err
+			}
+			if t != json.Delim('{') {
+				return errors.New("CSV renderer needs rows of objects")
+			}
+			if err = write(); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		if err = write(); err != nil {
+			return err
+		}
+	default:
+		return errors.New("CSV renderer needs rows of objects")
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvObject read the keys, in order, and the values of an object, the
+// opening brace already read
+func csvObject(dec *json.Decoder) (keys []string, values map[string]json.RawMessage, err error) {
+	values = make(map[string]json.RawMessage)
+	for dec.More() {
+		var t json.Token
+		if t, err = dec.Token(); err != nil {
+			return
+		}
+		key, _ := t.(string)
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	_, err = dec.Token()
+	return
+}
+
+// csvCell return the text of a JSON value, strings without quotes, null
+// empty and the objects and arrays as JSON
+func csvCell(value json.RawMessage) string {
+	if value == nil || string(value) == "null" {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteCSV(t *testing.T) {
+	Convey("Rows as CSV with a header line", t, func() {
+		var b bytes.Buffer
+		err := writeCSV(&b, []byte(`[{"id":1,"name":"prest, \"the\" API","tags":["a","b"],"note":null},{"id":2,"name":"nuveo","tags":[],"note":"x"}]`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, "id,name,tags,note\n1,\"prest, \"\"the\"\" API\",\"[\"\"a\"\",\"\"b\"\"]\",\n2,nuveo,[],x\n")
+	})
+	Convey("An object as a row", t, func() {
+		var b bytes.Buffer
+		err := writeCSV(&b, []byte(`{"count":10}`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, "count\n10\n")
+	})
+	Convey("Rows of other values", t, func() {
+		var b bytes.Buffer
+		err := writeCSV(&b, []byte(`[1,2]`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	Convey("A JSON line by row", t, func() {
+		w := httptest.NewRecorder()
+		err := writeNDJSON(w, []byte("[{\"id\": 1, \"name\": \"prest\"},\n {\"id\": 2, \"name\": \"nuveo\"}]"))
+		So(err, ShouldBeNil)
+		So(w.Body.String(), ShouldEqual, "{\"id\":1,\"name\":\"prest\"}\n{\"id\":2,\"name\":\"nuveo\"}\n")
+		So(w.Flushed, ShouldBeTrue)
+	})
+	Convey("An object as a line", t, func() {
+		w := httptest.NewRecorder()
+		err := writeNDJSON(w, []byte(`{"count": 10}`))
+		So(err, ShouldBeNil)
+		So(w.Body.String(), ShouldEqual, "{\"count\":10}\n")
+	})
+	Convey("Without rows", t, func() {
+		w := httptest.NewRecorder()
+		err := writeNDJSON(w, []byte(`[]`))
+		So(err, ShouldBeNil)
+		So(w.Body.String(), ShouldEqual, "")
+	})
+}
+
+func TestRenderer(t *testing.T) {
+	Convey("Format requested by the client", t, func() {
+		r := httptest.NewRequest("GET", "/prest/public/test?_renderer=csv", nil)
+		So(renderer(r), ShouldEqual, "csv")
+		r = httptest.NewRequest("GET", "/prest/public/test", nil)
+		r.Header.Set("Accept", "application/json;q=0.9, text/csv")
+		So(renderer(r), ShouldEqual, "csv")
+		r = httptest.NewRequest("GET", "/prest/public/test", nil)
+		r.Header.Set("Accept", "application/x-ndjson")
+		So(renderer(r), ShouldEqual, "ndjson")
+		r = httptest.NewRequest("GET", "/prest/public/test?_renderer=json", nil)
+		r.Header.Set("Accept", "text/csv")
+		So(renderer(r), ShouldEqual, "json")
+		r = httptest.NewRequest("GET", "/prest/public/test", nil)
+		r.Header.Set("Accept", "application/vnd.api+json")
+		So(renderer(r), ShouldEqual, "jsonapi")
+		r = httptest.NewRequest("GET", "/prest/public/test", nil)
+		r.Header.Set("Accept", xlsxContentType)
+		So(renderer(r), ShouldEqual, "xlsx")
+		r = httptest.NewRequest("GET", "/prest/public/test", nil)
+		So(renderer(r), ShouldEqual, "json")
+	})
+}
+
+func TestWriteJSONAPI(t *testing.T) {
+	Convey("Rows as resource objects", t, func() {
+		var b bytes.Buffer
+		err := writeJSONAPI(&b, "test", []string{"id"}, []byte(`[{"id":1,"name":"prest"},{"id":2,"name":null}]`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `{"data":[{"type":"test","id":"1","attributes":{"name":"prest"}},{"type":"test","id":"2","attributes":{"name":null}}]}`+"\n")
+	})
+	Convey("Composite keys joined by commas", t, func() {
+		var b bytes.Buffer
+		err := writeJSONAPI(&b, "test", []string{"code", "year"}, []byte(`[{"code":"br","year":2017,"total":3}]`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `{"data":[{"type":"test","id":"br,2017","attributes":{"code":"br","total":3,"year":2017}}]}`+"\n")
+	})
+	Convey("An object as a resource without key", t, func() {
+		var b bytes.Buffer
+		err := writeJSONAPI(&b, "test", []string{"id"}, []byte(`{"count":10}`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `{"data":{"type":"test","attributes":{"count":10}}}`+"\n")
+	})
+	Convey("Without rows", t, func() {
+		var b bytes.Buffer
+		err := writeJSONAPI(&b, "test", []string{"id"}, []byte(`[]`))
+		So(err, ShouldBeNil)
+		So(b.String(), ShouldEqual, `{"data":[]}`+"\n")
+	})
+	Convey("Rows of other values", t, func() {
+		var b bytes.Buffer
+		err := writeJSONAPI(&b, "test", []string{"id"}, []byte(`[1,2]`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSelectCSV(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Select a table as CSV", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test?_renderer=csv&_select=id,name&_order=id")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/csv")
+		So(resp.Header.Get("Content-Disposition"), ShouldEqual, `attachment; filename="test.csv"`)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldStartWith, "id,name\n")
+	})
+}
+
+func TestSelectRange(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Select rows of a table with Range", t, func() {
+		req, err := http.NewRequest("GET", server.URL+"/prest/public/test?_renderer=csv&_select=id,name&_order=id", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Range", "rows=0-0")
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusPartialContent)
+		So(resp.Header.Get("Accept-Ranges"), ShouldEqual, "rows")
+		So(resp.Header.Get("Content-Range"), ShouldStartWith, "rows 0-0/")
+		So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/csv")
+	})
+	Convey("Range after the last row", t, func() {
+		req, err := http.NewRequest("GET", server.URL+"/prest/public/test?_order=id", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Range", "rows=1000000-")
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+		So(resp.Header.Get("Content-Range"), ShouldStartWith, "rows */")
+	})
+}
+
+func TestWriteRowsRange(t *testing.T) {
+	Convey("Partial content with Content-Range", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Range", "rows 0-1/10")
+		writeRows(w, r, "test", []byte(`[{"id":1},{"id":2}]`))
+		So(w.Code, ShouldEqual, http.StatusPartialContent)
+		So(w.Body.String(), ShouldEqual, `[{"id":1},{"id":2}]`)
+
+		w = httptest.NewRecorder()
+		writeRows(w, r, "test", []byte(`[{"id":1},{"id":2}]`))
+		So(w.Code, ShouldEqual, http.StatusOK)
+	})
+}
+
+func TestStreamable(t *testing.T) {
+	Convey("JSON and ndjson rows are streamed", t, func() {
+		for url, ok := range map[string]bool{
+			"/prest/public/test":                   true,
+			"/prest/public/test?_renderer=ndjson":  true,
+			"/prest/public/test?_renderer=csv":     false,
+			"/prest/public/test?_renderer=jsonapi": false,
+			"/prest/public/test?_renderer=xlsx":    false,
+		} {
+			r, err := http.NewRequest("GET", url, nil)
+			So(err, ShouldBeNil)
+			So(streamable(r), ShouldEqual, ok)
+		}
+	})
+}
+
+func TestCountRows(t *testing.T) {
+	Convey("Rows of the JSON", t, func() {
+		So(countRows([]byte(`[{"id": 1}, {"id": 2}]`)), ShouldEqual, 2)
+		So(countRows([]byte(`[]`)), ShouldEqual, 0)
+		So(countRows([]byte(`{"count": 10}`)), ShouldEqual, 1)
+	})
+}
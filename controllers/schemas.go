@@ -7,11 +7,15 @@ import (
 	"strings"
 
 	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
 	"github.com/nuveo/prest/statements"
 )
 
 // GetSchemas list all (or filter) schemas
 func GetSchemas(w http.ResponseWriter, r *http.Request) {
+	if exposedCount(w, r) {
+		return
+	}
 	requestWhere, values, err := postgres.WhereByRequest(r, 1)
 	if err != nil {
 		log.Println(err)
@@ -29,7 +33,12 @@ func GetSchemas(w http.ResponseWriter, r *http.Request) {
 		sqlSchemas = fmt.Sprint(sqlSchemas, fmt.Sprintf(statements.SchemasGroupBy, statements.FieldSchemaName))
 	}
 
-	order, _ := postgres.OrderByRequest(r)
+	order, err := postgres.OrderByRequest(r, "", "", "", nil)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if order != "" {
 		sqlSchemas = fmt.Sprint(sqlSchemas, order)
 	} else {
@@ -44,6 +53,7 @@ func GetSchemas(w http.ResponseWriter, r *http.Request) {
 
 	sqlSchemas = fmt.Sprint(sqlSchemas, " ", page)
 
+	traceSQL(r, sqlSchemas, values...)
 	object, err := postgres.Query(sqlSchemas, values...)
 	if err != nil {
 		log.Println(err)
@@ -51,5 +61,16 @@ func GetSchemas(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(object)
+	database := config.FromContext(r.Context()).PGDatabase
+	object, err = exposedRows(config.FromContext(r.Context()), object, func(row map[string]interface{}) (string, string, string) {
+		return database, rowString(row, "schema_name"), ""
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setLinkHeader(w, r, -1)
+	writeRows(w, r, "schemas", object)
 }
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/signedurl"
+)
+
+const defaultSignedURLExpires = 3600
+
+// SignURL return a signed URL of the select in the table with the request
+// filters, valid for `_expires` seconds (one hour by default)
+func SignURL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	signKey := config.FromContext(r.Context()).SignKey
+	if signKey == "" {
+		err := errors.New("Signed URLs are not configured")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	seconds := defaultSignedURLExpires
+	if e := r.URL.Query().Get("_expires"); e != "" {
+		var err error
+		seconds, err = strconv.Atoi(e)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "Invalid _expires", http.StatusBadRequest)
+			return
+		}
+	}
+	expires := time.Now().Add(time.Duration(seconds) * time.Second)
+
+	u := *withoutParams(r, "_expires").URL
+	u.Path = fmt.Sprintf("/%s/%s/%s", database, schema, table)
+	signed := signedurl.Sign(signKey, &u, expires)
+
+	object, err := json.Marshal(map[string]interface{}{
+		"url":     signed.String(),
+		"expires": expires.UTC(),
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
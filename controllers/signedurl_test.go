@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/signedurl"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignURL(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/_sign/{database}/{schema}/{table}", SignURL).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Sign URL without sign key", t, func() {
+		doRequest(server.URL+"/_sign/prest/public/test?name=prest", api.Request{}, "GET", 501, "SignURL")
+	})
+	Convey("Sign URL", t, func() {
+		defer config.Set(config.Update(func(cfg *config.Prest) { cfg.SignKey = "secret" }))
+		resp, err := http.Get(server.URL + "/_sign/prest/public/test?name=prest&_expires=60")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+
+		var body struct {
+			URL string `json:"url"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		So(err, ShouldBeNil)
+
+		u, err := url.Parse(body.URL)
+		So(err, ShouldBeNil)
+		So(u.Path, ShouldEqual, "/prest/public/test")
+		So(u.Query().Get("name"), ShouldEqual, "prest")
+		So(signedurl.Verify("secret", u, time.Now()), ShouldBeNil)
+	})
+	Convey("Sign URL with invalid expiration", t, func() {
+		defer config.Set(config.Update(func(cfg *config.Prest) { cfg.SignKey = "secret" }))
+		doRequest(server.URL+"/_sign/prest/public/test?_expires=abc", api.Request{}, "GET", 400, "SignURL")
+	})
+}
@@ -1,16 +1,23 @@
 package controllers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"encoding/json"
 
 	"github.com/gorilla/mux"
 	"github.com/nuveo/prest/adapters/postgres"
 	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/config"
 	"github.com/nuveo/prest/statements"
+	"github.com/nuveo/prest/usage"
 )
 
 // GetTables list all (or filter) tables
@@ -22,7 +29,7 @@ func GetTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order, err := postgres.OrderByRequest(r)
+	order, err := postgres.OrderByRequest(r, "", "", "", nil)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -43,6 +50,15 @@ func GetTables(w http.ResponseWriter, r *http.Request) {
 
 	sqlTables = fmt.Sprint(sqlTables, order)
 
+	page, err := postgres.PaginateIfPossible(r)
+	if err != nil {
+		http.Error(w, "Paging error", http.StatusBadRequest)
+		return
+	}
+
+	sqlTables = fmt.Sprint(sqlTables, " ", page)
+
+	traceSQL(r, sqlTables, values...)
 	object, err := postgres.Query(sqlTables, values...)
 	if err != nil {
 		log.Println(err)
@@ -50,7 +66,18 @@ func GetTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(object)
+	database := config.FromContext(r.Context()).PGDatabase
+	object, err = exposedRows(config.FromContext(r.Context()), object, func(row map[string]interface{}) (string, string, string) {
+		return database, rowString(row, "schema"), rowString(row, "name")
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setLinkHeader(w, r, -1)
+	writeRows(w, r, "tables", object)
 }
 
 // GetTablesByDatabaseAndSchema list all (or filter) tables based on database and schema
@@ -83,7 +110,12 @@ func GetTablesByDatabaseAndSchema(w http.ResponseWriter, r *http.Request) {
 		sqlSchemaTables = fmt.Sprint(sqlSchemaTables, " AND ", requestWhere)
 	}
 
-	order, _ := postgres.OrderByRequest(r)
+	order, err := postgres.OrderByRequest(r, "", "", "", nil)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if order != "" {
 		sqlSchemaTables = fmt.Sprint(sqlSchemaTables, order)
 	} else {
@@ -103,6 +135,7 @@ func GetTablesByDatabaseAndSchema(w http.ResponseWriter, r *http.Request) {
 	valuesAux = append(valuesAux, schema)
 	valuesAux = append(valuesAux, values...)
 
+	traceSQL(r, sqlSchemaTables, valuesAux...)
 	object, err := postgres.Query(sqlSchemaTables, valuesAux...)
 	if err != nil {
 		log.Println(err)
@@ -110,7 +143,17 @@ func GetTablesByDatabaseAndSchema(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(object)
+	object, err = exposedRows(config.FromContext(r.Context()), object, func(row map[string]interface{}) (string, string, string) {
+		return rowString(row, "database"), rowString(row, "schema"), rowString(row, "name")
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setLinkHeader(w, r, -1)
+	writeRows(w, r, schema, object)
 }
 
 // SelectFromTables perform select in database
@@ -135,16 +178,19 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	permission := postgres.TablePermissions(table, "read")
-	if !permission {
-		log.Println("You don't have permission for this action.")
-		http.Error(w, "Unable to parse table in URI", http.StatusMethodNotAllowed)
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
 		return
 	}
 
 	// get selected columns, "*" if empty "_columns"
-	cols := postgres.ColumnsByRequest(r)
-	cols = postgres.FieldsPermissions(table, cols, "read")
+	cols, err := postgres.ReadableFields(config.FromContext(r.Context()), database, schema, table, postgres.ColumnsByRequest(r))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	if len(cols) == 0 {
 		log.Println("You don't have permission for this action. Please check the permitted fields for this table.")
@@ -152,15 +198,57 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	selectStr, _ := postgres.SelectFields(cols)
-	query := fmt.Sprintf("%s %s.%s.%s", selectStr, database, schema, table)
+	cols, aggregates, err := postgres.AggregateByRequest(r, database, schema, table, cols)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bytea, err := postgres.ByteaByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamps, err := postgres.TimestampByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	selectStr, err := postgres.SelectFields(cols, aggregates...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	distinct, err := postgres.DistinctByRequest(r, database, schema, table)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if distinct != "" {
+		selectStr = strings.Replace(selectStr, "SELECT", fmt.Sprint("SELECT ", distinct), 1)
+	}
 
-	countQuery := postgres.CountByRequest(r)
+	query := fmt.Sprintf("%s %s", selectStr, postgres.QuoteTable(database, schema, table))
+
+	countQuery, err := postgres.CountByRequest(r, database, schema, table)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if countQuery != "" {
-		query = fmt.Sprintf("%s %s.%s.%s", countQuery, database, schema, table)
+		query = fmt.Sprintf("%s %s", countQuery, postgres.QuoteTable(database, schema, table))
 	}
 
-	joinValues, err := postgres.JoinByRequest(r)
+	joinValues, joinArgs, err := postgres.JoinByRequest(r, database, schema, 1)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -171,12 +259,34 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 		query = fmt.Sprint(query, j)
 	}
 
-	requestWhere, values, err := postgres.WhereByRequest(r, 1)
+	requestWhere, whereValues, err := postgres.WhereByRequest(r, len(joinArgs)+1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := append(joinArgs, whereValues...)
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	keyset, err := postgres.KeysetByRequest(r, len(values)+1)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if keyset != nil && keyset.Where != "" {
+		if requestWhere != "" {
+			requestWhere = fmt.Sprint(requestWhere, " AND ", keyset.Where)
+		} else {
+			requestWhere = keyset.Where
+		}
+		values = append(values, keyset.Values...)
+	}
 
 	sqlSelect := query
 	if requestWhere != "" {
@@ -186,7 +296,23 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 			requestWhere)
 	}
 
-	order, err := postgres.OrderByRequest(r)
+	groupBy, err := postgres.GroupByRequest(r, database, schema, table)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sqlSelect = fmt.Sprint(sqlSelect, groupBy)
+	sqlTotal := sqlSelect
+
+	tableColumns, err := postgres.TableColumns(database, schema, table)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	order, err := postgres.OrderByRequest(r, database, schema, table, tableColumns)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -196,18 +322,68 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 		sqlSelect = fmt.Sprintf("%s %s", sqlSelect, order)
 	}
 
-	page, err := postgres.PaginateIfPossible(r)
+	rowRange, err := postgres.RowRangeByRequest(r)
 	if err != nil {
-		http.Error(w, "Paging error", http.StatusBadRequest)
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	// the counts and the cursor pages ignore the Range
+	if countQuery != "" || keyset != nil {
+		rowRange = nil
+	}
+
+	var page string
+	switch {
+	case keyset != nil:
+		sqlSelect = fmt.Sprintf("%s LIMIT %d", sqlSelect, keyset.Limit)
+	case rowRange != nil:
+		sqlSelect = fmt.Sprint(sqlSelect, " ", rowRange.Clause())
+	default:
+		page, err = postgres.PaginateIfPossible(r)
+		if err != nil {
+			http.Error(w, "Paging error", http.StatusBadRequest)
+			return
+		}
+		sqlSelect = fmt.Sprint(sqlSelect, " ", page)
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if page != "" && countQuery == "" {
+		filtered := requestWhere != "" || groupBy != "" || distinct != "" || len(joinValues) > 0 || postgres.Aggregated(r)
+		total, err := queryTotal(r, session, database, schema, table, filtered, sqlTotal, values...)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setPaginationHeaders(w, r, total)
+	}
+
+	// the rows are written one by one when nothing needs the whole result
+	if countQuery == "" && keyset == nil && rowRange == nil && streamable(r) {
+		w.Header().Set("Accept-Ranges", "rows")
+		funcs, err := postgres.ResponseRows(config.FromContext(r.Context()), database, schema, table, bytea, timestamps)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		streamRows(w, r, session, funcs, sqlSelect, values)
 		return
 	}
-	sqlSelect = fmt.Sprint(sqlSelect, " ", page)
 
-	runQuery := postgres.Query
+	runQuery := session.Query
 	if countQuery != "" {
-		runQuery = postgres.QueryCount
+		runQuery = session.QueryCount
 	}
 
+	traceSQL(r, sqlSelect, values...)
 	object, err := runQuery(sqlSelect, values...)
 	if err != nil {
 		log.Println(err)
@@ -215,11 +391,70 @@ func SelectFromTables(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Write(object)
+	if rowRange != nil {
+		traceSQL(r, sqlTotal, values...)
+		total, err := session.QueryTotal(sqlTotal, values...)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !setContentRange(w, rowRange, countRows(object), total) {
+			return
+		}
+	}
+
+	if keyset != nil && countQuery == "" {
+		cursor, err := keyset.NextCursor(object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cursor != "" {
+			w.Header().Set("X-Next-Cursor", cursor)
+		}
+	}
+
+	if countQuery == "" {
+		w.Header().Set("Accept-Ranges", "rows")
+		object, err = postgres.CompositeResponse(database, schema, table, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.RangeResponse(database, schema, table, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.ByteaResponse(database, schema, table, bytea, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.TimestampResponse(database, schema, table, timestamps, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.TransformResponse(config.FromContext(r.Context()), database, schema, table, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeRows(w, r, table, object)
 }
 
-// InsertInTables perform insert in specific table
-func InsertInTables(w http.ResponseWriter, r *http.Request) {
+// CountFromTable perform count in a table honoring the request filters
+func CountFromTable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	database, ok := vars["database"]
 	if !ok {
@@ -239,25 +474,64 @@ func InsertInTables(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
 		return
 	}
-	req := api.Request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", postgres.QuoteTable(database, schema, table))
+
+	joinValues, joinArgs, err := postgres.JoinByRequest(r, database, schema, 1)
 	if err != nil {
-		log.Println("InsertInTables:", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, j := range joinValues {
+		query = fmt.Sprint(query, j)
+	}
+
+	requestWhere, whereValues, err := postgres.WhereByRequest(r, len(joinArgs)+1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := append(joinArgs, whereValues...)
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if requestWhere != "" {
+		query = fmt.Sprint(query, " WHERE ", requestWhere)
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	object, err := postgres.Insert(database, schema, table, req)
+
+	traceSQL(r, query, values...)
+	object, err := session.QueryCount(query, values...)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Write(object)
+	writeRows(w, r, table, object)
 }
 
-// DeleteFromTable perform delete sql
-func DeleteFromTable(w http.ResponseWriter, r *http.Request) {
+// ExistsInTable check if there are rows in a table matching the request filters
+func ExistsInTable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	database, ok := vars["database"]
 	if !ok {
@@ -278,25 +552,66 @@ func DeleteFromTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	where, values, err := postgres.WhereByRequest(r, 1)
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := fmt.Sprintf("SELECT 1 FROM %s", postgres.QuoteTable(database, schema, table))
+
+	joinValues, joinArgs, err := postgres.JoinByRequest(r, database, schema, 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, j := range joinValues {
+		query = fmt.Sprint(query, j)
+	}
+
+	requestWhere, whereValues, err := postgres.WhereByRequest(r, len(joinArgs)+1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := append(joinArgs, whereValues...)
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if requestWhere != "" {
+		query = fmt.Sprint(query, " WHERE ", requestWhere)
+	}
+
+	session, err := postgres.SessionByRequest(r)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	object, err := postgres.Delete(database, schema, table, where, values)
+	traceSQL(r, query, values...)
+	object, exists, err := session.QueryExists(query, values...)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+	}
 	w.Write(object)
 }
 
-// UpdateTable perform update table
-func UpdateTable(w http.ResponseWriter, r *http.Request) {
+// AggregateFromTable return grouped aggregates of a table
+func AggregateFromTable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	database, ok := vars["database"]
 	if !ok {
@@ -317,33 +632,74 @@ func UpdateTable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := api.Request{}
-	err := json.NewDecoder(r.Body).Decode(&req)
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	cols, aggregates, groupBy, err := postgres.MetricsByRequest(r, database, schema, table)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	where, values, err := postgres.WhereByRequest(r, 1)
+	selectStr, err := postgres.SelectFields(cols, aggregates...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := fmt.Sprintf("%s %s", selectStr, postgres.QuoteTable(database, schema, table))
+
+	requestWhere, values, err := postgres.WhereByRequest(withoutParams(r, "metrics", "by"), 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if requestWhere != "" {
+		query = fmt.Sprint(query, " WHERE ", requestWhere)
+	}
+	query = fmt.Sprint(query, groupBy)
+
+	order, err := postgres.OrderByRequest(r, "", "", "", nil)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	query = fmt.Sprint(query, order)
 
-	object, err := postgres.Update(database, schema, table, where, values, req)
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, query, values...)
+	object, err := session.Query(query, values...)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Write(object)
+	writeRows(w, r, table, object)
 }
 
-// SelectFromViews
-func SelectFromViews(w http.ResponseWriter, r *http.Request) {
+// DuplicatesFromTable return the groups of rows with the same values in the
+// `by` columns, with the number of rows of each group
+func DuplicatesFromTable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	database, ok := vars["database"]
 	if !ok {
@@ -357,78 +713,1068 @@ func SelectFromViews(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
 		return
 	}
-	view, ok := vars["view"]
+	table, ok := vars["table"]
 	if !ok {
-		log.Println("Unable to parse view in URI")
-		http.Error(w, "Unable to parse view in URI", http.StatusInternalServerError)
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
 		return
 	}
 
-	// get selected columns, "*" if empty "_columns"
-	cols := postgres.ColumnsByRequest(r)
-
-	selectStr, _ := postgres.SelectFields(cols)
-	query := fmt.Sprintf("%s %s.%s.%s", selectStr, database, schema, view)
-
-	countQuery := postgres.CountByRequest(r)
-	if countQuery != "" {
-		query = fmt.Sprintf("%s %s.%s.%s", countQuery, database, schema, view)
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
 	}
 
-	joinValues, err := postgres.JoinByRequest(r)
+	by, err := postgres.DuplicatesByRequest(r, database, schema, table)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	for _, j := range joinValues {
-		query = fmt.Sprint(query, j)
-	}
+	cols := strings.Join(by, ", ")
+	query := fmt.Sprintf("SELECT %s, COUNT(*) AS count FROM %s", cols, postgres.QuoteTable(database, schema, table))
 
-	requestWhere, values, err := postgres.WhereByRequest(r, 1)
+	requestWhere, values, err := postgres.WhereByRequest(withoutParams(r, "by"), 1)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	sqlSelect := query
-	if requestWhere != "" {
-		sqlSelect = fmt.Sprint(
-			query,
-			" WHERE ",
-			requestWhere)
-	}
-
-	order, err := postgres.OrderByRequest(r)
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	if len(order) > 0 {
-		sqlSelect = fmt.Sprintf("%s %s", sqlSelect, order)
+	if requestWhere != "" {
+		query = fmt.Sprint(query, " WHERE ", requestWhere)
 	}
+	query = fmt.Sprintf("%s GROUP BY %s HAVING COUNT(*) > 1 ORDER BY count DESC", query, cols)
 
 	page, err := postgres.PaginateIfPossible(r)
 	if err != nil {
 		http.Error(w, "Paging error", http.StatusBadRequest)
 		return
 	}
-	sqlSelect = fmt.Sprint(sqlSelect, " ", page)
+	query = fmt.Sprint(query, " ", page)
 
-	runQuery := postgres.Query
-	if countQuery != "" {
-		runQuery = postgres.QueryCount
-	}
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, query, values...)
+	object, err := session.Query(query, values...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRows(w, r, table, object)
+}
+
+// ProfileTable return the profile of the table columns (null rate, distinct
+// count, min, max and top values) computed in a sample of rows
+func ProfileTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if ownerUnsupported(w, r, database, schema, table) {
+		return
+	}
+
+	sample, top, err := postgres.ProfileByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	object, err := session.Profile(database, schema, table, sample, top)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
+
+// TimeseriesFromTable return a metric aggregated in continuous time buckets
+func TimeseriesFromTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestWhere, values, err := postgres.WhereByRequest(withoutParams(r, "bucket", "ts", "metric"), 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	from := postgres.QuoteTable(database, schema, table)
+	query, err := postgres.TimeseriesByRequest(r, database, schema, table, from, requestWhere)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, query, values...)
+	object, err := session.Query(query, values...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRows(w, r, table, object)
+}
+
+// InsertInTables perform insert in specific table
+func InsertInTables(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+	req := api.Request{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println("InsertInTables:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = authorize(r, database, schema, table, "insert", bodyColumns(req.Data)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	bytea, err := postgres.ByteaByRequest(r)
+	if err == nil {
+		err = postgres.ByteaRequest(database, schema, table, bytea, req.Data)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamps, err := postgres.TimestampByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Data, err = postgres.OwnerRequest(r, database, schema, table, req.Data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ignore, err := postgres.OnConflictByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, fmt.Sprintf("INSERT INTO %s", postgres.QuoteTable(database, schema, table)), req.Data)
+	representation := preferReturn(r) == "representation"
+	if ignore {
+		insertIgnoring(w, r, session, database, schema, table, bytea, timestamps, req, representation)
+		return
+	}
+	var object []byte
+	err = session.Transaction(func(tx *sql.Tx) (err error) {
+		if representation {
+			object, err = postgres.InsertReturningTx(tx, database, schema, table, req, preferFields(r)...)
+			return
+		}
+		object, err = postgres.InsertTx(tx, database, schema, table, req)
+		return
+	})
+	if err != nil {
+		log.Println(err)
+		if writeUnknownColumns(w, err, 0) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	usage.AddRows(r.Context(), 1)
+
+	object, err = postgres.ByteaResponse(database, schema, table, bytea, object)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	object, err = postgres.TimestampResponse(database, schema, table, timestamps, object)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if representation {
+		w.Header().Set("Preference-Applied", "return=representation")
+	}
+	if writeMinimal(w, r) {
+		return
+	}
+	w.Write(object)
+}
+
+// insertIgnoring insert the row of the request skipping it when it conflicts
+// with an existing one, the response has the rows inserted and skipped (or
+// the inserted row, as a JSON array, with return=representation)
+func insertIgnoring(w http.ResponseWriter, r *http.Request, session postgres.Session, database, schema, table, bytea string, timestamps postgres.TimestampFormat, req api.Request, representation bool) {
+	var object []byte
+	var rowsAffected int64
+	err := session.Transaction(func(tx *sql.Tx) (err error) {
+		object, rowsAffected, err = postgres.InsertIgnoreTx(tx, database, schema, table, req, preferFields(r)...)
+		return
+	})
+	if err != nil {
+		log.Println(err)
+		if writeUnknownColumns(w, err, 0) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	skipped := 1 - rowsAffected
+	if representation {
+		object, err = postgres.ByteaResponse(database, schema, table, bytea, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.TimestampResponse(database, schema, table, timestamps, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Preference-Applied", "return=representation")
+	} else {
+		object, err = json.Marshal(map[string]int64{"rows_affected": rowsAffected, "rows_skipped": skipped})
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("X-Affected-Rows", strconv.FormatInt(rowsAffected, 10))
+	w.Header().Set("X-Skipped-Rows", strconv.FormatInt(skipped, 10))
+	if writeMinimal(w, r) {
+		return
+	}
+	w.Write(object)
+}
+
+// ChangesFromTable return the rows changed since the `since` cursor, for
+// clients keeping a local copy of the table in sync
+func ChangesFromTable(w http.ResponseWriter, r *http.Request) {
+	if rlsUnsupported(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if ownerUnsupported(w, r, database, schema, table) {
+		return
+	}
+
+	limit := 0
+	if size := r.URL.Query().Get("_page_size"); size != "" {
+		var err error
+		limit, err = strconv.Atoi(size)
+		if err != nil {
+			http.Error(w, "Paging error", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := postgres.ChangesSince(config.FromContext(r.Context()), database, schema, table, r.URL.Query().Get("since"), limit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	object, err := json.Marshal(changes)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
+
+// CopyToTable load the CSV of the request body (the header row has the
+// columns) into the table using COPY
+func CopyToTable(w http.ResponseWriter, r *http.Request) {
+	if rlsUnsupported(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/csv" {
+		http.Error(w, "Content-Type must be text/csv", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if err := authorize(r, database, schema, table, "insert", nil); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if ownerUnsupported(w, r, database, schema, table) {
+		return
+	}
+
+	ignore, err := postgres.OnConflictByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns, next, err := postgres.CSVRows(r.Body)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := make(map[string]int64)
+	var rows, skipped int64
+	if ignore {
+		rows, skipped, err = postgres.CopyFromIgnoring(config.FromContext(r.Context()), database, schema, table, columns, next, nil)
+	} else {
+		rows, err = postgres.CopyFrom(config.FromContext(r.Context()), database, schema, table, columns, next, nil)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result["rows_affected"] = rows
+	if ignore {
+		result["rows_skipped"] = skipped
+		w.Header().Set("X-Skipped-Rows", strconv.FormatInt(skipped, 10))
+	}
+
+	object, err := json.Marshal(result)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Affected-Rows", strconv.FormatInt(rows, 10))
+	w.Write(object)
+}
+
+// DeleteByKeysFromTable delete the rows with the primary keys of the body
+// (a JSON array) in a single statement
+func DeleteByKeysFromTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	var keys []interface{}
+	err := json.NewDecoder(r.Body).Decode(&keys)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(keys) == 0 {
+		err = errors.New("Body must be a non empty array of primary keys")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = authorize(r, database, schema, table, "delete", nil); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	where, values, err := postgres.OwnerByRequest(r, database, schema, table, 2)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	traceSQL(r, fmt.Sprintf("DELETE FROM %s WHERE pk = ANY($1)", postgres.QuoteTable(database, schema, table)), keys)
+	var object []byte
+	err = session.Transaction(func(tx *sql.Tx) (err error) {
+		object, err = postgres.DeleteByKeysTx(tx, database, schema, table, keys, where, values)
+		return
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setAffectedRows(w, object)
+	if writeMinimal(w, r) {
+		return
+	}
+	w.Write(object)
+}
+
+// DeleteFromTable perform delete sql
+func DeleteFromTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	where, values, err := postgres.WhereByRequest(r, 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if unfilteredWrite(r, where) {
+		err = errors.New("Refusing to delete all rows without filters, use _force=true")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, values, err = ownedRows(r, database, schema, table, where, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err = authorize(r, database, schema, table, "delete", nil); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, fmt.Sprintf("DELETE FROM %s WHERE %s", postgres.QuoteTable(database, schema, table), where), values...)
+	var object []byte
+	if fields, ok := returningFields(r); ok {
+		var rowsAffected int64
+		err = session.Transaction(func(tx *sql.Tx) (err error) {
+			object, rowsAffected, err = postgres.DeleteReturningTx(tx, database, schema, table, where, values, fields...)
+			return
+		})
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if preferReturn(r) == "representation" {
+			w.Header().Set("Preference-Applied", "return=representation")
+		}
+		w.Header().Set("X-Affected-Rows", strconv.FormatInt(rowsAffected, 10))
+		w.Write(object)
+		return
+	}
+
+	err = session.Transaction(func(tx *sql.Tx) (err error) {
+		object, err = postgres.DeleteTx(tx, database, schema, table, where, values)
+		return
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setAffectedRows(w, object)
+	if writeMinimal(w, r) {
+		return
+	}
+	w.Write(object)
+}
 
-	object, err := runQuery(sqlSelect, values...)
+// UpdateTable perform update table
+func UpdateTable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	table, ok := vars["table"]
+	if !ok {
+		log.Println("Unable to parse table in URI")
+		http.Error(w, "Unable to parse table in URI", http.StatusInternalServerError)
+		return
+	}
+
+	req := api.Request{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	where, values, err := postgres.WhereByRequest(r, 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if unfilteredWrite(r, where) {
+		err = errors.New("Refusing to update all rows without filters, use _force=true")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, values, err = ownedRows(r, database, schema, table, where, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err = authorize(r, database, schema, table, "update", bodyColumns(req.Data)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	bytea, err := postgres.ByteaByRequest(r)
+	if err == nil {
+		err = postgres.ByteaRequest(database, schema, table, bytea, req.Data)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamps, err := postgres.TimestampByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Data, err = postgres.OwnerRequest(r, database, schema, table, req.Data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceSQL(r, fmt.Sprintf("UPDATE %s WHERE %s", postgres.QuoteTable(database, schema, table), where), append(values, req.Data)...)
+	// PUT replaces the whole rows, PATCH only changes the fields in the body
+	update := postgres.UpdateTx
+	if r.Method == "PUT" {
+		update = postgres.ReplaceTx
+	}
+	var object []byte
+	var rowsAffected int64
+	err = session.Transaction(func(tx *sql.Tx) (err error) {
+		object, rowsAffected, err = update(tx, database, schema, table, where, values, req, preferFields(r)...)
+		return
+	})
+	if err != nil {
+		log.Println(err)
+		if writeUnknownColumns(w, err, 0) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	object, err = postgres.ByteaResponse(database, schema, table, bytea, object)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	object, err = postgres.TimestampResponse(database, schema, table, timestamps, object)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("X-Affected-Rows", strconv.FormatInt(rowsAffected, 10))
+	if writeMinimal(w, r) {
+		return
+	}
 	w.Write(object)
 }
+
+// SelectFromViews
+func SelectFromViews(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	database, ok := vars["database"]
+	if !ok {
+		log.Println("Unable to parse database in URI")
+		http.Error(w, "Unable to parse database in URI", http.StatusInternalServerError)
+		return
+	}
+	schema, ok := vars["schema"]
+	if !ok {
+		log.Println("Unable to parse schema in URI")
+		http.Error(w, "Unable to parse schema in URI", http.StatusInternalServerError)
+		return
+	}
+	view, ok := vars["view"]
+	if !ok {
+		log.Println("Unable to parse view in URI")
+		http.Error(w, "Unable to parse view in URI", http.StatusInternalServerError)
+		return
+	}
+
+	if err := authorize(r, database, schema, view, "read", postgres.ColumnsByRequest(r)); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// get selected columns, "*" if empty "_columns"
+	cols, err := postgres.ReadableFields(config.FromContext(r.Context()), database, schema, view, postgres.ColumnsByRequest(r))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(cols) == 0 {
+		log.Println("You don't have permission for this action. Please check the permitted fields for this view.")
+		http.Error(w, "You don't have permission for this action. Please check the permitted fields for this view.", http.StatusUnauthorized)
+		return
+	}
+
+	cols, aggregates, err := postgres.AggregateByRequest(r, database, schema, view, cols)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bytea, err := postgres.ByteaByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamps, err := postgres.TimestampByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	selectStr, err := postgres.SelectFields(cols, aggregates...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	distinct, err := postgres.DistinctByRequest(r, database, schema, view)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if distinct != "" {
+		selectStr = strings.Replace(selectStr, "SELECT", fmt.Sprint("SELECT ", distinct), 1)
+	}
+
+	query := fmt.Sprintf("%s %s", selectStr, postgres.QuoteTable(database, schema, view))
+
+	countQuery, err := postgres.CountByRequest(r, database, schema, view)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if countQuery != "" {
+		query = fmt.Sprintf("%s %s", countQuery, postgres.QuoteTable(database, schema, view))
+	}
+
+	joinValues, joinArgs, err := postgres.JoinByRequest(r, database, schema, 1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, j := range joinValues {
+		query = fmt.Sprint(query, j)
+	}
+
+	requestWhere, whereValues, err := postgres.WhereByRequest(r, len(joinArgs)+1)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := append(joinArgs, whereValues...)
+	requestWhere, values, err = ownedRows(r, database, schema, view, requestWhere, values)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	sqlSelect := query
+	if requestWhere != "" {
+		sqlSelect = fmt.Sprint(
+			query,
+			" WHERE ",
+			requestWhere)
+	}
+
+	groupBy, err := postgres.GroupByRequest(r, database, schema, view)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sqlSelect = fmt.Sprint(sqlSelect, groupBy)
+	sqlTotal := sqlSelect
+
+	tableColumns, err := postgres.TableColumns(database, schema, view)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	order, err := postgres.OrderByRequest(r, database, schema, view, tableColumns)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(order) > 0 {
+		sqlSelect = fmt.Sprintf("%s %s", sqlSelect, order)
+	}
+
+	rowRange, err := postgres.RowRangeByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	// the counts ignore the Range
+	if countQuery != "" {
+		rowRange = nil
+	}
+
+	var page string
+	if rowRange != nil {
+		sqlSelect = fmt.Sprint(sqlSelect, " ", rowRange.Clause())
+	} else {
+		page, err = postgres.PaginateIfPossible(r)
+		if err != nil {
+			http.Error(w, "Paging error", http.StatusBadRequest)
+			return
+		}
+		sqlSelect = fmt.Sprint(sqlSelect, " ", page)
+	}
+
+	session, err := postgres.SessionByRequest(r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if page != "" && countQuery == "" {
+		filtered := requestWhere != "" || groupBy != "" || distinct != "" || len(joinValues) > 0 || postgres.Aggregated(r)
+		total, err := queryTotal(r, session, database, schema, view, filtered, sqlTotal, values...)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setPaginationHeaders(w, r, total)
+	}
+
+	// the rows are written one by one when nothing needs the whole result
+	if countQuery == "" && rowRange == nil && streamable(r) {
+		w.Header().Set("Accept-Ranges", "rows")
+		funcs, err := postgres.ResponseRows(config.FromContext(r.Context()), database, schema, view, bytea, timestamps)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		streamRows(w, r, session, funcs, sqlSelect, values)
+		return
+	}
+
+	runQuery := session.Query
+	if countQuery != "" {
+		runQuery = session.QueryCount
+	}
+
+	traceSQL(r, sqlSelect, values...)
+	object, err := runQuery(sqlSelect, values...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rowRange != nil {
+		traceSQL(r, sqlTotal, values...)
+		total, err := session.QueryTotal(sqlTotal, values...)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !setContentRange(w, rowRange, countRows(object), total) {
+			return
+		}
+	}
+
+	if countQuery == "" {
+		w.Header().Set("Accept-Ranges", "rows")
+		object, err = postgres.CompositeResponse(database, schema, view, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.RangeResponse(database, schema, view, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.ByteaResponse(database, schema, view, bytea, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.TimestampResponse(database, schema, view, timestamps, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		object, err = postgres.TransformResponse(config.FromContext(r.Context()), database, schema, view, object)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeRows(w, r, view, object)
+}
@@ -1,11 +1,17 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/adapters/postgres"
 	"github.com/nuveo/prest/api"
 	"github.com/nuveo/prest/config"
 	. "github.com/smartystreets/goconvey/convey"
@@ -82,6 +88,239 @@ func TestSelectFromTables(t *testing.T) {
 	Convey("execute select in a table with select *", t, func() {
 		doValidGetRequest(server.URL+"/prest/public/test5?_select=*", "SelectFromTables")
 	})
+	Convey("execute select in a table with order by unknown column", t, func() {
+		doRequest(server.URL+"/prest/public/test?_order=notexist", api.Request{}, "GET", 400, "SelectFromTables")
+	})
+	Convey("execute select in a table with a qualified masked column", t, func() {
+		doRequest(server.URL+"/prest/public/test_masked?_select=test_masked.password_hash", api.Request{}, "GET", 401, "SelectFromTables")
+	})
+	Convey("execute select in a table filtered by a masked column", t, func() {
+		doRequest(server.URL+"/prest/public/test_masked?password_hash=$regex.^a", api.Request{}, "GET", 405, "SelectFromTables")
+	})
+	Convey("execute select in a table with distinct", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test?_select=name&_distinct=true", "SelectFromTables")
+	})
+	Convey("execute select in a table with distinct on", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test?_distinct_on=name&_order=name", "SelectFromTables")
+	})
+	Convey("execute select in a table with group by", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test?_select=name&_groupby=name", "SelectFromTables")
+	})
+	Convey("execute select in a table with sum and group by", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test?_sum=id&_groupby=name", "SelectFromTables")
+	})
+}
+
+func TestSelectBytea(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) { cfg.AccessConf.Restrict = false })
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	router.HandleFunc("/{database}/{schema}/{table}", InsertInTables).Methods("POST")
+	server := httptest.NewServer(withConfig(router, cfg))
+	defer server.Close()
+
+	Convey("Select bytea columns in base64", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test_bytea?id=1&_bytea=base64")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, `[{"content":"AQL/","id":1}]`)
+	})
+	Convey("Insert bytea columns in hex", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test_bytea?_bytea=hex", "application/json", strings.NewReader(`{"data": {"content": "cafe"}}`))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		var row map[string]interface{}
+		So(json.NewDecoder(resp.Body).Decode(&row), ShouldBeNil)
+		So(row["content"], ShouldEqual, "cafe")
+	})
+	Convey("Invalid bytea encoding", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test_bytea?_bytea=octal")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+		resp, err = http.Post(server.URL+"/prest/public/test_bytea?_bytea=base64", "application/json", strings.NewReader(`{"data": {"content": "not base64"}}`))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
+
+func TestOwnedTable(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) {
+		cfg.AccessConf.Tables = append([]config.TablesConf{{Name: "test3", Owner: "name", Permissions: []string{"read", "write", "delete"}, Fields: []string{"*"}}}, cfg.AccessConf.Tables...)
+	})
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	router.HandleFunc("/{database}/{schema}/{table}", InsertInTables).Methods("POST")
+	router.HandleFunc("/{database}/{schema}/{table}/_changes", ChangesFromTable).Methods("GET")
+	serve := func(method, url, body, subject string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if subject != "" {
+			r = r.WithContext(postgres.WithOwner(r.Context(), subject))
+		}
+		w := httptest.NewRecorder()
+		withConfig(router, cfg).ServeHTTP(w, r)
+		return w
+	}
+
+	Convey("Select only the rows of the subject", t, func() {
+		w := serve("GET", "/prest/public/test3", "", "prest")
+		So(w.Code, ShouldEqual, 200)
+		var rows []map[string]interface{}
+		So(json.Unmarshal(w.Body.Bytes(), &rows), ShouldBeNil)
+		So(rows, ShouldNotBeEmpty)
+		for _, row := range rows {
+			So(row["name"], ShouldEqual, "prest")
+		}
+	})
+	Convey("Insert with the owner of the subject", t, func() {
+		w := serve("POST", "/prest/public/test3", `{"data": {"name": "someone else"}}`, "owner_insert01")
+		So(w.Code, ShouldEqual, 200)
+		var row map[string]interface{}
+		So(json.Unmarshal(w.Body.Bytes(), &row), ShouldBeNil)
+		So(row["name"], ShouldEqual, "owner_insert01")
+	})
+	Convey("Requests without subject are refused", t, func() {
+		w := serve("GET", "/prest/public/test3", "", "")
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+		w = serve("GET", "/prest/public/test3/_changes?since=0", "", "prest")
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
+
+func TestSelectFromTablesPaginationHeaders(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute select in a table with pagination returns total headers", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test?_page=1&_page_size=1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("X-Total-Count"), ShouldNotBeEmpty)
+		So(resp.Header.Get("X-Total-Pages"), ShouldNotBeEmpty)
+		So(resp.Header.Get("X-Page"), ShouldEqual, "1")
+		So(resp.Header.Get("Link"), ShouldContainSubstring, `rel="first"`)
+	})
+}
+
+func TestSelectFromTablesCursor(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute select in a table with cursor pagination", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test?_order=id&_cursor=true&_page_size=1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		cursor := resp.Header.Get("X-Next-Cursor")
+		So(cursor, ShouldNotBeEmpty)
+
+		doValidGetRequest(server.URL+"/prest/public/test?_order=id&_page_size=1&_after="+cursor, "SelectFromTables")
+	})
+	Convey("execute select in a table with cursor pagination without order", t, func() {
+		doRequest(server.URL+"/prest/public/test?_cursor=true", api.Request{}, "GET", 400, "SelectFromTables")
+	})
+	Convey("execute select in a table with cursor page sizes below 1", t, func() {
+		doRequest(server.URL+"/prest/public/test?_order=id&_cursor=true&_page_size=0", api.Request{}, "GET", 400, "SelectFromTables")
+		doRequest(server.URL+"/prest/public/test?_order=id&_cursor=true&_page_size=-1", api.Request{}, "GET", 400, "SelectFromTables")
+	})
+}
+
+func TestCountFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_count", CountFromTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute count in a table without custom where clause", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test/_count", "CountFromTable")
+	})
+	Convey("execute count in a table with custom where clause", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test/_count?name=nuveo", "CountFromTable")
+	})
+	Convey("execute count in a table with invalid where clause", t, func() {
+		doRequest(server.URL+"/prest/public/test/_count?0name=nuveo", api.Request{}, "GET", 400, "CountFromTable")
+	})
+}
+
+func TestExistsInTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_exists", ExistsInTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute exists in a table with matching rows", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test/_exists?name=tester02", "ExistsInTable")
+	})
+	Convey("execute exists in a table without matching rows", t, func() {
+		doRequest(server.URL+"/prest/public/test/_exists?name=notexist", api.Request{}, "GET", 404, "ExistsInTable")
+	})
+	Convey("execute exists in a table with invalid where clause", t, func() {
+		doRequest(server.URL+"/prest/public/test/_exists?0name=nuveo", api.Request{}, "GET", 400, "ExistsInTable")
+	})
+}
+
+func TestAggregateFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_aggregate", AggregateFromTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute aggregate in a table", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test2/_aggregate?metrics=sum:number,count:*&by=name", "AggregateFromTable")
+	})
+	Convey("execute aggregate in a table with filter", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test2/_aggregate?metrics=count:*&by=name&name=tester02", "AggregateFromTable")
+	})
+	Convey("execute aggregate in a table without metrics", t, func() {
+		doRequest(server.URL+"/prest/public/test2/_aggregate?by=name", api.Request{}, "GET", 400, "AggregateFromTable")
+	})
+}
+
+func TestDuplicatesFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_duplicates", DuplicatesFromTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute duplicates in a table", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test/_duplicates?by=name", "DuplicatesFromTable")
+	})
+	Convey("execute duplicates in a table with filter", t, func() {
+		doValidGetRequest(server.URL+"/prest/public/test/_duplicates?by=name&name=prest", "DuplicatesFromTable")
+	})
+	Convey("execute duplicates in a table without by", t, func() {
+		doRequest(server.URL+"/prest/public/test/_duplicates", api.Request{}, "GET", 400, "DuplicatesFromTable")
+	})
+	Convey("execute duplicates by a field without permission", t, func() {
+		doRequest(server.URL+"/prest/public/test_list_only_id/_duplicates?by=name", api.Request{}, "GET", 400, "DuplicatesFromTable")
+	})
+}
+
+func TestProfileTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/_profile/{database}/{schema}/{table}", ProfileTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+	Convey("execute profile of a table", t, func() {
+		doValidGetRequest(server.URL+"/_profile/prest/public/test", "ProfileTable")
+	})
+	Convey("execute profile of a table with sample and top", t, func() {
+		doValidGetRequest(server.URL+"/_profile/prest/public/test?_sample=10&_top=2", "ProfileTable")
+	})
+	Convey("execute profile with invalid sample", t, func() {
+		doRequest(server.URL+"/_profile/prest/public/test?_sample=0", api.Request{}, "GET", 400, "ProfileTable")
+	})
+	Convey("execute profile of a table without permission", t, func() {
+		doRequest(server.URL+"/_profile/prest/public/test_write_and_delete_access", api.Request{}, "GET", 405, "ProfileTable")
+	})
 }
 
 func TestInsertInTables(t *testing.T) {
@@ -101,20 +340,185 @@ func TestInsertInTables(t *testing.T) {
 
 		doValidPostRequest(server.URL+"/prest/public/test", r, "InsertInTables")
 	})
+	Convey("execute insert in a table with Prefer return=representation", t, func() {
+		resp, err := doPreferRequest("POST", server.URL+"/prest/public/test", "return=representation")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("Preference-Applied"), ShouldEqual, "return=representation")
+
+		var rows []map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&rows)
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldEqual, 1)
+		So(rows[0]["name"], ShouldEqual, "prest")
+	})
+	Convey("execute insert in a table skipping the conflicting row", t, func() {
+		resp, err := doPreferRequest("POST", server.URL+"/prest/public/test3?_on_conflict=ignore", "")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("X-Skipped-Rows"), ShouldEqual, "1")
+
+		var result map[string]int64
+		So(json.NewDecoder(resp.Body).Decode(&result), ShouldBeNil)
+		So(result, ShouldResemble, map[string]int64{"rows_affected": 0, "rows_skipped": 1})
+	})
+	Convey("execute insert in a table with Prefer return=minimal", t, func() {
+		resp, err := doPreferRequest("POST", server.URL+"/prest/public/test", "return=minimal")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+	})
+}
+
+func TestChangesFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_changes", ChangesFromTable).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Changes of a table since the beginning and since the cursor", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test/_changes?_page_size=1")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+
+		var changes postgres.Changes
+		err = json.NewDecoder(resp.Body).Decode(&changes)
+		So(err, ShouldBeNil)
+		So(len(changes.Upserts), ShouldEqual, 1)
+		So(changes.Cursor, ShouldNotBeEmpty)
+
+		doValidGetRequest(server.URL+"/prest/public/test/_changes?since="+changes.Cursor, "ChangesFromTable")
+	})
+	Convey("Changes with invalid cursor", t, func() {
+		doRequest(server.URL+"/prest/public/test/_changes?since=invalid", api.Request{}, "GET", 400, "ChangesFromTable")
+	})
+	Convey("Changes of a table without permission", t, func() {
+		doRequest(server.URL+"/prest/public/test_write_and_delete_access/_changes", api.Request{}, "GET", 405, "ChangesFromTable")
+	})
+}
+
+func TestCopyToTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_copy", CopyToTable).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Copy CSV into a table", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test/_copy", "text/csv", strings.NewReader("name\ncopy01\ncopy02\n"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("X-Affected-Rows"), ShouldEqual, "2")
+	})
+	Convey("Copy with another content type", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test/_copy", "application/json", strings.NewReader("{}"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusUnsupportedMediaType)
+	})
+	Convey("Copy into a table without permission", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test_readonly_access/_copy", "text/csv", strings.NewReader("name\ncopy01\n"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+	Convey("Copy CSV with unknown column", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test/_copy", "text/csv", strings.NewReader("notexist\ncopy01\n"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("Copy CSV skipping the conflicting rows", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test3/_copy?_on_conflict=ignore", "text/csv", strings.NewReader("name\nprest\ncopy_unique02\n"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("X-Affected-Rows"), ShouldEqual, "1")
+		So(resp.Header.Get("X-Skipped-Rows"), ShouldEqual, "1")
+
+		var result map[string]int64
+		So(json.NewDecoder(resp.Body).Decode(&result), ShouldBeNil)
+		So(result, ShouldResemble, map[string]int64{"rows_affected": 1, "rows_skipped": 1})
+	})
+	Convey("Copy with invalid conflict option", t, func() {
+		resp, err := http.Post(server.URL+"/prest/public/test3/_copy?_on_conflict=update", "text/csv", strings.NewReader("name\nprest\n"))
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+}
+
+func TestDeleteByKeysFromTable(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}/_batch", DeleteByKeysFromTable).Methods("DELETE")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	doDelete := func(url, body string) *http.Response {
+		req, err := http.NewRequest("DELETE", url, strings.NewReader(body))
+		So(err, ShouldBeNil)
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		return resp
+	}
+
+	Convey("Delete rows by primary keys", t, func() {
+		resp := doDelete(server.URL+"/prest/public/test_put/_batch", "[2, 3]")
+		So(resp.StatusCode, ShouldEqual, 200)
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(string(body), ShouldEqual, `{"rows_affected":2}`)
+	})
+	Convey("Delete by primary keys with empty array", t, func() {
+		resp := doDelete(server.URL+"/prest/public/test_put/_batch", "[]")
+		So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+	})
+	Convey("Delete by primary keys in a table without primary key", t, func() {
+		resp := doDelete(server.URL+"/prest/public/test/_batch", "[1]")
+		So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+	})
+}
+
+func doPreferRequest(method, url, prefer string) (*http.Response, error) {
+	byt, err := json.Marshal(api.Request{Data: map[string]interface{}{"name": "prest"}})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(byt))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Prefer", prefer)
+	return http.DefaultClient.Do(req)
 }
 
 func TestDeleteFromTable(t *testing.T) {
 	config.InitConf()
 	router := mux.NewRouter()
 	router.HandleFunc("/{database}/{schema}/{table}", DeleteFromTable).Methods("DELETE")
+	router.HandleFunc("/{database}/{schema}/{table}", InsertInTables).Methods("POST")
 	server := httptest.NewServer(router)
 	defer server.Close()
 	Convey("excute delete in a table without where clause", t, func() {
-		doValidDeleteRequest(server.URL+"/prest/public/test", "DeleteFromTable")
+		doRequest(server.URL+"/prest/public/test", api.Request{}, "DELETE", 400, "DeleteFromTable")
+	})
+	Convey("excute delete in a table without where clause using _force", t, func() {
+		doValidDeleteRequest(server.URL+"/prest/public/test?_force=true", "DeleteFromTable")
 	})
 	Convey("excute delete in a table with where clause", t, func() {
 		doValidDeleteRequest(server.URL+"/prest/public/test?name=nuveo", "DeleteFromTable")
 	})
+	Convey("execute delete in a table returning the deleted rows", t, func() {
+		resp, err := doPreferRequest("POST", server.URL+"/prest/public/test", "")
+		So(err, ShouldBeNil)
+		resp, err = doPreferRequest("DELETE", server.URL+"/prest/public/test?name=prest&_returning=name", "")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("Preference-Applied"), ShouldBeEmpty)
+
+		var rows []map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&rows)
+		So(err, ShouldBeNil)
+		So(len(rows), ShouldBeGreaterThan, 0)
+		So(rows[0], ShouldResemble, map[string]interface{}{"name": "prest"})
+		So(resp.Header.Get("X-Affected-Rows"), ShouldEqual, strconv.Itoa(len(rows)))
+	})
 }
 
 func TestUpdateFromTable(t *testing.T) {
@@ -132,17 +536,37 @@ func TestUpdateFromTable(t *testing.T) {
 	}
 
 	Convey("excute update in a table without where clause using PUT", t, func() {
-		doValidPutRequest(server.URL+"/prest/public/test", r, "UpdateTable")
+		doRequest(server.URL+"/prest/public/test", r, "PUT", 400, "UpdateTable")
+	})
+	Convey("excute update in a table without where clause using PUT and _force", t, func() {
+		doValidPutRequest(server.URL+"/prest/public/test?_force=true", r, "UpdateTable")
 	})
 	Convey("excute update in a table with where clause using PUT", t, func() {
 		doValidPutRequest(server.URL+"/prest/public/test?name=nuveo", r, "UpdateTable")
 	})
 	Convey("excute update in a table without where clause using PATCH", t, func() {
-		doValidPatchRequest(server.URL+"/prest/public/test", r, "UpdateTable")
+		doRequest(server.URL+"/prest/public/test", r, "PATCH", 400, "UpdateTable")
+	})
+	Convey("excute update in a table without where clause using PATCH and _force", t, func() {
+		doValidPatchRequest(server.URL+"/prest/public/test?_force=true", r, "UpdateTable")
 	})
 	Convey("excute update in a table with where clause using PATCH", t, func() {
 		doValidPatchRequest(server.URL+"/prest/public/test?name=nuveo", r, "UpdateTable")
 	})
+	Convey("excute update in a table returns the affected rows", t, func() {
+		byt, err := json.Marshal(r)
+		So(err, ShouldBeNil)
+		req, err := http.NewRequest("PATCH", server.URL+"/prest/public/test?name=prest", bytes.NewBuffer(byt))
+		So(err, ShouldBeNil)
+		resp, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+
+		var rows []map[string]interface{}
+		err = json.NewDecoder(resp.Body).Decode(&rows)
+		So(err, ShouldBeNil)
+		So(resp.Header.Get("X-Affected-Rows"), ShouldEqual, strconv.Itoa(len(rows)))
+	})
 }
 
 func TestSelectFromViews(t *testing.T) {
@@ -187,7 +611,7 @@ func TestSelectFromViews(t *testing.T) {
 	r := api.Request{}
 
 	Convey("execute select in a view with a column invalid", t, func() {
-		doRequest(server.URL+"/_VIEW/prest/public/view_test?_select=celphone", r, "GET", 500, "SelectFromViews")
+		doRequest(server.URL+"/_VIEW/prest/public/view_test?_select=celphone", r, "GET", 401, "SelectFromViews")
 	})
 
 	Convey("execute select in a view with where and column invalid", t, func() {
@@ -198,7 +622,17 @@ func TestSelectFromViews(t *testing.T) {
 		doRequest(server.URL+"/_VIEW/prest/public/view_test?_join=inner:test2.name:eq:view_test.player", r, "GET", 400, "SelectFromViews")
 	})
 
+	Convey("execute select in a view without read permission", t, func() {
+		doRequest(server.URL+"/_VIEW/prest/public/view_test_no_access", r, "GET", 405, "SelectFromViews")
+	})
+
 	Convey("execute select in a view with custom where clause and pagination invalid", t, func() {
 		doRequest(server.URL+"/_VIEW/prest/public/view_test?player=gopher&_page=A&_page_size=20", r, "GET", 400, "SelectFromViews")
 	})
+
+	Convey("execute select in a view with pages or page sizes below 1", t, func() {
+		doRequest(server.URL+"/_VIEW/prest/public/view_test?_page=0", r, "GET", 400, "SelectFromViews")
+		doRequest(server.URL+"/_VIEW/prest/public/view_test?_page=-1&_page_size=20", r, "GET", 400, "SelectFromViews")
+		doRequest(server.URL+"/_VIEW/prest/public/view_test?_page=1&_page_size=0", r, "GET", 400, "SelectFromViews")
+	})
 }
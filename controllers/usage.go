@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/nuveo/prest/usage"
+)
+
+// Usage return the requests and rows of each API key
+func Usage(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		log.Println("You don't have permission for this action.")
+		http.Error(w, "You don't have permission for this action.", http.StatusForbidden)
+		return
+	}
+
+	if usage.Default == nil {
+		err := errors.New("Usage tracking is not enabled")
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	object, err := json.Marshal(usage.Default.Usage())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(object)
+}
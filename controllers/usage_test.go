@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/usage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUsage(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	router := mux.NewRouter()
+	router.HandleFunc("/_usage", Usage).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	do := func(key string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", server.URL+"/_usage", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Admin-Key", key)
+		return http.DefaultClient.Do(req)
+	}
+
+	Convey("Usage without admin key", t, func() {
+		resp, err := do("")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+	})
+	Convey("Usage not enabled", t, func() {
+		resp, err := do("secret")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusNotImplemented)
+	})
+	Convey("Usage of the API keys", t, func() {
+		tracker, err := usage.New("")
+		So(err, ShouldBeNil)
+		tracker.Track("reports", 10)
+		usage.Default = tracker
+		defer func() { usage.Default = nil }()
+
+		resp, err := do("secret")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+		var result []usage.Usage
+		So(json.NewDecoder(resp.Body).Decode(&result), ShouldBeNil)
+		So(result, ShouldResemble, []usage.Usage{{Key: "reports", Requests: 1, Rows: 10}})
+	})
+}
@@ -0,0 +1,441 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nuveo/prest/adapters/postgres"
+	"github.com/nuveo/prest/authz"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/counts"
+)
+
+// isAdminRequest check the X-Admin-Key header against the configured admin
+// key, admin endpoints are disabled when there is no key
+func isAdminRequest(r *http.Request) bool {
+	key := config.FromContext(r.Context()).AdminKey
+	if key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(key)) == 1
+}
+
+// errPermission is the error of the table permissions of the config
+var errPermission = errors.New("You don't have permission for this action.")
+
+// authorize check if the principal of the request can run the action in the
+// columns of the table (and the joined tables of reads) with the authorizer,
+// or the table permissions of the config when there is none. The masked
+// columns never filter the rows
+func authorize(r *http.Request, database, schema, table, action string, columns []string) error {
+	if err := postgres.MaskedByRequest(r, database, schema, table); err != nil {
+		return err
+	}
+	// with the authorizer only the rules of the API key are checked
+	if !postgres.TablePermissions(config.FromContext(r.Context()), database, schema, table, action) {
+		return errPermission
+	}
+	if authz.Default == nil {
+		return nil
+	}
+	principal := authz.FromContext(r.Context())
+	if err := authz.Default.Authorize(principal, database, schema, table, action, columns); err != nil {
+		return err
+	}
+	// the joined tables are read with the table, invalid joins fail later
+	if action == "read" {
+		for _, j := range r.URL.Query()["_join"] {
+			args := strings.SplitN(j, ":", 3)
+			if len(args) < 2 {
+				continue
+			}
+			if err := authz.Default.Authorize(principal, database, schema, args[1], "read", []string{"*"}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bodyColumns return the columns of the body of an insert or update, sorted
+func bodyColumns(data map[string]interface{}) []string {
+	cols := make([]string, 0, len(data))
+	for col := range data {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// exposedRows remove the rows of a listing not exposed by the config, name
+// return the database, schema and table (empty in the listings of databases
+// and schemas) of a row
+func exposedRows(cfg *config.Prest, object []byte, name func(row map[string]interface{}) (database, schema, table string)) ([]byte, error) {
+	if !cfg.HidesTables() {
+		return object, nil
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(object, &rows); err != nil {
+		return nil, err
+	}
+	exposed := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if cfg.Exposed(name(row)) {
+			exposed = append(exposed, row)
+		}
+	}
+	return json.Marshal(exposed)
+}
+
+// exposedCount refuse the counts of the listings when the exposed tables are
+// configured, the hidden ones would be counted
+func exposedCount(w http.ResponseWriter, r *http.Request) bool {
+	if !config.FromContext(r.Context()).HidesTables() || r.URL.Query().Get("_count") == "" {
+		return false
+	}
+	http.Error(w, "_count is not available with exposed tables", http.StatusBadRequest)
+	return true
+}
+
+// rowString return the string value of the key of a row
+func rowString(row map[string]interface{}, key string) string {
+	v, _ := row[key].(string)
+	return v
+}
+
+// withoutParams return a copy of the request without the query string keys,
+// used when an endpoint has parameters that must not be parsed as filters
+func withoutParams(r *http.Request, keys ...string) *http.Request {
+	queries := r.URL.Query()
+	for _, k := range keys {
+		queries.Del(k)
+	}
+
+	u := *r.URL
+	u.RawQuery = queries.Encode()
+
+	req := r.WithContext(r.Context())
+	req.URL = &u
+	return req
+}
+
+// unfilteredWrite check if a delete or update would change the whole table,
+// which is refused (when enabled) unless the request has `_force=true`
+func unfilteredWrite(r *http.Request, where string) bool {
+	if !config.FromContext(r.Context()).RequireWhere || where != "" {
+		return false
+	}
+	return r.URL.Query().Get("_force") != "true"
+}
+
+// rlsUnsupported refuse the endpoints that don't run with the role of the
+// principal, they would bypass the row level security policies
+func rlsUnsupported(w http.ResponseWriter, r *http.Request) bool {
+	if role, _ := postgres.RoleFromContext(r.Context()); role == "" {
+		return false
+	}
+	http.Error(w, "Not available with row level security", http.StatusForbidden)
+	return true
+}
+
+// ownerUnsupported refuse the endpoints that can't limit the rows of the
+// tables with an owner column to the ones of the subject
+func ownerUnsupported(w http.ResponseWriter, r *http.Request, database, schema, table string) bool {
+	if postgres.OwnerColumn(config.FromContext(r.Context()), database, schema, table) == "" {
+		return false
+	}
+	http.Error(w, "Not available for tables with an owner column", http.StatusForbidden)
+	return true
+}
+
+// ownedRows add the filter of the rows owned by the subject of the request
+// to the where of the table, its value is bound after the others
+func ownedRows(r *http.Request, database, schema, table, where string, values []interface{}) (string, []interface{}, error) {
+	owned, ownedValues, err := postgres.OwnerByRequest(r, database, schema, table, len(values)+1)
+	if err != nil || owned == "" {
+		return where, values, err
+	}
+	if where != "" {
+		owned = fmt.Sprint(where, " AND ", owned)
+	}
+	return owned, append(values, ownedValues...), nil
+}
+
+// traceSQL log the statement and its parameters when an admin request has
+// `_trace_sql=true`, to reproduce issues of a single request
+func traceSQL(r *http.Request, SQL string, values ...interface{}) {
+	if r.URL.Query().Get("_trace_sql") != "true" || !isAdminRequest(r) {
+		return
+	}
+	log.Printf("[trace] %s %s: %s %v\n", r.Method, r.URL.Path, SQL, values)
+}
+
+// preferReturn return the `return` preference (representation or minimal)
+// of the Prefer header, empty when it isn't informed
+func preferReturn(r *http.Request) string {
+	return preferences(r)["return"]
+}
+
+// preferFields return the columns of the `fields` preference
+// (e.g. `Prefer: return=representation, fields="id,name"`) used to restrict
+// the rows returned by writes
+func preferFields(r *http.Request) (fields []string) {
+	for _, f := range strings.Split(preferences(r)["fields"], ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return
+}
+
+// returningFields return the columns of the rows returned by a write, of
+// `_returning` (`*` is all the readable ones) or of the Prefer header. ok is
+// false when the request doesn't ask for the rows
+func returningFields(r *http.Request) (fields []string, ok bool) {
+	values, ok := r.URL.Query()["_returning"]
+	if !ok {
+		return preferFields(r), preferReturn(r) == "representation"
+	}
+	for _, v := range values {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" {
+				return nil, true
+			} else if f != "" {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return
+}
+
+// preferences parse the Prefer headers, values can be quoted to have commas
+func preferences(r *http.Request) map[string]string {
+	prefs := make(map[string]string)
+	for _, h := range r.Header["Prefer"] {
+		quoted := false
+		start := 0
+		for i := 0; i <= len(h); i++ {
+			if i < len(h) {
+				if h[i] == '"' {
+					quoted = !quoted
+				}
+				if h[i] != ',' || quoted {
+					continue
+				}
+			}
+			kv := strings.SplitN(strings.TrimSpace(h[start:i]), "=", 2)
+			if len(kv) == 2 {
+				prefs[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+			start = i + 1
+		}
+	}
+	return prefs
+}
+
+// writeMinimal answer a write without body when the client prefers
+// `return=minimal`, it returns false when the body must be written
+func writeMinimal(w http.ResponseWriter, r *http.Request) bool {
+	if preferReturn(r) != "minimal" {
+		return false
+	}
+	w.Header().Set("Preference-Applied", "return=minimal")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// setAffectedRows set the X-Affected-Rows header of the writes answering
+// their rows_affected
+func setAffectedRows(w http.ResponseWriter, object []byte) {
+	var result struct {
+		RowsAffected *int64 `json:"rows_affected"`
+	}
+	if json.Unmarshal(object, &result) == nil && result.RowsAffected != nil {
+		w.Header().Set("X-Affected-Rows", strconv.FormatInt(*result.RowsAffected, 10))
+	}
+}
+
+// writeUnknownColumns answer 400 with the columns of the body the table
+// doesn't have (and the operation of a batch, when not 0), it returns false
+// for the other errors
+func writeUnknownColumns(w http.ResponseWriter, err error, operation int) bool {
+	e, ok := err.(*postgres.UnknownColumnsError)
+	if !ok {
+		return false
+	}
+	details := map[string]interface{}{
+		"error":           e.Error(),
+		"table":           e.Table,
+		"unknown_columns": e.Columns,
+	}
+	if operation > 0 {
+		details["operation"] = operation
+	}
+	object, err := json.Marshal(details)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(object)
+	return true
+}
+
+// setContentRange set the Content-Range of the rows of a Range request, it
+// answers 416 (and returns false) when the range starts after the last row
+func setContentRange(w http.ResponseWriter, rows *postgres.RowRange, n, total int64) bool {
+	if n == 0 {
+		if rows.Offset == 0 {
+			return true
+		}
+		log.Println("Range starts after the last row")
+		w.Header().Set("Content-Range", fmt.Sprintf("rows */%d", total))
+		http.Error(w, "Range starts after the last row", http.StatusRequestedRangeNotSatisfiable)
+		return false
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("rows %d-%d/%d", rows.Offset, rows.Offset+n-1, total))
+	return true
+}
+
+// queryTotal return the total of the select, the cached count of the huge
+// tables when the select has no filters (nor a role, the policies of row
+// level security filter the rows)
+func queryTotal(r *http.Request, session postgres.Session, database, schema, table string, filtered bool, sqlTotal string, values ...interface{}) (int64, error) {
+	cache := counts.Default
+	if cache == nil || filtered || session.Role != "" || !cache.Huge(table) {
+		traceSQL(r, sqlTotal, values...)
+		return session.QueryTotal(sqlTotal, values...)
+	}
+	if total, ok := cache.Get(database, schema, table); ok {
+		return total, nil
+	}
+	traceSQL(r, sqlTotal, values...)
+	total, err := session.QueryTotal(sqlTotal, values...)
+	if err != nil {
+		return 0, err
+	}
+	cache.Set(database, schema, table, total)
+	return total, nil
+}
+
+// setPaginationHeaders set X-Total-Count, X-Total-Pages and X-Page headers
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, total int64) {
+	pageNumber, pageSize, paginated, err := postgres.PaginationByRequest(r)
+	if err != nil || !paginated || pageSize <= 0 {
+		return
+	}
+
+	pages := (total + int64(pageSize) - 1) / int64(pageSize)
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	w.Header().Set("X-Total-Pages", strconv.FormatInt(pages, 10))
+	w.Header().Set("X-Page", strconv.Itoa(pageNumber))
+	setLinkHeader(w, r, total)
+}
+
+// setLinkHeader set the RFC 5988 Link header of paginated responses, first,
+// prev and last are only emitted when total (-1 if unknown) is known
+func setLinkHeader(w http.ResponseWriter, r *http.Request, total int64) {
+	pageNumber, pageSize, paginated, err := postgres.PaginationByRequest(r)
+	if err != nil || !paginated || pageSize <= 0 {
+		return
+	}
+
+	link := func(page int64, rel string) string {
+		queries := r.URL.Query()
+		queries.Set("_page", strconv.FormatInt(page, 10))
+		return fmt.Sprintf("<%s?%s>; rel=\"%s\"", r.URL.Path, queries.Encode(), rel)
+	}
+
+	page := int64(pageNumber)
+	links := []string{}
+	if total < 0 {
+		links = append(links, link(page+1, "next"))
+		if page > 1 {
+			links = append(links, link(page-1, "prev"))
+		}
+	} else {
+		last := (total + int64(pageSize) - 1) / int64(pageSize)
+		if last < 1 {
+			last = 1
+		}
+		if page < last {
+			links = append(links, link(page+1, "next"))
+		}
+		if page > 1 {
+			links = append(links, link(page-1, "prev"))
+		}
+		links = append(links, link(1, "first"), link(last, "last"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// selectQuery build a SELECT in a table honoring the request columns, joins,
+// filters and order, the configured columns are anonymized when anonymize is
+// set, it returns the http status to use when there is an error
+func selectQuery(r *http.Request, database, schema, table string, anonymize bool) (query string, values []interface{}, status int, err error) {
+	if err = authorize(r, database, schema, table, "read", postgres.ColumnsByRequest(r)); err != nil {
+		return "", nil, http.StatusMethodNotAllowed, err
+	}
+
+	cols, err := postgres.ReadableFields(config.FromContext(r.Context()), database, schema, table, postgres.ColumnsByRequest(r))
+	if err != nil {
+		return "", nil, http.StatusInternalServerError, err
+	}
+	if len(cols) == 0 {
+		return "", nil, http.StatusUnauthorized, errors.New("You don't have permission for this action. Please check the permitted fields for this table.")
+	}
+
+	selectStr, err := postgres.SelectFields(cols)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+	if anonymize {
+		expressions, err := postgres.AnonymizedFields(config.FromContext(r.Context()), database, schema, table, cols)
+		if err != nil {
+			return "", nil, http.StatusInternalServerError, err
+		}
+		selectStr, _ = postgres.SelectFields(nil, expressions...)
+	}
+	query = fmt.Sprintf("%s %s", selectStr, postgres.QuoteTable(database, schema, table))
+
+	joins, joinArgs, err := postgres.JoinByRequest(r, database, schema, 1)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+	for _, j := range joins {
+		query = fmt.Sprint(query, j)
+	}
+
+	requestWhere, whereValues, err := postgres.WhereByRequest(r, len(joinArgs)+1)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+	values = append(joinArgs, whereValues...)
+	requestWhere, values, err = ownedRows(r, database, schema, table, requestWhere, values)
+	if err != nil {
+		return "", nil, http.StatusForbidden, err
+	}
+	if requestWhere != "" {
+		query = fmt.Sprint(query, " WHERE ", requestWhere)
+	}
+
+	tableColumns, err := postgres.TableColumns(database, schema, table)
+	if err != nil {
+		return "", nil, http.StatusInternalServerError, err
+	}
+	order, err := postgres.OrderByRequest(r, database, schema, table, tableColumns)
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+	query = fmt.Sprint(query, order)
+	return
+}
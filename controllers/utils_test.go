@@ -3,17 +3,289 @@ package controllers
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
 
 	"net/http/httptest"
 
 	"bytes"
 	"encoding/json"
 
+	"errors"
+	"testing"
+
+	"github.com/nuveo/prest/adapters/postgres"
 	"github.com/nuveo/prest/api"
+	"github.com/nuveo/prest/authz"
+	"github.com/nuveo/prest/config"
+	"github.com/nuveo/prest/counts"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func TestTraceSQL(t *testing.T) {
+	config.InitConf()
+	defer config.Set(config.Update(func(cfg *config.Prest) { cfg.AdminKey = "secret" }))
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	Convey("Trace SQL of admin requests", t, func() {
+		buf.Reset()
+		r, err := http.NewRequest("GET", "/prest/public/test?_trace_sql=true", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("X-Admin-Key", "secret")
+		traceSQL(r, "SELECT * FROM test WHERE name=$1", "prest")
+		So(buf.String(), ShouldContainSubstring, "SELECT * FROM test WHERE name=$1 [prest]")
+	})
+	Convey("Don't trace SQL of other requests", t, func() {
+		buf.Reset()
+		r, err := http.NewRequest("GET", "/prest/public/test?_trace_sql=true", nil)
+		So(err, ShouldBeNil)
+		traceSQL(r, "SELECT * FROM test WHERE name=$1", "prest")
+		So(buf.String(), ShouldBeEmpty)
+	})
+}
+
+func TestPreferReturn(t *testing.T) {
+	Convey("Prefer return preference", t, func() {
+		r, err := http.NewRequest("POST", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		So(preferReturn(r), ShouldBeEmpty)
+
+		r.Header.Set("Prefer", "count=exact, return=minimal")
+		So(preferReturn(r), ShouldEqual, "minimal")
+
+		r.Header.Set("Prefer", "return=representation")
+		So(preferReturn(r), ShouldEqual, "representation")
+	})
+}
+
+func TestPreferFields(t *testing.T) {
+	Convey("Prefer fields preference", t, func() {
+		r, err := http.NewRequest("POST", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		So(preferFields(r), ShouldBeEmpty)
+
+		r.Header.Set("Prefer", `return=representation, fields="id, name"`)
+		So(preferReturn(r), ShouldEqual, "representation")
+		So(preferFields(r), ShouldResemble, []string{"id", "name"})
+
+		r.Header.Set("Prefer", "fields=id")
+		So(preferFields(r), ShouldResemble, []string{"id"})
+	})
+}
+
+func TestReturningFields(t *testing.T) {
+	Convey("Returned rows of _returning", t, func() {
+		r, err := http.NewRequest("DELETE", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		_, ok := returningFields(r)
+		So(ok, ShouldBeFalse)
+
+		r, err = http.NewRequest("DELETE", "/prest/public/test?_returning=*", nil)
+		So(err, ShouldBeNil)
+		fields, ok := returningFields(r)
+		So(ok, ShouldBeTrue)
+		So(fields, ShouldBeEmpty)
+
+		r, err = http.NewRequest("DELETE", "/prest/public/test?_returning=id,%20name", nil)
+		So(err, ShouldBeNil)
+		fields, ok = returningFields(r)
+		So(ok, ShouldBeTrue)
+		So(fields, ShouldResemble, []string{"id", "name"})
+	})
+	Convey("Returned rows of the Prefer header", t, func() {
+		r, err := http.NewRequest("DELETE", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Prefer", `return=representation, fields="id"`)
+		fields, ok := returningFields(r)
+		So(ok, ShouldBeTrue)
+		So(fields, ShouldResemble, []string{"id"})
+	})
+}
+
+func TestRLSUnsupported(t *testing.T) {
+	Convey("Endpoints without session are refused with a role", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test/_changes", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		So(rlsUnsupported(w, r), ShouldBeFalse)
+
+		r = r.WithContext(postgres.WithRole(r.Context(), "test_rls_reader"))
+		So(rlsUnsupported(w, r), ShouldBeTrue)
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
+
+func TestOwnedRows(t *testing.T) {
+	config.InitConf()
+	cfg := configWith(func(cfg *config.Prest) {
+		cfg.AccessConf.Tables = append([]config.TablesConf{{Name: "test_owned", Owner: "user_id"}}, cfg.AccessConf.Tables...)
+	})
+	Convey("Rows of the subject added to the filters", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test_owned?name=prest", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(config.NewContext(r.Context(), cfg))
+		_, _, err = ownedRows(r, "prest", "public", "test_owned", "name=$1", []interface{}{"prest"})
+		So(err, ShouldEqual, postgres.ErrNoOwner)
+
+		r = r.WithContext(postgres.WithOwner(r.Context(), "ana"))
+		where, values, err := ownedRows(r, "prest", "public", "test_owned", "name=$1", []interface{}{"prest"})
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "name=$1 AND test_owned.user_id=$2")
+		So(values, ShouldResemble, []interface{}{"prest", "ana"})
+
+		where, values, err = ownedRows(r, "prest", "public", "test_owned", "", nil)
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "test_owned.user_id=$1")
+		So(values, ShouldResemble, []interface{}{"ana"})
+
+		where, values, err = ownedRows(r, "prest", "public", "test", "name=$1", []interface{}{"prest"})
+		So(err, ShouldBeNil)
+		So(where, ShouldEqual, "name=$1")
+		So(values, ShouldResemble, []interface{}{"prest"})
+	})
+	Convey("Endpoints without owner filter are refused", t, func() {
+		r, err := http.NewRequest("GET", "/_changes/prest/public/test_owned", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(config.NewContext(r.Context(), cfg))
+		w := httptest.NewRecorder()
+		So(ownerUnsupported(w, r, "prest", "public", "test"), ShouldBeFalse)
+		So(ownerUnsupported(w, r, "prest", "public", "test_owned"), ShouldBeTrue)
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	config.InitConf()
+	Convey("Table permissions of the config without authorizer", t, func() {
+		r, err := http.NewRequest("POST", "/prest/public/test_readonly_access", nil)
+		So(err, ShouldBeNil)
+		So(authorize(r, "prest", "public", "test_readonly_access", "read", []string{"*"}), ShouldBeNil)
+		So(authorize(r, "prest", "public", "test_readonly_access", "insert", []string{"name"}), ShouldEqual, errPermission)
+	})
+	Convey("Authorizer with the principal of the request", t, func() {
+		var calls []string
+		authz.Default = authz.AuthorizerFunc(func(principal, database, schema, table, action string, columns []string) error {
+			calls = append(calls, fmt.Sprintf("%s %s %s.%s.%s %v", principal, action, database, schema, table, columns))
+			if table == "secret" {
+				return errors.New("Secret table")
+			}
+			return nil
+		})
+		defer func() { authz.Default = nil }()
+
+		r, err := http.NewRequest("GET", "/prest/public/test_readonly_access?_join=inner:test2:test2.name:$eq:test_readonly_access.name", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(authz.NewContext(r.Context(), "apikey:reports"))
+		So(authorize(r, "prest", "public", "test_readonly_access", "insert", []string{"name"}), ShouldBeNil)
+		So(authorize(r, "prest", "public", "test_readonly_access", "read", []string{"name"}), ShouldBeNil)
+		So(calls, ShouldResemble, []string{
+			"apikey:reports insert prest.public.test_readonly_access [name]",
+			"apikey:reports read prest.public.test_readonly_access [name]",
+			"apikey:reports read prest.public.test2 [*]",
+		})
+		So(postgres.TablePermissions(config.Get(), "prest", "public", "test_no_permission", "delete"), ShouldBeTrue)
+
+		r, err = http.NewRequest("GET", "/prest/public/test?_join=inner:secret:secret.id:$eq:test.id", nil)
+		So(err, ShouldBeNil)
+		So(authorize(r, "prest", "public", "test", "read", []string{"*"}), ShouldResemble, errors.New("Secret table"))
+	})
+}
+
+func TestSetAffectedRows(t *testing.T) {
+	Convey("X-Affected-Rows of the writes", t, func() {
+		w := httptest.NewRecorder()
+		setAffectedRows(w, []byte(`{"rows_affected":3}`))
+		So(w.Header().Get("X-Affected-Rows"), ShouldEqual, "3")
+
+		w = httptest.NewRecorder()
+		setAffectedRows(w, []byte(`[{"id":1}]`))
+		So(w.Header().Get("X-Affected-Rows"), ShouldEqual, "")
+	})
+}
+
+func TestSetContentRange(t *testing.T) {
+	Convey("Content-Range of the rows", t, func() {
+		w := httptest.NewRecorder()
+		So(setContentRange(w, &postgres.RowRange{Offset: 10, Limit: 5}, 5, 100), ShouldBeTrue)
+		So(w.Header().Get("Content-Range"), ShouldEqual, "rows 10-14/100")
+	})
+	Convey("Range after the last row", t, func() {
+		w := httptest.NewRecorder()
+		So(setContentRange(w, &postgres.RowRange{Offset: 100}, 0, 100), ShouldBeFalse)
+		So(w.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+		So(w.Header().Get("Content-Range"), ShouldEqual, "rows */100")
+	})
+	Convey("Range of an empty table", t, func() {
+		w := httptest.NewRecorder()
+		So(setContentRange(w, &postgres.RowRange{}, 0, 0), ShouldBeTrue)
+		So(w.Header().Get("Content-Range"), ShouldEqual, "")
+	})
+}
+
+func TestQueryTotal(t *testing.T) {
+	counts.Default = counts.New([]string{"test"})
+	defer func() { counts.Default = nil }()
+	Convey("Cached count of a huge table", t, func() {
+		counts.Default.Set("prest", "public", "test", 1000000)
+		r := httptest.NewRequest("GET", "/prest/public/test?_page=1", nil)
+		total, err := queryTotal(r, postgres.Session{}, "prest", "public", "test", false, "SELECT * FROM prest.public.test")
+		So(err, ShouldBeNil)
+		So(total, ShouldEqual, 1000000)
+	})
+	Convey("Filtered selects are counted", t, func() {
+		r := httptest.NewRequest("GET", "/prest/public/test?_page=1&name=prest", nil)
+		total, err := queryTotal(r, postgres.Session{}, "prest", "public", "test", true, "SELECT * FROM prest.public.test WHERE name=$1", "prest")
+		So(err, ShouldBeNil)
+		So(total, ShouldBeLessThan, 1000000)
+	})
+}
+
+func TestSetLinkHeader(t *testing.T) {
+	Convey("Link header with known total", t, func() {
+		r, err := http.NewRequest("GET", "/prest/public/test?_page=2&_page_size=10", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		setLinkHeader(w, r, 35)
+		link := w.Header().Get("Link")
+		So(link, ShouldContainSubstring, `</prest/public/test?_page=3&_page_size=10>; rel="next"`)
+		So(link, ShouldContainSubstring, `</prest/public/test?_page=1&_page_size=10>; rel="prev"`)
+		So(link, ShouldContainSubstring, `</prest/public/test?_page=1&_page_size=10>; rel="first"`)
+		So(link, ShouldContainSubstring, `</prest/public/test?_page=4&_page_size=10>; rel="last"`)
+	})
+	Convey("Link header with unknown total", t, func() {
+		r, err := http.NewRequest("GET", "/databases?_page=1&_page_size=10", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		setLinkHeader(w, r, -1)
+		So(w.Header().Get("Link"), ShouldEqual, `</databases?_page=2&_page_size=10>; rel="next"`)
+	})
+	Convey("Link header without pagination", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		setLinkHeader(w, r, -1)
+		So(w.Header().Get("Link"), ShouldBeEmpty)
+	})
+}
+
+// configWith return a copy of the current config changed by fn
+func configWith(fn func(cfg *config.Prest)) *config.Prest {
+	cfg := *config.Get()
+	fn(&cfg)
+	return &cfg
+}
+
+// withConfig serve the requests with the config snapshot in their context,
+// like the config middleware
+func withConfig(h http.Handler, cfg *config.Prest) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(config.NewContext(r.Context(), cfg)))
+	})
+}
+
 func validate(w *httptest.ResponseRecorder, r *http.Request, h http.HandlerFunc, where string) {
 	h(w, r)
 	fmt.Println("Test:", where)
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xlsxContentType media type of the Excel workbooks
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxParts fixed parts of the workbook, the worksheet is written with the
+// rows
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// writeXLSX write a JSON array of objects (or a single object) as an Excel
+// workbook of one sheet called name, with a header row of the keys of the
+// first object. Strings (and JSON fields) are text cells, numbers and
+// booleans keep their types and null is an empty cell
+func writeXLSX(w io.Writer, name string, object []byte) error {
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	rowNumber := 0
+	writeRow := func(values []json.RawMessage) error {
+		rowNumber++
+		row := strconv.Itoa(rowNumber)
+		sheet.WriteString(`<row r="` + row + `">`)
+		for i, value := range values {
+			if err := xlsxCell(&sheet, xlsxColumn(i)+row, value); err != nil {
+				return err
+			}
+		}
+		sheet.WriteString(`</row>`)
+		return nil
+	}
+
+	var header []string
+	write := func(dec *json.Decoder) error {
+		keys, values, err := csvObject(dec)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			header = keys
+			names := make([]json.RawMessage, len(header))
+			for i, key := range header {
+				if names[i], err = json.Marshal(key); err != nil {
+					return err
+				}
+			}
+			if err = writeRow(names); err != nil {
+				return err
+			}
+		}
+		record := make([]json.RawMessage, len(header))
+		for i, key := range header {
+			record[i] = values[key]
+		}
+		return writeRow(record)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(object))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case json.Delim('['):
+		for dec.More() {
+			if t, err = dec.Token(); err != nil {
+				return err
+			}
+			if t != json.Delim('{') {
+				return errors.New("XLSX renderer needs rows of objects")
+			}
+			if err = write(dec); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		if err = write(dec); err != nil {
+			return err
+		}
+	default:
+		return errors.New("XLSX renderer needs rows of objects")
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	var workbook bytes.Buffer
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	if err = xml.EscapeText(&workbook, []byte(xlsxSheetName(name))); err != nil {
+		return err
+	}
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+
+	archive := zip.NewWriter(w)
+	parts := append(xlsxParts[:len(xlsxParts):len(xlsxParts)],
+		struct{ name, content string }{"xl/workbook.xml", workbook.String()},
+		struct{ name, content string }{"xl/worksheets/sheet1.xml", sheet.String()})
+	for _, part := range parts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// xlsxCell write the cell of a JSON value, nothing for null
+func xlsxCell(sheet *bytes.Buffer, ref string, value json.RawMessage) error {
+	text := strings.TrimSpace(string(value))
+	switch {
+	case text == "" || text == "null":
+		return nil
+	case text == "true" || text == "false":
+		b := "0"
+		if text == "true" {
+			b = "1"
+		}
+		sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + b + `</v></c>`)
+		return nil
+	case text[0] != '"' && text[0] != '{' && text[0] != '[':
+		sheet.WriteString(`<c r="` + ref + `"><v>` + text + `</v></c>`)
+		return nil
+	}
+	sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	if err := xml.EscapeText(sheet, []byte(csvCell(value))); err != nil {
+		return err
+	}
+	sheet.WriteString(`</t></is></c>`)
+	return nil
+}
+
+// xlsxColumn return the letters of the column (A, B, ..., Z, AA, ...)
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSheetName return a valid sheet name, without the forbidden characters
+// and up to 31 characters
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nuveo/prest/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// xlsxPart return the content of a part of the workbook
+func xlsxPart(workbook []byte, name string) string {
+	archive, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	So(err, ShouldBeNil)
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		So(err, ShouldBeNil)
+		defer r.Close()
+		content, err := ioutil.ReadAll(r)
+		So(err, ShouldBeNil)
+		return string(content)
+	}
+	return ""
+}
+
+func TestWriteXLSX(t *testing.T) {
+	Convey("Rows as a worksheet with a header row", t, func() {
+		var b bytes.Buffer
+		err := writeXLSX(&b, "test", []byte(`[{"id":1,"name":"prest & <nuveo>","active":true,"tags":["a"],"note":null}]`))
+		So(err, ShouldBeNil)
+		So(xlsxPart(b.Bytes(), "[Content_Types].xml"), ShouldContainSubstring, "/xl/worksheets/sheet1.xml")
+		So(xlsxPart(b.Bytes(), "xl/workbook.xml"), ShouldContainSubstring, `<sheet name="test" sheetId="1" r:id="rId1"/>`)
+
+		sheet := xlsxPart(b.Bytes(), "xl/worksheets/sheet1.xml")
+		So(sheet, ShouldContainSubstring, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+		So(sheet, ShouldContainSubstring, `<c r="A2"><v>1</v></c>`)
+		So(sheet, ShouldContainSubstring, `<c r="B2" t="inlineStr"><is><t xml:space="preserve">prest &amp; &lt;nuveo&gt;</t></is></c>`)
+		So(sheet, ShouldContainSubstring, `<c r="C2" t="b"><v>1</v></c>`)
+		So(sheet, ShouldContainSubstring, `<c r="D2" t="inlineStr"><is><t xml:space="preserve">[&#34;a&#34;]</t></is></c>`)
+		So(sheet, ShouldNotContainSubstring, `r="E2"`)
+	})
+	Convey("An object as a row", t, func() {
+		var b bytes.Buffer
+		err := writeXLSX(&b, "test", []byte(`{"count":10}`))
+		So(err, ShouldBeNil)
+		So(xlsxPart(b.Bytes(), "xl/worksheets/sheet1.xml"), ShouldContainSubstring, `<row r="2"><c r="A2"><v>10</v></c></row>`)
+	})
+	Convey("Rows of other values", t, func() {
+		var b bytes.Buffer
+		err := writeXLSX(&b, "test", []byte(`[1,2]`))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestXLSXColumn(t *testing.T) {
+	Convey("Letters of the columns", t, func() {
+		So(xlsxColumn(0), ShouldEqual, "A")
+		So(xlsxColumn(25), ShouldEqual, "Z")
+		So(xlsxColumn(26), ShouldEqual, "AA")
+		So(xlsxColumn(701), ShouldEqual, "ZZ")
+		So(xlsxColumn(702), ShouldEqual, "AAA")
+	})
+}
+
+func TestXLSXSheetName(t *testing.T) {
+	Convey("Valid sheet names", t, func() {
+		So(xlsxSheetName("test"), ShouldEqual, "test")
+		So(xlsxSheetName("a/b:c"), ShouldEqual, "a_b_c")
+		So(xlsxSheetName("a_table_with_a_very_long_name_of_columns"), ShouldEqual, "a_table_with_a_very_long_name_o")
+		So(xlsxSheetName(""), ShouldEqual, "Sheet1")
+	})
+}
+
+func TestSelectXLSX(t *testing.T) {
+	config.InitConf()
+	router := mux.NewRouter()
+	router.HandleFunc("/{database}/{schema}/{table}", SelectFromTables).Methods("GET")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	Convey("Select a table as Excel", t, func() {
+		resp, err := http.Get(server.URL + "/prest/public/test?_renderer=xlsx&_select=id,name&_order=id")
+		So(err, ShouldBeNil)
+		So(resp.StatusCode, ShouldEqual, 200)
+		So(resp.Header.Get("Content-Type"), ShouldEqual, xlsxContentType)
+		So(resp.Header.Get("Content-Disposition"), ShouldEqual, `attachment; filename="test.xlsx"`)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(xlsxPart(body, "xl/worksheets/sheet1.xml"), ShouldContainSubstring, `<t xml:space="preserve">name</t>`)
+	})
+}
@@ -0,0 +1,109 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ExposedHeaders response headers of pREST readable by the browsers
+var ExposedHeaders = []string{
+	"Accept-Ranges",
+	"Content-Range",
+	"Link",
+	"Preference-Applied",
+	"Retry-After",
+	"X-Affected-Rows",
+	"X-Next-Cursor",
+	"X-Page",
+	"X-Prest-Sandbox",
+	"X-Prest-Stale",
+	"X-Request-Id",
+	"X-Skipped-Rows",
+	"X-Total-Count",
+	"X-Total-Pages",
+}
+
+// Options of cross-origin requests, "*" in AllowedOrigins allows any origin
+// and in AllowedHeaders any header
+type Options struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge seconds the browsers cache the preflight, 0 doesn't send it
+	MaxAge int
+}
+
+// ServeHTTP set the CORS headers of the allowed origins and answer the
+// preflight requests, it's a negroni handler
+func (o Options) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		next(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if !o.originAllowed(origin) {
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+		return
+	}
+
+	if contains(o.AllowedOrigins, "*") && !o.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if o.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(ExposedHeaders, ", "))
+		next(w, r)
+		return
+	}
+
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if contains(o.AllowedMethods, method) {
+		h.Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+		headers := strings.Join(o.AllowedHeaders, ", ")
+		if contains(o.AllowedHeaders, "*") {
+			headers = r.Header.Get("Access-Control-Request-Headers")
+		}
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if o.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(o.MaxAge))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o Options) originAllowed(origin string) bool {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serve(o Options, r *http.Request) (w *httptest.ResponseRecorder, called bool) {
+	w = httptest.NewRecorder()
+	o.ServeHTTP(w, r, func(http.ResponseWriter, *http.Request) {
+		called = true
+	})
+	return
+}
+
+func TestCORS(t *testing.T) {
+	o := Options{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         600,
+	}
+
+	Convey("Request without origin", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		w, called := serve(o, r)
+		So(called, ShouldBeTrue)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+	})
+	Convey("Request of an allowed origin", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://app.example.com")
+		w, called := serve(o, r)
+		So(called, ShouldBeTrue)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+		So(w.Header().Get("Access-Control-Expose-Headers"), ShouldContainSubstring, "X-Total-Count")
+	})
+	Convey("Request of other origin", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://evil.example.com")
+		w, called := serve(o, r)
+		So(called, ShouldBeTrue)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+	})
+	Convey("Preflight of an allowed method", t, func() {
+		r, err := http.NewRequest("OPTIONS", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		w, called := serve(o, r)
+		So(called, ShouldBeFalse)
+		So(w.Code, ShouldEqual, http.StatusNoContent)
+		So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST")
+		So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, Authorization")
+		So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+	})
+	Convey("Preflight of other method", t, func() {
+		r, err := http.NewRequest("OPTIONS", "/prest/public/test", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", "DELETE")
+		w, called := serve(o, r)
+		So(called, ShouldBeFalse)
+		So(w.Header().Get("Access-Control-Allow-Methods"), ShouldBeEmpty)
+	})
+	Convey("Any origin and header", t, func() {
+		any := Options{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, AllowedHeaders: []string{"*"}}
+		r, err := http.NewRequest("OPTIONS", "/databases", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://other.example.com")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		r.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+		w, _ := serve(any, r)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+		So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "X-API-Key")
+	})
+	Convey("Credentials echo the origin", t, func() {
+		creds := Options{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set("Origin", "https://other.example.com")
+		w, _ := serve(creds, r)
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://other.example.com")
+		So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+	})
+}
@@ -0,0 +1,84 @@
+package counts
+
+import (
+	"log"
+	"path"
+	"sync"
+	"time"
+)
+
+// CountFunc count the rows of a table
+type CountFunc func(database, schema, table string) (int64, error)
+
+// Cache row counts of huge tables, refreshed by interval, used as the total
+// of the selects without filters instead of a COUNT(*) scan by request
+type Cache struct {
+	// Tables names (or glob patterns) of the huge tables
+	Tables []string
+
+	mu     sync.RWMutex
+	totals map[key]int64
+}
+
+type key struct {
+	database, schema, table string
+}
+
+// Default cache of pREST, nil when disabled
+var Default *Cache
+
+// New return a cache of the counts of tables
+func New(tables []string) *Cache {
+	return &Cache{Tables: tables, totals: make(map[key]int64)}
+}
+
+// Huge check if the counts of the table are cached
+func (c *Cache) Huge(table string) bool {
+	for _, pattern := range c.Tables {
+		if ok, err := path.Match(pattern, table); ok || (err != nil && pattern == table) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get return the cached count of the table, false before it is counted
+func (c *Cache) Get(database, schema, table string) (total int64, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	total, ok = c.totals[key{database, schema, table}]
+	return
+}
+
+// Set cache the count of the table, it is refreshed from now on
+func (c *Cache) Set(database, schema, table string, total int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totals[key{database, schema, table}] = total
+}
+
+// Refresh count again the cached tables, the tables that fail keep the
+// previous count
+func (c *Cache) Refresh(count CountFunc) {
+	c.mu.RLock()
+	keys := make([]key, 0, len(c.totals))
+	for k := range c.totals {
+		keys = append(keys, k)
+	}
+	c.mu.RUnlock()
+	for _, k := range keys {
+		total, err := count(k.database, k.schema, k.table)
+		if err != nil {
+			log.Printf("[counts] %s.%s.%s: %v\n", k.database, k.schema, k.table, err)
+			continue
+		}
+		c.Set(k.database, k.schema, k.table, total)
+	}
+}
+
+// Run refresh the counts by interval
+func (c *Cache) Run(count CountFunc, interval time.Duration) {
+	for range time.Tick(interval) {
+		c.Refresh(count)
+	}
+}
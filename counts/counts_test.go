@@ -0,0 +1,35 @@
+package counts
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("Huge tables by name or pattern", t, func() {
+		c := New([]string{"events", "logs_*"})
+		So(c.Huge("events"), ShouldBeTrue)
+		So(c.Huge("logs_2024"), ShouldBeTrue)
+		So(c.Huge("users"), ShouldBeFalse)
+	})
+	Convey("Counts cached and refreshed", t, func() {
+		c := New([]string{"events"})
+		_, ok := c.Get("prest", "public", "events")
+		So(ok, ShouldBeFalse)
+
+		c.Set("prest", "public", "events", 10)
+		total, ok := c.Get("prest", "public", "events")
+		So(ok, ShouldBeTrue)
+		So(total, ShouldEqual, 10)
+
+		c.Refresh(func(database, schema, table string) (int64, error) { return 25, nil })
+		total, _ = c.Get("prest", "public", "events")
+		So(total, ShouldEqual, 25)
+
+		c.Refresh(func(database, schema, table string) (int64, error) { return 0, errors.New("connection refused") })
+		total, _ = c.Get("prest", "public", "events")
+		So(total, ShouldEqual, 25)
+	})
+}
@@ -0,0 +1,71 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Filter of the client IPs, the denied networks win over the allowed ones
+// and with allowed networks any other IP is denied
+type Filter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// New return a filter of the CIDRs (e.g. 10.0.0.0/8), single IPs are
+// accepted too
+func New(allow, deny []string) (f *Filter, err error) {
+	f = &Filter{}
+	if f.allow, err = parse(allow); err != nil {
+		return
+	}
+	f.deny, err = parse(deny)
+	return
+}
+
+func parse(cidrs []string) (nets []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				err = fmt.Errorf("invalid IP %s", cidr)
+				return
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(cidr); err != nil {
+			return
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+// Allowed check if the IP can access, invalid IPs are denied
+func (f *Filter) Allowed(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if contains(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, ip)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package ipfilter
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilter(t *testing.T) {
+	Convey("Allow only the listed networks", t, func() {
+		f, err := New([]string{"10.0.0.0/8", "192.168.1.10"}, nil)
+		So(err, ShouldBeNil)
+		So(f.Allowed("10.1.2.3"), ShouldBeTrue)
+		So(f.Allowed("192.168.1.10"), ShouldBeTrue)
+		So(f.Allowed("192.168.1.11"), ShouldBeFalse)
+		So(f.Allowed("8.8.8.8"), ShouldBeFalse)
+	})
+	Convey("Deny wins over allow", t, func() {
+		f, err := New([]string{"10.0.0.0/8"}, []string{"10.0.0.0/24"})
+		So(err, ShouldBeNil)
+		So(f.Allowed("10.0.0.5"), ShouldBeFalse)
+		So(f.Allowed("10.0.1.5"), ShouldBeTrue)
+	})
+	Convey("Deny only the listed networks", t, func() {
+		f, err := New(nil, []string{"203.0.113.0/24", "2001:db8::/32"})
+		So(err, ShouldBeNil)
+		So(f.Allowed("203.0.113.9"), ShouldBeFalse)
+		So(f.Allowed("2001:db8::1"), ShouldBeFalse)
+		So(f.Allowed("198.51.100.1"), ShouldBeTrue)
+		So(f.Allowed("::1"), ShouldBeTrue)
+	})
+	Convey("IPv4 mapped addresses", t, func() {
+		f, err := New([]string{"127.0.0.1"}, nil)
+		So(err, ShouldBeNil)
+		So(f.Allowed("::ffff:127.0.0.1"), ShouldBeTrue)
+	})
+	Convey("Invalid IPs", t, func() {
+		_, err := New([]string{"10.0.0.0/33"}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = New(nil, []string{"localhost"})
+		So(err, ShouldNotBeNil)
+		f, err := New(nil, nil)
+		So(err, ShouldBeNil)
+		So(f.Allowed("unknown"), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// StatusRunning job still running
+	StatusRunning = "running"
+	// StatusDone job finished with success
+	StatusDone = "done"
+	// StatusFailed job finished with error
+	StatusFailed = "failed"
+)
+
+// Info job status representation
+type Info struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Status   string     `json:"status"`
+	Rows     int64      `json:"rows"`
+	URL      string     `json:"url,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Job background operation (export, import...)
+type Job struct {
+	mu   sync.RWMutex
+	info Info
+}
+
+var (
+	jobs   = make(map[string]*Job)
+	jobsMu sync.RWMutex
+)
+
+// New register a running job
+func New(kind string) (*Job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	j := &Job{
+		info: Info{
+			ID:      hex.EncodeToString(b),
+			Kind:    kind,
+			Status:  StatusRunning,
+			Created: time.Now(),
+		},
+	}
+	jobsMu.Lock()
+	jobs[j.info.ID] = j
+	jobsMu.Unlock()
+	return j, nil
+}
+
+// Get return the job status
+func Get(id string) (Info, bool) {
+	jobsMu.RLock()
+	j, ok := jobs[id]
+	jobsMu.RUnlock()
+	if !ok {
+		return Info{}, false
+	}
+	return j.Info(), true
+}
+
+// Info return a copy of the job status
+func (j *Job) Info() Info {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.info
+}
+
+// Progress update the number of processed rows
+func (j *Job) Progress(rows int64) {
+	j.mu.Lock()
+	j.info.Rows = rows
+	j.mu.Unlock()
+}
+
+// Done finish the job with success
+func (j *Job) Done(url string) {
+	now := time.Now()
+	j.mu.Lock()
+	j.info.Status = StatusDone
+	j.info.URL = url
+	j.info.Finished = &now
+	j.mu.Unlock()
+}
+
+// Fail finish the job with error
+func (j *Job) Fail(err error) {
+	now := time.Now()
+	j.mu.Lock()
+	j.info.Status = StatusFailed
+	j.info.Error = err.Error()
+	j.info.Finished = &now
+	j.mu.Unlock()
+}
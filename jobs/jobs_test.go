@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJobs(t *testing.T) {
+	Convey("New job is running", t, func() {
+		j, err := New("export")
+		So(err, ShouldBeNil)
+
+		info, ok := Get(j.Info().ID)
+		So(ok, ShouldBeTrue)
+		So(info.Kind, ShouldEqual, "export")
+		So(info.Status, ShouldEqual, StatusRunning)
+	})
+	Convey("Finish job with success", t, func() {
+		j, err := New("export")
+		So(err, ShouldBeNil)
+		j.Progress(10)
+		j.Done("http://example.com/file.csv")
+
+		info, ok := Get(j.Info().ID)
+		So(ok, ShouldBeTrue)
+		So(info.Status, ShouldEqual, StatusDone)
+		So(info.Rows, ShouldEqual, 10)
+		So(info.URL, ShouldEqual, "http://example.com/file.csv")
+		So(info.Finished, ShouldNotBeNil)
+	})
+	Convey("Finish job with error", t, func() {
+		j, err := New("import")
+		So(err, ShouldBeNil)
+		j.Fail(errors.New("boom"))
+
+		info, ok := Get(j.Info().ID)
+		So(ok, ShouldBeTrue)
+		So(info.Status, ShouldEqual, StatusFailed)
+		So(info.Error, ShouldEqual, "boom")
+	})
+	Convey("Get unknown job", t, func() {
+		_, ok := Get("notexist")
+		So(ok, ShouldBeFalse)
+	})
+}
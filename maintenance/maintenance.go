@@ -0,0 +1,64 @@
+package maintenance
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// State of the maintenance mode
+type State struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	// RetryAfter seconds sent in the Retry-After header
+	RetryAfter int `json:"retry_after"`
+}
+
+var (
+	state   State
+	stateMu sync.RWMutex
+)
+
+// exempt endpoints answered in maintenance, the health checks and the admin
+// endpoints
+var exempt = []string{"_health", "_maintenance", "_pool", "_usage", "_access", "_jobs", "_backup", "_restore"}
+
+// Get return the current state
+func Get() State {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return state
+}
+
+// Set change the state
+func Set(s State) {
+	stateMu.Lock()
+	state = s
+	stateMu.Unlock()
+}
+
+// Exempt check if the endpoint of the path is answered in maintenance
+func Exempt(path string) bool {
+	first := strings.SplitN(strings.Trim(path, "/"), "/", 2)[0]
+	for _, e := range exempt {
+		if first == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP refuse the requests of the data endpoints with 503 in
+// maintenance, it's a negroni handler
+func ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	s := Get()
+	if !s.Enabled || Exempt(r.URL.Path) {
+		next(w, r)
+		return
+	}
+	if s.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(s.RetryAfter))
+	}
+	http.Error(w, s.Message, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,48 @@
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serve(path string) (w *httptest.ResponseRecorder, called bool) {
+	r := httptest.NewRequest("GET", path, nil)
+	w = httptest.NewRecorder()
+	ServeHTTP(w, r, func(http.ResponseWriter, *http.Request) {
+		called = true
+	})
+	return
+}
+
+func TestMaintenance(t *testing.T) {
+	Convey("Requests out of maintenance", t, func() {
+		Set(State{})
+		_, called := serve("/prest/public/test")
+		So(called, ShouldBeTrue)
+	})
+	Convey("Data endpoints in maintenance", t, func() {
+		Set(State{Enabled: true, Message: "Migrating, back soon", RetryAfter: 600})
+		w, called := serve("/prest/public/test")
+		So(called, ShouldBeFalse)
+		So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(w.Header().Get("Retry-After"), ShouldEqual, "600")
+		So(w.Body.String(), ShouldContainSubstring, "Migrating, back soon")
+		_, called = serve("/_VIEW/prest/public/test_view")
+		So(called, ShouldBeFalse)
+		Set(State{})
+	})
+	Convey("Health and admin endpoints in maintenance", t, func() {
+		Set(State{Enabled: true, Message: "Maintenance"})
+		w, called := serve("/_health")
+		So(called, ShouldBeTrue)
+		So(w.Header().Get("Retry-After"), ShouldBeEmpty)
+		_, called = serve("/_maintenance")
+		So(called, ShouldBeTrue)
+		_, called = serve("/_jobs/1")
+		So(called, ShouldBeTrue)
+		Set(State{})
+	})
+}
@@ -0,0 +1,153 @@
+package pool
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteStat time spent by the requests of a route, the requests hold the
+// connections of the pool while they run
+type RouteStat struct {
+	Route    string        `json:"route"`
+	Requests int64         `json:"requests"`
+	Total    time.Duration `json:"total_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+// Metrics of the pool since the start
+type Metrics struct {
+	OpenConnections    int           `json:"open_connections"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	MaxOpenConnections int           `json:"max_open_connections"`
+	WaitCount          int64         `json:"wait_count"`
+	WaitDuration       time.Duration `json:"wait_duration_ns"`
+	Warnings           int64         `json:"saturation_warnings"`
+	Routes             []RouteStat   `json:"routes"`
+}
+
+// Monitor check the pool stats by interval, logging a warning when the
+// average wait for a connection is over the threshold or the pool is
+// exhausted, with the routes holding the connections longest
+type Monitor struct {
+	Threshold time.Duration
+
+	mu       sync.Mutex
+	last     sql.DBStats
+	warnings int64
+	window   map[string]*RouteStat
+	routes   map[string]*RouteStat
+}
+
+// topRoutes number of routes in the warnings
+const topRoutes = 3
+
+// Default monitor of the pREST pool, nil when disabled
+var Default *Monitor
+
+// New return a monitor warning about waits over threshold
+func New(threshold time.Duration) *Monitor {
+	return &Monitor{
+		Threshold: threshold,
+		window:    make(map[string]*RouteStat),
+		routes:    make(map[string]*RouteStat),
+	}
+}
+
+// Track register the time of a request of the route
+func (m *Monitor) Track(route string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, routes := range []map[string]*RouteStat{m.window, m.routes} {
+		s, ok := routes[route]
+		if !ok {
+			s = &RouteStat{Route: route}
+			routes[route] = s
+		}
+		s.Requests++
+		s.Total += d
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+}
+
+// Check compare the stats with the ones of the last check, returning the
+// warnings of the interval
+func (m *Monitor) Check(stats sql.DBStats) (warnings []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	waits := stats.WaitCount - m.last.WaitCount
+	if waits > 0 {
+		avg := (stats.WaitDuration - m.last.WaitDuration) / time.Duration(waits)
+		if avg > m.Threshold {
+			warnings = append(warnings, fmt.Sprintf("%d requests waited %v on average for a connection", waits, avg))
+		}
+	}
+	if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		warnings = append(warnings, fmt.Sprintf("pool exhausted, %d of %d connections in use", stats.InUse, stats.MaxOpenConnections))
+	}
+	if len(warnings) > 0 {
+		var longest []string
+		for _, s := range top(m.window, topRoutes) {
+			longest = append(longest, fmt.Sprintf("%s (%d requests, %v)", s.Route, s.Requests, s.Total))
+		}
+		if len(longest) > 0 {
+			warnings = append(warnings, "routes holding connections longest: "+strings.Join(longest, ", "))
+		}
+		m.warnings++
+	}
+
+	m.last = stats
+	m.window = make(map[string]*RouteStat)
+	return
+}
+
+// Run check the stats of the pool by interval, it doesn't return
+func (m *Monitor) Run(stats func() sql.DBStats, interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, w := range m.Check(stats()) {
+			log.Println("[pool]", w)
+		}
+	}
+}
+
+// Metrics return the current stats of the pool and the routes since the start
+func (m *Monitor) Metrics(stats sql.DBStats) Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Metrics{
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration,
+		Warnings:           m.warnings,
+		Routes:             top(m.routes, len(m.routes)),
+	}
+}
+
+// top return the n routes with the longest total time
+func top(routes map[string]*RouteStat, n int) []RouteStat {
+	stats := make([]RouteStat, 0, len(routes))
+	for _, s := range routes {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total == stats[j].Total {
+			return stats[i].Route < stats[j].Route
+		}
+		return stats[i].Total > stats[j].Total
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
@@ -0,0 +1,45 @@
+package pool
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMonitor(t *testing.T) {
+	Convey("No warnings without waits", t, func() {
+		m := New(100 * time.Millisecond)
+		So(m.Check(sql.DBStats{MaxOpenConnections: 10, InUse: 2}), ShouldBeEmpty)
+	})
+	Convey("Warn about the average wait of the interval", t, func() {
+		m := New(100 * time.Millisecond)
+		m.Check(sql.DBStats{WaitCount: 10, WaitDuration: 10 * time.Second})
+		m.Track("GET /{database}/{schema}/{table}", 3*time.Second)
+		m.Track("GET /databases", time.Second)
+		warnings := m.Check(sql.DBStats{WaitCount: 12, WaitDuration: 11 * time.Second})
+		So(len(warnings), ShouldEqual, 2)
+		So(warnings[0], ShouldEqual, "2 requests waited 500ms on average for a connection")
+		So(warnings[1], ShouldStartWith, "routes holding connections longest: GET /{database}/{schema}/{table} (1 requests, 3s)")
+	})
+	Convey("Don't warn about waits under the threshold", t, func() {
+		m := New(100 * time.Millisecond)
+		So(m.Check(sql.DBStats{WaitCount: 10, WaitDuration: 10 * time.Millisecond}), ShouldBeEmpty)
+	})
+	Convey("Warn about the exhausted pool", t, func() {
+		m := New(100 * time.Millisecond)
+		warnings := m.Check(sql.DBStats{MaxOpenConnections: 10, InUse: 10})
+		So(warnings, ShouldResemble, []string{"pool exhausted, 10 of 10 connections in use"})
+		So(m.Metrics(sql.DBStats{}).Warnings, ShouldEqual, 1)
+	})
+	Convey("Metrics with the routes since the start", t, func() {
+		m := New(100 * time.Millisecond)
+		m.Track("GET /databases", time.Second)
+		m.Check(sql.DBStats{})
+		m.Track("GET /databases", 2*time.Second)
+		metrics := m.Metrics(sql.DBStats{MaxOpenConnections: 10, InUse: 3})
+		So(metrics.InUse, ShouldEqual, 3)
+		So(metrics.Routes, ShouldResemble, []RouteStat{{Route: "GET /databases", Requests: 2, Total: 3 * time.Second, Max: 2 * time.Second}})
+	})
+}
@@ -0,0 +1,95 @@
+package pretty
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Indent of the pretty-printed responses
+const Indent = "  "
+
+// writer buffer the JSON responses to indent them, the others (CSV, JSON
+// Lines...) are written as they come
+type writer struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	decided  bool
+	buffered bool
+}
+
+func (w *writer) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.buffered = err == nil && (mediaType == "application/json" || mediaType == "application/vnd.api+json")
+}
+
+func (w *writer) WriteHeader(status int) {
+	w.decide()
+	if w.buffered {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	w.decide()
+	if w.buffered {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the streamed responses
+func (w *writer) Flush() {
+	w.decide()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.buffered {
+		f.Flush()
+	}
+}
+
+// Requested check if the request asks for indented JSON, with `_pretty` or
+// by default
+func Requested(r *http.Request, byDefault bool) bool {
+	value := r.URL.Query().Get("_pretty")
+	if value == "" {
+		return byDefault
+	}
+	pretty, err := strconv.ParseBool(value)
+	return err == nil && pretty
+}
+
+// New return a negroni handler indenting the JSON responses of the requests
+// with `_pretty=true`, or of all the requests (unless `_pretty=false`) with
+// byDefault
+func New(byDefault bool) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if !Requested(r, byDefault) {
+			next(w, r)
+			return
+		}
+		pw := &writer{ResponseWriter: w}
+		next(pw, r)
+		if !pw.buffered {
+			return
+		}
+		body := pw.body.Bytes()
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", Indent); err == nil {
+			indented.WriteByte('\n')
+			body = indented.Bytes()
+		}
+		w.Header().Del("Content-Length")
+		if pw.status != 0 {
+			w.WriteHeader(pw.status)
+		}
+		w.Write(body)
+	}
+}
@@ -0,0 +1,53 @@
+package pretty
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serve(byDefault bool, path, contentType, body string, status int) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	New(byDefault)(w, httptest.NewRequest("GET", path, nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+	return w
+}
+
+func TestPretty(t *testing.T) {
+	Convey("Indented JSON with _pretty", t, func() {
+		w := serve(false, "/prest/public/test?_pretty=true", "application/json", `[{"id":1}]`, http.StatusOK)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "[\n  {\n    \"id\": 1\n  }\n]\n")
+
+		w = serve(false, "/prest/public/test?_pretty=true", "application/json", `{"error":"not found"}`, http.StatusNotFound)
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+		So(w.Body.String(), ShouldEqual, "{\n  \"error\": \"not found\"\n}\n")
+
+		w = serve(false, "/prest/public/test?_pretty=true", "application/vnd.api+json", `{"data":[]}`, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "{\n  \"data\": []\n}\n")
+	})
+	Convey("Compact JSON without _pretty", t, func() {
+		w := serve(false, "/prest/public/test", "application/json", `[{"id":1}]`, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `[{"id":1}]`)
+	})
+	Convey("Indented JSON by default", t, func() {
+		w := serve(true, "/prest/public/test", "application/json", `{"id":1}`, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "{\n  \"id\": 1\n}\n")
+
+		w = serve(true, "/prest/public/test?_pretty=false", "application/json", `{"id":1}`, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `{"id":1}`)
+	})
+	Convey("Other responses are kept", t, func() {
+		w := serve(true, "/prest/public/test", "text/csv; charset=utf-8", "id\n1\n", http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "id\n1\n")
+
+		w = serve(true, "/prest/public/test", "text/plain; charset=utf-8", "Table not found\n", http.StatusNotFound)
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+		So(w.Body.String(), ShouldEqual, "Table not found\n")
+	})
+}
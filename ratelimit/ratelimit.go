@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cleanupInterval how often the buckets refilled to the burst are removed
+const cleanupInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter token buckets by client, each client has up to burst requests
+// refilled at rate requests per second
+type Limiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	cleaned time.Time
+}
+
+// New return a limiter of rate requests per second, the burst is the rate
+// (at least 1) when it is 0
+func New(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(math.Ceil(rate), 1)
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   b,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow take a token of the client at now, when there is none it returns
+// false and the time until the next one
+func (l *Limiter) Allow(client string, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cleaned.IsZero() {
+		l.cleaned = now
+	}
+	if now.Sub(l.cleaned) >= cleanupInterval {
+		l.cleanup(now)
+	}
+
+	b, found := l.buckets[client]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+}
+
+// cleanup remove the buckets of idle clients, they are full again
+func (l *Limiter) cleanup(now time.Time) {
+	for client, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.cleaned = now
+}
+
+// ClientIP return the IP of the request, from the header (e.g. X-Real-IP of
+// a reverse proxy) when it is set
+func ClientIP(r *http.Request, header string) string {
+	if header != "" {
+		if v := r.Header.Get(header); v != "" {
+			// X-Forwarded-For has the client first
+			return strings.TrimSpace(strings.Split(v, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RetryAfter format the wait as the seconds of the Retry-After header, at
+// least 1
+func RetryAfter(wait time.Duration) int {
+	return int(math.Max(math.Ceil(wait.Seconds()), 1))
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	Convey("Allow the burst and refuse the next request", t, func() {
+		l := New(1, 2)
+		ok, _ := l.Allow("a", now)
+		So(ok, ShouldBeTrue)
+		ok, _ = l.Allow("a", now)
+		So(ok, ShouldBeTrue)
+		ok, wait := l.Allow("a", now)
+		So(ok, ShouldBeFalse)
+		So(wait, ShouldEqual, time.Second)
+	})
+	Convey("Refill the tokens by the rate", t, func() {
+		l := New(2, 1)
+		ok, _ := l.Allow("a", now)
+		So(ok, ShouldBeTrue)
+		ok, wait := l.Allow("a", now)
+		So(ok, ShouldBeFalse)
+		So(wait, ShouldEqual, 500*time.Millisecond)
+		ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+		So(ok, ShouldBeTrue)
+	})
+	Convey("Each client has its bucket", t, func() {
+		l := New(1, 1)
+		ok, _ := l.Allow("a", now)
+		So(ok, ShouldBeTrue)
+		ok, _ = l.Allow("b", now)
+		So(ok, ShouldBeTrue)
+	})
+	Convey("Burst is the rate when not set", t, func() {
+		l := New(0.5, 0)
+		ok, _ := l.Allow("a", now)
+		So(ok, ShouldBeTrue)
+		ok, wait := l.Allow("a", now)
+		So(ok, ShouldBeFalse)
+		So(wait, ShouldEqual, 2*time.Second)
+	})
+	Convey("Remove idle clients", t, func() {
+		l := New(1, 1)
+		l.Allow("a", now)
+		l.Allow("b", now.Add(cleanupInterval))
+		So(len(l.buckets), ShouldEqual, 1)
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	Convey("IP of the remote address", t, func() {
+		r, err := http.NewRequest("GET", "/databases", nil)
+		So(err, ShouldBeNil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		So(ClientIP(r, ""), ShouldEqual, "10.0.0.1")
+
+		r.Header.Set("X-Forwarded-For", "192.168.0.1, 10.0.0.2")
+		So(ClientIP(r, ""), ShouldEqual, "10.0.0.1")
+		So(ClientIP(r, "X-Forwarded-For"), ShouldEqual, "192.168.0.1")
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	Convey("Retry-After seconds", t, func() {
+		So(RetryAfter(100*time.Millisecond), ShouldEqual, 1)
+		So(RetryAfter(1500*time.Millisecond), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,66 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	expiresKey   = "_expires"
+	signatureKey = "_signature"
+)
+
+// Sign return a copy of the URL with `_expires` and the HMAC-SHA256
+// `_signature` of its path and query string
+func Sign(key string, u *url.URL, expires time.Time) *url.URL {
+	queries := u.Query()
+	queries.Del(signatureKey)
+	queries.Set(expiresKey, strconv.FormatInt(expires.Unix(), 10))
+
+	signed := &url.URL{Path: u.Path}
+	queries.Set(signatureKey, signature(key, u.Path, queries))
+	signed.RawQuery = queries.Encode()
+	return signed
+}
+
+// Verify check the signature and expiration of a signed URL
+func Verify(key string, u *url.URL, now time.Time) error {
+	queries := u.Query()
+	sig := queries.Get(signatureKey)
+	if sig == "" {
+		return errors.New("URL is not signed")
+	}
+	queries.Del(signatureKey)
+
+	expires, err := strconv.ParseInt(queries.Get(expiresKey), 10, 64)
+	if err != nil {
+		return errors.New("Invalid URL expiration")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(key, u.Path, queries))) {
+		return errors.New("Invalid URL signature")
+	}
+	if now.Unix() > expires {
+		return errors.New("Signed URL expired")
+	}
+	return nil
+}
+
+// IsSigned return true when the URL has a signature
+func IsSigned(u *url.URL) bool {
+	return u.Query().Get(signatureKey) != ""
+}
+
+func signature(key, path string, queries url.Values) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(path))
+	mac.Write([]byte("?"))
+	// Encode sorts by key, the signature doesn't depend on the parameters order
+	mac.Write([]byte(queries.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,51 @@
+package signedurl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	u, err := url.Parse("/prest/public/test?name=prest&_select=id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Sign and verify URL", t, func() {
+		signed := Sign("secret", u, now.Add(time.Hour))
+		So(IsSigned(signed), ShouldBeTrue)
+		So(signed.Query().Get("name"), ShouldEqual, "prest")
+		So(signed.Query().Get("_expires"), ShouldEqual, "1500003600")
+		So(Verify("secret", signed, now), ShouldBeNil)
+	})
+	Convey("Verify with parameters in another order", t, func() {
+		signed := Sign("secret", u, now.Add(time.Hour))
+		reordered, err := url.Parse(signed.Path + "?_signature=" + signed.Query().Get("_signature") +
+			"&_select=id&_expires=1500003600&name=prest")
+		So(err, ShouldBeNil)
+		So(Verify("secret", reordered, now), ShouldBeNil)
+	})
+	Convey("Verify tampered URL", t, func() {
+		signed := Sign("secret", u, now.Add(time.Hour))
+		queries := signed.Query()
+		queries.Set("name", "other")
+		signed.RawQuery = queries.Encode()
+		So(Verify("secret", signed, now), ShouldNotBeNil)
+	})
+	Convey("Verify with another key", t, func() {
+		signed := Sign("secret", u, now.Add(time.Hour))
+		So(Verify("other", signed, now), ShouldNotBeNil)
+	})
+	Convey("Verify expired URL", t, func() {
+		signed := Sign("secret", u, now.Add(time.Hour))
+		So(Verify("secret", signed, now.Add(2*time.Hour)), ShouldNotBeNil)
+	})
+	Convey("Verify URL without signature", t, func() {
+		So(IsSigned(u), ShouldBeFalse)
+		So(Verify("secret", u, now), ShouldNotBeNil)
+	})
+}
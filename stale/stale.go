@@ -0,0 +1,212 @@
+package stale
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header set in the responses served from the cache
+const Header = "X-Prest-Stale"
+
+// credentials headers, the responses are cached by principal
+var credentials = []string{"Authorization", "X-API-Key", "X-Admin-Key"}
+
+type entry struct {
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// Cache of the successful GET responses, served when a request fails and the
+// database is not available
+type Cache struct {
+	maxAge       time.Duration
+	maxEntries   int
+	maxEntrySize int
+	available    func() bool
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// keys in insertion order, the oldest are evicted first
+	keys []string
+}
+
+// New return a cache of up to maxEntries responses of up to maxEntrySize
+// bytes (0 is unlimited) served until maxAge, available checks if the
+// database answers
+func New(maxAge time.Duration, maxEntries, maxEntrySize int, available func() bool) *Cache {
+	return &Cache{
+		maxAge:       maxAge,
+		maxEntries:   maxEntries,
+		maxEntrySize: maxEntrySize,
+		available:    available,
+		entries:      make(map[string]*entry),
+	}
+}
+
+// recorder buffer the response to cache it or replace it by a stale one. The
+// streamed responses (flushed or NDJSON), the partial ones and the successful
+// ones bigger than maxSize are passed to the ResponseWriter and not cached
+type recorder struct {
+	http.ResponseWriter
+	maxSize int
+	status  int
+	body    bytes.Buffer
+	passed  bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.passed {
+		return
+	}
+	r.status = status
+	if status == http.StatusPartialContent {
+		r.pass()
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	// the errors are buffered to be replaced by the cached response
+	if !r.passed && (strings.HasPrefix(r.Header().Get("Content-Type"), "application/x-ndjson") ||
+		r.maxSize > 0 && r.status < 500 && r.body.Len()+len(b) > r.maxSize) {
+		r.pass()
+	}
+	if r.passed {
+		return r.ResponseWriter.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+// Flush write the response buffered so far, the rest is streamed
+func (r *recorder) Flush() {
+	r.pass()
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// pass write the buffered response to the ResponseWriter, it isn't cached
+func (r *recorder) pass() {
+	if r.passed {
+		return
+	}
+	r.passed = true
+	r.ResponseWriter.WriteHeader(r.status)
+	r.ResponseWriter.Write(r.body.Bytes())
+	r.body.Reset()
+}
+
+// ServeHTTP cache the GET responses, on failures (5xx or panic) with the
+// database unavailable the cached response is served, it's a negroni handler
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method != "GET" {
+		next(w, r)
+		return
+	}
+
+	key := c.key(r)
+	// headers of the other middlewares (e.g. CORS) are not cached
+	before := make(map[string]bool, len(w.Header()))
+	for k := range w.Header() {
+		before[k] = true
+	}
+	rec := &recorder{ResponseWriter: w, maxSize: c.maxEntrySize, status: http.StatusOK}
+	defer func() {
+		if p := recover(); p != nil {
+			if rec.passed || !c.serve(w, key, before) {
+				panic(p)
+			}
+		}
+	}()
+	next(rec, r)
+
+	switch {
+	case rec.passed:
+		return
+	case rec.status == http.StatusOK:
+		header := make(http.Header)
+		for k, v := range w.Header() {
+			if !before[k] || k == "Content-Type" {
+				header[k] = append([]string(nil), v...)
+			}
+		}
+		c.store(key, header, rec.body.Bytes())
+	case rec.status >= 500 && c.serve(w, key, before):
+		return
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// serve write the cached response when the database is not available, the
+// headers of the failed response (the ones not in before) are removed
+func (c *Cache) serve(w http.ResponseWriter, key string, before map[string]bool) bool {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	age := time.Since(e.stored)
+	if age > c.maxAge || c.available() {
+		return false
+	}
+
+	h := w.Header()
+	for k := range h {
+		if !before[k] {
+			delete(h, k)
+		}
+	}
+	for k, v := range e.header {
+		h[k] = v
+	}
+	h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	h.Set("Warning", `110 - "Response is Stale"`)
+	h.Set(Header, "true")
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+	return true
+}
+
+func (c *Cache) store(key string, header http.Header, body []byte) {
+	e := &entry{
+		header: header,
+		body:   make([]byte, len(body)),
+		stored: time.Now(),
+	}
+	copy(e.body, body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.entries[key] = e
+	for len(c.keys) > c.maxEntries {
+		delete(c.entries, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+}
+
+// key identify the response by the URL and the credentials of the request
+func (c *Cache) key(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.RequestURI()))
+	// JSON and CSV responses (and the rows of a Range) of the same URL
+	h.Write([]byte{0})
+	h.Write([]byte(r.Header.Get("Accept")))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Header.Get("Range")))
+	for _, name := range credentials {
+		h.Write([]byte{0})
+		h.Write([]byte(r.Header.Get(name)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package stale
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	available := true
+	c := New(time.Minute, 2, 0, func() bool { return available })
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "1")
+		w.Write([]byte(`[{"id":1}]`))
+	}
+	fail := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "dial tcp: connection refused", http.StatusInternalServerError)
+	}
+	crash := func(w http.ResponseWriter, r *http.Request) {
+		panic("Unable to connection to database")
+	}
+	serve := func(path string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/json")
+		c.ServeHTTP(w, r, h)
+		return w
+	}
+
+	Convey("Successful responses are written", t, func() {
+		w := serve("/prest/public/test", ok)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `[{"id":1}]`)
+		So(w.Header().Get(Header), ShouldBeEmpty)
+	})
+	Convey("Failures with the database available are written", t, func() {
+		w := serve("/prest/public/test", fail)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+	})
+	Convey("Failures with the database unavailable serve the cache", t, func() {
+		available = false
+		w := serve("/prest/public/test", fail)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `[{"id":1}]`)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(w.Header().Get("X-Total-Count"), ShouldEqual, "1")
+		So(w.Header().Get("Warning"), ShouldEqual, `110 - "Response is Stale"`)
+		So(w.Header().Get(Header), ShouldEqual, "true")
+
+		w = serve("/prest/public/test", crash)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		available = true
+	})
+	Convey("Failures without cached response are written", t, func() {
+		available = false
+		w := serve("/prest/public/test2", fail)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		So(func() { serve("/prest/public/test2", crash) }, ShouldPanic)
+		available = true
+	})
+	Convey("Responses are cached by credentials", t, func() {
+		available = false
+		r := httptest.NewRequest("GET", "/prest/public/test", nil)
+		r.Header.Set("X-API-Key", "other")
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r, fail)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		available = true
+	})
+	Convey("The oldest responses are evicted", t, func() {
+		serve("/prest/public/test3", ok)
+		serve("/prest/public/test4", ok)
+		available = false
+		w := serve("/prest/public/test", fail)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		w = serve("/prest/public/test4", fail)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		available = true
+	})
+}
+
+func TestCacheStreamed(t *testing.T) {
+	c := New(time.Minute, 10, 16, func() bool { return false })
+	fail := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "dial tcp: connection refused", http.StatusInternalServerError)
+	}
+	serve := func(path string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r, h)
+		return w
+	}
+
+	Convey("Flushed responses are streamed and not cached", t, func() {
+		var flushed string
+		w := serve("/prest/public/flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":1}`))
+			w.(http.Flusher).Flush()
+			flushed = w.(*recorder).ResponseWriter.(*httptest.ResponseRecorder).Body.String()
+			w.Write([]byte(`]`))
+		})
+		So(flushed, ShouldEqual, `[{"id":1}`)
+		So(w.Flushed, ShouldBeTrue)
+		So(w.Body.String(), ShouldEqual, `[{"id":1}]`)
+		So(serve("/prest/public/flushed", fail).Code, ShouldEqual, http.StatusInternalServerError)
+	})
+	Convey("NDJSON responses are not cached", t, func() {
+		w := serve("/prest/public/ndjson", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte("{\"id\":1}\n"))
+		})
+		So(w.Body.String(), ShouldEqual, "{\"id\":1}\n")
+		So(serve("/prest/public/ndjson", fail).Code, ShouldEqual, http.StatusInternalServerError)
+	})
+	Convey("Partial responses are not cached", t, func() {
+		w := serve("/prest/public/partial", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(`[]`))
+		})
+		So(w.Code, ShouldEqual, http.StatusPartialContent)
+		So(w.Body.String(), ShouldEqual, `[]`)
+		So(serve("/prest/public/partial", fail).Code, ShouldEqual, http.StatusInternalServerError)
+	})
+	Convey("Responses bigger than the max entry size are not cached", t, func() {
+		w := serve("/prest/public/big", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":1},`))
+			w.Write([]byte(`{"id":2}]`))
+		})
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `[{"id":1},{"id":2}]`)
+		So(serve("/prest/public/big", fail).Code, ShouldEqual, http.StatusInternalServerError)
+
+		serve("/prest/public/small", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":1}]`))
+		})
+		So(serve("/prest/public/small", fail).Code, ShouldEqual, http.StatusOK)
+	})
+	Convey("Failures bigger than the max entry size serve the cache", t, func() {
+		serve("/prest/public/small", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":1}]`))
+		})
+		w := serve("/prest/public/small", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, strings.Repeat("connection refused ", 10), http.StatusServiceUnavailable)
+		})
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `[{"id":1}]`)
+	})
+}
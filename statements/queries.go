@@ -103,6 +103,241 @@ ORDER BY
 	// SchemaTables default query
 	SchemaTables = SchemaTablesSelect + SchemaTablesWhere + SchemaTablesOrderBy
 
+	// TableColumns list the columns of a table
+	TableColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3
+ORDER BY
+	ordinal_position`
+
+	// GeneratedColumns list the columns of a table the database always
+	// generates, GENERATED ALWAYS AS (expression) and GENERATED ALWAYS AS
+	// IDENTITY
+	GeneratedColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3 AND
+	(is_generated = 'ALWAYS' OR identity_generation = 'ALWAYS')
+ORDER BY
+	ordinal_position`
+
+	// ByteaColumns list the columns of a table of bytea type
+	ByteaColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3 AND
+	data_type = 'bytea'
+ORDER BY
+	ordinal_position`
+
+	// TimestampColumns list the columns of a table of timestamp types
+	TimestampColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3 AND
+	data_type IN ('timestamp without time zone', 'timestamp with time zone')
+ORDER BY
+	ordinal_position`
+
+	// TimestampTZColumns list the columns of a table of timestamptz type
+	TimestampTZColumns = `
+SELECT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3 AND
+	data_type = 'timestamp with time zone'
+ORDER BY
+	ordinal_position`
+
+	// CompositeColumns list the columns of a table of composite types with the
+	// schema and the name of the type
+	CompositeColumns = `
+SELECT
+	c.column_name,
+	c.udt_schema,
+	c.udt_name
+FROM
+	information_schema.columns c
+INNER JOIN
+	pg_catalog.pg_namespace n ON n.nspname = c.udt_schema
+INNER JOIN
+	pg_catalog.pg_type t ON t.typnamespace = n.oid AND t.typname = c.udt_name
+WHERE
+	c.table_catalog = $1 AND
+	c.table_schema = $2 AND
+	c.table_name = $3 AND
+	t.typtype = 'c'
+ORDER BY
+	c.ordinal_position`
+
+	// CompositeAttributes list the attributes of a composite type with their
+	// data types, the schema and the name of the composite ones
+	CompositeAttributes = `
+SELECT
+	a.attribute_name,
+	a.data_type,
+	a.attribute_udt_schema,
+	a.attribute_udt_name,
+	COALESCE(t.typtype = 'c', false)
+FROM
+	information_schema.attributes a
+LEFT JOIN
+	pg_catalog.pg_namespace n ON n.nspname = a.attribute_udt_schema
+LEFT JOIN
+	pg_catalog.pg_type t ON t.typnamespace = n.oid AND t.typname = a.attribute_udt_name
+WHERE
+	a.udt_catalog = $1 AND
+	a.udt_schema = $2 AND
+	a.udt_name = $3
+ORDER BY
+	a.ordinal_position`
+
+	// RangeColumns list the columns of a table of range types with the schema
+	// and the name of the type and the data type of its bounds
+	RangeColumns = `
+SELECT
+	c.column_name,
+	c.udt_schema,
+	c.udt_name,
+	format_type(r.rngsubtype, NULL)
+FROM
+	information_schema.columns c
+INNER JOIN
+	pg_catalog.pg_namespace n ON n.nspname = c.udt_schema
+INNER JOIN
+	pg_catalog.pg_type t ON t.typnamespace = n.oid AND t.typname = c.udt_name
+INNER JOIN
+	pg_catalog.pg_range r ON r.rngtypid = t.oid
+WHERE
+	c.table_catalog = $1 AND
+	c.table_schema = $2 AND
+	c.table_name = $3
+ORDER BY
+	c.ordinal_position`
+
+	// ColumnTypes list the columns of a table with their data types, the
+	// extension types (e.g. citext) by name and the domains with the type
+	// underlying them
+	ColumnTypes = `
+SELECT
+	column_name,
+	CASE WHEN data_type = 'USER-DEFINED' THEN udt_name ELSE data_type END,
+	COALESCE(domain_name, '')
+FROM
+	information_schema.columns
+WHERE
+	table_catalog = $1 AND
+	table_schema = $2 AND
+	table_name = $3
+ORDER BY
+	ordinal_position`
+
+	// AccessColumns list the columns of the tables of a name, only of the
+	// database and the schema when they are not empty
+	AccessColumns = `
+SELECT DISTINCT
+	column_name
+FROM
+	information_schema.columns
+WHERE
+	($1 = '' OR table_catalog = $1) AND
+	($2 = '' OR table_schema = $2) AND
+	table_name = $3`
+
+	// ForeignKeys list the foreign keys of a table with the referenced columns
+	ForeignKeys = `
+SELECT
+	kcu.column_name,
+	ccu.table_schema,
+	ccu.table_name,
+	ccu.column_name
+FROM
+	information_schema.table_constraints tc
+INNER JOIN
+	information_schema.key_column_usage kcu ON
+	kcu.constraint_catalog = tc.constraint_catalog AND
+	kcu.constraint_schema = tc.constraint_schema AND
+	kcu.constraint_name = tc.constraint_name
+INNER JOIN
+	information_schema.constraint_column_usage ccu ON
+	ccu.constraint_catalog = tc.constraint_catalog AND
+	ccu.constraint_schema = tc.constraint_schema AND
+	ccu.constraint_name = tc.constraint_name
+WHERE
+	tc.constraint_type = 'FOREIGN KEY' AND
+	tc.table_catalog = $1 AND
+	tc.table_schema = $2 AND
+	tc.table_name = $3`
+
+	// PrimaryKeyColumns list the primary key columns of a table
+	PrimaryKeyColumns = `
+SELECT
+	kcu.column_name
+FROM
+	information_schema.table_constraints tc
+INNER JOIN
+	information_schema.key_column_usage kcu ON
+	kcu.constraint_catalog = tc.constraint_catalog AND
+	kcu.constraint_schema = tc.constraint_schema AND
+	kcu.constraint_name = tc.constraint_name
+WHERE
+	tc.constraint_type = 'PRIMARY KEY' AND
+	tc.table_catalog = $1 AND
+	tc.table_schema = $2 AND
+	tc.table_name = $3
+ORDER BY
+	kcu.ordinal_position`
+
+	// Timeseries aggregate rows in time buckets filling the gaps with zero
+	Timeseries = `
+WITH data AS (
+	SELECT
+		to_timestamp(floor(extract(epoch FROM %s) / %d) * %d) AS bucket,
+		%s(%s) AS value
+	FROM
+		%s%s
+	GROUP BY 1
+), series AS (
+	SELECT
+		generate_series(MIN(bucket), MAX(bucket), interval '%d seconds') AS bucket
+	FROM
+		data
+)
+SELECT
+	series.bucket,
+	COALESCE(data.value, 0) AS value
+FROM
+	series
+LEFT JOIN
+	data ON data.bucket = series.bucket
+ORDER BY
+	series.bucket`
+
 	// SelectInTable default query
 	SelectInTable = `
 SELECT
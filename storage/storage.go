@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuveo/prest/config"
+)
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	service         = "s3"
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// Client object storage client, works with S3 and S3 compatible APIs (GCS interoperability, minio...)
+type Client struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	HTTP      *http.Client
+	now       func() time.Time
+}
+
+// New create a client based in prest configuration
+func New(cfg *config.Prest) (*Client, error) {
+	if cfg.StorageBucket == "" {
+		return nil, errors.New("Storage bucket not configured")
+	}
+	return &Client{
+		Endpoint:  strings.TrimRight(cfg.StorageEndpoint, "/"),
+		Region:    cfg.StorageRegion,
+		Bucket:    cfg.StorageBucket,
+		AccessKey: cfg.StorageAccessKey,
+		SecretKey: cfg.StorageSecretKey,
+		HTTP:      http.DefaultClient,
+		now:       time.Now,
+	}, nil
+}
+
+// Put upload an object
+func (c *Client) Put(key string, body io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequest("PUT", c.objectURL(key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Storage put %s: %s %s", key, resp.Status, msg)
+	}
+	return nil
+}
+
+// Get download an object, the caller must close the body
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", c.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Storage get %s: %s %s", key, resp.Status, msg)
+	}
+	return resp.Body, nil
+}
+
+// PresignGet return a signed URL to download an object without credentials
+func (c *Client) PresignGet(key string, expires time.Duration) (string, error) {
+	u, err := url.Parse(c.objectURL(key))
+	if err != nil {
+		return "", err
+	}
+
+	t := c.now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", algorithm)
+	query.Set("X-Amz-Credential", fmt.Sprintf("%s/%s", c.AccessKey, c.scope(t)))
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	query.Set("X-Amz-Signature", c.signature(t, canonicalRequest))
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+func (c *Client) objectURL(key string) string {
+	segments := strings.Split(strings.TrimLeft(key, "/"), "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+	return fmt.Sprintf("%s/%s/%s", c.Endpoint, uriEncode(c.Bucket), strings.Join(segments, "/"))
+}
+
+// sign add the AWS signature version 4 headers
+func (c *Client) sign(req *http.Request) {
+	t := c.now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.URL.Host, unsignedPayload, t.Format(amzDateFormat))
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		unsignedPayload,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.AccessKey, c.scope(t), strings.Join(signedHeaders, ";"), c.signature(t, canonicalRequest)))
+}
+
+func (c *Client) scope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", t.Format(shortDateFormat), c.Region, service)
+}
+
+func (c *Client) signature(t time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		algorithm,
+		t.Format(amzDateFormat),
+		c.scope(t),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), t.Format(shortDateFormat))
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, k := range keys {
+		for _, v := range values[k] {
+			params = append(params, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// uriEncode encode following RFC 3986 as required by the signature
+func uriEncode(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
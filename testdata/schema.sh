@@ -5,23 +5,63 @@ psql prest -c "create table test2(name text, number integer);" -U postgres
 psql prest -c "create table test3(id serial, name text UNIQUE);" -U postgres
 psql prest -c "create table test4(id serial, name text UNIQUE);" -U postgres
 psql prest -c "create table test5(id serial, name text, celphone text);" -U postgres
+psql prest -c "create table test_put(id serial primary key, name text, celphone text default 'unknown');" -U postgres
+psql prest -c "create table test_address(id serial primary key, street text);" -U postgres
+psql prest -c "create table test_person(id serial, name text, address_id integer references test_address(id));" -U postgres
 psql prest -c "insert into test (name) values ('prest tester');" -U postgres
 psql prest -c "insert into test (name) values ('tester02');" -U postgres
 psql prest -c "insert into test2 (name, number) values ('tester02', 2);" -U postgres
 psql prest -c "insert into test3 (name) values ('prest');" -U postgres
 psql prest -c "insert into test3 (name) values ('prest tester');" -U postgres
 psql prest -c "insert into test5 (name, celphone) values ('prest tester', '444444');" -U postgres
+psql prest -c "insert into test_put (name, celphone) values ('prest tester', '444444');" -U postgres
+psql prest -c "insert into test_put (name) values ('batch01'), ('batch02');" -U postgres
+
+# Basic auth
+psql prest -c "create extension if not exists pgcrypto;" -U postgres
+psql prest -c "create table test_users(username text, password text);" -U postgres
+psql prest -c "insert into test_users (username, password) values ('prest', crypt('secret', gen_salt('bf')));" -U postgres
+
+# Row level security
+psql -c "DROP ROLE IF EXISTS test_rls_reader" -U postgres
+psql prest -c "create role test_rls_reader;" -U postgres
+psql prest -c "create table test_rls(name text);" -U postgres
+psql prest -c "insert into test_rls (name) values ('test_rls_reader'), ('other');" -U postgres
+psql prest -c "grant select on test_rls to test_rls_reader;" -U postgres
+psql prest -c "alter table test_rls enable row level security;" -U postgres
+psql prest -c "create policy test_rls_owner on test_rls using (name = current_user);" -U postgres
 
 # Permission tests
 psql prest -c "create table test_readonly_access(id serial, name text);" -U postgres
 psql prest -c "create table test_write_and_delete_access(id serial, name text);" -U postgres
 psql prest -c "create table test_list_only_id(id serial, name text);" -U postgres
+psql prest -c "create table test_insert_only(id serial, name text);" -U postgres
 psql prest -c "create table test_deleteonly_access(id serial, name text);" -U postgres
+psql prest -c "create table test_masked(id serial, name text, password_hash text);" -U postgres
+psql prest -c "create type test_point as (x numeric, y numeric);" -U postgres
+psql prest -c "create type test_location as (label text, point test_point, active boolean);" -U postgres
+psql prest -c "create table test_composite(id serial, location test_location);" -U postgres
+psql prest -c "insert into test_composite (location) values (row('home, \"main\"', row(1.5, 2), true));" -U postgres
+psql prest -c "create table test_range(id serial, seats int4range, period tstzrange);" -U postgres
+psql prest -c "insert into test_range (seats, period) values ('[1,10)', '[2017-01-01 00:00:00+00,)');" -U postgres
+psql prest -c "create extension if not exists citext;" -U postgres
+psql prest -c "create domain positive_int as integer check (value > 0);" -U postgres
+psql prest -c "create table test_citext(id positive_int, email citext);" -U postgres
+psql prest -c "insert into test_citext (id, email) values (1, 'Ana@Example.com'), (2, 'ana@example.com'), (3, 'bob@example.com');" -U postgres
+psql prest -c "create table test_bytea(id serial, content bytea);" -U postgres
+psql prest -c "insert into test_bytea (content) values ('\\x0102ff');" -U postgres
+psql prest -c "create table test_timestamp(id serial, created timestamptz, day timestamp);" -U postgres
+psql prest -c "insert into test_timestamp (created, day) values ('2017-01-02 03:04:05.5+00', '2017-01-02 00:00:00');" -U postgres
+psql prest -c "create table test_generated(id integer generated always as identity, price numeric, quantity integer, total numeric generated always as (price * quantity) stored);" -U postgres
+
+psql prest -c "create table prest_access(name text not null, database text, schema text, permissions text[], fields text[], masked text[]);" -U postgres
+psql prest -c "insert into prest_access (name, schema, permissions, fields) values ('test_access_table', 'public', '{read}', '{id,name}');" -U postgres
 
 psql prest -c "insert into test_readonly_access (name) values ('test01');" -U postgres
 psql prest -c "insert into test_write_and_delete_access (name) values ('test01');" -U postgres
 psql prest -c "insert into test_list_only_id (name) values ('test01');" -U postgres
 psql prest -c "insert into test_deleteonly_access (name) values ('test01');" -U postgres
+psql prest -c "insert into test_masked (name, password_hash) values ('test01', 'secret');" -U postgres
 
 
 # Views
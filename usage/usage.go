@@ -0,0 +1,128 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Usage requests and rows (returned or affected) of an API key
+type Usage struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+	Rows     int64  `json:"rows"`
+}
+
+// Tracker count the usage by API key, saved in File (when not empty) to
+// keep the counts across restarts
+type Tracker struct {
+	File string
+
+	mu    sync.Mutex
+	usage map[string]*Usage
+}
+
+// Default tracker of pREST, nil when disabled
+var Default *Tracker
+
+// New return a tracker saving the counts in file, the ones saved before are
+// loaded
+func New(file string) (*Tracker, error) {
+	t := &Tracker{File: file, usage: make(map[string]*Usage)}
+	if file == "" {
+		return t, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []Usage
+	if err = json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	for i := range saved {
+		t.usage[saved[i].Key] = &saved[i]
+	}
+	return t, nil
+}
+
+// Track count a request of the key with its rows
+func (t *Tracker) Track(key string, rows int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.usage[key]
+	if !ok {
+		u = &Usage{Key: key}
+		t.usage[key] = u
+	}
+	u.Requests++
+	u.Rows += rows
+}
+
+// Usage return the counts of the keys, sorted by key
+func (t *Tracker) Usage() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := make([]Usage, 0, len(t.usage))
+	for _, u := range t.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Key < usage[j].Key })
+	return usage
+}
+
+// Save write the counts in the file, replacing it at once
+func (t *Tracker) Save() error {
+	if t.File == "" {
+		return nil
+	}
+	b, err := json.Marshal(t.Usage())
+	if err != nil {
+		return err
+	}
+	tmp := t.File + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.File)
+}
+
+// Run save the counts by interval
+func (t *Tracker) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := t.Save(); err != nil {
+			log.Println("[usage]", err)
+		}
+	}
+}
+
+type rowsKey struct{}
+
+// NewContext return a context counting the rows of the request
+func NewContext(ctx context.Context) (context.Context, *int64) {
+	rows := new(int64)
+	return context.WithValue(ctx, rowsKey{}, rows), rows
+}
+
+// AddRows count rows returned or affected by the request of the context,
+// nothing is counted when the usage isn't tracked
+func AddRows(ctx context.Context, n int64) {
+	if rows, ok := ctx.Value(rowsKey{}).(*int64); ok {
+		atomic.AddInt64(rows, n)
+	}
+}
+
+// Tracked check if the rows of the request of the context are counted
+func Tracked(ctx context.Context) bool {
+	_, ok := ctx.Value(rowsKey{}).(*int64)
+	return ok
+}
@@ -0,0 +1,64 @@
+package usage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTracker(t *testing.T) {
+	Convey("Count requests and rows by key", t, func() {
+		tracker, err := New("")
+		So(err, ShouldBeNil)
+		tracker.Track("reports", 10)
+		tracker.Track("billing", 1)
+		tracker.Track("reports", 5)
+		So(tracker.Usage(), ShouldResemble, []Usage{
+			{Key: "billing", Requests: 1, Rows: 1},
+			{Key: "reports", Requests: 2, Rows: 15},
+		})
+		So(tracker.Save(), ShouldBeNil)
+	})
+	Convey("Counts saved in the file", t, func() {
+		dir, err := ioutil.TempDir("", "usage")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "usage.json")
+
+		tracker, err := New(file)
+		So(err, ShouldBeNil)
+		tracker.Track("reports", 10)
+		So(tracker.Save(), ShouldBeNil)
+
+		tracker, err = New(file)
+		So(err, ShouldBeNil)
+		tracker.Track("reports", 1)
+		So(tracker.Usage(), ShouldResemble, []Usage{{Key: "reports", Requests: 2, Rows: 11}})
+	})
+	Convey("Invalid file", t, func() {
+		dir, err := ioutil.TempDir("", "usage")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "usage.json")
+		So(ioutil.WriteFile(file, []byte("{"), 0600), ShouldBeNil)
+		_, err = New(file)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestRows(t *testing.T) {
+	Convey("Rows of the request", t, func() {
+		AddRows(context.Background(), 1)
+		So(Tracked(context.Background()), ShouldBeFalse)
+
+		ctx, rows := NewContext(context.Background())
+		So(Tracked(ctx), ShouldBeTrue)
+		AddRows(ctx, 3)
+		AddRows(ctx, 2)
+		So(*rows, ShouldEqual, 5)
+	})
+}